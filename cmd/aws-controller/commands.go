@@ -0,0 +1,155 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/golang/glog"
+
+	"github.com/kopeio/aws-controller/pkg/awscontroller/config"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/inventory"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"github.com/kopeio/aws-controller/pkg/kope/notify"
+)
+
+// The commands, given as the first argument; with no command we run the controllers
+const (
+	commandRun       = "run"
+	commandValidate  = "validate"
+	commandDumpState = "dump-state"
+	commandSelfTest  = "selftest"
+	commandVersion   = "version"
+
+	commandPrintIAMPolicy = "print-iam-policy"
+)
+
+var commands = []string{commandRun, commandValidate, commandDumpState, commandSelfTest, commandVersion, commandPrintIAMPolicy}
+
+// zoneNamer is implemented by DNS providers which can look up their zone
+type zoneNamer interface {
+	ZoneName(ctx context.Context) (string, error)
+}
+
+// runValidate checks the configuration, our AWS credentials and the DNS zone, without changing anything,
+// exiting non-zero if any check fails
+func runValidate(cloud *kopeaws.AWSCloud, events *notify.Notifier) {
+	ctx := context.Background()
+	failed := false
+	check := func(what string, err error) {
+		if err != nil {
+			fmt.Printf("FAIL  %s: %v\n", what, err)
+			failed = true
+		} else {
+			fmt.Printf("OK    %s\n", what)
+		}
+	}
+
+	cc, err := buildControllerContext(cloud, events)
+	check("configuration flags", err)
+	if err == nil && *flagConfigFile != "" {
+		check(fmt.Sprintf("configuration file %q", *flagConfigFile), config.NewFileWatcher(*flagConfigFile, cc.applier.apply, configFilePeriod).Load())
+	}
+	_, err = enabledControllers(*flagControllers, true)
+	check("controllers", err)
+
+	instances, err := cloud.DescribeInstances(ctx)
+	check(fmt.Sprintf("AWS credentials (found %d instances in cluster %q)", len(instances), cloud.ClusterID()), err)
+
+	if cc != nil && cc.applier.zoneName != "" {
+		options := instanceDNSOptions(cloud)
+		options.ZoneName = cc.applier.zoneName
+		dns, err := kope.BuildDNSProvider(*flagDNSProvider, options)
+		if err == nil {
+			if z, ok := dns.(zoneNamer); ok {
+				_, err = z.ZoneName(ctx)
+			} else {
+				glog.Warningf("cannot check zone with the %s DNS provider", *flagDNSProvider)
+			}
+		}
+		check(fmt.Sprintf("DNS zone %q", options.ZoneName), err)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// stateDump is the output of the dump-state command
+type stateDump struct {
+	ClusterID string `json:"clusterID"`
+	// DNSZone is the zone the DNS records would be published in, if any
+	DNSZone string `json:"dnsZone,omitempty"`
+
+	Instances  []inventory.Instance  `json:"instances"`
+	DNSRecords []inventory.DNSRecord `json:"dnsRecords"`
+}
+
+// runDumpState prints the instances we find and the DNS records we would publish for them, as JSON
+func runDumpState(cloud *kopeaws.AWSCloud, events *notify.Notifier) {
+	ctx := context.Background()
+
+	cc, err := buildControllerContext(cloud, events)
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+	if *flagConfigFile != "" {
+		if err := config.NewFileWatcher(*flagConfigFile, cc.applier.apply, configFilePeriod).Load(); err != nil {
+			glog.Fatalf("%v", err)
+		}
+	}
+
+	instances, err := cc.instanceCache.ListInstances(ctx)
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+	records, err := cc.instances.DesiredDNSRecords(ctx)
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+
+	dump := &stateDump{
+		ClusterID:  cloud.ClusterID(),
+		DNSZone:    cc.applier.zoneName,
+		Instances:  []inventory.Instance{},
+		DNSRecords: []inventory.DNSRecord{},
+	}
+	for _, i := range instances {
+		dump.Instances = append(dump.Instances, inventory.NewInstance(i))
+	}
+	sort.Slice(dump.Instances, func(a, b int) bool { return dump.Instances[a].ID < dump.Instances[b].ID })
+	for k, v := range records {
+		dump.DNSRecords = append(dump.DNSRecords, inventory.NewDNSRecord(k, v))
+	}
+	sort.Slice(dump.DNSRecords, func(a, b int) bool {
+		if dump.DNSRecords[a].Name != dump.DNSRecords[b].Name {
+			return dump.DNSRecords[a].Name < dump.DNSRecords[b].Name
+		}
+		return dump.DNSRecords[a].Type < dump.DNSRecords[b].Type
+	})
+
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		glog.Fatalf("error serializing state: %v", err)
+	}
+	fmt.Println(string(data))
+}
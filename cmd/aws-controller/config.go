@@ -0,0 +1,448 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+
+	"github.com/kopeio/aws-controller/pkg/apis/awscontroller/v1alpha1"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/instances"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/securitygroups"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// specFromFlags builds the configuration specified by the flags (see reloadFlags), which is the default
+// for any fields not set in a config object
+func specFromFlags(flags flagValues) (*v1alpha1.AWSControllerConfigSpec, error) {
+	sourceDestCheck := false
+	reportOnly := flags.Bool("report-only")
+	spec := &v1alpha1.AWSControllerConfigSpec{
+		ResyncPeriod:    &metav1.Duration{Duration: resyncPeriod},
+		SourceDestCheck: &sourceDestCheck,
+		ReportOnly:      &reportOnly,
+		FilterTags:      flags.KeyValues("filter-tag"),
+		RequiredTags:    flags.KeyValues("required-tag"),
+		NameTemplate:    flags.String("name-template"),
+		DNS: &v1alpha1.DNSSpec{
+			ZoneName:          flags.String("zone-name"),
+			PublicCNAME:       flags.Bool("dns-public-cname"),
+			EtcdSRVDomain:     flags.String("etcd-srv-domain"),
+			PrivateZoneName:   flags.String("dns-private-zone-name"),
+			ReverseZoneName:   flags.String("dns-reverse-zone-name"),
+			RoleGroupDomain:   flags.String("dns-role-group-domain"),
+			HealthCheckPort:   flags.Int("dns-health-check-port"),
+			LatencyRouting:    flags.Bool("dns-latency-routing"),
+			MultiValue:        flags.Bool("dns-multi-value"),
+			MaxChanges:        flags.Int("dns-max-changes"),
+			MaxChangePercent:  flags.Int("dns-max-change-percent"),
+			AllowMassChanges:  flags.Bool("dns-allow-mass-changes"),
+			MaxRecordsPerName: flags.Int("dns-max-records-per-name"),
+		},
+	}
+	if window := flags.Duration("dns-coalesce-window"); window != 0 {
+		spec.DNS.CoalesceWindow = &metav1.Duration{Duration: window}
+	}
+	if window := flags.Duration("dns-verify-window"); window != 0 {
+		spec.DNS.VerifyWindow = &metav1.Duration{Duration: window}
+	}
+	if nameServers := flags.String("dns-verify-nameservers"); nameServers != "" {
+		spec.DNS.VerifyNameServers = strings.Split(nameServers, ",")
+	}
+
+	if s := flags.String("detailed-monitoring"); s != "" {
+		detailedMonitoring, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid detailed-monitoring flag %q: %v", s, err)
+		}
+		spec.DetailedMonitoring = &detailedMonitoring
+	}
+
+	requireIMDSv2, hopLimit := flags.Bool("require-imdsv2"), flags.Int64("imds-hop-limit")
+	if requireIMDSv2 || hopLimit != 0 {
+		spec.MetadataOptions = &v1alpha1.MetadataOptionsSpec{
+			HttpPutResponseHopLimit: hopLimit,
+			ReportOnly:              flags.Bool("imds-report-only"),
+		}
+		if requireIMDSv2 {
+			spec.MetadataOptions.HttpTokens = ec2.HttpTokensStateRequired
+		}
+	}
+
+	terminationProtection := make(map[string]bool)
+	if flags.Bool("master-termination-protection") {
+		terminationProtection[kopeaws.RoleMaster] = true
+	}
+	if s := flags.String("node-termination-protection"); s != "" {
+		protect, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node-termination-protection flag %q: %v", s, err)
+		}
+		terminationProtection[kopeaws.RoleNode] = protect
+	}
+	if len(terminationProtection) != 0 {
+		spec.TerminationProtection = terminationProtection
+	}
+
+	return spec, nil
+}
+
+// mergeSpec returns base, with the fields which are set in override replaced
+func mergeSpec(base *v1alpha1.AWSControllerConfigSpec, override *v1alpha1.AWSControllerConfigSpec) *v1alpha1.AWSControllerConfigSpec {
+	merged := *base
+	if override.ResyncPeriod != nil {
+		merged.ResyncPeriod = override.ResyncPeriod
+	}
+	if override.ReportOnly != nil {
+		merged.ReportOnly = override.ReportOnly
+	}
+	if override.Paused != nil {
+		merged.Paused = override.Paused
+	}
+	if override.SourceDestCheck != nil {
+		merged.SourceDestCheck = override.SourceDestCheck
+	}
+	if override.DetailedMonitoring != nil {
+		merged.DetailedMonitoring = override.DetailedMonitoring
+	}
+	if override.MetadataOptions != nil {
+		merged.MetadataOptions = override.MetadataOptions
+	}
+	if override.TerminationProtection != nil {
+		merged.TerminationProtection = override.TerminationProtection
+	}
+	if override.RequiredTags != nil {
+		merged.RequiredTags = override.RequiredTags
+	}
+	if override.NameTemplate != "" {
+		merged.NameTemplate = override.NameTemplate
+	}
+	if override.FilterTags != nil {
+		merged.FilterTags = override.FilterTags
+	}
+	if override.DNS != nil {
+		merged.DNS = override.DNS
+	}
+	if override.SecurityGroups != nil {
+		merged.SecurityGroups = override.SecurityGroups
+	}
+	return &merged
+}
+
+// buildPolicy builds the instances policy from the configuration
+func buildPolicy(spec *v1alpha1.AWSControllerConfigSpec) (*instances.Policy, error) {
+	policy := &instances.Policy{
+		ReportOnly:            spec.ReportOnly != nil && *spec.ReportOnly,
+		SourceDestCheck:       spec.SourceDestCheck,
+		DetailedMonitoring:    spec.DetailedMonitoring,
+		TerminationProtection: spec.TerminationProtection,
+	}
+
+	if spec.MetadataOptions != nil {
+		policy.MetadataOptions = &kopeaws.InstanceMetadataOptions{
+			HttpTokens:              spec.MetadataOptions.HttpTokens,
+			HttpPutResponseHopLimit: spec.MetadataOptions.HttpPutResponseHopLimit,
+		}
+		policy.MetadataOptionsReportOnly = spec.MetadataOptions.ReportOnly
+	}
+
+	if spec.DNS != nil {
+		policy.PublicCNAME = spec.DNS.PublicCNAME
+		policy.EtcdSRVDomain = spec.DNS.EtcdSRVDomain
+		policy.PrivateZoneName = spec.DNS.PrivateZoneName
+		policy.ReverseZoneName = spec.DNS.ReverseZoneName
+		policy.RoleGroupDomain = spec.DNS.RoleGroupDomain
+		policy.HealthCheckPort = spec.DNS.HealthCheckPort
+		policy.LatencyRouting = spec.DNS.LatencyRouting
+		policy.MultiValue = spec.DNS.MultiValue
+		policy.MaxDNSChanges = spec.DNS.MaxChanges
+		policy.MaxDNSChangePercent = spec.DNS.MaxChangePercent
+		policy.AllowMassDNSChanges = spec.DNS.AllowMassChanges
+		if spec.DNS.MaxRecordsPerName < 0 {
+			return nil, fmt.Errorf("invalid dns-max-records-per-name %d: must not be negative", spec.DNS.MaxRecordsPerName)
+		}
+		policy.MaxRecordsPerName = spec.DNS.MaxRecordsPerName
+		if spec.DNS.CoalesceWindow != nil {
+			policy.DNSCoalesceWindow = spec.DNS.CoalesceWindow.Duration
+		}
+		if spec.DNS.VerifyWindow != nil {
+			policy.DNSVerifyWindow = spec.DNS.VerifyWindow.Duration
+		}
+		policy.DNSVerifyNameServers = spec.DNS.VerifyNameServers
+	}
+	if policy.HealthCheckPort == 0 {
+		policy.HealthCheckPort = 443
+	}
+
+	requiredTagValues := make(map[string]string)
+	for k, v := range spec.RequiredTags {
+		requiredTagValues[k] = v
+	}
+	if spec.NameTemplate != "" {
+		requiredTagValues["Name"] = spec.NameTemplate
+	}
+	if len(requiredTagValues) != 0 {
+		requiredTags, err := instances.ParseTemplates(requiredTagValues)
+		if err != nil {
+			return nil, fmt.Errorf("invalid required tags: %v", err)
+		}
+		policy.RequiredTags = requiredTags
+	}
+
+	return policy, nil
+}
+
+// dnsNameFilter, if set, restricts the DNS providers to the names selected by dns-include and dns-exclude
+var dnsNameFilter *kope.DNSNameFilter
+
+// buildDNSProvider builds the dns-provider for the zone, restricted by dnsNameFilter, or returns nil if
+// options.ZoneName is empty
+func buildDNSProvider(options kope.DNSProviderOptions) (kope.DNSProvider, error) {
+	if options.ZoneName == "" {
+		return nil, nil
+	}
+	dns, err := kope.BuildDNSProvider(*flagDNSProvider, options)
+	if err != nil || dnsNameFilter == nil {
+		return dns, err
+	}
+	return kope.NewFilteredDNSProvider(dns, dnsNameFilter), nil
+}
+
+// instanceDNSOptions returns the DNS provider options for the instance records, which are marked as
+// owned by the cluster so they can be found again after a restart
+func instanceDNSOptions(cloud *kopeaws.AWSCloud) kope.DNSProviderOptions {
+	return kope.DNSProviderOptions{
+		OwnerID:        cloud.ClusterID(),
+		ForceOverwrite: *flagForceOverwrite,
+		Pause:          mutationPause,
+	}
+}
+
+// controllerDNSOptions returns the DNS provider options for the records published by the named controller,
+// which are marked as owned by the controller within the cluster: each controller then only lists (and
+// removes) its own records, and never overwrites the instance records or those of another controller
+func controllerDNSOptions(ctx *controllerContext, controller string) kope.DNSProviderOptions {
+	return kope.DNSProviderOptions{
+		ZoneName:       *flagZoneName,
+		OwnerID:        ctx.instanceCloud.ClusterID() + "/" + controller,
+		ForceOverwrite: *flagForceOverwrite,
+		Pause:          mutationPause,
+	}
+}
+
+// configApplier applies configuration changes to the running controllers
+type configApplier struct {
+	cloud *kopeaws.AWSCloud
+	ic    *instances.InstancesController
+	sg    *securitygroups.SecurityGroupController
+	// pause is paused while the configuration says so
+	pause *kope.PauseSwitch
+	// dnsOptions configures the DNS provider; its ZoneName is set from the configuration
+	dnsOptions kope.DNSProviderOptions
+
+	// mutex serializes applying configuration, so that a reload doesn't interleave with the config
+	// watchers; it protects the fields below
+	mutex sync.Mutex
+
+	// defaults is the configuration from the flags
+	defaults *v1alpha1.AWSControllerConfigSpec
+	// spec is the configuration last applied on top of the defaults
+	spec *v1alpha1.AWSControllerConfigSpec
+	// zoneName, privateZoneName and reverseZoneName are the DNS zones currently configured
+	zoneName        string
+	privateZoneName string
+	reverseZoneName string
+}
+
+// buildInstanceDNSProvider builds the DNS provider for the instance records in the zone, combined with
+// the providers of the split-horizon private zone and of the reverse zone if they are set; it returns nil
+// if zoneName is empty
+func (a *configApplier) buildInstanceDNSProvider(zoneName string, privateZoneName string, reverseZoneName string) (kope.DNSProvider, error) {
+	options := a.dnsOptions
+	options.ZoneName = zoneName
+	if zoneName == "" {
+		return nil, nil
+	}
+
+	if privateZoneName != "" {
+		// The zones of a split-horizon pair usually have the same name
+		public, private := false, true
+		options.PrivateZone = &public
+		dns, err := buildDNSProvider(options)
+		if err != nil {
+			return nil, fmt.Errorf("error building DNS provider: %v", err)
+		}
+
+		privateOptions := a.dnsOptions
+		privateOptions.ZoneName = privateZoneName
+		privateOptions.PrivateZone = &private
+		privateDNS, err := buildDNSProvider(privateOptions)
+		if err != nil {
+			return nil, fmt.Errorf("error building DNS provider for private zone: %v", err)
+		}
+		glog.Infof("Managing split-horizon records in private DNS zone %q", privateZoneName)
+		return a.withReverseZone(kope.NewSplitHorizonDNSProvider(dns, privateDNS), reverseZoneName)
+	}
+
+	dns, err := buildDNSProvider(options)
+	if err != nil {
+		return nil, fmt.Errorf("error building DNS provider: %v", err)
+	}
+	return a.withReverseZone(dns, reverseZoneName)
+}
+
+// withReverseZone combines dns with the provider of the reverse zone, if it is set
+func (a *configApplier) withReverseZone(dns kope.DNSProvider, reverseZoneName string) (kope.DNSProvider, error) {
+	if reverseZoneName == "" {
+		return dns, nil
+	}
+	if !kope.InZone(reverseZoneName, "in-addr.arpa") {
+		return nil, fmt.Errorf("reverse zone %q is not an in-addr.arpa zone", reverseZoneName)
+	}
+	options := a.dnsOptions
+	options.ZoneName = reverseZoneName
+	reverse, err := buildDNSProvider(options)
+	if err != nil {
+		return nil, fmt.Errorf("error building DNS provider for reverse zone: %v", err)
+	}
+	glog.Infof("Managing PTR records in DNS zone %q", reverseZoneName)
+	return kope.NewReverseDNSProvider(dns, reverse), nil
+}
+
+// apply applies the configuration (on top of the defaults); invalid configuration is not applied at all
+func (a *configApplier) apply(spec *v1alpha1.AWSControllerConfigSpec) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.applyLocked(spec)
+}
+
+// setDefaults replaces the configuration from the flags, and reapplies the last configuration on top of it
+func (a *configApplier) setDefaults(defaults *v1alpha1.AWSControllerConfigSpec) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	previous := a.defaults
+	a.defaults = defaults
+	if err := a.applyLocked(a.spec); err != nil {
+		a.defaults = previous
+		return err
+	}
+	return nil
+}
+
+// reload rereads the flags file (if any) and the config file, applying changes to the running controllers.
+// Reconciles in progress complete with the previous configuration; affected instances are then requeued.
+func (ctx *controllerContext) reload() error {
+	var flags flagValues
+	if *flagFlagsFile != "" {
+		fs, err := reloadFlags(*flagFlagsFile)
+		if err != nil {
+			return err
+		}
+		flags.fs = fs
+	}
+
+	defaults, err := specFromFlags(flags)
+	if err != nil {
+		return err
+	}
+	if err := ctx.applier.setDefaults(defaults); err != nil {
+		return fmt.Errorf("error applying configuration: %v", err)
+	}
+	if ctx.configFile != nil {
+		if err := ctx.configFile.Reload(); err != nil {
+			return err
+		}
+	}
+
+	// The filter tags may have changed, so the instances must be listed again
+	ctx.instanceCache.Invalidate()
+	glog.Infof("Reloaded configuration")
+	return nil
+}
+
+// applyLocked applies the configuration; the caller must hold a.mutex
+func (a *configApplier) applyLocked(spec *v1alpha1.AWSControllerConfigSpec) error {
+	effective := mergeSpec(a.defaults, spec)
+
+	policy, err := buildPolicy(effective)
+	if err != nil {
+		return err
+	}
+	if effective.ResyncPeriod != nil && effective.ResyncPeriod.Duration <= 0 {
+		return fmt.Errorf("invalid resync period %v", effective.ResyncPeriod.Duration)
+	}
+
+	securityGroups := effective.SecurityGroups
+	if securityGroups == nil {
+		securityGroups = &v1alpha1.SecurityGroupsSpec{}
+	}
+	if err := securitygroups.ValidateSpec(securityGroups); err != nil {
+		return err
+	}
+
+	zoneName, privateZoneName, reverseZoneName := "", "", ""
+	if effective.DNS != nil {
+		zoneName = effective.DNS.ZoneName
+		privateZoneName = effective.DNS.PrivateZoneName
+		reverseZoneName = effective.DNS.ReverseZoneName
+	}
+	zoneChanged := zoneName != a.zoneName || privateZoneName != a.privateZoneName || reverseZoneName != a.reverseZoneName
+	var dns kope.DNSProvider
+	if zoneChanged {
+		dns, err = a.buildInstanceDNSProvider(zoneName, privateZoneName, reverseZoneName)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Everything has been validated and built, so nothing is applied unless all of it is
+	if err := a.sg.SetSpec(securityGroups); err != nil {
+		return err
+	}
+	if zoneChanged {
+		glog.Infof("Managing DNS zone %q", zoneName)
+		a.ic.SetDNSProvider(dns, zoneName)
+		a.zoneName = zoneName
+		a.privateZoneName = privateZoneName
+		a.reverseZoneName = reverseZoneName
+	}
+
+	if effective.ResyncPeriod != nil {
+		a.ic.SetPeriod(effective.ResyncPeriod.Duration)
+	}
+	a.cloud.SetFilterTags(effective.FilterTags)
+	a.ic.SetPolicy(policy)
+	if effective.Paused != nil && *effective.Paused {
+		if !a.pause.Paused() {
+			glog.Warningf("Pausing mutations, as configured")
+		}
+		a.pause.Pause(kope.PauseSourceConfig, "paused by configuration")
+	} else {
+		a.pause.Resume(kope.PauseSourceConfig)
+	}
+	a.spec = spec
+	return nil
+}
@@ -0,0 +1,231 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kubeutils"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// controller is implemented by each of our long-running controllers
+type controller interface {
+	Run()
+	Stop() error
+}
+
+// healthChecker is implemented by controllers which can report themselves unhealthy
+type healthChecker interface {
+	Healthz() error
+}
+
+// drainingController is implemented by controllers which wait on Stop for their in-flight work to finish
+type drainingController interface {
+	SetShutdownTimeout(timeout time.Duration)
+}
+
+// leaderElectionRunnable is implemented by controllers which say whether they must only run on the leader;
+// controllers which don't implement it do
+type leaderElectionRunnable interface {
+	NeedLeaderElection() bool
+}
+
+// namedController is a controller with its name in the registry
+type namedController struct {
+	name string
+	controller
+}
+
+// controllerManager runs several controllers together
+type controllerManager struct {
+	controllers []namedController
+
+	// stopCh is closed when the controllers are stopped; it is shared with helpers which should
+	// stop along with the controllers
+	stopCh chan struct{}
+
+	// handlers are served on our HTTP server, by pattern
+	handlers map[string]http.Handler
+
+	// leaderElection, if set, is used to run the controllers needing leader election only while we are the leader
+	leaderElection leaderElectionFunc
+
+	// stopLock serializes calls to Stop, so that later calls wait for the controllers to be stopped
+	stopLock sync.Mutex
+	shutdown bool
+	// stopErr is the result of stopping the controllers, returned by later calls to Stop
+	stopErr error
+}
+
+func newControllerManager() *controllerManager {
+	return &controllerManager{
+		handlers: make(map[string]http.Handler),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// add adds a controller, which must be done before Run
+func (m *controllerManager) add(name string, c controller) {
+	m.controllers = append(m.controllers, namedController{name: name, controller: c})
+}
+
+// names returns the names of the controllers, in the order they are started
+func (m *controllerManager) names() []string {
+	var names []string
+	for _, c := range m.controllers {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+func (m *controllerManager) Run() {
+	glog.Infof("starting controllers: %s", strings.Join(m.names(), ","))
+
+	var leaderControllers []namedController
+	var wg sync.WaitGroup
+	for _, c := range m.controllers {
+		if m.leaderElection != nil && needLeaderElection(c) {
+			leaderControllers = append(leaderControllers, c)
+			continue
+		}
+		startController(&wg, c)
+	}
+
+	if len(leaderControllers) != 0 {
+		// Returns on Stop, without waiting for the controllers we started to stop
+		m.leaderElection(wait.ContextForChannel(m.stopCh), func(ctx context.Context) {
+			var leaderWG sync.WaitGroup
+			for _, c := range leaderControllers {
+				startController(&leaderWG, c)
+			}
+			leaderWG.Wait()
+		})
+	}
+	wg.Wait()
+}
+
+// startController runs the controller in a goroutine, tracked by wg
+func startController(wg *sync.WaitGroup, c namedController) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.Run()
+		glog.V(2).Infof("%s controller stopped", c.name)
+	}()
+}
+
+// needLeaderElection returns true if the controller must only run on the leader
+func needLeaderElection(c namedController) bool {
+	if r, ok := c.controller.(leaderElectionRunnable); ok {
+		return r.NeedLeaderElection()
+	}
+	return true
+}
+
+// Healthz returns an error if any of the controllers is unhealthy.  Controllers fail while
+// mutations are paused, so we report ourselves healthy rather than being restarted.
+func (m *controllerManager) Healthz() error {
+	if mutationPause.Paused() {
+		return nil
+	}
+
+	var errors []error
+	for _, h := range m.health() {
+		if !h.Healthy {
+			errors = append(errors, fmt.Errorf("%s: %s", h.Name, h.Error))
+		}
+	}
+	if len(errors) != 0 {
+		return fmt.Errorf("unhealthy controllers: %v", errors)
+	}
+	return nil
+}
+
+// Stop stops all the controllers, in parallel so that their in-flight work drains within a single shutdown
+// timeout.  Calls after the first wait for it to finish, and return its result.
+func (m *controllerManager) Stop() error {
+	m.stopLock.Lock()
+	defer m.stopLock.Unlock()
+
+	if m.shutdown {
+		return m.stopErr
+	}
+	close(m.stopCh)
+	m.shutdown = true
+
+	var wg sync.WaitGroup
+	var errorsMutex sync.Mutex
+	var errors []error
+	for _, c := range m.controllers {
+		wg.Add(1)
+		go func(c namedController) {
+			defer wg.Done()
+			if err := c.Stop(); err != nil {
+				errorsMutex.Lock()
+				errors = append(errors, fmt.Errorf("%s: %v", c.name, err))
+				errorsMutex.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+	if len(errors) != 0 {
+		m.stopErr = fmt.Errorf("errors stopping controllers: %v", errors)
+	}
+	return m.stopErr
+}
+
+// mutationPause is shared by the AWS sessions and the Kubernetes client, to refuse mutating calls while paused
+var mutationPause = &kope.PauseSwitch{}
+
+var kubernetesClient kubernetes.Interface
+
+// mustBuildKubernetesClient returns the (shared) Kubernetes client, exiting if it cannot be built
+func mustBuildKubernetesClient() kubernetes.Interface {
+	if kubernetesClient == nil {
+		client, err := kubeutils.NewClient(*flagKubeconfig, mutationPause)
+		if err != nil {
+			glog.Fatalf("%v", err)
+		}
+		kubernetesClient = client
+	}
+	return kubernetesClient
+}
+
+var dynamicClient dynamic.Interface
+
+// mustBuildDynamicClient returns the (shared) Kubernetes client for custom resources, exiting if it cannot be built
+func mustBuildDynamicClient() dynamic.Interface {
+	if dynamicClient == nil {
+		client, err := kubeutils.NewDynamicClient(*flagKubeconfig)
+		if err != nil {
+			glog.Fatalf("%v", err)
+		}
+		dynamicClient = client
+	}
+	return dynamicClient
+}
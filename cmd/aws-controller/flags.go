@@ -0,0 +1,375 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// reloadableFlags are the flags which are applied to the running controllers when the flags file is reloaded;
+// they are those which make up the default configuration (see specFromFlags)
+var reloadableFlags = map[string]bool{
+	"zone-name":                     true,
+	"dns-public-cname":              true,
+	"etcd-srv-domain":               true,
+	"dns-private-zone-name":         true,
+	"dns-reverse-zone-name":         true,
+	"dns-coalesce-window":           true,
+	"dns-max-records-per-name":      true,
+	"dns-verify-window":             true,
+	"dns-verify-nameservers":        true,
+	"dns-role-group-domain":         true,
+	"dns-health-check-port":         true,
+	"dns-latency-routing":           true,
+	"dns-multi-value":               true,
+	"dns-max-changes":               true,
+	"dns-max-change-percent":        true,
+	"dns-allow-mass-changes":        true,
+	"report-only":                   true,
+	"filter-tag":                    true,
+	"required-tag":                  true,
+	"name-template":                 true,
+	"detailed-monitoring":           true,
+	"require-imdsv2":                true,
+	"imds-hop-limit":                true,
+	"imds-report-only":              true,
+	"master-termination-protection": true,
+	"node-termination-protection":   true,
+}
+
+// commandLineFlags are the flags set on the command line, which take precedence over the flags file
+var commandLineFlags map[string]bool
+
+// startupFileValues are the values read from the flags file at startup, by flag name
+var startupFileValues map[string][]string
+
+// loadFlagsFile sets the flags listed in the file at path, one --name=value per line (blank lines and
+// lines starting with # are ignored); flags set on the command line take precedence.  It is only called
+// at startup, before the flags are read by any other goroutine: later changes are applied by reloadFlags.
+func loadFlagsFile(path string) error {
+	commandLineFlags = make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		commandLineFlags[f.Name] = true
+	})
+
+	values, err := readFlagsFile(path)
+	if err != nil {
+		return err
+	}
+	for name, vs := range values {
+		if commandLineFlags[name] {
+			glog.Warningf("ignoring flag %q in flags file %q, which is set on the command line", name, path)
+			continue
+		}
+		f := flag.Lookup(name)
+		for _, v := range vs {
+			if err := f.Value.Set(v); err != nil {
+				return fmt.Errorf("invalid value %q for flag %q in flags file %q: %v", v, name, path, err)
+			}
+		}
+	}
+	startupFileValues = values
+	return nil
+}
+
+// reloadFlags rereads the flags file into a new FlagSet holding the reloadableFlags, set from their
+// defaults, the command line and the file in turn; the flags themselves are never changed after startup.
+// Changes to other flags are reported, as they only take effect on restart.
+func reloadFlags(path string) (*flag.FlagSet, error) {
+	values, err := readFlagsFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	var buildErr error
+	flag.VisitAll(func(f *flag.Flag) {
+		if !reloadableFlags[f.Name] || buildErr != nil {
+			return
+		}
+		value, err := newFlagValue(f)
+		if err != nil {
+			buildErr = err
+			return
+		}
+		fs.Var(value, f.Name, f.Usage)
+	})
+	if buildErr != nil {
+		return nil, buildErr
+	}
+
+	for name, vs := range values {
+		if commandLineFlags[name] {
+			continue
+		}
+		if !reloadableFlags[name] {
+			if !stringSlicesEqual(vs, startupFileValues[name]) {
+				glog.Warningf("flag %s has changed, but will only be applied on restart", name)
+			}
+			continue
+		}
+		f := fs.Lookup(name)
+		if kv, ok := f.Value.(keyValueFlag); ok {
+			// The file replaces any default of a repeatable flag, rather than adding to it
+			for k := range kv {
+				delete(kv, k)
+			}
+		}
+		for _, v := range vs {
+			if err := f.Value.Set(v); err != nil {
+				return nil, fmt.Errorf("invalid value %q for flag %q in flags file %q: %v", v, name, path, err)
+			}
+		}
+	}
+	return fs, nil
+}
+
+// newFlagValue returns a new value of the same type as the flag, holding its command line value if it
+// was set there and otherwise its default
+func newFlagValue(f *flag.Flag) (flag.Value, error) {
+	var value flag.Value
+	switch v := f.Value.(flag.Getter).Get().(type) {
+	case map[string]string:
+		kv := keyValueFlag{}
+		if commandLineFlags[f.Name] {
+			for k, x := range v {
+				kv[k] = x
+			}
+		}
+		return kv, nil
+	case string:
+		value = new(stringValue)
+	case bool:
+		value = new(boolValue)
+	case int:
+		value = new(intValue)
+	case int64:
+		value = new(int64Value)
+	case time.Duration:
+		value = new(durationValue)
+	default:
+		return nil, fmt.Errorf("flag %q of type %T cannot be reloaded", f.Name, v)
+	}
+
+	initial := f.DefValue
+	if commandLineFlags[f.Name] {
+		initial = f.Value.String()
+	}
+	if err := value.Set(initial); err != nil {
+		return nil, fmt.Errorf("error copying flag %q: %v", f.Name, err)
+	}
+	return value, nil
+}
+
+// readFlagsFile returns the values of the flags in the file, by flag name
+func readFlagsFile(path string) (map[string][]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading flags file %q: %v", path, err)
+	}
+
+	values := make(map[string][]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens := strings.SplitN(strings.TrimLeft(line, "-"), "=", 2)
+		f := flag.Lookup(tokens[0])
+		if f == nil {
+			return nil, fmt.Errorf("unknown flag %q in flags file %q", tokens[0], path)
+		}
+		if len(tokens) == 1 {
+			// Only boolean flags may omit the value
+			if b, ok := f.Value.(interface {
+				IsBoolFlag() bool
+			}); !ok || !b.IsBoolFlag() {
+				return nil, fmt.Errorf("flag %q in flags file %q has no value", f.Name, path)
+			}
+			tokens = append(tokens, "true")
+		}
+		values[f.Name] = append(values[f.Name], tokens[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading flags file %q: %v", path, err)
+	}
+	return values, nil
+}
+
+func stringSlicesEqual(l, r []string) bool {
+	if len(l) != len(r) {
+		return false
+	}
+	for i := range l {
+		if l[i] != r[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// The values of the reloaded flags, with the same parsing as those of the flag package
+type stringValue string
+
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+func (v *stringValue) String() string     { return string(*v) }
+func (v *stringValue) Get() interface{}   { return string(*v) }
+
+type boolValue bool
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*v = boolValue(b)
+	return nil
+}
+func (v *boolValue) String() string   { return strconv.FormatBool(bool(*v)) }
+func (v *boolValue) Get() interface{} { return bool(*v) }
+func (v *boolValue) IsBoolFlag() bool { return true }
+
+type intValue int
+
+func (v *intValue) Set(s string) error {
+	i, err := strconv.ParseInt(s, 0, strconv.IntSize)
+	if err != nil {
+		return err
+	}
+	*v = intValue(i)
+	return nil
+}
+func (v *intValue) String() string   { return strconv.Itoa(int(*v)) }
+func (v *intValue) Get() interface{} { return int(*v) }
+
+type int64Value int64
+
+func (v *int64Value) Set(s string) error {
+	i, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		return err
+	}
+	*v = int64Value(i)
+	return nil
+}
+func (v *int64Value) String() string   { return strconv.FormatInt(int64(*v), 10) }
+func (v *int64Value) Get() interface{} { return int64(*v) }
+
+type durationValue time.Duration
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*v = durationValue(d)
+	return nil
+}
+func (v *durationValue) String() string   { return time.Duration(*v).String() }
+func (v *durationValue) Get() interface{} { return time.Duration(*v) }
+
+// flagValues reads the values of flags from a FlagSet, falling back to the command line flags for those
+// it does not hold
+type flagValues struct {
+	fs *flag.FlagSet
+}
+
+func (v flagValues) get(name string) interface{} {
+	var f *flag.Flag
+	if v.fs != nil {
+		f = v.fs.Lookup(name)
+	}
+	if f == nil {
+		f = flag.Lookup(name)
+	}
+	return f.Value.(flag.Getter).Get()
+}
+
+func (v flagValues) String(name string) string               { return v.get(name).(string) }
+func (v flagValues) Bool(name string) bool                   { return v.get(name).(bool) }
+func (v flagValues) Int(name string) int                     { return v.get(name).(int) }
+func (v flagValues) Int64(name string) int64                 { return v.get(name).(int64) }
+func (v flagValues) Duration(name string) time.Duration      { return v.get(name).(time.Duration) }
+func (v flagValues) KeyValues(name string) map[string]string { return v.get(name).(map[string]string) }
+
+// keyValueFlag is a repeatable flag of the form key=value
+type keyValueFlag map[string]string
+
+// newKeyValueFlag registers a keyValueFlag on the command line
+func newKeyValueFlag(name string, usage string) keyValueFlag {
+	f := keyValueFlag{}
+	flag.Var(f, name, usage)
+	return f
+}
+
+func (f keyValueFlag) String() string {
+	var pairs []string
+	for k, v := range f {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f keyValueFlag) Set(s string) error {
+	tokens := strings.SplitN(s, "=", 2)
+	if len(tokens) != 2 || tokens[0] == "" {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	f[tokens[0]] = tokens[1]
+	return nil
+}
+
+// Get returns a copy of the values
+func (f keyValueFlag) Get() interface{} {
+	return f.values()
+}
+
+// values returns a copy of the values, which may be retained by the caller
+func (f keyValueFlag) values() map[string]string {
+	if len(f) == 0 {
+		return nil
+	}
+	values := make(map[string]string)
+	for k, v := range f {
+		values[k] = v
+	}
+	return values
+}
+
+// tagMapping returns the key mapping from a keyValueFlag, where an empty value maps a key to itself
+func tagMapping(f keyValueFlag) map[string]string {
+	mapping := make(map[string]string)
+	for k, v := range f {
+		if v == "" {
+			v = k
+		}
+		mapping[k] = v
+	}
+	return mapping
+}
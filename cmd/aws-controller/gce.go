@@ -0,0 +1,73 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/golang/glog"
+
+	"github.com/kopeio/aws-controller/pkg/kope/kopegce"
+)
+
+// The clouds we can run on
+const (
+	cloudAWS = "aws"
+	cloudGCE = "gce"
+)
+
+// buildGCEControllers builds the cloud-neutral controllers for a cluster on GCE
+func buildGCEControllers() *controllerManager {
+	cloud, err := kopegce.NewGCECloud(context.Background(), kopegce.GCEOptions{
+		Project:   *flagGCEProject,
+		ClusterID: *flagClusterID,
+		Pause:     mutationPause,
+	})
+	if err != nil {
+		glog.Fatalf("error building cloud: %v", err)
+	}
+
+	kopegce.RegisterCloudDNSProvider(cloud)
+	if !isFlagSet("dns-provider") {
+		*flagDNSProvider = kopegce.CloudDNSProviderName
+	}
+	if !isDNSProvider(*flagDNSProvider) {
+		glog.Fatalf("dns-provider %q is not supported on GCE", *flagDNSProvider)
+	}
+
+	ctx := &controllerContext{
+		instanceCloud: cloud,
+		events:        buildEventNotifier(cloud.ClusterID()),
+	}
+	m, err := buildControllers(ctx, *flagControllers)
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+	return m
+}
+
+// isFlagSet returns true if the flag was set on the command line
+func isFlagSet(name string) bool {
+	found := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kopeio/aws-controller/pkg/awscontroller/instances"
+	"github.com/kopeio/aws-controller/pkg/kope"
+)
+
+// syncStatusReporter is implemented by controllers which resync periodically, reporting the results of their resyncs
+type syncStatusReporter interface {
+	SyncStatus() *kope.SyncStatus
+}
+
+// reconcileStatusReporter is implemented by the instances controller, whose status includes the DNS names
+// truncated by the limit on the addresses published under each name
+type reconcileStatusReporter interface {
+	Status() *instances.ReconcileStatus
+}
+
+// controllerHealth is the health of a single controller, as served at /controllers
+type controllerHealth struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	// Error is the reason the controller is unhealthy
+	Error string `json:"error,omitempty"`
+
+	// Sync is the results of the controller's resyncs, if it resyncs periodically
+	Sync *kope.SyncStatus `json:"sync,omitempty"`
+}
+
+// health returns the health of each controller, in the order they are started.  A controller is unhealthy if
+// it reports itself unhealthy, or if unhealthyResyncFailures of its resyncs have failed in a row.
+func (m *controllerManager) health() []controllerHealth {
+	var health []controllerHealth
+	for _, c := range m.controllers {
+		h := controllerHealth{Name: c.name, Healthy: true}
+		if r, ok := c.controller.(syncStatusReporter); ok {
+			h.Sync = r.SyncStatus()
+		}
+
+		var err error
+		if checker, ok := c.controller.(healthChecker); ok {
+			err = checker.Healthz()
+		} else if h.Sync != nil && *flagUnhealthyResyncFailures > 0 && h.Sync.ConsecutiveFailures >= *flagUnhealthyResyncFailures {
+			err = fmt.Errorf("%d consecutive resyncs failed: %s", h.Sync.ConsecutiveFailures, h.Sync.LastError)
+		}
+		if err != nil {
+			h.Healthy = false
+			h.Error = err.Error()
+		}
+		health = append(health, h)
+	}
+	return health
+}
+
+// serveControllers serves the health of each controller as JSON
+func (m *controllerManager) serveControllers(w http.ResponseWriter, r *http.Request) {
+	data, err := json.MarshalIndent(m.health(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// serveMetrics serves the health of each controller in the Prometheus text format, labelled by controller
+func (m *controllerManager) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+
+	health := m.health()
+	writeMetric := func(name string, kind string, help string, value func(h *controllerHealth) (float64, bool)) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for i := range health {
+			if v, ok := value(&health[i]); ok {
+				fmt.Fprintf(&b, "%s{controller=%q} %v\n", name, health[i].Name, v)
+			}
+		}
+	}
+
+	writeMetric("aws_controller_healthy", "gauge", "Whether the controller is healthy (1) or not (0).", func(h *controllerHealth) (float64, bool) {
+		if h.Healthy {
+			return 1, true
+		}
+		return 0, true
+	})
+	writeMetric("aws_controller_resync_successes_total", "counter", "Resyncs which succeeded.", func(h *controllerHealth) (float64, bool) {
+		if h.Sync == nil {
+			return 0, false
+		}
+		return float64(h.Sync.Successes), true
+	})
+	writeMetric("aws_controller_resync_failures_total", "counter", "Resyncs which failed.", func(h *controllerHealth) (float64, bool) {
+		if h.Sync == nil {
+			return 0, false
+		}
+		return float64(h.Sync.Failures), true
+	})
+	writeMetric("aws_controller_resync_consecutive_failures", "gauge", "Resyncs which have failed since the last success.", func(h *controllerHealth) (float64, bool) {
+		if h.Sync == nil {
+			return 0, false
+		}
+		return float64(h.Sync.ConsecutiveFailures), true
+	})
+	writeMetric("aws_controller_resync_last_success_timestamp_seconds", "gauge", "Time of the last successful resync.", func(h *controllerHealth) (float64, bool) {
+		if h.Sync == nil || h.Sync.LastSuccessTime.IsZero() {
+			return 0, false
+		}
+		return float64(h.Sync.LastSuccessTime.UnixNano()) / float64(time.Second), true
+	})
+
+	fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", "aws_controller_dns_truncated_addresses", "Addresses not published under a DNS name, because more instances share it than are published.", "aws_controller_dns_truncated_addresses", "gauge")
+	for _, c := range m.controllers {
+		if r, ok := c.controller.(reconcileStatusReporter); ok {
+			for _, t := range r.Status().DNSTruncated {
+				fmt.Fprintf(&b, "aws_controller_dns_truncated_addresses{controller=%q,record=%q} %d\n", c.name, t.Record, t.Members-t.Published)
+			}
+		}
+	}
+
+	paused := 0
+	if mutationPause.Paused() {
+		paused = 1
+	}
+	fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", "aws_controller_mutations_paused", "Whether mutating actions are paused (1) or not (0).", "aws_controller_mutations_paused", "gauge")
+	fmt.Fprintf(&b, "aws_controller_mutations_paused %d\n", paused)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, b.String())
+}
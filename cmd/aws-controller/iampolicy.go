@@ -0,0 +1,187 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+)
+
+// zoneRolePolicy collects the Route53 permissions when they are needed by zone-role-arn rather than by us
+var zoneRolePolicy *kopeaws.IAMPolicy
+
+// zoneID is the id of the hosted zone to scope the Route53 permissions to, if known
+var zoneID string
+
+// runPrintIAMPolicy prints the IAM policy needed by the controllers enabled by the flags
+func runPrintIAMPolicy(args []string) {
+	flags := flag.NewFlagSet(commandPrintIAMPolicy, flag.ExitOnError)
+	flags.StringVar(&zoneID, "zone-id", "", "Id of the hosted zone named by zone-name, to scope the Route53 permissions to (defaults to zone-name if it is an id)")
+	forZoneRole := flags.Bool("zone-role", false, "Print the policy needed by zone-role-arn, rather than by the controller")
+	flags.Parse(args)
+
+	if zoneID == "" && *flagZoneName != "" && !strings.Contains(*flagZoneName, ".") {
+		zoneID = *flagZoneName
+	}
+	if *flagConfigName != "" || *flagConfigFile != "" {
+		glog.Warningf("the policy is built from the flags; configuration from config-name or config-file is not included")
+	}
+
+	policy, err := buildIAMPolicy()
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+	if *forZoneRole {
+		if *flagZoneRoleARN == "" {
+			glog.Fatalf("zone-role-arn must be set with --zone-role")
+		}
+		policy = zoneRolePolicy
+	} else if *flagZoneRoleARN != "" {
+		glog.Infof("the Route53 permissions are needed by %s; print them with --zone-role", *flagZoneRoleARN)
+	}
+
+	data, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		glog.Fatalf("error serializing policy: %v", err)
+	}
+	fmt.Fprintln(os.Stdout, string(data))
+}
+
+// buildIAMPolicy returns the permissions needed by the controllers enabled by the flags (or by agent mode)
+func buildIAMPolicy() (*kopeaws.IAMPolicy, error) {
+	p := kopeaws.NewIAMPolicy()
+	zoneRolePolicy = kopeaws.NewIAMPolicy()
+
+	// We always describe ourselves, to find our VPC
+	p.Allow("*", "ec2:DescribeInstances")
+
+	if *flagAgent {
+		p.Allow("*", "ec2:CreateTags")
+	} else {
+		enabled, err := enabledControllers(*flagControllers, true)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range controllerDefinitions {
+			if enabled[d.name] && d.iamPolicy != nil {
+				d.iamPolicy(p)
+			}
+		}
+	}
+
+	if *flagSNSTopicARN != "" {
+		p.Allow(*flagSNSTopicARN, "sns:Publish")
+	}
+	if *flagLeaderElectDynamoDBTable != "" {
+		region := *flagRegion
+		if region == "" {
+			region = "*"
+		}
+		p.Allow(fmt.Sprintf("arn:%s:dynamodb:%s:*:table/%s", iamPartition(), region, *flagLeaderElectDynamoDBTable), "dynamodb:PutItem", "dynamodb:DeleteItem")
+	}
+	if *flagAssumeRoleARN != "" {
+		glog.Infof("the policy is needed by %s, which must trust the role we run as", *flagAssumeRoleARN)
+	}
+	return p, nil
+}
+
+// allowActions returns an iamPolicy function allowing actions which don't support resource-level permissions
+func allowActions(actions ...string) func(p *kopeaws.IAMPolicy) {
+	return func(p *kopeaws.IAMPolicy) {
+		p.Allow("*", actions...)
+	}
+}
+
+// allowDNS adds the Route53 permissions for publishing records in the zone; with zone-role-arn they are
+// needed by the zone role instead, and we need permission to assume it
+func allowDNS(p *kopeaws.IAMPolicy) {
+	if *flagDNSProvider != kopeaws.Route53ProviderName {
+		return
+	}
+	if *flagZoneRoleARN != "" {
+		p.Allow(*flagZoneRoleARN, "sts:AssumeRole")
+		p = zoneRolePolicy
+	}
+
+	zoneARN := fmt.Sprintf("arn:%s:route53:::hostedzone/", iamPartition())
+	if zoneID != "" && *flagZoneShards == "" {
+		zoneARN += strings.TrimPrefix(zoneID, "/hostedzone/")
+	} else {
+		// We find the zone (and with zone-shards, the delegated zones) by name
+		zoneARN += "*"
+		p.Allow("*", "route53:ListHostedZonesByName")
+		if *flagCreateZone {
+			p.Allow("*", "route53:CreateHostedZone")
+			p.Allow(zoneARN, "route53:ChangeTagsForResource")
+			if *flagCreateZonePrivate {
+				p.Allow("*", "ec2:DescribeVpcs")
+				p.Allow(zoneARN, "route53:AssociateVPCWithHostedZone")
+			}
+		}
+		if *flagZoneShards != "" {
+			p.Allow("*", "route53:CreateHostedZone")
+		} else {
+			glog.Warningf("allowing changes to every hosted zone; pass --zone-id to allow only the zone %q", *flagZoneName)
+		}
+	}
+	p.Allow(zoneARN, "route53:GetHostedZone", "route53:ListResourceRecordSets", "route53:ChangeResourceRecordSets")
+	if *flagDNSPrivateZoneName != "" || *flagDNSReverseZoneName != "" {
+		// The private and reverse zones are found by name, so their ids are not known in advance
+		otherZoneARN := fmt.Sprintf("arn:%s:route53:::hostedzone/*", iamPartition())
+		p.Allow("*", "route53:ListHostedZonesByName")
+		p.Allow(otherZoneARN, "route53:GetHostedZone", "route53:ListResourceRecordSets", "route53:ChangeResourceRecordSets")
+	}
+
+	// Health checks are created for failover and multi-value records, and their ids are not known in advance
+	p.Allow("*", "route53:CreateHealthCheck", "route53:DeleteHealthCheck", "route53:GetHealthCheck", "route53:ListHealthChecks")
+	p.Allow(fmt.Sprintf("arn:%s:route53:::healthcheck/*", iamPartition()), "route53:ChangeTagsForResource")
+
+	if *flagRoute53WaitTimeout != 0 {
+		p.Allow(fmt.Sprintf("arn:%s:route53:::change/*", iamPartition()), "route53:GetChange")
+	}
+}
+
+// iamPartition returns the partition of the region flag, defaulting to the standard partition
+func iamPartition() string {
+	if *flagRegion == "" {
+		return "aws"
+	}
+	return kopeaws.PartitionForRegion(*flagRegion)
+}
+
+// sqsQueueARN converts an SQS queue URL (https://sqs.<region>.amazonaws.com/<account>/<name>) to its ARN,
+// returning "*" if it cannot be parsed
+func sqsQueueARN(queueURL string) string {
+	u, err := url.Parse(queueURL)
+	if err == nil {
+		host := strings.Split(u.Host, ".")
+		path := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(host) >= 3 && host[0] == "sqs" && len(path) == 2 {
+			return kopeaws.BuildARN(host[1], "sqs", path[0], path[1])
+		}
+	}
+	glog.Warningf("cannot determine the ARN of queue %q; allowing access to every queue", queueURL)
+	return "*"
+}
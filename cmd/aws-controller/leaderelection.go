@@ -0,0 +1,117 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// The timings of leader election, as used by the kubernetes controller-manager
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// leaderElectionFunc runs onStartedLeading once we hold the leader lease, returning when ctx is done
+type leaderElectionFunc func(ctx context.Context, onStartedLeading func(ctx context.Context))
+
+// buildLeaderElection returns a leaderElectionFunc holding a Lease in the cluster, so that several replicas
+// can be deployed with only one of them running the controllers.  Losing the lease other than during
+// shutdown is fatal, because our controllers cannot be restarted.
+func buildLeaderElection(namespace string, name string) (leaderElectionFunc, error) {
+	id, err := leaderElectionIdentity()
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Client: mustBuildKubernetesClient().CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: id,
+		},
+	}
+
+	return func(ctx context.Context, onStartedLeading func(ctx context.Context)) {
+		glog.Infof("waiting to acquire lease %s/%s as %q", namespace, name, id)
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   leaseDuration,
+			RenewDeadline:   renewDeadline,
+			RetryPeriod:     retryPeriod,
+			ReleaseOnCancel: true,
+			Name:            name,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					glog.Infof("acquired lease %s/%s", namespace, name)
+					onStartedLeading(ctx)
+				},
+				OnStoppedLeading: func() {
+					if ctx.Err() != nil {
+						glog.Infof("released lease %s/%s", namespace, name)
+						return
+					}
+					glog.Fatalf("lost lease %s/%s", namespace, name)
+				},
+				OnNewLeader: func(identity string) {
+					if identity != id {
+						glog.Infof("lease %s/%s is held by %q", namespace, name, identity)
+					}
+				},
+			},
+		})
+	}, nil
+}
+
+// buildDynamoDBLeaderElection returns a leaderElectionFunc holding a lock in a DynamoDB table, for when
+// the API server is not available to hold a Lease (e.g. while we are run as a static pod to bootstrap it)
+func buildDynamoDBLeaderElection(cloud *kopeaws.AWSCloud, tableName string, name string) (leaderElectionFunc, error) {
+	id, err := leaderElectionIdentity()
+	if err != nil {
+		return nil, err
+	}
+	lock := cloud.NewDynamoDBLock(tableName, name, id)
+
+	return func(ctx context.Context, onStartedLeading func(ctx context.Context)) {
+		if err := lock.Run(ctx, onStartedLeading); err != nil {
+			glog.Fatalf("%v", err)
+		}
+	}, nil
+}
+
+// leaderElectionIdentity returns the name we hold leader election locks under
+func leaderElectionIdentity() (string, error) {
+	id, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("error getting hostname for leader election: %v", err)
+	}
+	return id, nil
+}
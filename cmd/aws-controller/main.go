@@ -17,31 +17,45 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/pprof"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/golang/glog"
 
-	"github.com/kopeio/aws-controller/pkg/awscontroller/instances"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/ipv6"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/secondaryips"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/selftest"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/servicedns"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/snapshots"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/spot"
 	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/dnsmasq"
+	"github.com/kopeio/aws-controller/pkg/kope/hostsfile"
 	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"github.com/kopeio/aws-controller/pkg/kope/kopedo"
+	"github.com/kopeio/aws-controller/pkg/kope/notify"
+	"github.com/kopeio/aws-controller/pkg/kope/utils"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 const (
 	healthPort = 10245
+	adminPort  = 10246
 )
 
 var (
-	// value overwritten during build. This can be used to resolve issues.
-	version = "0.5"
-	gitRepo = "https://github.com/kopeio/aws-controller"
-
 	//flags = pflag.NewFlagSet("", pflag.ExitOnError)
 
 	//resyncPeriod = flags.Duration("sync-period", 30*time.Second,
@@ -49,13 +63,223 @@ var (
 
 	resyncPeriod = 30 * time.Second
 
+	// instanceCacheMaxAge is how long the instance list is shared between controllers before it is relisted
+	instanceCacheMaxAge = 10 * time.Second
+
+	// maintenancePeriod is how often we check for EC2 scheduled events
+	maintenancePeriod = 5 * time.Minute
+
+	// recyclePeriod is how often we look for instances to recycle
+	recyclePeriod = 5 * time.Minute
+
+	// remediationPeriod is how often we check instance status checks
+	remediationPeriod = time.Minute
+
+	// recoveryPeriod is how often we reconcile recovery alarms
+	recoveryPeriod = 5 * time.Minute
+
+	// nodeSyncPeriod is how often we synchronize nodes with their instances
+	nodeSyncPeriod = time.Minute
+
+	// configStatusPeriod is how often we publish status to the config object
+	configStatusPeriod = time.Minute
+
+	// configFilePeriod is how often we check the config file for changes
+	configFilePeriod = 10 * time.Second
+
+	// gcPeriod is how often we look for orphaned cloud resources
+	gcPeriod = 10 * time.Minute
+
+	// snapshotPeriod is how often we check for scheduled snapshots which are due
+	snapshotPeriod = 5 * time.Minute
+
+	// natCheckPeriod is how often we health-check NAT instances and gateways
+	natCheckPeriod = 30 * time.Second
+
+	// driftReportPeriod is how often we publish drift reports
+	driftReportPeriod = time.Hour
+
+	// spotPollPeriod is how often agents check for spot notices (as recommended by AWS)
+	spotPollPeriod = 5 * time.Second
+
 	healthzPort = flag.Int("healthz-port", healthPort, "port for healthz endpoint.")
 
-	//kubeConfig = flags.String("kubeconfig", "", "Path to kubeconfig file with authorization information.")
+	flagBindAddress      = flag.String("bind-address", "", "Address to serve /healthz, /metrics and the other read-only endpoints on; empty serves on all interfaces")
+	flagAdminBindAddress = flag.String("admin-bind-address", "127.0.0.1", "Address to serve the admin endpoints (/stop, /pause, /resume and /debug/pprof) on")
+	flagAdminPort        = flag.Int("admin-port", adminPort, "Port to serve the admin endpoints on; 0 serves them alongside /healthz")
+
+	flagUnhealthyResyncFailures = flag.Int("unhealthy-resync-failures", 5, "Report a controller unhealthy once this many of its resyncs have failed in a row; 0 disables")
+
+	flagResyncJitter = flag.Float64("resync-jitter", 0.1, "Randomly extend each resync period by up to this fraction, so that the controllers of clusters sharing an AWS account don't resync (and hit API throttling) together")
+
+	flagKubeconfig = flag.String("kubeconfig", "", "Path to kubeconfig file with authorization information (defaults to in-cluster configuration, else $KUBECONFIG or ~/.kube/config)")
+
+	flagNodeName            = flag.String("node-name", os.Getenv("NODE_NAME"), "name of this node (in agent mode); if empty it is found by instance id")
+	flagZoneName            = flag.String("zone-name", "", "DNS zone name to use (if managing DNS)")
+	flagDNSProvider         = flag.String("dns-provider", kopeaws.Route53ProviderName, "DNS provider managing zone-name")
+	flagDNSInclude          = flag.String("dns-include", "", "Comma-separated glob patterns (e.g. *.nodes.example.com) of the only DNS names to manage; * also matches dots")
+	flagDNSExclude          = flag.String("dns-exclude", "", "Comma-separated glob patterns of DNS names not to manage, e.g. those managed by external-dns")
+	flagDNSPublicCNAME      = flag.Bool("dns-public-cname", false, "Publish "+kopeaws.TagNameKubernetesDnsPublic+" names as CNAMEs of the instance's public DNS name, instead of A records")
+	flagDNSPrivateZoneName  = flag.String("dns-private-zone-name", "", "Private zone (usually with the same name as zone-name) in which to publish the internal IPs of split-horizon names")
+	flagDNSReverseZoneName  = flag.String("dns-reverse-zone-name", "", "Private in-addr.arpa zone in which to publish PTR records for the instances' internal names")
+	flagEtcdSRVDomain       = flag.String("etcd-srv-domain", "", "Publish _etcd-server-ssl._tcp and _etcd-client-ssl._tcp SRV records for the masters under this domain, for etcd --discovery-srv")
+	flagServiceDNS          = flag.Bool("service-dns", false, "Publish DNS records (in zone-name) for Services annotated with "+servicedns.AnnotationHostname+", pointing at their load balancer or at the nodes")
+	flagServiceDNSInternal  = flag.Bool("service-dns-internal", false, "Publish the internal (rather than external) node addresses for NodePort Services")
+	flagDNSRoleGroupDomain  = flag.String("dns-role-group-domain", "", "Publish the internal IPs of the instances of each role as <role>s.<domain>, e.g. masters.<domain> and nodes.<domain>")
+	flagDNSHealthCheckPort  = flag.Int("dns-health-check-port", 443, "Port of the Route53 TCP health checks of "+kopeaws.TagNameKubernetesDnsFailoverPrimary+" and "+kopeaws.TagNameKubernetesDnsFailoverSecondary+" records")
+	flagDNSLatencyRouting   = flag.Bool("dns-latency-routing", false, "Publish DNS names as latency-based record sets, one per region (with --regions)")
+	flagDNSMultiValue       = flag.Bool("dns-multi-value", false, "Publish DNS names as multi-value answer record sets, with a health check of each public address")
+	flagDNSMaxChanges       = flag.Int("dns-max-changes", 0, "Refuse to change or remove more than this many existing DNS records in one reconcile (0 for no limit)")
+	flagDNSMaxChangePercent = flag.Int("dns-max-change-percent", 50, "Refuse to change or remove more than this percentage of the existing DNS records in one reconcile (0 for no limit)")
+	flagDNSAllowMassChanges = flag.Bool("dns-allow-mass-changes", false, "Override dns-max-changes and dns-max-change-percent")
+	flagClusterID           = flag.String("cluster-id", "", "cluster id")
+	flagCloud               = flag.String("cloud", cloudAWS, "Cloud the cluster runs on: aws, or gce (which supports only the cloud-neutral controllers and clouddns)")
+	flagGCEProject          = flag.String("gce-project", "", "GCP project of the cluster (defaults to our own project, from the metadata server)")
+	flagRegion              = flag.String("region", "", "AWS region; if set the EC2 metadata service is not used, so cluster-id must also be set")
+	flagRegions             = flag.String("regions", "", "Comma-separated list of regions whose instances should be managed (defaults to our own region)")
+	flagVPCID               = flag.String("vpc-id", "", "Only manage instances in this VPC (defaults to the VPC we are running in)")
+	flagAllVPCs             = flag.Bool("all-vpcs", false, "Manage cluster instances in all VPCs, rather than only our own")
+
+	flagAWSProfile           = flag.String("aws-profile", "", "Name of the AWS profile (in the shared credentials/config files) to use")
+	flagAWSStaticCredentials = flag.Bool("aws-static-credentials", false, "Only use static AWS credentials from the AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY environment variables")
+	flagWebIdentityTokenFile = flag.String("web-identity-token-file", os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), "Path to a web identity token (e.g. an IRSA projected service account token) to exchange for role credentials")
+	flagWebIdentityRoleARN   = flag.String("web-identity-role-arn", os.Getenv("AWS_ROLE_ARN"), "ARN of the IAM role to assume with the web identity token")
+	flagAssumeRoleARN        = flag.String("assume-role-arn", "", "ARN of an IAM role to assume (via STS) for all AWS API calls")
+	flagAssumeRoleExternalID = flag.String("assume-role-external-id", "", "External ID to use when assuming assume-role-arn")
+
+	flagEC2Endpoint           = flag.String("ec2-endpoint", "", "Override the EC2 API endpoint (e.g. for testing against LocalStack, or a VPC endpoint)")
+	flagDigitalOceanTokenFile = flag.String("digitalocean-token-file", "", "File containing the DigitalOcean API token, for the digitalocean dns-provider; defaults to $DIGITALOCEAN_TOKEN")
+	flagHostsFile             = flag.String("hosts-file", "/etc/hosts", "File to write records to, for the hosts-file dns-provider")
+	flagDnsmasqHostsFile      = flag.String("dnsmasq-hosts-file", "", "File to write A records to for dnsmasq to load with addn-hosts, for the dnsmasq dns-provider")
+	flagDnsmasqConfFile       = flag.String("dnsmasq-conf-file", "", "File to write CNAME and SRV records to as dnsmasq configuration, for the dnsmasq dns-provider; if not set, they are not published")
+	flagDnsmasqPIDFile        = flag.String("dnsmasq-pid-file", "", "Pid file of the dnsmasq process to send SIGHUP when dnsmasq-hosts-file changes")
+	flagRoute53Endpoint       = flag.String("route53-endpoint", "", "Override the Route53 API endpoint")
+	flagMetadataEndpoint      = flag.String("metadata-endpoint", "", "Override the EC2 instance metadata service endpoint")
+	flagInsecureSkipTLSVerify = flag.Bool("aws-insecure-skip-tls-verify", false, "Disable TLS certificate verification of AWS endpoints (for testing only)")
+
+	flagAWSProxy   = flag.String("aws-proxy", "", "URL of an HTTP(S) proxy to use for AWS API requests (the metadata service is never proxied)")
+	flagAWSNoProxy = flag.String("aws-no-proxy", "", "Comma-separated hosts (or domain suffixes starting with '.') which should bypass aws-proxy")
 
-	//nodeName       = flags.String("node-name", "", "name of this node")
-	flagZoneName  = flag.String("zone-name", "", "DNS zone name to use (if managing DNS)")
-	flagClusterID = flag.String("cluster-id", "", "cluster id")
+	flagEC2QPS       = flag.Float64("ec2-api-qps", 10, "Maximum sustained rate of EC2 API requests (0 to disable client-side rate limiting)")
+	flagEC2Burst     = flag.Int("ec2-api-burst", 20, "Maximum burst of EC2 API requests")
+	flagRoute53QPS   = flag.Float64("route53-api-qps", 2, "Maximum sustained rate of Route53 API requests (0 to disable client-side rate limiting)")
+	flagRoute53Burst = flag.Int("route53-api-burst", 5, "Maximum burst of Route53 API requests")
+
+	flagForceOverwrite = flag.Bool("force-overwrite", false, "Overwrite (and take ownership of) existing DNS records which are not marked as ours (of instances, services, DNS aliases or the API load balancer), e.g. once when upgrading from a version which did not mark its records")
+
+	flagRoute53ChangeQPS = flag.Float64("route53-change-qps", 1, "Maximum sustained rate of Route53 ChangeResourceRecordSets requests (0 to disable)")
+
+	flagDNSMaxRecordsPerName = flag.Int("dns-max-records-per-name", 0, "Publish at most this many addresses under each name, choosing the oldest instances spread across availability zones (0 for no limit)")
+	flagDNSCoalesceWindow    = flag.Duration("dns-coalesce-window", 0, "If set, buffer DNS changes for this long (e.g. 10s) after they are first seen, and apply them as a single batch")
+	flagDNSVerifyWindow      = flag.Duration("dns-verify-window", 0, "If set, check that applied DNS changes are served by the zone's authoritative name servers within this window, reporting and reapplying those which are not")
+	flagDNSVerifyNameServers = flag.String("dns-verify-nameservers", "", "Comma-separated name servers to verify DNS changes against, instead of the zone's authoritative name servers; required to verify changes to a private zone")
+	flagRoute53WaitTimeout   = flag.Duration("route53-wait-timeout", 0, "If set, wait for each Route53 change to reach INSYNC, failing the reconcile if it takes longer than this")
+
+	flagZoneRoleARN        = flag.String("zone-role-arn", "", "ARN of an IAM role to assume for managing the DNS zone (e.g. a zone owned by another account)")
+	flagZoneRoleExternalID = flag.String("zone-role-external-id", "", "External ID to use when assuming zone-role-arn")
+
+	flagDetailedMonitoring = flag.String("detailed-monitoring", "", "Enforce detailed (1-minute) CloudWatch monitoring on cluster instances: true or false (empty to leave unmanaged)")
+
+	flagRequireIMDSv2             = flag.Bool("require-imdsv2", false, "Require IMDSv2 (session tokens) for the instance metadata service on cluster instances")
+	flagIMDSHopLimit              = flag.Int64("imds-hop-limit", 0, "Enforce this PUT response hop limit for the instance metadata service (0 to leave unmanaged); 2 allows access from containers")
+	flagMetadataOptionsReportOnly = flag.Bool("imds-report-only", false, "Only report instances whose metadata options don't match, without changing them")
+
+	flagMasterTerminationProtection = flag.Bool("master-termination-protection", false, "Enable API termination protection (DisableApiTermination) on master instances")
+	flagNodeTerminationProtection   = flag.String("node-termination-protection", "", "Enforce API termination protection on node instances: true or false (empty to leave unmanaged)")
+
+	flagLeaderElect          = flag.Bool("leader-elect", false, "Hold a Lease in the cluster while running the controllers, so that several replicas can be deployed for availability")
+	flagLeaderElectNamespace = flag.String("leader-elect-namespace", "kube-system", "Namespace of the leader election Lease")
+	flagLeaderElectName      = flag.String("leader-elect-name", "aws-controller", "Name of the leader election Lease (or DynamoDB lock)")
+
+	flagLeaderElectDynamoDBTable = flag.String("leader-elect-dynamodb-table", "", "DynamoDB table (with a string partition key LockName) to hold the leader election lock in, rather than a Lease, for running without the API server; implies leader-elect")
+
+	flagExitLinger      = flag.Duration("exit-linger", 0, "How long to keep running (serving the admin endpoints) after the controllers stop, before exiting; 0 exits immediately")
+	flagShutdownTimeout = flag.Duration("shutdown-timeout", kope.DefaultShutdownTimeout, "How long to wait on shutdown for each controller's in-flight changes to finish before aborting them")
+	flagInstanceWorkers = flag.Int("instance-workers", 10, "Number of instances reconciled in parallel; their AWS calls remain subject to ec2-api-qps")
+
+	flagStateFile = flag.String("state-file", "", "Path to a file (e.g. on an emptyDir volume) to save the instances and DNS state to after each resync, so that a restarted controller need not reconcile everything again")
+
+	flagControllers = flag.String("controllers", "*", "Comma-separated controllers to run, applied in order: * for the controllers enabled by their own flags, <name> to enable a controller, -<name> to disable one")
+
+	flagConfigName = flag.String("config-name", "", "Name of an AWSControllerConfig object to watch for configuration, overriding the flags (empty to use only the flags)")
+	flagConfigFile = flag.String("config-file", "", "Path to a configuration file (e.g. from a mounted ConfigMap) holding an AWSControllerConfig spec in YAML, which is reloaded when it changes")
+	flagFlagsFile  = flag.String("flags-file", "", "Path to a file of additional flags, one --name=value per line; flags on the command line take precedence.  On SIGHUP the file is reread and the configuration flags (zone-name, dns-*, filter-tag, ...) applied without a restart")
+
+	flagCreateZone        = flag.Bool("create-zone", false, "Create the hosted zone named by zone-name at startup if it does not exist, tagged as owned by the cluster; a public zone is delegated from its parent hosted zone, if there is one")
+	flagCreateZonePrivate = flag.Bool("create-zone-private", false, "Create the zone as a private zone, associated with our VPC")
+
+	flagZoneShards = flag.String("zone-shards", "", "Comma-separated subdomains of the DNS zone (e.g. nodes,masters) whose records are sharded into a delegated hosted zone each")
+
+	flagAgent                = flag.Bool("agent", false, "Run in node agent mode (e.g. as a DaemonSet), running only node-local watchers such as the spot interruption watcher")
+	flagSpotDrainOnRebalance = flag.Bool("spot-drain-on-rebalance", false, "Drain the node on spot rebalance recommendations, not only on interruption notices")
+	flagSpotDrainGracePeriod = flag.Duration("spot-drain-grace-period", 0, "Override the termination grace period of pods evicted ahead of a spot interruption")
+
+	flagMaintenanceEvents      = flag.Bool("maintenance-events", false, "Cordon nodes whose instances have EC2 scheduled reboot/retirement/maintenance events")
+	flagMaintenanceDNSWithdraw = flag.Duration("maintenance-dns-withdraw", 0, "Withdraw instances from DNS this long before their scheduled maintenance (0 to disable)")
+
+	flagLifecycleQueueURL     = flag.String("lifecycle-queue-url", "", "URL of an SQS queue receiving autoscaling lifecycle hook notifications; terminating instances are drained before the lifecycle action is completed")
+	flagLifecycleDrainTimeout = flag.Duration("lifecycle-drain-timeout", 10*time.Minute, "Maximum time to spend draining a terminating instance")
+
+	flagRecycleMaxAge        = flag.Duration("recycle-max-age", 0, "Cordon, drain and terminate instances older than this (0 to disable)")
+	flagRecycleMaxConcurrent = flag.Int("recycle-max-concurrent", 1, "Maximum number of instances recycled at once in each availability zone")
+	flagRecycleRoles         = flag.String("recycle-roles", kopeaws.RoleNode, "Comma-separated instance roles (from k8s.io/role/<role> tags) which are recycled")
+
+	flagRemediateStatusChecks = flag.String("remediate-status-checks", "", "Remediate instances failing EC2 status checks: reboot or terminate (empty to disable)")
+	flagRemediateThreshold    = flag.Duration("remediate-threshold", 10*time.Minute, "How long status checks must be failing before an instance is remediated")
+	flagRemediateReportOnly   = flag.Bool("remediate-report-only", false, "Only report the remediation that would be applied to instances failing status checks")
+	flagTaintImpaired         = flag.Bool("taint-impaired", false, "Taint (NoSchedule) nodes whose instances have impaired EC2 system status, removing the taint on recovery")
+
+	flagRecoveryAlarms = flag.Bool("recovery-alarms", false, "Create CloudWatch alarms which recover master instances (ec2:recover) when their host fails")
+
+	flagAnnotateNodes = flag.Bool("annotate-nodes", false, "Annotate nodes with their instance id, type, AMI, lifecycle, availability zone, subnet and security groups")
+
+	flagMasterService     = flag.String("master-service", "", "Maintain a Service and EndpointSlice (as namespace/name) holding the private IPs of master instances (empty to disable)")
+	flagMasterServicePort = flag.Int("master-service-port", 443, "Port of the API server on master instances, for master-service")
+
+	flagAPILoadBalancer         = flag.Bool("api-load-balancer", false, "Create and maintain a network load balancer for the Kubernetes API across the master instances")
+	flagAPILoadBalancerInternal = flag.Bool("api-load-balancer-internal", false, "Make the API load balancer internal, rather than internet-facing")
+	flagAPILoadBalancerPort     = flag.Int64("api-load-balancer-port", 443, "Port of the API server on the masters, and of the load balancer listener")
+	flagAPILoadBalancerDNSName  = flag.String("api-load-balancer-dns-name", "", "DNS name (in zone-name) to publish as an alias to the API load balancer")
+
+	flagReportOnly        = flag.Bool("report-only", false, "Only report the changes the instances controller would make to instances and DNS, without making them; other controllers are not affected (see paused)")
+	flagPaused            = flag.Bool("paused", false, "Start with all mutating actions (against the cloud, DNS and Kubernetes) paused, until resumed with POST /resume")
+	flagDriftReportURL    = flag.String("drift-report-url", "", "Periodically publish a drift report to s3://bucket/prefix or by POSTing it to an http(s) URL")
+	flagDriftReportPeriod = flag.Duration("drift-report-period", driftReportPeriod, "How often to publish drift reports")
+
+	flagInventoryGRPCAddress = flag.String("inventory-grpc-address", "", "Address (e.g. :10247, which listens on localhost only) to serve the Inventory gRPC API on, for other components to query the cluster instances and DNS records; it is served without TLS or authentication")
+	flagSSMInventoryPath     = flag.String("ssm-inventory-path", "", "SSM Parameter Store path (e.g. /clusters/<cluster-id>) under which to publish each instance, as <path>/<role>s/<instance-id>")
+	flagS3InventoryURL       = flag.String("s3-inventory-url", "", "Upload a timestamped JSON snapshot of the cluster instances and DNS records to s3://bucket/prefix whenever they change")
+	flagInventoryAPI         = flag.Bool("inventory-api", false, "Serve the cluster instances and DNS records as JSON at /api/v1/instances and /api/v1/dns on the healthz port")
+
+	flagCloudWatchMetricsNamespace = flag.String("cloudwatch-metrics-namespace", "", "Publish custom CloudWatch metrics (instances by role, state and zone, DNS records, reconcile errors) under this namespace, with a ClusterId dimension")
+	flagCloudWatchMetricsPeriod    = flag.Duration("cloudwatch-metrics-period", time.Minute, "How often to publish CloudWatch metrics")
+
+	flagSNSTopicARN = flag.String("sns-topic-arn", "", "ARN of an SNS topic to notify of every change the controller makes to AWS")
+
+	flagNotifySlackURL   = flag.String("notify-slack-url", "", "Slack-compatible incoming webhook URL to notify of significant actions, such as recycling instances or failovers")
+	flagNotifyWebhookURL = flag.String("notify-webhook-url", "", "URL to POST a JSON event to for each significant action, such as recycling instances or failovers")
+
+	flagNodeSecurityGroup         = flag.String("node-security-group", "", "id or name of the node security group; its self-referencing all-traffic rule is always enforced")
+	flagGCLoadBalancers           = flag.Bool("gc-load-balancers", false, "Delete the cluster's classic ELBs whose Service no longer exists; load balancers not tagged with a Service are never deleted")
+	flagGCLoadBalancerGracePeriod = flag.Duration("gc-load-balancer-grace-period", time.Hour, "How long a load balancer must be orphaned before it is deleted")
+	flagGCVolumesTTL              = flag.Duration("gc-volumes-ttl", 0, "Delete the cluster's EBS volumes once they have been detached this long (0 to disable)")
+	flagGCVolumesSnapshot         = flag.Bool("gc-volumes-snapshot", false, "Snapshot volumes before garbage collecting them")
+	flagCostAllocationReportOnly  = flag.Bool("cost-allocation-report-only", false, "Only report the resources missing cost allocation tags, without tagging them")
+	flagGCVolumesReportOnly       = flag.Bool("gc-volumes-report-only", false, "Only log the volumes which would be garbage collected")
+	flagSnapshotSchedules         = flag.Bool("snapshot-schedules", false, "Snapshot volumes tagged with "+snapshots.TagNameSchedule)
+	flagSnapshotRetain            = flag.Int("snapshot-retain", 7, "Number of scheduled snapshots to retain per volume, unless overridden by the "+snapshots.TagNameRetain+" tag")
+	flagIPForwarding              = flag.Bool("ip-forwarding", false, "Enable IP forwarding (canIpForward on GCE) on running instances")
+	flagIPForwardingRoles         = flag.String("ip-forwarding-roles", "", "Comma-separated instance roles on which ip-forwarding is enabled (empty for all)")
+	flagNATFailover               = flag.Bool("nat-failover", false, "Fail over the cluster's route tables when their NAT instance or gateway fails")
+	flagNATFailureThreshold       = flag.Int("nat-failure-threshold", 2, "Consecutive failed health checks before a NAT is considered failed")
+	flagEIPPool                   = flag.String("eip-pool", "", "Assign elastic IPs tagged "+kopeaws.TagNameEIPPool+"=<pool> to instances with the egress role")
+	flagEIPPoolRole               = flag.String("eip-pool-role", kopeaws.RoleEgress, "Role of the instances which are assigned addresses from the EIP pool")
+	flagSecondaryIPs              = flag.Bool("manage-secondary-ips", false, "Provision network interfaces and secondary private IPs on node instances, for CNI plugins")
+	flagSecondaryIPsPerNode       = flag.Int64("secondary-ips-per-node", 0, "Default number of secondary private IPs per node, unless overridden by the "+secondaryips.AnnotationSecondaryIPs+" annotation")
+	flagNetworkInterfacesPerNode  = flag.Int64("network-interfaces-per-node", 1, "Default number of network interfaces per node, unless overridden by the "+secondaryips.AnnotationNetworkInterfaces+" annotation")
+	flagAssignIPv6                = flag.Bool("assign-ipv6", false, "Assign IPv6 addresses to node instances in dual-stack subnets, recording them in the "+ipv6.AnnotationIPv6Addresses+" annotation")
+	flagSelfTestTag               = flag.String("selftest-tag", "k8s.io/aws-controller/selftest", "selftest: tag marking instances whose attributes may be modified by the test")
+	flagSelfTestDNSPrefix         = flag.String("selftest-dns-prefix", "aws-controller-selftest", "selftest: name (within the DNS zone) of the test record to create and delete")
 	//systemUUIDPath = flags.String("system-uuid", "", "path to file containing system-uuid (as set in node status)")
 	//bootIDPath     = flags.String("boot-id", "", "path to file containing boot-id (as set in node status)")
 	//providerID     = flags.String("provider", "gre", "route backend to use")
@@ -65,6 +289,16 @@ var (
 	//	`Optional, if this controller is running in a kubernetes cluster, use the
 	//	 pod secrets for creating a Kubernetes client.`)
 
+	flagFilterTags            = newKeyValueFlag("filter-tag", "Only manage instances with this tag, as key=value (repeatable)")
+	flagRequiredTags          = newKeyValueFlag("required-tag", "Ensure every cluster instance has this tag, as key=value (repeatable); the value is a template, e.g. {{.ClusterID}}-{{.Role}}")
+	flagCostAllocationTags    = newKeyValueFlag("cost-allocation-tag", "Ensure the cluster's instances, volumes, network interfaces and load balancers have this tag, as key=value (repeatable); existing values are kept, and with an empty value the tag is only reported if missing, at /api/v1/cost-allocation")
+	flagNameTemplate          = flag.String("name-template", "", "Template for the Name tag of every cluster instance, e.g. {{.ClusterID}}-{{.Role}}-{{.AZ}}-{{.LaunchIndex}}")
+	flagNodeLabelTags         = newKeyValueFlag("node-label-tag", "Copy this node label onto the node's instance as a tag, as label=tag-key (repeatable); an empty tag key uses the label key")
+	flagNodeAnnotationTags    = newKeyValueFlag("node-annotation-tag", "Copy this node annotation onto the node's instance as a tag, as annotation=tag-key (repeatable); an empty tag key uses the annotation key")
+	flagDNSAliases            = newKeyValueFlag("dns-alias", "Publish a Route53 alias record pointing at a load balancer, as <name>=<elbv2-arn>, <name>=tag:<key>=<value> or <name>=elb:<classic-elb-name> (repeatable)")
+	flagTargetGroups          = newKeyValueFlag("target-group", "Register matching instances in an ELBv2 target group, as <arn>=role:<role> or <arn>=node-label:<key>[=<value>] (repeatable)")
+	flagTargetGroupAllowEmpty = flag.Bool("target-group-allow-empty", false, "Allow deregistering every instance of a target group when none match its target-group binding")
+
 	profiling = flag.Bool("profiling", true, `Enable profiling via web interface host:port/debug/pprof/`)
 )
 
@@ -73,81 +307,423 @@ func main() {
 	flag.Set("logtostderr", "true")
 	flag.Parse()
 
-	glog.Infof("Using build: %v - %v", gitRepo, version)
+	if *flagFlagsFile != "" {
+		if err := loadFlagsFile(*flagFlagsFile); err != nil {
+			glog.Fatalf("%v", err)
+		}
+	}
+
+	if *flagResyncJitter < 0 {
+		glog.Fatalf("resync-jitter must not be negative")
+	}
+	utils.ResyncJitter = *flagResyncJitter
+	if *flagInstanceWorkers < 1 {
+		glog.Fatalf("instance-workers must be at least 1")
+	}
+
+	if *flagPaused {
+		mutationPause.Pause(kope.PauseSourceAdmin, "started with --paused")
+	}
+
+	command := flag.Arg(0)
+	switch command {
+	case commandVersion:
+		runVersion(flag.Args()[1:])
+		return
+	case commandPrintIAMPolicy:
+		runPrintIAMPolicy(flag.Args()[1:])
+		return
+	case "", commandRun, commandValidate, commandDumpState, commandSelfTest:
+	default:
+		glog.Fatalf("unknown command %q; expected one of %s", command, strings.Join(commands, ","))
+	}
 
-	cloud, err := kopeaws.NewAWSCloud()
+	glog.Infof("Using build: %s", getBuildInfo())
+
+	registerDigitalOceanDNSProvider()
+	hostsfile.RegisterHostsFileProvider(*flagHostsFile)
+	dnsmasq.RegisterDnsmasqProvider(dnsmasq.DnsmasqOptions{
+		HostsFile: *flagDnsmasqHostsFile,
+		ConfFile:  *flagDnsmasqConfFile,
+		PIDFile:   *flagDnsmasqPIDFile,
+	})
+
+	switch *flagCloud {
+	case cloudAWS:
+	case cloudGCE:
+		if command != "" && command != commandRun {
+			glog.Fatalf("the %s command is only supported on AWS", command)
+		}
+		runControllers(buildGCEControllers())
+		return
+	default:
+		glog.Fatalf("unknown cloud %q; expected %q or %q", *flagCloud, cloudAWS, cloudGCE)
+	}
+
+	sessionOptions := kopeaws.SessionOptions{
+		Profile:              *flagAWSProfile,
+		StaticCredentials:    *flagAWSStaticCredentials,
+		WebIdentityTokenFile: *flagWebIdentityTokenFile,
+		WebIdentityRoleARN:   *flagWebIdentityRoleARN,
+		AssumeRoleARN:        *flagAssumeRoleARN,
+		AssumeRoleExternalID: *flagAssumeRoleExternalID,
+
+		InsecureSkipTLSVerify: *flagInsecureSkipTLSVerify,
+
+		ProxyURL: *flagAWSProxy,
+
+		Pause: mutationPause,
+	}
+	if *flagAWSNoProxy != "" {
+		sessionOptions.NoProxy = strings.Split(*flagAWSNoProxy, ",")
+	}
+
+	var notifier *kopeaws.SNSNotifier
+	if *flagSNSTopicARN != "" {
+		n, err := kopeaws.NewSNSNotifier(sessionOptions, *flagSNSTopicARN)
+		if err != nil {
+			glog.Fatalf("error building SNS notifier: %v", err)
+		}
+		notifier = n
+		sessionOptions.MutationNotifier = n
+	}
+
+	var regions []string
+	if *flagRegions != "" {
+		regions = strings.Split(*flagRegions, ",")
+	}
+
+	cloudOptions := kopeaws.AWSCloudOptions{
+		Region:     *flagRegion,
+		Regions:    regions,
+		ClusterID:  *flagClusterID,
+		FilterTags: flagFilterTags,
+		VPCID:      *flagVPCID,
+		AllVPCs:    *flagAllVPCs,
+		Session:    sessionOptions,
+
+		EC2Endpoint:      *flagEC2Endpoint,
+		MetadataEndpoint: *flagMetadataEndpoint,
+
+		EC2RateLimit: kopeaws.RateLimit{QPS: float32(*flagEC2QPS), Burst: *flagEC2Burst},
+	}
+	cloud, err := kopeaws.NewAWSCloud(context.Background(), cloudOptions)
 	if err != nil {
 		glog.Fatalf("error building cloud: %v", err)
 	}
 
-	clusterID := *flagClusterID
-	if clusterID == "" && cloud != nil {
-		clusterID = cloud.ClusterID()
-	}
-	if clusterID == "" {
+	if cloud.ClusterID() == "" {
 		glog.Fatalf("cluster-id flag must be set")
 	}
 
-	var dns kope.DNSProvider
+	if notifier != nil {
+		notifier.ClusterID = cloud.ClusterID()
+	}
+
+	events := buildEventNotifier(cloud.ClusterID())
+
 	zoneName := *flagZoneName
-	if zoneName != "" {
-		dns = kopeaws.NewRoute53DNSProvider(zoneName)
+	route53Options := kopeaws.Route53Options{
+		Session:         sessionOptions,
+		RoleARN:         *flagZoneRoleARN,
+		RoleExternalID:  *flagZoneRoleExternalID,
+		Region:          cloud.Region(),
+		Endpoint:        *flagRoute53Endpoint,
+		RateLimit:       kopeaws.RateLimit{QPS: float32(*flagRoute53QPS), Burst: *flagRoute53Burst},
+		WaitTimeout:     *flagRoute53WaitTimeout,
+		ChangeRateLimit: kopeaws.RateLimit{QPS: float32(*flagRoute53ChangeQPS), Burst: 1},
+	}
+	var zoneShards []string
+	if *flagZoneShards != "" {
+		zoneShards = strings.Split(*flagZoneShards, ",")
+	}
+	kopeaws.RegisterRoute53DNSProvider(route53Options, zoneShards)
+	if !isDNSProvider(*flagDNSProvider) {
+		glog.Fatalf("unknown dns-provider %q; known providers are %s", *flagDNSProvider, strings.Join(kope.DNSProviderNames(), ","))
+	}
+	if *flagDNSInclude != "" || *flagDNSExclude != "" {
+		dnsNameFilter = &kope.DNSNameFilter{}
+		if *flagDNSInclude != "" {
+			dnsNameFilter.Include = strings.Split(*flagDNSInclude, ",")
+		}
+		if *flagDNSExclude != "" {
+			dnsNameFilter.Exclude = strings.Split(*flagDNSExclude, ",")
+		}
+		if err := dnsNameFilter.Validate(); err != nil {
+			glog.Fatalf("%v", err)
+		}
 	}
 
-	c := instances.NewInstancesController(cloud, resyncPeriod, dns)
+	switch command {
+	case commandSelfTest:
+		runSelfTest(cloud, zoneName, route53Options)
+		return
+	case commandValidate:
+		runValidate(cloud, events)
+		return
+	case commandDumpState:
+		runDumpState(cloud, events)
+		return
+	}
 
-	sourceDestCheck := false
-	c.SourceDestCheck = &sourceDestCheck
+	var m *controllerManager
+	if *flagAgent {
+		m = newControllerManager()
+		m.add("spot-interruption", buildAgent(cloud))
+	} else {
+		if *flagCreateZone {
+			if err := ensureHostedZone(cloud, zoneName, route53Options); err != nil {
+				glog.Fatalf("%v", err)
+			}
+		}
+
+		ctx, err := buildControllerContext(cloud, events)
+		if err != nil {
+			glog.Fatalf("%v", err)
+		}
+		m, err = buildControllers(ctx, *flagControllers)
+		if err != nil {
+			glog.Fatalf("%v", err)
+		}
+		go handleSighup(ctx.reload)
+	}
 
-	go registerHandlers(c)
-	go handleSigterm(c)
+	if notifier != nil {
+		go notifier.Run(wait.ContextForChannel(m.stopCh))
+	}
+
+	runControllers(m)
+}
+
+// ensureHostedZone creates the hosted zone named by zone-name, once at startup, if it does not exist
+func ensureHostedZone(cloud *kopeaws.AWSCloud, zoneName string, route53Options kopeaws.Route53Options) error {
+	creation := kopeaws.ZoneCreationOptions{
+		ClusterID: cloud.ClusterID(),
+		Tags:      map[string]string{kopeaws.TagNameKubernetesCluster: cloud.ClusterID()},
+	}
+	if *flagCreateZonePrivate {
+		if cloud.VPCID() == "" {
+			return fmt.Errorf("create-zone-private requires a VPC; pass --vpc-id")
+		}
+		creation.PrivateVPCID = cloud.VPCID()
+		creation.PrivateVPCRegion = cloud.Region()
+	}
+	return kopeaws.EnsureHostedZone(context.Background(), zoneName, route53Options, creation)
+}
+
+// runControllers runs the controllers until they are stopped, by a signal or the /stop endpoint, then exits
+// (after lingering for exit-linger, if set)
+func runControllers(m *controllerManager) {
+	go registerHandlers(m)
+	go handleTermination(m)
+
+	m.Run()
+
+	// Waits for the controllers to finish stopping, if they are still being stopped
+	exitCode := 0
+	if err := m.Stop(); err != nil {
+		glog.Warningf("Error during shutdown: %v", err)
+		exitCode = 1
+	}
+
+	if *flagExitLinger != 0 {
+		glog.Infof("Controllers stopped; exiting in %s", *flagExitLinger)
+		time.Sleep(*flagExitLinger)
+	}
+	glog.Infof("Exiting with %v", exitCode)
+	glog.Flush()
+	os.Exit(exitCode)
+}
+
+// registerDigitalOceanDNSProvider registers DigitalOcean DNS, which can be used on any cloud
+func registerDigitalOceanDNSProvider() {
+	token := os.Getenv("DIGITALOCEAN_TOKEN")
+	if *flagDigitalOceanTokenFile != "" {
+		data, err := ioutil.ReadFile(*flagDigitalOceanTokenFile)
+		if err != nil {
+			glog.Fatalf("error reading digitalocean-token-file: %v", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	kopedo.RegisterDigitalOceanDNSProvider(kopedo.DigitalOceanOptions{Token: token})
+}
+
+// isDNSProvider returns true if name is a registered DNS provider
+func isDNSProvider(name string) bool {
+	for _, n := range kope.DNSProviderNames() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// buildEventNotifier builds the notifier for significant actions from the flags, or returns nil if none are configured
+func buildEventNotifier(clusterID string) *notify.Notifier {
+	n := &notify.Notifier{ClusterID: clusterID}
+	if *flagNotifySlackURL != "" {
+		sink, err := notify.NewSlackSink(*flagNotifySlackURL)
+		if err != nil {
+			glog.Fatalf("error building slack notifier: %v", err)
+		}
+		n.Sinks = append(n.Sinks, sink)
+	}
+	if *flagNotifyWebhookURL != "" {
+		sink, err := notify.NewWebhookSink(*flagNotifyWebhookURL)
+		if err != nil {
+			glog.Fatalf("error building webhook notifier: %v", err)
+		}
+		n.Sinks = append(n.Sinks, sink)
+	}
+	if len(n.Sinks) == 0 {
+		return nil
+	}
+	return n
+}
+
+// buildAgent builds the node-local watcher run in agent mode
+func buildAgent(cloud *kopeaws.AWSCloud) controller {
+	if cloud.InstanceID() == "" {
+		glog.Fatalf("agent mode requires the ec2 metadata service")
+	}
 
-	c.Run()
+	w := spot.NewSpotInterruptionWatcher(cloud, mustBuildKubernetesClient(), *flagNodeName, spotPollPeriod)
+	w.DrainOnRebalance = *flagSpotDrainOnRebalance
+	w.DrainGracePeriod = *flagSpotDrainGracePeriod
+	return w
+}
+
+// runSelfTest exercises the AWS code paths against sandbox resources, exiting non-zero on failure
+func runSelfTest(cloud *kopeaws.AWSCloud, zoneName string, route53Options kopeaws.Route53Options) {
+	t := &selftest.SelfTest{
+		Cloud:      cloud,
+		SandboxTag: *flagSelfTestTag,
+	}
+	if zoneName != "" {
+		dns, err := kopeaws.NewRoute53DNSProvider(zoneName, route53Options)
+		if err != nil {
+			glog.Fatalf("error building DNS provider: %v", err)
+		}
+		t.DNS = dns
+		t.DNSPrefix = *flagSelfTestDNSPrefix
+	}
 
-	for {
-		glog.Infof("Handled quit, awaiting pod deletion")
-		time.Sleep(30 * time.Second)
+	if err := t.Run(context.Background()); err != nil {
+		glog.Fatalf("%v", err)
 	}
+	glog.Infof("All selftests passed")
 }
 
-func registerHandlers(c *instances.InstancesController) {
+// registerHandlers serves our endpoints, exiting if they cannot be served.  The admin endpoints, which change
+// our behaviour or expose internals, are served separately (by default only on localhost) from those which
+// only report on us, so that only the latter need be exposed to the cluster.
+func registerHandlers(m *controllerManager) {
 	mux := http.NewServeMux()
-	// TODO: healthz
-	//healthz.InstallHandler(mux, lbc.nginx)
+	adminMux := mux
+	if *flagAdminPort != 0 {
+		adminMux = http.NewServeMux()
+	}
 
-	http.HandleFunc("/build", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := m.Healthz(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
 		w.WriteHeader(http.StatusOK)
-		fmt.Fprint(w, "build: %v - %v", gitRepo, version)
+		if mutationPause.Paused() {
+			// Healthy, but not acting: say so, for whoever is looking
+			fmt.Fprint(w, "ok (mutations paused)")
+			return
+		}
+		fmt.Fprint(w, "ok")
 	})
+	for pattern, h := range m.handlers {
+		mux.Handle(pattern, h)
+	}
 
-	http.HandleFunc("/stop", func(w http.ResponseWriter, r *http.Request) {
-		c.Stop()
-	})
+	mux.HandleFunc("/controllers", m.serveControllers)
+	mux.HandleFunc("/metrics", m.serveMetrics)
+	mux.HandleFunc("/build", serveBuildInfo)
 
+	adminMux.HandleFunc("/pause", servePause)
+	adminMux.HandleFunc("/resume", servePause)
+	adminMux.HandleFunc("/stop", func(w http.ResponseWriter, r *http.Request) {
+		m.Stop()
+	})
 	if *profiling {
-		mux.HandleFunc("/debug/pprof/", pprof.Index)
-		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
-		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		adminMux.HandleFunc("/debug/pprof/", pprof.Index)
+		adminMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		adminMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	}
+
+	if adminMux != mux {
+		go serveHTTP(net.JoinHostPort(*flagAdminBindAddress, strconv.Itoa(*flagAdminPort)), adminMux)
 	}
+	serveHTTP(net.JoinHostPort(*flagBindAddress, strconv.Itoa(*healthzPort)), mux)
+}
 
+// serveHTTP serves handler on addr, exiting if it cannot be served
+func serveHTTP(addr string, handler http.Handler) {
+	glog.V(2).Infof("serving on %s", addr)
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%v", *healthzPort),
-		Handler: mux,
+		Addr:    addr,
+		Handler: handler,
 	}
 	glog.Fatal(server.ListenAndServe())
 }
 
-func handleSigterm(c *instances.InstancesController) {
+// handleSighup calls reload on each SIGHUP; if reload fails, the previous configuration remains in effect
+func handleSighup(reload func() error) {
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGTERM)
-	<-signalChan
-	glog.Infof("Received SIGTERM, shutting down")
+	signal.Notify(signalChan, syscall.SIGHUP)
+	for range signalChan {
+		glog.Infof("Received SIGHUP, reloading configuration")
+		if err := reload(); err != nil {
+			glog.Warningf("error reloading configuration: %v", err)
+		}
+	}
+}
 
-	exitCode := 0
-	if err := c.Stop(); err != nil {
-		glog.Infof("Error during shutdown %v", err)
-		exitCode = 1
+// servePause serves the pause state at /pause; POST /pause?reason=<reason> pauses mutations, and POST /resume
+// resumes them (unless they are also paused by the configuration)
+func servePause(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet:
+	case r.Method == http.MethodPost && r.URL.Path == "/pause":
+		reason := r.URL.Query().Get("reason")
+		glog.Warningf("Pausing mutations from %s (reason %q)", r.RemoteAddr, reason)
+		mutationPause.Pause(kope.PauseSourceAdmin, reason)
+	case r.Method == http.MethodPost && r.URL.Path == "/resume":
+		glog.Warningf("Resuming mutations from %s", r.RemoteAddr)
+		mutationPause.Resume(kope.PauseSourceAdmin)
+		if mutationPause.Paused() {
+			glog.Warningf("Mutations remain paused by the configuration")
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
-	glog.Infof("Exiting with %v", exitCode)
-	os.Exit(exitCode)
+
+	data, err := json.MarshalIndent(mutationPause.Status(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// handleTermination stops the controllers on SIGTERM or SIGINT; a second signal exits without waiting for them
+func handleTermination(c controller) {
+	signalChan := make(chan os.Signal, 2)
+	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGINT)
+
+	sig := <-signalChan
+	glog.Infof("Received %s, shutting down", sig)
+	go c.Stop()
+
+	sig = <-signalChan
+	glog.Warningf("Received %s again, exiting without waiting for shutdown", sig)
+	glog.Flush()
+	os.Exit(1)
 }
@@ -0,0 +1,685 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/kopeio/aws-controller/pkg/apis/awscontroller/v1alpha1"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/apiloadbalancer"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/cloudwatchmetrics"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/config"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/costallocation"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/dnsalias"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/driftreport"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/eippool"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/gc"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/instances"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/inventory"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/ipforwarding"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/ipv6"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/lifecycle"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/maintenance"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/masterendpoints"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/natfailover"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/nodesync"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/recovery"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/recycle"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/remediation"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/secondaryips"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/securitygroups"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/servicedns"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/snapshots"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/targetgroups"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"github.com/kopeio/aws-controller/pkg/kope/notify"
+	"k8s.io/client-go/kubernetes"
+)
+
+// controllerContext holds the dependencies shared by the controllers
+type controllerContext struct {
+	// cloud is nil if we are not running on AWS, in which case only the cloudNeutral controllers can run
+	cloud *kopeaws.AWSCloud
+	// instanceCloud is the cloud-neutral view of the instances, on any cloud
+	instanceCloud kope.Cloud
+	// instanceCache is the list of instances shared between controllers on AWS
+	instanceCache *kopeaws.InstanceCache
+	events        *notify.Notifier
+
+	// On AWS, the instances and security group controllers are always built, because configuration
+	// is applied to them (and the instances status reported) even if they are not run
+	instances      *instances.InstancesController
+	securityGroups *securitygroups.SecurityGroupController
+	applier        *configApplier
+
+	// configFile is the config-file controller, if it is running
+	configFile *config.FileWatcher
+}
+
+// buildControllerContext builds the instances and security group controllers, and applies the configuration
+// from the flags to them
+func buildControllerContext(cloud *kopeaws.AWSCloud, events *notify.Notifier) (*controllerContext, error) {
+	if *flagConfigName != "" && *flagConfigFile != "" {
+		return nil, fmt.Errorf("config-name and config-file cannot both be set")
+	}
+
+	defaults, err := specFromFlags(flagValues{})
+	if err != nil {
+		return nil, err
+	}
+
+	instanceCache := kopeaws.NewInstanceCache(cloud, instanceCacheMaxAge)
+	ic := instances.NewInstancesController(cloud, instanceCache, resyncPeriod, nil)
+	ic.Notifier = events
+	ic.StatePath = *flagStateFile
+	ic.Workers = *flagInstanceWorkers
+	ic.ShutdownTimeout = *flagShutdownTimeout
+	if err := ic.LoadState(); err != nil {
+		glog.Warningf("ignoring saved state: %v", err)
+	}
+	sg := securitygroups.NewSecurityGroupController(cloud, resyncPeriod)
+	sg.NodeSecurityGroup = *flagNodeSecurityGroup
+	applier := &configApplier{
+		cloud:      cloud,
+		ic:         ic,
+		sg:         sg,
+		pause:      mutationPause,
+		dnsOptions: instanceDNSOptions(cloud),
+		defaults:   defaults,
+	}
+	if err := applier.apply(&v1alpha1.AWSControllerConfigSpec{}); err != nil {
+		return nil, fmt.Errorf("error applying configuration: %v", err)
+	}
+
+	return &controllerContext{
+		cloud:          cloud,
+		instanceCloud:  cloud,
+		events:         events,
+		instanceCache:  instanceCache,
+		instances:      ic,
+		securityGroups: sg,
+		applier:        applier,
+	}, nil
+}
+
+// instanceLister returns the lister for inventory APIs: the shared cache on AWS, else the cloud itself
+func (ctx *controllerContext) instanceLister() inventory.InstanceLister {
+	if ctx.instanceCache != nil {
+		return ctx.instanceCache
+	}
+	return ctx.instanceCloud
+}
+
+// dnsRecordLister returns the source of DNS records for inventory APIs, or nil if there is none
+func (ctx *controllerContext) dnsRecordLister() inventory.DNSRecordLister {
+	if ctx.instances == nil {
+		return nil
+	}
+	return ctx.instances
+}
+
+// controllerDefinition is a controller which can be selected with the controllers flag
+type controllerDefinition struct {
+	name string
+	// configured returns true if the controller's own flags enable it, so that it runs by default
+	configured func() bool
+	// cloudNeutral marks controllers which only use controllerContext.instanceCloud, so can run on any cloud
+	cloudNeutral bool
+	// build builds the controller, returning an error if its flags are invalid
+	build func(ctx *controllerContext) (controller, error)
+	// iamPolicy adds the AWS permissions the controller needs (as configured by the flags) to the policy
+	iamPolicy func(p *kopeaws.IAMPolicy)
+}
+
+// always is the configured function of controllers which run by default
+func always() bool {
+	return true
+}
+
+// controllerDefinitions lists the controllers we can run, in the order they are started
+var controllerDefinitions = []*controllerDefinition{
+	{
+		name:       "instances",
+		configured: always,
+		build: func(ctx *controllerContext) (controller, error) {
+			return ctx.instances, nil
+		},
+		iamPolicy: func(p *kopeaws.IAMPolicy) {
+			p.Allow("*", "ec2:DescribeInstances", "ec2:DescribeInstanceAttribute", "ec2:ModifyInstanceAttribute", "ec2:ModifyInstanceMetadataOptions",
+				"ec2:ModifyNetworkInterfaceAttribute", "ec2:MonitorInstances", "ec2:UnmonitorInstances", "ec2:CreateTags")
+			if *flagZoneName != "" {
+				allowDNS(p)
+			}
+		},
+	},
+	{
+		name:       "security-groups",
+		configured: always,
+		build: func(ctx *controllerContext) (controller, error) {
+			return ctx.securityGroups, nil
+		},
+		iamPolicy: allowActions("ec2:DescribeSecurityGroups", "ec2:AuthorizeSecurityGroupIngress", "ec2:RevokeSecurityGroupIngress"),
+	},
+	{
+		name:       "config-file",
+		configured: func() bool { return *flagConfigFile != "" },
+		build: func(ctx *controllerContext) (controller, error) {
+			if *flagConfigFile == "" {
+				return nil, fmt.Errorf("config-file must be set")
+			}
+			fw := config.NewFileWatcher(*flagConfigFile, ctx.applier.apply, configFilePeriod)
+			if err := fw.Load(); err != nil {
+				return nil, err
+			}
+			ctx.configFile = fw
+			return fw, nil
+		},
+	},
+	{
+		name:       "drift-report",
+		configured: func() bool { return *flagDriftReportURL != "" },
+		build: func(ctx *controllerContext) (controller, error) {
+			status := func() interface{} { return ctx.instances.Status() }
+			return driftreport.NewDriftReportController(ctx.cloud, *flagDriftReportURL, status, *flagDriftReportPeriod)
+		},
+		iamPolicy: func(p *kopeaws.IAMPolicy) {
+			if u, err := driftreport.ParseDestination(*flagDriftReportURL); err == nil && u.Scheme == "s3" {
+				p.Allow(fmt.Sprintf("arn:%s:s3:::%s%s*", iamPartition(), u.Host, u.Path), "s3:PutObject")
+			}
+		},
+	},
+	{
+		name:       "config-crd",
+		configured: func() bool { return *flagConfigName != "" },
+		build: func(ctx *controllerContext) (controller, error) {
+			if *flagConfigName == "" {
+				return nil, fmt.Errorf("config-name must be set")
+			}
+			status := func() interface{} { return ctx.instances.Status() }
+			return config.NewCRDWatcher(mustBuildDynamicClient(), *flagConfigName, ctx.applier.apply, status, configStatusPeriod), nil
+		},
+	},
+	{
+		name:       "maintenance",
+		configured: func() bool { return *flagMaintenanceEvents },
+		build: func(ctx *controllerContext) (controller, error) {
+			mc := maintenance.NewMaintenanceController(ctx.cloud, ctx.instanceCache, mustBuildKubernetesClient(), maintenancePeriod)
+			mc.WithdrawFromDNSBefore = *flagMaintenanceDNSWithdraw
+			return mc, nil
+		},
+		iamPolicy: allowActions("ec2:DescribeInstances", "ec2:DescribeInstanceStatus", "ec2:CreateTags", "ec2:DeleteTags"),
+	},
+	{
+		name:         "recycle",
+		configured:   func() bool { return *flagRecycleMaxAge != 0 },
+		cloudNeutral: true,
+		build: func(ctx *controllerContext) (controller, error) {
+			if *flagRecycleMaxAge == 0 {
+				return nil, fmt.Errorf("recycle-max-age must be set")
+			}
+			rc := recycle.NewRecycleController(ctx.instanceCloud, mustBuildKubernetesClient(), *flagRecycleMaxAge, recyclePeriod)
+			rc.MaxConcurrentPerZone = *flagRecycleMaxConcurrent
+			rc.Roles = strings.Split(*flagRecycleRoles, ",")
+			rc.Notifier = ctx.events
+			return rc, nil
+		},
+		iamPolicy: allowActions("ec2:DescribeInstances", "ec2:CreateTags", "ec2:TerminateInstances"),
+	},
+	{
+		name:       "remediation",
+		configured: func() bool { return *flagRemediateStatusChecks != "" || *flagTaintImpaired },
+		build: func(ctx *controllerContext) (controller, error) {
+			rc, err := remediation.NewRemediationController(ctx.cloud, ctx.instanceCache, mustBuildKubernetesClient(), *flagRemediateStatusChecks, remediationPeriod)
+			if err != nil {
+				return nil, err
+			}
+			rc.FailureThreshold = *flagRemediateThreshold
+			rc.ReportOnly = *flagRemediateReportOnly
+			rc.TaintImpaired = *flagTaintImpaired
+			rc.Notifier = ctx.events
+			return rc, nil
+		},
+		iamPolicy: allowActions("ec2:DescribeInstances", "ec2:DescribeInstanceStatus", "ec2:RebootInstances", "ec2:TerminateInstances"),
+	},
+	{
+		name:       "recovery",
+		configured: func() bool { return *flagRecoveryAlarms },
+		build: func(ctx *controllerContext) (controller, error) {
+			return recovery.NewRecoveryController(ctx.cloud, ctx.instanceCache, recoveryPeriod), nil
+		},
+		iamPolicy: allowActions("ec2:DescribeInstances", "cloudwatch:DescribeAlarms", "cloudwatch:PutMetricAlarm", "cloudwatch:DeleteAlarms"),
+	},
+	{
+		name: "node-sync",
+		configured: func() bool {
+			return len(flagNodeLabelTags) != 0 || len(flagNodeAnnotationTags) != 0 || *flagAnnotateNodes
+		},
+		build: func(ctx *controllerContext) (controller, error) {
+			nc := nodesync.NewNodeSyncController(ctx.cloud, ctx.instanceCache, mustBuildKubernetesClient(), nodeSyncPeriod)
+			nc.LabelTags = tagMapping(flagNodeLabelTags)
+			nc.AnnotationTags = tagMapping(flagNodeAnnotationTags)
+			nc.AnnotateNodes = *flagAnnotateNodes
+			return nc, nil
+		},
+		iamPolicy: allowActions("ec2:DescribeInstances", "ec2:CreateTags", "ec2:DeleteTags"),
+	},
+	{
+		name:       "master-endpoints",
+		configured: func() bool { return *flagMasterService != "" },
+		build: func(ctx *controllerContext) (controller, error) {
+			tokens := strings.SplitN(*flagMasterService, "/", 2)
+			if len(tokens) != 2 || tokens[0] == "" || tokens[1] == "" {
+				return nil, fmt.Errorf("master-service must be of the form namespace/name, got %q", *flagMasterService)
+			}
+			mc := masterendpoints.NewMasterEndpointsController(ctx.cloud, ctx.instanceCache, mustBuildKubernetesClient(), resyncPeriod)
+			mc.Namespace = tokens[0]
+			mc.Name = tokens[1]
+			mc.Port = int32(*flagMasterServicePort)
+			return mc, nil
+		},
+		iamPolicy: allowActions("ec2:DescribeInstances"),
+	},
+	{
+		name:       "gc-load-balancers",
+		configured: func() bool { return *flagGCLoadBalancers },
+		build: func(ctx *controllerContext) (controller, error) {
+			lc := gc.NewLoadBalancerGCController(ctx.cloud, mustBuildKubernetesClient(), gcPeriod)
+			lc.GracePeriod = *flagGCLoadBalancerGracePeriod
+			return lc, nil
+		},
+		iamPolicy: allowActions("elasticloadbalancing:DescribeLoadBalancers", "elasticloadbalancing:DescribeTags", "elasticloadbalancing:DeleteLoadBalancer"),
+	},
+	{
+		name:       "gc-volumes",
+		configured: func() bool { return *flagGCVolumesTTL != 0 },
+		build: func(ctx *controllerContext) (controller, error) {
+			if *flagGCVolumesTTL == 0 {
+				return nil, fmt.Errorf("gc-volumes-ttl must be set")
+			}
+			vc := gc.NewVolumeGCController(ctx.cloud, mustBuildKubernetesClient(), *flagGCVolumesTTL, gcPeriod)
+			vc.Snapshot = *flagGCVolumesSnapshot
+			vc.ReportOnly = *flagGCVolumesReportOnly
+			return vc, nil
+		},
+		iamPolicy: allowActions("ec2:DescribeInstances", "ec2:DescribeVolumes", "ec2:DeleteVolume", "ec2:CreateSnapshot", "ec2:CreateTags", "ec2:DeleteTags"),
+	},
+	{
+		name:       "cost-allocation-tags",
+		configured: func() bool { return len(flagCostAllocationTags) != 0 },
+		build: func(ctx *controllerContext) (controller, error) {
+			cc, err := costallocation.NewCostAllocationController(ctx.cloud, ctx.instanceLister(), flagCostAllocationTags.values(), gcPeriod)
+			if err != nil {
+				return nil, err
+			}
+			cc.ReportOnly = *flagCostAllocationReportOnly
+			return cc, nil
+		},
+		iamPolicy: allowActions("ec2:DescribeInstances", "ec2:DescribeVolumes", "ec2:DescribeNetworkInterfaces", "ec2:CreateTags",
+			"elasticloadbalancing:DescribeLoadBalancers", "elasticloadbalancing:DescribeTags", "elasticloadbalancing:AddTags"),
+	},
+	{
+		name:       "snapshots",
+		configured: func() bool { return *flagSnapshotSchedules },
+		build: func(ctx *controllerContext) (controller, error) {
+			if *flagSnapshotRetain < 1 {
+				return nil, fmt.Errorf("snapshot-retain must be at least 1")
+			}
+			sc := snapshots.NewSnapshotController(ctx.cloud, snapshotPeriod)
+			sc.Retain = *flagSnapshotRetain
+			return sc, nil
+		},
+		iamPolicy: allowActions("ec2:DescribeVolumes", "ec2:CreateSnapshot", "ec2:CreateTags", "ec2:DescribeSnapshots", "ec2:DeleteSnapshot"),
+	},
+	{
+		name:       "nat-failover",
+		configured: func() bool { return *flagNATFailover },
+		build: func(ctx *controllerContext) (controller, error) {
+			nc := natfailover.NewNATFailoverController(ctx.cloud, ctx.instanceCache, natCheckPeriod)
+			nc.FailureThreshold = *flagNATFailureThreshold
+			nc.Notifier = ctx.events
+			return nc, nil
+		},
+		iamPolicy: allowActions("ec2:DescribeInstances", "ec2:DescribeInstanceStatus", "ec2:ModifyInstanceAttribute", "ec2:DescribeNatGateways", "ec2:DescribeRouteTables", "ec2:ReplaceRoute"),
+	},
+	{
+		name:       "eip-pool",
+		configured: func() bool { return *flagEIPPool != "" },
+		build: func(ctx *controllerContext) (controller, error) {
+			if *flagEIPPool == "" {
+				return nil, fmt.Errorf("eip-pool must be set")
+			}
+			ec := eippool.NewEIPPoolController(ctx.cloud, ctx.instanceCache, *flagEIPPool, resyncPeriod)
+			ec.Role = *flagEIPPoolRole
+			return ec, nil
+		},
+		iamPolicy: allowActions("ec2:DescribeInstances", "ec2:DescribeAddresses", "ec2:AssociateAddress", "ec2:DisassociateAddress"),
+	},
+	{
+		name:       "secondary-ips",
+		configured: func() bool { return *flagSecondaryIPs },
+		build: func(ctx *controllerContext) (controller, error) {
+			sc := secondaryips.NewSecondaryIPController(ctx.cloud, ctx.instanceCache, mustBuildKubernetesClient(), resyncPeriod)
+			sc.SecondaryIPs = *flagSecondaryIPsPerNode
+			sc.NetworkInterfaces = *flagNetworkInterfacesPerNode
+			return sc, nil
+		},
+		iamPolicy: allowActions("ec2:DescribeInstances", "ec2:DescribeInstanceTypes", "ec2:DescribeNetworkInterfaces", "ec2:CreateNetworkInterface",
+			"ec2:AttachNetworkInterface", "ec2:DetachNetworkInterface", "ec2:DeleteNetworkInterface", "ec2:AssignPrivateIpAddresses",
+			"ec2:ModifyNetworkInterfaceAttribute", "ec2:CreateTags"),
+	},
+	{
+		name:       "ipv6",
+		configured: func() bool { return *flagAssignIPv6 },
+		build: func(ctx *controllerContext) (controller, error) {
+			return ipv6.NewIPv6Controller(ctx.cloud, ctx.instanceCache, mustBuildKubernetesClient(), resyncPeriod), nil
+		},
+		iamPolicy: allowActions("ec2:DescribeInstances", "ec2:DescribeSubnets", "ec2:AssignIpv6Addresses"),
+	},
+	{
+		name:       "target-groups",
+		configured: func() bool { return len(flagTargetGroups) != 0 },
+		build: func(ctx *controllerContext) (controller, error) {
+			var bindings []*targetgroups.Binding
+			var kubernetes kubernetes.Interface
+			for arn, selector := range flagTargetGroups {
+				b, err := targetgroups.ParseBinding(arn, selector)
+				if err != nil {
+					return nil, fmt.Errorf("invalid target-group flag: %v", err)
+				}
+				if b.NodeLabel != "" {
+					kubernetes = mustBuildKubernetesClient()
+				}
+				bindings = append(bindings, b)
+			}
+			tc := targetgroups.NewTargetGroupController(ctx.cloud, ctx.instanceCache, kubernetes, bindings, resyncPeriod)
+			tc.AllowEmpty = *flagTargetGroupAllowEmpty
+			return tc, nil
+		},
+		iamPolicy: allowActions("ec2:DescribeInstances", "elasticloadbalancing:DescribeTargetHealth", "elasticloadbalancing:RegisterTargets", "elasticloadbalancing:DeregisterTargets"),
+	},
+	{
+		name:       "api-load-balancer",
+		configured: func() bool { return *flagAPILoadBalancer },
+		build: func(ctx *controllerContext) (controller, error) {
+			dns, err := buildDNSProvider(controllerDNSOptions(ctx, "api-load-balancer"))
+			if err != nil {
+				return nil, fmt.Errorf("error building DNS provider: %v", err)
+			}
+			lc := apiloadbalancer.NewAPILoadBalancerController(ctx.cloud, ctx.instanceCache, dns, resyncPeriod)
+			lc.Internal = *flagAPILoadBalancerInternal
+			lc.Port = *flagAPILoadBalancerPort
+			lc.DNSName = *flagAPILoadBalancerDNSName
+			return lc, nil
+		},
+		iamPolicy: func(p *kopeaws.IAMPolicy) {
+			p.Allow("*", "ec2:DescribeInstances", "elasticloadbalancing:DescribeLoadBalancers", "elasticloadbalancing:DescribeTargetGroups",
+				"elasticloadbalancing:DescribeListeners", "elasticloadbalancing:CreateLoadBalancer", "elasticloadbalancing:CreateTargetGroup",
+				"elasticloadbalancing:CreateListener", "elasticloadbalancing:ModifyListener", "elasticloadbalancing:AddTags", "elasticloadbalancing:DescribeTags",
+				"elasticloadbalancing:DescribeTargetHealth", "elasticloadbalancing:RegisterTargets", "elasticloadbalancing:DeregisterTargets")
+			if *flagAPILoadBalancerDNSName != "" {
+				allowDNS(p)
+			}
+		},
+	},
+	{
+		name:       "dns-alias",
+		configured: func() bool { return len(flagDNSAliases) != 0 },
+		build: func(ctx *controllerContext) (controller, error) {
+			for name, target := range flagDNSAliases {
+				if err := kopeaws.ValidateAliasTarget(target); err != nil {
+					return nil, fmt.Errorf("invalid dns-alias flag for %q: %v", name, err)
+				}
+			}
+			dns, err := buildDNSProvider(controllerDNSOptions(ctx, "dns-alias"))
+			if err != nil {
+				return nil, fmt.Errorf("error building DNS provider: %v", err)
+			}
+			if dns == nil {
+				return nil, fmt.Errorf("zone-name must be set with dns-alias")
+			}
+			return dnsalias.NewDNSAliasController(ctx.cloud, dns, flagDNSAliases.values(), resyncPeriod), nil
+		},
+		iamPolicy: func(p *kopeaws.IAMPolicy) {
+			p.Allow("*", "elasticloadbalancing:DescribeLoadBalancers", "elasticloadbalancing:DescribeTags")
+			allowDNS(p)
+		},
+	},
+	{
+		name:         "service-dns",
+		configured:   func() bool { return *flagServiceDNS },
+		cloudNeutral: true,
+		build: func(ctx *controllerContext) (controller, error) {
+			dns, err := buildDNSProvider(controllerDNSOptions(ctx, "service-dns"))
+			if err != nil {
+				return nil, fmt.Errorf("error building DNS provider: %v", err)
+			}
+			if dns == nil {
+				return nil, fmt.Errorf("zone-name must be set with service-dns")
+			}
+			sc := servicedns.NewServiceDNSController(mustBuildKubernetesClient(), dns, resyncPeriod)
+			sc.InternalNodeAddresses = *flagServiceDNSInternal
+			return sc, nil
+		},
+		iamPolicy: allowDNS,
+	},
+	{
+		name:         "ip-forwarding",
+		configured:   func() bool { return *flagIPForwarding },
+		cloudNeutral: true,
+		build: func(ctx *controllerContext) (controller, error) {
+			fc := ipforwarding.NewIPForwardingController(ctx.instanceCloud, resyncPeriod)
+			if *flagIPForwardingRoles != "" {
+				fc.Roles = strings.Split(*flagIPForwardingRoles, ",")
+			}
+			return fc, nil
+		},
+		iamPolicy: allowActions("ec2:DescribeInstances", "ec2:ModifyInstanceAttribute"),
+	},
+	{
+		name:         "inventory-grpc",
+		configured:   func() bool { return *flagInventoryGRPCAddress != "" },
+		cloudNeutral: true,
+		build: func(ctx *controllerContext) (controller, error) {
+			if *flagInventoryGRPCAddress == "" {
+				return nil, fmt.Errorf("inventory-grpc-address must be set")
+			}
+			return inventory.NewGRPCServer(*flagInventoryGRPCAddress, ctx.instanceLister(), ctx.dnsRecordLister())
+		},
+		iamPolicy: allowActions("ec2:DescribeInstances"),
+	},
+	{
+		name:       "ssm-inventory",
+		configured: func() bool { return *flagSSMInventoryPath != "" },
+		build: func(ctx *controllerContext) (controller, error) {
+			if *flagSSMInventoryPath == "" {
+				return nil, fmt.Errorf("ssm-inventory-path must be set")
+			}
+			return inventory.NewSSMPublisher(ctx.instanceLister(), ctx.cloud, *flagSSMInventoryPath, resyncPeriod)
+		},
+		iamPolicy: func(p *kopeaws.IAMPolicy) {
+			path := strings.TrimSuffix(*flagSSMInventoryPath, "/")
+			p.Allow("*", "ec2:DescribeInstances")
+			p.Allow(fmt.Sprintf("arn:%s:ssm:*:*:parameter%s", iamPartition(), path), "ssm:GetParametersByPath")
+			p.Allow(fmt.Sprintf("arn:%s:ssm:*:*:parameter%s/*", iamPartition(), path), "ssm:GetParametersByPath")
+			p.Allow(fmt.Sprintf("arn:%s:ssm:*:*:parameter%s/*s/i-*", iamPartition(), path), "ssm:PutParameter", "ssm:DeleteParameters")
+		},
+	},
+	{
+		name:       "cloudwatch-metrics",
+		configured: func() bool { return *flagCloudWatchMetricsNamespace != "" },
+		build: func(ctx *controllerContext) (controller, error) {
+			if *flagCloudWatchMetricsNamespace == "" {
+				return nil, fmt.Errorf("cloudwatch-metrics-namespace must be set")
+			}
+			var status func() *instances.ReconcileStatus
+			if ctx.instances != nil {
+				status = ctx.instances.Status
+			}
+			return cloudwatchmetrics.NewCloudWatchMetricsController(ctx.cloud, ctx.instanceLister(), status, *flagCloudWatchMetricsNamespace, *flagCloudWatchMetricsPeriod)
+		},
+		iamPolicy: allowActions("ec2:DescribeInstances", "cloudwatch:PutMetricData"),
+	},
+	{
+		name:       "s3-inventory",
+		configured: func() bool { return *flagS3InventoryURL != "" },
+		build: func(ctx *controllerContext) (controller, error) {
+			if *flagS3InventoryURL == "" {
+				return nil, fmt.Errorf("s3-inventory-url must be set")
+			}
+			return inventory.NewS3Snapshotter(ctx.instanceLister(), ctx.dnsRecordLister(), ctx.cloud, ctx.cloud.ClusterID(), *flagS3InventoryURL, resyncPeriod)
+		},
+		iamPolicy: func(p *kopeaws.IAMPolicy) {
+			p.Allow("*", "ec2:DescribeInstances")
+			if bucket, prefix, err := inventory.ParseS3Destination(*flagS3InventoryURL); err == nil {
+				if prefix != "" {
+					prefix += "/"
+				}
+				p.Allow(fmt.Sprintf("arn:%s:s3:::%s/%s*", iamPartition(), bucket, prefix), "s3:PutObject", "s3:GetObject")
+				p.Allow(fmt.Sprintf("arn:%s:s3:::%s", iamPartition(), bucket), "s3:ListBucket")
+			}
+		},
+	},
+	{
+		name:       "lifecycle",
+		configured: func() bool { return *flagLifecycleQueueURL != "" },
+		build: func(ctx *controllerContext) (controller, error) {
+			if *flagLifecycleQueueURL == "" {
+				return nil, fmt.Errorf("lifecycle-queue-url must be set")
+			}
+			lc := lifecycle.NewLifecycleController(ctx.cloud, mustBuildKubernetesClient(), *flagLifecycleQueueURL)
+			lc.DrainOptions.Timeout = *flagLifecycleDrainTimeout
+			return lc, nil
+		},
+		iamPolicy: func(p *kopeaws.IAMPolicy) {
+			p.Allow("*", "ec2:DescribeInstances", "ec2:CreateTags", "autoscaling:CompleteLifecycleAction", "autoscaling:RecordLifecycleActionHeartbeat")
+			p.Allow(sqsQueueARN(*flagLifecycleQueueURL), "sqs:ReceiveMessage", "sqs:DeleteMessage")
+		},
+	},
+}
+
+// controllerNames returns the sorted names of all the controllers
+func controllerNames() []string {
+	var names []string
+	for _, d := range controllerDefinitions {
+		names = append(names, d.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// enabledControllers parses the controllers flag: a comma-separated list of controller names, where
+// "*" selects the controllers enabled by their own flags and "-<name>" disables a controller.  Unless aws is
+// set, "*" only selects the cloud-neutral controllers.
+func enabledControllers(s string, aws bool) (map[string]bool, error) {
+	known := make(map[string]bool)
+	for _, d := range controllerDefinitions {
+		known[d.name] = true
+	}
+
+	enabled := make(map[string]bool)
+	for _, token := range strings.Split(s, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		if token == "*" {
+			for _, d := range controllerDefinitions {
+				if d.configured() && (aws || d.cloudNeutral) {
+					enabled[d.name] = true
+				}
+			}
+			continue
+		}
+
+		name := strings.TrimPrefix(token, "-")
+		if !known[name] {
+			return nil, fmt.Errorf("unknown controller %q; known controllers are %s", name, strings.Join(controllerNames(), ","))
+		}
+		enabled[name] = !strings.HasPrefix(token, "-")
+	}
+	return enabled, nil
+}
+
+// reportOnlyControllers are the controllers which honour the report-only flag, or never make changes to the cluster
+var reportOnlyControllers = map[string]bool{
+	"instances":    true,
+	"config-file":  true,
+	"drift-report": true,
+}
+
+// buildControllers builds the controllers enabled by the controllers flag s
+func buildControllers(ctx *controllerContext, s string) (*controllerManager, error) {
+	enabled, err := enabledControllers(s, ctx.cloud != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var notReportOnly []string
+	m := newControllerManager()
+	for _, d := range controllerDefinitions {
+		if !enabled[d.name] {
+			continue
+		}
+		if ctx.cloud == nil && !d.cloudNeutral {
+			return nil, fmt.Errorf("the %s controller is only supported on AWS", d.name)
+		}
+		c, err := d.build(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error building %s controller: %v", d.name, err)
+		}
+		if dc, ok := c.(drainingController); ok {
+			dc.SetShutdownTimeout(*flagShutdownTimeout)
+		}
+		m.add(d.name, c)
+		if *flagReportOnly && !reportOnlyControllers[d.name] {
+			notReportOnly = append(notReportOnly, d.name)
+		}
+		if cc, ok := c.(*costallocation.CostAllocationController); ok {
+			m.handlers[costallocation.ReportPath] = cc
+		}
+	}
+
+	if len(notReportOnly) != 0 {
+		glog.Warningf("report-only only applies to the instances controller; the %s controllers may still make changes (use paused to prevent all changes)",
+			strings.Join(notReportOnly, ","))
+	}
+
+	if *flagInventoryAPI {
+		h := inventory.NewRESTHandler(ctx.instanceLister(), ctx.dnsRecordLister())
+		m.handlers[inventory.InstancesPath] = h
+		m.handlers[inventory.DNSRecordsPath] = h
+	}
+
+	switch {
+	case *flagLeaderElectDynamoDBTable != "":
+		if ctx.cloud == nil {
+			return nil, fmt.Errorf("leader-elect-dynamodb-table is only supported on AWS")
+		}
+		m.leaderElection, err = buildDynamoDBLeaderElection(ctx.cloud, *flagLeaderElectDynamoDBTable, *flagLeaderElectName)
+	case *flagLeaderElect:
+		m.leaderElection, err = buildLeaderElection(*flagLeaderElectNamespace, *flagLeaderElectName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
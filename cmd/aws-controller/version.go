@@ -0,0 +1,104 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+)
+
+var (
+	// values overwritten during build (see LDFLAGS in the Makefile). These can be used to resolve issues.
+	// version is only "dev" in builds which don't set it, such as a plain go build.
+	version   = "dev"
+	gitRepo   = "https://github.com/kopeio/aws-controller"
+	gitCommit = ""
+	buildDate = ""
+)
+
+// buildInfo describes the build, as returned by the version command and the /build endpoint
+type buildInfo struct {
+	Version   string `json:"version"`
+	GitRepo   string `json:"gitRepo"`
+	GitCommit string `json:"gitCommit,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+}
+
+func getBuildInfo() *buildInfo {
+	return &buildInfo{
+		Version:   version,
+		GitRepo:   gitRepo,
+		GitCommit: gitCommit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
+
+func (b *buildInfo) String() string {
+	s := fmt.Sprintf("%s - %s", b.GitRepo, b.Version)
+	if b.GitCommit != "" {
+		s += " (" + b.GitCommit + ")"
+	}
+	return s
+}
+
+// runVersion prints the build information, as JSON with --output=json
+func runVersion(args []string) {
+	flags := flag.NewFlagSet("version", flag.ExitOnError)
+	output := flags.String("output", "", "Output format: empty for text, or json")
+	flags.Parse(args)
+
+	info := getBuildInfo()
+	switch *output {
+	case "":
+		fmt.Printf("Version:    %s\n", info.Version)
+		fmt.Printf("Git repo:   %s\n", info.GitRepo)
+		fmt.Printf("Git commit: %s\n", info.GitCommit)
+		fmt.Printf("Build date: %s\n", info.BuildDate)
+		fmt.Printf("Go version: %s\n", info.GoVersion)
+		fmt.Printf("Platform:   %s\n", info.Platform)
+	case "json":
+		data, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error serializing build information: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown output format %q; expected json\n", *output)
+		os.Exit(1)
+	}
+}
+
+// serveBuildInfo serves the build information as JSON
+func serveBuildInfo(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(getBuildInfo())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error serializing build information: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,145 @@
+// Package v1alpha1 holds the types of the AWSControllerConfig custom resource, which
+// configures the controller declaratively
+package v1alpha1
+
+import (
+	"encoding/json"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	GroupName = "aws-controller.kope.io"
+	Version   = "v1alpha1"
+	Kind      = "AWSControllerConfig"
+)
+
+// Resource identifies the (cluster-scoped) awscontrollerconfigs resource
+var Resource = schema.GroupVersionResource{Group: GroupName, Version: Version, Resource: "awscontrollerconfigs"}
+
+// AWSControllerConfig configures the controller; the controller watches a single object, by name
+type AWSControllerConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AWSControllerConfigSpec   `json:"spec,omitempty"`
+	Status AWSControllerConfigStatus `json:"status,omitempty"`
+}
+
+// AWSControllerConfigSpec is the desired configuration.  Unset fields keep the value
+// from the command line flags.
+type AWSControllerConfigSpec struct {
+	// ResyncPeriod is how often all instances are relisted and reconciled
+	ResyncPeriod *metav1.Duration `json:"resyncPeriod,omitempty"`
+	// ReportOnly reports the changes which would be made to instances and DNS, without making them.
+	// It only applies to the instances controller: other controllers have their own report-only flags.
+	ReportOnly *bool `json:"reportOnly,omitempty"`
+	// Paused refuses all mutating actions, against AWS and Kubernetes, while observation and status reporting carry on
+	Paused *bool `json:"paused,omitempty"`
+
+	// SourceDestCheck is the desired source-dest-check of instances
+	SourceDestCheck *bool `json:"sourceDestCheck,omitempty"`
+	// DetailedMonitoring is the desired state of detailed CloudWatch monitoring
+	DetailedMonitoring *bool `json:"detailedMonitoring,omitempty"`
+	// MetadataOptions are the desired instance metadata service options
+	MetadataOptions *MetadataOptionsSpec `json:"metadataOptions,omitempty"`
+	// TerminationProtection maps instance roles to the desired API termination protection
+	TerminationProtection map[string]bool `json:"terminationProtection,omitempty"`
+	// RequiredTags are tags (with templated values) every instance should have
+	RequiredTags map[string]string `json:"requiredTags,omitempty"`
+
+	// NameTemplate is a template for the Name tag of every instance, e.g. {{.ClusterID}}-{{.Role}}-{{.AZ}}-{{.LaunchIndex}}
+	NameTemplate string `json:"nameTemplate,omitempty"`
+
+	// FilterTags restricts management to instances with these tags
+	FilterTags map[string]string `json:"filterTags,omitempty"`
+
+	// DNS configures DNS management
+	DNS *DNSSpec `json:"dns,omitempty"`
+
+	// SecurityGroups declares the ingress rules of the cluster's security groups
+	SecurityGroups *SecurityGroupsSpec `json:"securityGroups,omitempty"`
+}
+
+// SecurityGroupsSpec declares security group ingress rules
+type SecurityGroupsSpec struct {
+	Rules []SecurityGroupRuleSpec `json:"rules,omitempty"`
+	// Prune removes ingress rules not declared here from the groups which have declared rules
+	Prune bool `json:"prune,omitempty"`
+	// DryRun reports the changes which would be made, without making them
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// SecurityGroupRuleSpec is an ingress rule; groups are specified by id or name
+type SecurityGroupRuleSpec struct {
+	// Group is the security group the rule belongs to
+	Group string `json:"group"`
+	// Protocol is tcp, udp, icmp, or all
+	Protocol string `json:"protocol"`
+	FromPort int64  `json:"fromPort,omitempty"`
+	ToPort   int64  `json:"toPort,omitempty"`
+	// Exactly one of SourceGroup and CIDR must be set
+	SourceGroup string `json:"sourceGroup,omitempty"`
+	CIDR        string `json:"cidr,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// MetadataOptionsSpec configures the instance metadata service on instances
+type MetadataOptionsSpec struct {
+	// HttpTokens is "required" to require IMDSv2, or "optional"
+	HttpTokens string `json:"httpTokens,omitempty"`
+	// HttpPutResponseHopLimit is the hop limit of token responses
+	HttpPutResponseHopLimit int64 `json:"httpPutResponseHopLimit,omitempty"`
+	// ReportOnly reports non-matching instances without changing them
+	ReportOnly bool `json:"reportOnly,omitempty"`
+}
+
+// DNSSpec configures DNS management
+type DNSSpec struct {
+	// ZoneName is the hosted zone to manage; empty to stop managing DNS
+	ZoneName string `json:"zoneName"`
+	// PublicCNAME publishes public names as CNAMEs of the instances' public DNS names, instead of A records
+	PublicCNAME bool `json:"publicCNAME,omitempty"`
+	// EtcdSRVDomain is the domain under which to publish etcd discovery SRV records for the masters
+	EtcdSRVDomain string `json:"etcdSRVDomain,omitempty"`
+	// PrivateZoneName is the private zone in which to publish the internal IPs of split-horizon names (tagged
+	// k8s.io/dns/split-horizon), whose public IPs are published in ZoneName; usually it has the same name
+	PrivateZoneName string `json:"privateZoneName,omitempty"`
+	// ReverseZoneName is the in-addr.arpa private zone in which to publish PTR records for the instances' internal names
+	ReverseZoneName string `json:"reverseZoneName,omitempty"`
+	// RoleGroupDomain is the domain under which to publish a record per instance role, e.g. masters.<domain>
+	RoleGroupDomain string `json:"roleGroupDomain,omitempty"`
+	// LatencyRouting publishes names as latency-based record sets, one per region
+	LatencyRouting bool `json:"latencyRouting,omitempty"`
+	// MultiValue publishes names as multi-value answer record sets, with health checks of public addresses
+	MultiValue bool `json:"multiValue,omitempty"`
+	// MaxChanges is the most existing records a single reconcile may change or remove (0 for no limit)
+	MaxChanges int `json:"maxChanges,omitempty"`
+	// MaxChangePercent is the largest percentage of the existing records a single reconcile may change or remove
+	MaxChangePercent int `json:"maxChangePercent,omitempty"`
+	// AllowMassChanges overrides MaxChanges and MaxChangePercent
+	AllowMassChanges bool `json:"allowMassChanges,omitempty"`
+	// HealthCheckPort is the port of the TCP health checks of failover records (default 443)
+	HealthCheckPort int `json:"healthCheckPort,omitempty"`
+	// MaxRecordsPerName limits the addresses published under each name (0 for no limit); the oldest
+	// instances are published, spread across availability zones
+	MaxRecordsPerName int `json:"maxRecordsPerName,omitempty"`
+	// CoalesceWindow, if set, is how long changes are buffered after they are first seen, so that a burst of
+	// instance churn is applied as a single batch
+	CoalesceWindow *metav1.Duration `json:"coalesceWindow,omitempty"`
+	// VerifyWindow, if set, is how long applied changes may take to be served by the zone's authoritative
+	// name servers, before they are reported and applied again
+	VerifyWindow *metav1.Duration `json:"verifyWindow,omitempty"`
+	// VerifyNameServers are the name servers to verify changes against, instead of the zone's
+	VerifyNameServers []string `json:"verifyNameServers,omitempty"`
+}
+
+// AWSControllerConfigStatus reports how the configuration was applied, and the results of reconciliation
+type AWSControllerConfigStatus struct {
+	// ObservedGeneration is the generation of the spec most recently applied
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// ConfigError is set if the spec could not be applied
+	ConfigError string `json:"configError,omitempty"`
+	// Instances summarizes the reconciliation of instances
+	Instances json.RawMessage `json:"instances,omitempty"`
+}
@@ -0,0 +1,160 @@
+package apiloadbalancer
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/targetgroups"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"sort"
+	"time"
+)
+
+// APILoadBalancerController creates and maintains a network load balancer for the Kubernetes API,
+// with a listener and target group across the master instances.  The load balancer spans the
+// subnets of the masters.  If a DNS name is configured, it is published as an alias record
+// pointing at the load balancer.
+type APILoadBalancerController struct {
+	// Port is the port of the API server on the masters, and of the listener
+	Port int64
+	// Internal creates an internal (rather than internet-facing) load balancer
+	Internal bool
+	// DNSName, if set, is published as an alias to the load balancer
+	DNSName string
+
+	cloud *kopeaws.AWSCloud
+
+	// cache is the instance list shared with other controllers
+
+	cache *kopeaws.InstanceCache
+	dns   kope.DNSProvider
+
+	// published holds the alias target we last published to DNS
+	published kopeaws.AliasTarget
+
+	*kope.PeriodicController
+}
+
+func NewAPILoadBalancerController(cloud *kopeaws.AWSCloud, cache *kopeaws.InstanceCache, dns kope.DNSProvider, period time.Duration) *APILoadBalancerController {
+	c := &APILoadBalancerController{
+		Port:  443,
+		cloud: cloud,
+		cache: cache,
+		dns:   dns,
+	}
+	c.PeriodicController = kope.NewPeriodicController("API load balancer controller", period, c.runOnce)
+	return c
+}
+
+func (c *APILoadBalancerController) runOnce(ctx context.Context) error {
+	instances, err := c.cache.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The load balancer lives in our own region, with the masters there
+	var masters []string
+	var vpcID string
+	subnetsByZone := make(map[string]string)
+	for _, i := range instances {
+		id := aws.StringValue(i.InstanceId)
+		if kopeaws.InstanceRole(i) != kopeaws.RoleMaster || c.cloud.InstanceRegion(id) != c.cloud.Region() {
+			continue
+		}
+		if aws.StringValue(i.State.Name) != ec2.InstanceStateNameRunning {
+			continue
+		}
+		masters = append(masters, id)
+		vpcID = aws.StringValue(i.VpcId)
+		if i.Placement != nil && i.SubnetId != nil {
+			subnetsByZone[aws.StringValue(i.Placement.AvailabilityZone)] = aws.StringValue(i.SubnetId)
+		}
+	}
+	if len(masters) == 0 {
+		glog.Warningf("No running master instances found; not configuring API load balancer")
+		return nil
+	}
+
+	var subnets []string
+	for _, subnet := range subnetsByZone {
+		subnets = append(subnets, subnet)
+	}
+	sort.Strings(subnets)
+
+	name := c.cloud.ELBName("api")
+
+	lb, err := c.cloud.FindLoadBalancer(ctx, name)
+	if err != nil {
+		return err
+	}
+	if lb == nil {
+		lb, err = c.cloud.CreateNetworkLoadBalancer(ctx, name, c.Internal, subnets)
+		if err != nil {
+			return err
+		}
+	} else if missing := missingZones(lb, subnetsByZone); len(missing) != 0 {
+		glog.Warningf("API load balancer %q does not span zones %v of the masters; recreate it to add them", name, missing)
+	}
+
+	tg, err := c.cloud.FindTargetGroup(ctx, name)
+	if err != nil {
+		return err
+	}
+	if tg == nil {
+		tg, err = c.cloud.CreateTCPTargetGroup(ctx, name, vpcID, c.Port)
+		if err != nil {
+			return err
+		}
+	}
+	tgARN := aws.StringValue(tg.TargetGroupArn)
+
+	if err := c.cloud.EnsureTCPListener(ctx, aws.StringValue(lb.LoadBalancerArn), c.Port, tgARN); err != nil {
+		return err
+	}
+
+	if err := targetgroups.SyncTargets(ctx, c.cloud, tgARN, masters, targetgroups.InstanceIDs(instances), false); err != nil {
+		return err
+	}
+
+	if c.DNSName != "" && c.dns != nil {
+		if err := c.publishDNS(ctx, kopeaws.LoadBalancerAliasTarget(lb)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// missingZones returns the zones with masters which the load balancer doesn't span
+func missingZones(lb *elbv2.LoadBalancer, subnetsByZone map[string]string) []string {
+	spanned := make(map[string]bool)
+	for _, az := range lb.AvailabilityZones {
+		spanned[aws.StringValue(az.ZoneName)] = true
+	}
+	var missing []string
+	for zone := range subnetsByZone {
+		if !spanned[zone] {
+			missing = append(missing, zone)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// publishDNS publishes DNSName as an alias to the load balancer
+func (c *APILoadBalancerController) publishDNS(ctx context.Context, target *kopeaws.AliasTarget) error {
+	if *target == c.published {
+		return nil
+	}
+
+	key, values := target.Record(c.DNSName)
+	glog.Infof("Publishing %q as an alias to API load balancer %q", c.DNSName, target.DNSName)
+	if err := c.dns.ApplyDNSChanges(ctx, map[kope.DNSRecordKey][]string{key: values}); err != nil {
+		return fmt.Errorf("error publishing API load balancer DNS name %q: %v", c.DNSName, err)
+	}
+	c.published = *target
+	return nil
+}
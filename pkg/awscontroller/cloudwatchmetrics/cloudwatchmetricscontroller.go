@@ -0,0 +1,144 @@
+package cloudwatchmetrics
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/instances"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/inventory"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Names of the metrics we publish; every metric has a ClusterId dimension
+const (
+	// MetricInstances counts the cluster instances, in total and by Role, State and AvailabilityZone
+	MetricInstances = "Instances"
+	// MetricDNSRecords counts the DNS names managed by the instances controller
+	MetricDNSRecords = "DNSRecords"
+	// MetricInstancesDrifted counts the instances whose configuration does not match the desired state
+	MetricInstancesDrifted = "InstancesDrifted"
+	// MetricReconcileErrors counts the failed reconciles of instances since the last publication
+	MetricReconcileErrors = "ReconcileErrors"
+)
+
+// noRole is the Role dimension of instances without a role
+const noRole = "none"
+
+// CloudWatchMetricsController periodically publishes custom CloudWatch metrics describing the size and health
+// of the cluster, so that alarms and dashboards can be built without Prometheus.
+type CloudWatchMetricsController struct {
+	cloud     *kopeaws.AWSCloud
+	instances inventory.InstanceLister
+	status    func() *instances.ReconcileStatus
+	namespace string
+
+	// groups holds the Role/State/AvailabilityZone dimensions we have published instance counts for, so that
+	// we publish zero once a group is empty, rather than leaving alarms with missing data
+	groups map[instanceGroup]bool
+	// reconcileErrors is the count of reconcile errors at our last publication
+	reconcileErrors int64
+
+	*kope.PeriodicController
+}
+
+// instanceGroup is the dimensions of an instance count
+type instanceGroup struct {
+	Role             string
+	State            string
+	AvailabilityZone string
+}
+
+// NewCloudWatchMetricsController builds the controller; status may be nil if the instances controller is not
+// built, in which case we only publish instance counts
+func NewCloudWatchMetricsController(cloud *kopeaws.AWSCloud, lister inventory.InstanceLister, status func() *instances.ReconcileStatus, namespace string, period time.Duration) (*CloudWatchMetricsController, error) {
+	if namespace == "" || strings.HasPrefix(namespace, "AWS/") {
+		return nil, fmt.Errorf("invalid CloudWatch namespace %q: must be set, and must not start with AWS/", namespace)
+	}
+
+	c := &CloudWatchMetricsController{
+		cloud:     cloud,
+		instances: lister,
+		status:    status,
+		namespace: namespace,
+		groups:    make(map[instanceGroup]bool),
+	}
+	c.PeriodicController = kope.NewPeriodicController("CloudWatch metrics controller", period, c.runOnce)
+	return c, nil
+}
+
+func (c *CloudWatchMetricsController) runOnce(ctx context.Context) error {
+	clusterInstances, err := c.instances.ListInstances(ctx)
+	if err != nil {
+		return err
+	}
+
+	clusterID := c.cloud.ClusterID()
+	metric := func(name string, value float64, dimensions map[string]string) kopeaws.CloudWatchMetric {
+		if dimensions == nil {
+			dimensions = make(map[string]string)
+		}
+		dimensions["ClusterId"] = clusterID
+		return kopeaws.CloudWatchMetric{Name: name, Dimensions: dimensions, Value: value, Unit: cloudwatch.StandardUnitCount}
+	}
+
+	counts := make(map[instanceGroup]int)
+	for _, i := range clusterInstances {
+		g := instanceGroup{Role: i.Role, State: i.State, AvailabilityZone: i.Zone}
+		if g.Role == "" {
+			g.Role = noRole
+		}
+		counts[g]++
+	}
+	for g := range counts {
+		c.groups[g] = true
+	}
+	var groups []instanceGroup
+	for g := range c.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(a, b int) bool {
+		return groups[a].Role+"/"+groups[a].State+"/"+groups[a].AvailabilityZone < groups[b].Role+"/"+groups[b].State+"/"+groups[b].AvailabilityZone
+	})
+
+	metrics := []kopeaws.CloudWatchMetric{metric(MetricInstances, float64(len(clusterInstances)), nil)}
+	for _, g := range groups {
+		metrics = append(metrics, metric(MetricInstances, float64(counts[g]), map[string]string{
+			"Role":             g.Role,
+			"State":            g.State,
+			"AvailabilityZone": g.AvailabilityZone,
+		}))
+	}
+
+	var reconcileErrors int64
+	if c.status != nil {
+		status := c.status()
+		reconcileErrors = status.Reconcile.Errors
+		errors := reconcileErrors - c.reconcileErrors
+		if errors < 0 {
+			errors = 0
+		}
+		metrics = append(metrics,
+			metric(MetricDNSRecords, float64(status.DNSRecords), nil),
+			metric(MetricInstancesDrifted, float64(len(status.Drift)), nil),
+			metric(MetricReconcileErrors, float64(errors), nil))
+	}
+
+	if err := c.cloud.PutCloudWatchMetrics(ctx, c.namespace, metrics); err != nil {
+		return err
+	}
+	glog.V(4).Infof("Published %d CloudWatch metrics to %q", len(metrics), c.namespace)
+
+	c.reconcileErrors = reconcileErrors
+	// Once we have published zero for an empty group, we stop publishing it
+	for g := range c.groups {
+		if counts[g] == 0 {
+			delete(c.groups, g)
+		}
+	}
+	return nil
+}
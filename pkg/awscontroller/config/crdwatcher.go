@@ -0,0 +1,197 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/apis/awscontroller/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"sync"
+	"time"
+)
+
+// ApplyFunc applies a configuration, returning an error if it is invalid
+type ApplyFunc func(spec *v1alpha1.AWSControllerConfigSpec) error
+
+// StatusFunc returns the reconcile status to publish, which must be serializable to JSON
+type StatusFunc func() interface{}
+
+// CRDWatcher watches an AWSControllerConfig object, applying its spec whenever it changes
+// (or the flag defaults, if it is deleted), and periodically publishes the reconcile status
+// to the object's status.
+type CRDWatcher struct {
+	name         string
+	client       dynamic.Interface
+	apply        ApplyFunc
+	status       StatusFunc
+	statusPeriod time.Duration
+
+	// mutex protects observedGeneration and configError
+	mutex              sync.Mutex
+	observedGeneration int64
+	configError        string
+
+	// stopLock protects shutdown, so that stopCh is only closed once
+	stopLock sync.Mutex
+	shutdown bool
+	stopCh   chan struct{}
+
+	// ctx is cancelled by Stop, aborting any in-flight calls
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewCRDWatcher(client dynamic.Interface, name string, apply ApplyFunc, status StatusFunc, statusPeriod time.Duration) *CRDWatcher {
+	c := &CRDWatcher{
+		name:         name,
+		client:       client,
+		apply:        apply,
+		status:       status,
+		statusPeriod: statusPeriod,
+		stopCh:       make(chan struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+func (c *CRDWatcher) Run() {
+	glog.Infof("watching %s %q for configuration", v1alpha1.Kind, c.name)
+
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.client, 0, metav1.NamespaceAll, func(options *metav1.ListOptions) {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", c.name).String()
+	})
+	informer := factory.ForResource(v1alpha1.Resource).Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.onUpdate,
+		UpdateFunc: func(old, obj interface{}) {
+			c.onUpdate(obj)
+		},
+		DeleteFunc: c.onDelete,
+	})
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("error watching %s: %v", v1alpha1.Kind, err))
+		return
+	}
+	factory.Start(c.stopCh)
+
+	if c.status != nil {
+		go wait.Until(c.writeStatus, c.statusPeriod, c.stopCh)
+	}
+
+	<-c.stopCh
+	glog.Infof("shutting down configuration watcher")
+}
+
+// Stop stops the configuration watcher.
+func (c *CRDWatcher) Stop() error {
+	c.stopLock.Lock()
+	defer c.stopLock.Unlock()
+
+	if !c.shutdown {
+		close(c.stopCh)
+		c.cancel()
+		c.shutdown = true
+
+		return nil
+	}
+
+	return fmt.Errorf("shutdown already in progress")
+}
+
+func (c *CRDWatcher) onUpdate(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		runtime.HandleError(fmt.Errorf("unexpected object of type %T", obj))
+		return
+	}
+
+	config := &v1alpha1.AWSControllerConfig{}
+	data, err := u.MarshalJSON()
+	if err == nil {
+		err = json.Unmarshal(data, config)
+	}
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("error parsing %s %q: %v", v1alpha1.Kind, u.GetName(), err))
+		return
+	}
+
+	c.mutex.Lock()
+	observed := c.observedGeneration
+	c.mutex.Unlock()
+	if config.Generation != 0 && config.Generation == observed {
+		// Only the status changed
+		return
+	}
+
+	glog.Infof("Applying configuration from %s %q (generation %d)", v1alpha1.Kind, c.name, config.Generation)
+	c.recordApply(config.Generation, c.apply(&config.Spec))
+}
+
+func (c *CRDWatcher) onDelete(obj interface{}) {
+	glog.Infof("%s %q deleted; reverting to default configuration", v1alpha1.Kind, c.name)
+	c.recordApply(0, c.apply(&v1alpha1.AWSControllerConfigSpec{}))
+}
+
+func (c *CRDWatcher) recordApply(generation int64, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.observedGeneration = generation
+	c.configError = ""
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("error applying configuration: %v", err))
+		c.configError = err.Error()
+	}
+}
+
+// writeStatus publishes the current status to the config object, if it exists
+func (c *CRDWatcher) writeStatus() {
+	client := c.client.Resource(v1alpha1.Resource)
+
+	u, err := client.Get(c.ctx, c.name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			runtime.HandleError(fmt.Errorf("error fetching %s %q: %v", v1alpha1.Kind, c.name, err))
+		}
+		return
+	}
+
+	instances, err := json.Marshal(c.status())
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("error serializing status: %v", err))
+		return
+	}
+
+	c.mutex.Lock()
+	status := v1alpha1.AWSControllerConfigStatus{
+		ObservedGeneration: c.observedGeneration,
+		ConfigError:        c.configError,
+		Instances:          instances,
+	}
+	c.mutex.Unlock()
+
+	// Round-trip through JSON to get the unstructured representation
+	var statusObject map[string]interface{}
+	data, err := json.Marshal(status)
+	if err == nil {
+		err = json.Unmarshal(data, &statusObject)
+	}
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("error serializing status: %v", err))
+		return
+	}
+	u.Object["status"] = statusObject
+
+	if _, err := client.UpdateStatus(c.ctx, u, metav1.UpdateOptions{}); err != nil {
+		runtime.HandleError(fmt.Errorf("error updating status of %s %q: %v", v1alpha1.Kind, c.name, err))
+	}
+}
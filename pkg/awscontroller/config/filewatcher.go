@@ -0,0 +1,124 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/apis/awscontroller/v1alpha1"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"os"
+	"sigs.k8s.io/yaml"
+	"sync"
+	"time"
+)
+
+// FileWatcher watches a configuration file (typically a key of a mounted ConfigMap) holding an
+// AWSControllerConfigSpec in YAML or JSON, applying it whenever it changes.
+//
+// The file is polled, rather than watched with inotify, because the kubelet updates mounted
+// ConfigMaps by swapping symlinks, which is easy to miss; a missing file applies the defaults.
+type FileWatcher struct {
+	path   string
+	apply  ApplyFunc
+	period time.Duration
+
+	// mutex serializes loads, so that a Reload doesn't race with polling; it protects last and applied
+	mutex sync.Mutex
+	// last holds the contents we last applied; nil if the file did not exist
+	last    []byte
+	applied bool
+
+	// stopLock protects shutdown, so that stopCh is only closed once
+	stopLock sync.Mutex
+	shutdown bool
+	stopCh   chan struct{}
+}
+
+func NewFileWatcher(path string, apply ApplyFunc, period time.Duration) *FileWatcher {
+	return &FileWatcher{
+		path:   path,
+		apply:  apply,
+		period: period,
+		stopCh: make(chan struct{}),
+	}
+}
+
+// NeedLeaderElection returns false: standby replicas load the configuration too, so they are ready to take over
+func (c *FileWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+func (c *FileWatcher) Run() {
+	glog.Infof("watching %q for configuration", c.path)
+
+	go wait.Until(func() {
+		if err := c.load(false); err != nil {
+			runtime.HandleError(err)
+		}
+	}, c.period, c.stopCh)
+
+	<-c.stopCh
+	glog.Infof("shutting down configuration watcher")
+}
+
+// Stop stops the configuration watcher.
+func (c *FileWatcher) Stop() error {
+	c.stopLock.Lock()
+	defer c.stopLock.Unlock()
+
+	if !c.shutdown {
+		close(c.stopCh)
+		c.shutdown = true
+
+		return nil
+	}
+
+	return fmt.Errorf("shutdown already in progress")
+}
+
+// Load reads and applies the configuration file, if it has changed since it was last applied
+func (c *FileWatcher) Load() error {
+	return c.load(false)
+}
+
+// Reload reads and applies the configuration file, even if it has not changed, e.g. because the defaults have
+func (c *FileWatcher) Reload() error {
+	return c.load(true)
+}
+
+func (c *FileWatcher) load(force bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("error reading configuration file %q: %v", c.path, err)
+		}
+		data = nil
+	}
+
+	if !force && c.applied && bytes.Equal(data, c.last) && (data == nil) == (c.last == nil) {
+		return nil
+	}
+
+	spec := &v1alpha1.AWSControllerConfigSpec{}
+	if data == nil {
+		glog.Infof("Configuration file %q not found; applying default configuration", c.path)
+	} else {
+		glog.Infof("Applying configuration from %q", c.path)
+		if err := yaml.UnmarshalStrict(data, spec); err != nil {
+			return fmt.Errorf("error parsing configuration file %q: %v", c.path, err)
+		}
+	}
+
+	if err := c.apply(spec); err != nil {
+		return fmt.Errorf("error applying configuration file %q: %v", c.path, err)
+	}
+
+	c.last = data
+	c.applied = true
+	return nil
+}
@@ -0,0 +1,218 @@
+package costallocation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/inventory"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// The limits on the length (in characters) of EC2 tag keys and values
+const (
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// ReportPath is the path at which the compliance report is served
+const ReportPath = "/api/v1/cost-allocation"
+
+// Report is the compliance report of the last resync
+type Report struct {
+	Time time.Time `json:"time"`
+	// Resources is the number of resources checked
+	Resources int `json:"resources"`
+	// Noncompliant lists the resources which are still missing cost allocation tags
+	Noncompliant []NoncompliantResource `json:"noncompliant"`
+}
+
+// NoncompliantResource is a resource missing some of the cost allocation tags
+type NoncompliantResource struct {
+	Type    string   `json:"type"`
+	ID      string   `json:"id"`
+	Region  string   `json:"region,omitempty"`
+	Missing []string `json:"missing"`
+	// Error is the error tagging the resource, if we tried
+	Error string `json:"error,omitempty"`
+}
+
+// CostAllocationController ensures the cost allocation tags are present on the cluster's instances, volumes,
+// network interfaces and load balancers, and reports the resources which are missing any of them.  Tags are
+// only added, never overwritten: a resource may carry a different value, e.g. of a team which owns it.  A tag
+// with an empty value is only required, and reported if missing, as we cannot know its value.
+type CostAllocationController struct {
+	// Tags are the cost allocation tags
+	Tags map[string]string
+	// ReportOnly reports the resources missing tags, without tagging them
+	ReportOnly bool
+
+	cloud     *kopeaws.AWSCloud
+	instances inventory.InstanceLister
+
+	// mutex protects report
+	mutex  sync.Mutex
+	report *Report
+
+	*kope.PeriodicController
+}
+
+func NewCostAllocationController(cloud *kopeaws.AWSCloud, instances inventory.InstanceLister, tags map[string]string, period time.Duration) (*CostAllocationController, error) {
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("no cost allocation tags specified")
+	}
+	for k, v := range tags {
+		if err := validateTag(k, v); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &CostAllocationController{
+		Tags:      tags,
+		cloud:     cloud,
+		instances: instances,
+	}
+	c.PeriodicController = kope.NewPeriodicController("cost allocation controller", period, c.runOnce)
+	return c, nil
+}
+
+// validateTag checks a cost allocation tag against the EC2 tag restrictions, so that a bad tag is reported
+// at startup rather than failing every resync
+func validateTag(k string, v string) error {
+	if k == "" {
+		return fmt.Errorf("invalid cost allocation tag: the key is empty")
+	}
+	if strings.HasPrefix(strings.ToLower(k), "aws:") {
+		return fmt.Errorf("invalid cost allocation tag %q: the aws: prefix is reserved", k)
+	}
+	if utf8.RuneCountInString(k) > maxTagKeyLength {
+		return fmt.Errorf("invalid cost allocation tag %q: keys are limited to %d characters", k, maxTagKeyLength)
+	}
+	if utf8.RuneCountInString(v) > maxTagValueLength {
+		return fmt.Errorf("invalid value of cost allocation tag %q: values are limited to %d characters", k, maxTagValueLength)
+	}
+	return nil
+}
+
+// Report returns the compliance report of the last resync, or nil before the first
+func (c *CostAllocationController) Report() *Report {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.report
+}
+
+// ServeHTTP serves the compliance report as JSON
+func (c *CostAllocationController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := c.Report()
+	if report == nil {
+		http.Error(w, "cost allocation tags not yet checked", http.StatusServiceUnavailable)
+		return
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (c *CostAllocationController) runOnce(ctx context.Context) error {
+	instances, err := c.instances.ListInstances(ctx)
+	if err != nil {
+		return err
+	}
+
+	var resources []*kopeaws.TaggableResource
+	var instanceIDs []string
+	for _, i := range instances {
+		if i.State == kope.InstanceStateTerminated {
+			continue
+		}
+		instanceIDs = append(instanceIDs, i.ID)
+		resources = append(resources, &kopeaws.TaggableResource{
+			Type:   kopeaws.ResourceTypeInstance,
+			ID:     i.ID,
+			Region: i.Region,
+			Tags:   i.Tags,
+		})
+	}
+	sort.Slice(resources, func(a, b int) bool { return resources[a].ID < resources[b].ID })
+
+	others, err := c.cloud.ListTaggableResources(ctx, instanceIDs)
+	if err != nil {
+		return err
+	}
+	resources = append(resources, others...)
+
+	report := &Report{
+		Time:         time.Now(),
+		Resources:    len(resources),
+		Noncompliant: []NoncompliantResource{},
+	}
+	for _, r := range resources {
+		if n := c.syncResource(ctx, r); n != nil {
+			report.Noncompliant = append(report.Noncompliant, *n)
+		}
+	}
+	if len(report.Noncompliant) != 0 {
+		glog.V(2).Infof("%d of %d resources are missing cost allocation tags", len(report.Noncompliant), len(resources))
+	}
+
+	c.mutex.Lock()
+	c.report = report
+	c.mutex.Unlock()
+	return nil
+}
+
+// syncResource adds the missing tags to the resource, returning the tags it is still missing, or nil if none
+func (c *CostAllocationController) syncResource(ctx context.Context, r *kopeaws.TaggableResource) *NoncompliantResource {
+	var missing []string
+	add := make(map[string]string)
+	for k, v := range c.Tags {
+		if _, found := r.Tags[k]; found {
+			continue
+		}
+		missing = append(missing, k)
+		if v != "" {
+			add[k] = v
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+
+	n := &NoncompliantResource{Type: r.Type, ID: r.ID, Region: r.Region, Missing: missing}
+	if len(add) == 0 {
+		return n
+	}
+	if c.ReportOnly {
+		glog.Infof("%s %q is missing cost allocation tags %v; would tag (report-only)", r.Type, r.ID, missing)
+		return n
+	}
+	if err := c.cloud.TagResource(ctx, r, add); err != nil {
+		runtime.HandleError(err)
+		n.Error = err.Error()
+		return n
+	}
+
+	n.Missing = nil
+	for _, k := range missing {
+		if _, added := add[k]; !added {
+			n.Missing = append(n.Missing, k)
+		}
+	}
+	if len(n.Missing) == 0 {
+		return nil
+	}
+	return n
+}
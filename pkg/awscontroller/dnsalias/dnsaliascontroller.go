@@ -0,0 +1,127 @@
+package dnsalias
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DNSAliasController publishes Route53 alias records pointing at load balancers.  Each load balancer
+// is found by ARN or tag on every pass, so a load balancer which is recreated (and so has a new DNS
+// name) is picked up without reconfiguration.
+//
+// If the DNS provider records ownership, the aliases we own which are no longer configured are deleted; the
+// records of other owners are never touched.
+type DNSAliasController struct {
+	// Aliases maps each DNS name to publish to the load balancer it should point at, in the syntax
+	// accepted by kopeaws.FindAliasTarget
+	Aliases map[string]string
+
+	cloud *kopeaws.AWSCloud
+	dns   kope.DNSProvider
+
+	// published holds the alias target we last published for each name
+	published map[string]kopeaws.AliasTarget
+	// removedStale is set once we have deleted the aliases we own which are no longer configured
+	removedStale bool
+
+	*kope.PeriodicController
+}
+
+func NewDNSAliasController(cloud *kopeaws.AWSCloud, dns kope.DNSProvider, aliases map[string]string, period time.Duration) *DNSAliasController {
+	c := &DNSAliasController{
+		Aliases:   aliases,
+		cloud:     cloud,
+		dns:       dns,
+		published: make(map[string]kopeaws.AliasTarget),
+	}
+	c.PeriodicController = kope.NewPeriodicController("DNS alias controller", period, c.runOnce)
+	return c
+}
+
+func (c *DNSAliasController) runOnce(ctx context.Context) error {
+	if !c.removedStale {
+		if err := c.removeStaleAliases(ctx); err != nil {
+			return err
+		}
+		c.removedStale = true
+	}
+
+	var names []string
+	for name := range c.Aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	changes := make(map[kope.DNSRecordKey][]string)
+	changed := make(map[string]kopeaws.AliasTarget)
+	for _, name := range names {
+		target, err := c.cloud.FindAliasTarget(ctx, c.Aliases[name])
+		if err != nil {
+			return err
+		}
+		if target == nil {
+			// We don't delete the record: the load balancer may be in the middle of being replaced
+			glog.Warningf("Load balancer %q for DNS alias %q not found", c.Aliases[name], name)
+			continue
+		}
+		if *target == c.published[name] {
+			continue
+		}
+
+		glog.Infof("Publishing %q as an alias to load balancer %q", name, target.DNSName)
+		key, values := target.Record(name)
+		changes[key] = values
+		changed[name] = *target
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+	if err := c.dns.ApplyDNSChanges(ctx, changes); err != nil {
+		return fmt.Errorf("error publishing DNS aliases: %v", err)
+	}
+	for name, target := range changed {
+		c.published[name] = target
+	}
+	return nil
+}
+
+// removeStaleAliases deletes the alias records we own at names which are no longer configured
+func (c *DNSAliasController) removeStaleAliases(ctx context.Context) error {
+	owned, ok := c.dns.(kope.OwnedDNSProvider)
+	if !ok {
+		return nil
+	}
+	records, err := owned.ListOwnedDNSRecords(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing DNS aliases: %v", err)
+	}
+
+	configured := make(map[string]bool)
+	for name := range c.Aliases {
+		configured[strings.ToLower(strings.TrimSuffix(name, "."))] = true
+	}
+
+	var stale []kope.DNSRecordKey
+	for key := range records {
+		if key.AliasHostedZoneID == "" || configured[strings.ToLower(key.Name)] {
+			continue
+		}
+		stale = append(stale, key)
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	glog.Infof("Removing DNS aliases which are no longer configured: %v", stale)
+	if err := owned.DeleteDNSRecords(ctx, stale); err != nil {
+		return fmt.Errorf("error removing DNS aliases: %v", err)
+	}
+	return nil
+}
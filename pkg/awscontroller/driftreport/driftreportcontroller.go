@@ -0,0 +1,119 @@
+package driftreport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// webhookTimeout bounds each POST of a report to a webhook
+const webhookTimeout = 30 * time.Second
+
+// Report is the drift report we publish
+type Report struct {
+	ClusterID string    `json:"clusterID"`
+	Time      time.Time `json:"time"`
+	// Status is the reconcile status, describing the drift from the desired state
+	Status interface{} `json:"status"`
+}
+
+// DriftReportController periodically publishes a drift report, to S3 (s3://bucket/prefix) or by POSTing
+// it to an HTTP(S) endpoint.  Reports to S3 are written under <prefix>/<cluster-id>/<timestamp>.json.
+type DriftReportController struct {
+	cloud       *kopeaws.AWSCloud
+	destination *url.URL
+	status      func() interface{}
+
+	*kope.PeriodicController
+}
+
+func NewDriftReportController(cloud *kopeaws.AWSCloud, destination string, status func() interface{}, period time.Duration) (*DriftReportController, error) {
+	u, err := ParseDestination(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &DriftReportController{
+		cloud:       cloud,
+		destination: u,
+		status:      status,
+	}
+	c.PeriodicController = kope.NewPeriodicController("drift report controller", period, c.runOnce)
+	return c, nil
+}
+
+// ParseDestination parses and validates a report destination
+func ParseDestination(destination string) (*url.URL, error) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, fmt.Errorf("invalid report destination %q: %v", destination, err)
+	}
+	switch u.Scheme {
+	case "s3", "http", "https":
+	default:
+		return nil, fmt.Errorf("invalid report destination %q: expected s3://, http:// or https://", destination)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid report destination %q: no bucket or host", destination)
+	}
+	return u, nil
+}
+
+func (c *DriftReportController) runOnce(ctx context.Context) error {
+	report := &Report{
+		ClusterID: c.cloud.ClusterID(),
+		Time:      time.Now().UTC(),
+		Status:    c.status(),
+	}
+	body, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error serializing drift report: %v", err)
+	}
+
+	if c.destination.Scheme == "s3" {
+		key := strings.Trim(c.destination.Path, "/")
+		if key != "" {
+			key += "/"
+		}
+		key += report.ClusterID + "/" + report.Time.Format("20060102T150405Z") + ".json"
+		if err := c.cloud.PutS3Object(ctx, c.destination.Host, key, body, "application/json"); err != nil {
+			return err
+		}
+		glog.V(2).Infof("Published drift report to s3://%s/%s", c.destination.Host, key)
+		return nil
+	}
+
+	return c.post(ctx, body)
+}
+
+// post POSTs the report to the webhook
+func (c *DriftReportController) post(ctx context.Context, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.destination.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error building drift report request: %v", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("error posting drift report to %s: %v", c.destination.Host, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("drift report endpoint %s returned %s", c.destination.Host, response.Status)
+	}
+	glog.V(2).Infof("Published drift report to %s", c.destination.Host)
+	return nil
+}
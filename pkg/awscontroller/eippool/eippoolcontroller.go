@@ -0,0 +1,111 @@
+package eippool
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"sort"
+	"time"
+)
+
+// EIPPoolController assigns elastic IPs from a tagged pool to the instances with the egress role,
+// and reclaims them from instances which are terminated or no longer have the role, so that the
+// cluster's outbound addresses are always drawn from a stable set.
+type EIPPoolController struct {
+	// Role is the role tag identifying the instances which get an address
+	Role string
+
+	pool  string
+	cloud *kopeaws.AWSCloud
+	// cache is the instance list shared with other controllers
+	cache *kopeaws.InstanceCache
+
+	*kope.PeriodicController
+}
+
+func NewEIPPoolController(cloud *kopeaws.AWSCloud, cache *kopeaws.InstanceCache, pool string, period time.Duration) *EIPPoolController {
+	c := &EIPPoolController{
+		Role:  kopeaws.RoleEgress,
+		pool:  pool,
+		cloud: cloud,
+		cache: cache,
+	}
+	c.PeriodicController = kope.NewPeriodicController(fmt.Sprintf("EIP pool controller for pool %q", pool), period, c.runOnce)
+	return c
+}
+
+func (c *EIPPoolController) runOnce(ctx context.Context) error {
+	instances, err := c.cache.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	addresses, err := c.cloud.ListPoolAddresses(ctx, c.pool)
+	if err != nil {
+		return err
+	}
+
+	// eligible holds the instances which should have an address
+	eligible := make(map[string]*ec2.Instance)
+	for _, i := range instances {
+		if !kopeaws.HasRole(i, c.Role) {
+			continue
+		}
+		switch aws.StringValue(i.State.Name) {
+		case "shutting-down", "terminated":
+			continue
+		}
+		eligible[aws.StringValue(i.InstanceId)] = i
+	}
+
+	// Reclaim addresses from instances which are no longer eligible
+	assigned := make(map[string]bool)
+	free := make(map[string][]*kopeaws.Address)
+	for _, address := range addresses {
+		if address.AssociationID == "" {
+			free[address.Region] = append(free[address.Region], address)
+			continue
+		}
+		if address.InstanceID != "" && eligible[address.InstanceID] != nil && !assigned[address.InstanceID] {
+			assigned[address.InstanceID] = true
+			continue
+		}
+
+		// Associated with a non-eligible instance, another network interface, or a second
+		// address on the same instance
+		if err := c.cloud.DisassociateAddress(ctx, address); err != nil {
+			runtime.HandleError(err)
+			continue
+		}
+		free[address.Region] = append(free[address.Region], address)
+	}
+
+	var ids []string
+	for id := range eligible {
+		if !assigned[id] && aws.StringValue(eligible[id].State.Name) == "running" {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		region := c.cloud.InstanceRegion(id)
+		if len(free[region]) == 0 {
+			glog.Warningf("EIP pool %q has no free address in %s for instance %q", c.pool, region, id)
+			continue
+		}
+		address := free[region][0]
+		free[region] = free[region][1:]
+
+		if err := c.cloud.AssociateAddress(ctx, address, id); err != nil {
+			runtime.HandleError(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	"strings"
+	"time"
+)
+
+// maxLoadBalancerNameLength is the length to which the cloud provider truncates load balancer names
+const maxLoadBalancerNameLength = 32
+
+// LoadBalancerGCController deletes the cluster's classic ELBs once the Service they were created for
+// no longer exists (or no longer wants a load balancer).  Only load balancers tagged with the Service
+// they were created for are considered: others (such as the API load balancer) may legitimately have
+// no live instances, e.g. while every node is replaced, and are never deleted.  A load balancer must
+// remain orphaned for GracePeriod before it is deleted.
+type LoadBalancerGCController struct {
+	// GracePeriod is how long a load balancer must be orphaned before it is deleted
+	GracePeriod time.Duration
+
+	cloud      *kopeaws.AWSCloud
+	kubernetes kubernetes.Interface
+
+	// orphanedSince records when we first saw each orphaned load balancer, keyed by region/name
+	orphanedSince map[string]time.Time
+
+	*kope.PeriodicController
+}
+
+func NewLoadBalancerGCController(cloud *kopeaws.AWSCloud, kubernetes kubernetes.Interface, period time.Duration) *LoadBalancerGCController {
+	c := &LoadBalancerGCController{
+		GracePeriod:   time.Hour,
+		cloud:         cloud,
+		kubernetes:    kubernetes,
+		orphanedSince: make(map[string]time.Time),
+	}
+	c.PeriodicController = kope.NewPeriodicController("load balancer GC controller", period, c.runOnce)
+	return c
+}
+
+func (c *LoadBalancerGCController) runOnce(ctx context.Context) error {
+	loadBalancers, err := c.cloud.ListClassicLoadBalancers(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	orphanedSince := make(map[string]time.Time)
+	for _, lb := range loadBalancers {
+		orphaned, reason, err := c.isOrphaned(ctx, lb)
+		if err != nil {
+			runtime.HandleError(err)
+			continue
+		}
+		if !orphaned {
+			continue
+		}
+
+		key := lb.Region + "/" + lb.Name
+		since, found := c.orphanedSince[key]
+		if !found {
+			glog.Infof("Load balancer %q in %s is orphaned (%s); deleting after %v", lb.Name, lb.Region, reason, c.GracePeriod)
+			since = now
+		}
+		orphanedSince[key] = since
+
+		// Never delete a load balancer younger than the grace period; the cloud provider may
+		// not yet have tagged it or registered instances
+		if now.Sub(since) < c.GracePeriod || now.Sub(lb.Created) < c.GracePeriod {
+			continue
+		}
+
+		if err := c.cloud.DeleteClassicLoadBalancer(ctx, lb); err != nil {
+			runtime.HandleError(err)
+			continue
+		}
+		delete(orphanedSince, key)
+	}
+	c.orphanedSince = orphanedSince
+
+	return nil
+}
+
+// isOrphaned returns true (and a description of why) if the load balancer is no longer needed
+func (c *LoadBalancerGCController) isOrphaned(ctx context.Context, lb *kopeaws.ClassicLoadBalancer) (bool, string, error) {
+	if lb.ServiceName == "" {
+		// Not created for a Service; we cannot know whether it is still needed
+		return false, "", nil
+	}
+
+	tokens := strings.SplitN(lb.ServiceName, "/", 2)
+	if len(tokens) != 2 {
+		return false, "", fmt.Errorf("load balancer %q has unexpected service name tag %q", lb.Name, lb.ServiceName)
+	}
+
+	service, err := c.kubernetes.CoreV1().Services(tokens[0]).Get(ctx, tokens[1], metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return true, fmt.Sprintf("service %s not found", lb.ServiceName), nil
+		}
+		return false, "", fmt.Errorf("error getting service %s: %v", lb.ServiceName, err)
+	}
+	if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+		return true, fmt.Sprintf("service %s is of type %s", lb.ServiceName, service.Spec.Type), nil
+	}
+	if lb.Name != defaultLoadBalancerName(service) {
+		// The service was deleted and recreated with the same name
+		return true, fmt.Sprintf("service %s has been recreated", lb.ServiceName), nil
+	}
+	return false, "", nil
+}
+
+// defaultLoadBalancerName is the name the cloud provider gives the load balancer for a service
+func defaultLoadBalancerName(service *v1.Service) string {
+	name := "a" + strings.Replace(string(service.UID), "-", "", -1)
+	if len(name) > maxLoadBalancerNameLength {
+		name = name[:maxLoadBalancerNameLength]
+	}
+	return name
+}
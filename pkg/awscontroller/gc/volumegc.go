@@ -0,0 +1,139 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	"strings"
+	"time"
+)
+
+// TagNameAvailableSince records when we first saw a volume detached.  EC2 doesn't report when a
+// volume was detached, so we record it on the volume itself, where it survives restarts.
+const TagNameAvailableSince = "k8s.io/aws-controller/available-since"
+
+// TagNameCreatedForPV is set by the in-tree and EBS CSI provisioners on the volumes of PersistentVolumes
+const TagNameCreatedForPV = "kubernetes.io/created-for/pv/name"
+
+// VolumeGCController deletes the cluster's EBS volumes once they have been detached for longer
+// than TTL, optionally taking a final snapshot first.  The volumes of PersistentVolumes are never deleted,
+// whether they are tagged as provisioned for a PV or referenced by one: a detached PV volume holds data
+// waiting for its pod to be rescheduled.
+type VolumeGCController struct {
+	// TTL is how long a volume must be detached before it is deleted
+	TTL time.Duration
+	// Snapshot takes a snapshot of each volume before it is deleted
+	Snapshot bool
+	// ReportOnly logs the volumes which would be deleted, without deleting them
+	ReportOnly bool
+
+	cloud      *kopeaws.AWSCloud
+	kubernetes kubernetes.Interface
+
+	*kope.PeriodicController
+}
+
+func NewVolumeGCController(cloud *kopeaws.AWSCloud, kubernetes kubernetes.Interface, ttl time.Duration, period time.Duration) *VolumeGCController {
+	c := &VolumeGCController{
+		TTL:        ttl,
+		cloud:      cloud,
+		kubernetes: kubernetes,
+	}
+	c.PeriodicController = kope.NewPeriodicController("volume GC controller", period, c.runOnce)
+	return c
+}
+
+func (c *VolumeGCController) runOnce(ctx context.Context) error {
+	volumes, err := c.cloud.ListVolumes(ctx)
+	if err != nil {
+		return err
+	}
+	pvVolumes, err := c.listPVVolumes(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, v := range volumes {
+		if _, found := v.Tags[TagNameCreatedForPV]; found || pvVolumes[v.ID] {
+			glog.V(4).Infof("Ignoring volume %q of a PersistentVolume", v.ID)
+			continue
+		}
+		if err := c.syncVolume(ctx, v, now); err != nil {
+			runtime.HandleError(err)
+		}
+	}
+	return nil
+}
+
+func (c *VolumeGCController) syncVolume(ctx context.Context, v *kopeaws.Volume, now time.Time) error {
+	tag, tagged := v.Tags[TagNameAvailableSince]
+
+	if v.State != "available" {
+		if tagged && v.State == "in-use" {
+			// Reattached; restart the clock if it is detached again
+			return c.cloud.UntagVolume(ctx, v, []string{TagNameAvailableSince})
+		}
+		return nil
+	}
+
+	if !tagged {
+		return c.cloud.TagVolume(ctx, v, map[string]string{TagNameAvailableSince: now.UTC().Format(time.RFC3339)})
+	}
+
+	since, err := time.Parse(time.RFC3339, tag)
+	if err != nil {
+		glog.Warningf("Ignoring invalid %s tag %q on volume %q; resetting", TagNameAvailableSince, tag, v.ID)
+		return c.cloud.TagVolume(ctx, v, map[string]string{TagNameAvailableSince: now.UTC().Format(time.RFC3339)})
+	}
+
+	detached := now.Sub(since)
+	if detached < c.TTL {
+		return nil
+	}
+
+	if c.ReportOnly {
+		glog.Infof("Volume %q has been detached for %v; would delete (report-only)", v.ID, detached)
+		return nil
+	}
+
+	if c.Snapshot {
+		snapshotID, err := c.cloud.SnapshotVolume(ctx, v, fmt.Sprintf("Final snapshot of %s, detached since %s; created by aws-controller", v.ID, tag), nil)
+		if err != nil {
+			return err
+		}
+		glog.Infof("Created snapshot %q of volume %q", snapshotID, v.ID)
+	}
+
+	glog.Infof("Volume %q has been detached for %v; deleting", v.ID, detached)
+	return c.cloud.DeleteVolume(ctx, v)
+}
+
+// listPVVolumes returns the ids of the EBS volumes referenced by PersistentVolumes
+func (c *VolumeGCController) listPVVolumes(ctx context.Context) (map[string]bool, error) {
+	pvs, err := c.kubernetes.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing persistent volumes: %v", err)
+	}
+
+	ids := make(map[string]bool)
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		var volumeID string
+		if pv.Spec.AWSElasticBlockStore != nil {
+			volumeID = pv.Spec.AWSElasticBlockStore.VolumeID
+		} else if pv.Spec.CSI != nil {
+			volumeID = pv.Spec.CSI.VolumeHandle
+		}
+		// In-tree volume ids may be of the form aws://<zone>/<volume-id>
+		if volumeID = volumeID[strings.LastIndex(volumeID, "/")+1:]; strings.HasPrefix(volumeID, "vol-") {
+			ids[volumeID] = true
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,95 @@
+package instances
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"sort"
+)
+
+// DNSRecordTruncation describes a record set which holds more addresses than Policy.MaxRecordsPerName allows
+type DNSRecordTruncation struct {
+	Record string `json:"record"`
+	// Members is the number of addresses which share the record set
+	Members int `json:"members"`
+	// Published is the number of addresses we publish
+	Published int `json:"published"`
+}
+
+// capRecords limits each A record set to max addresses, keeping the addresses of a stable subset of the
+// instances: the oldest in each availability zone, taken from each zone in turn so that the subset is spread
+// across zones.  It returns the record sets which were truncated.
+func capRecords(records map[kope.DNSRecordKey][]string, instances map[string]*instance, max int) []DNSRecordTruncation {
+	// byAddress maps each address to its instance
+	byAddress := make(map[string]*instance)
+	for _, i := range instances {
+		for _, ip := range []*string{i.status.PrivateIpAddress, i.status.PublicIpAddress} {
+			if ip := aws.StringValue(ip); ip != "" {
+				byAddress[ip] = i
+			}
+		}
+	}
+
+	var truncated []DNSRecordTruncation
+	for k, values := range records {
+		if k.Type != kope.DNSTypeA || len(values) <= max {
+			continue
+		}
+		selected := selectMembers(values, byAddress, max)
+		glog.V(2).Infof("Publishing %d of the %d addresses of %s", len(selected), len(values), k)
+		truncated = append(truncated, DNSRecordTruncation{Record: k.String(), Members: len(values), Published: len(selected)})
+		records[k] = selected
+	}
+	sort.Slice(truncated, func(a, b int) bool { return truncated[a].Record < truncated[b].Record })
+	return truncated
+}
+
+// selectMembers chooses max of the addresses (or all of them, if there are no more than max), round-robin
+// across availability zones, oldest instance first; addresses whose instance is unknown sort last
+func selectMembers(values []string, byAddress map[string]*instance, max int) []string {
+	zones := make(map[string][]string)
+	for _, v := range values {
+		zone := ""
+		if i := byAddress[v]; i != nil && i.status.Placement != nil {
+			zone = aws.StringValue(i.status.Placement.AvailabilityZone)
+		}
+		zones[zone] = append(zones[zone], v)
+	}
+
+	var zoneNames []string
+	for zone, members := range zones {
+		zoneNames = append(zoneNames, zone)
+		sort.Slice(members, func(a, b int) bool {
+			return olderMember(members[a], members[b], byAddress)
+		})
+	}
+	sort.Strings(zoneNames)
+
+	var selected []string
+	for n := 0; len(selected) < max && len(selected) < len(values); n++ {
+		for _, zone := range zoneNames {
+			if n < len(zones[zone]) && len(selected) < max {
+				selected = append(selected, zones[zone][n])
+			}
+		}
+	}
+	sort.Strings(selected)
+	return selected
+}
+
+// olderMember returns true if the instance with address a was launched before that with address b,
+// breaking ties by instance id and then address
+func olderMember(a string, b string, byAddress map[string]*instance) bool {
+	ia, ib := byAddress[a], byAddress[b]
+	if (ia == nil) != (ib == nil) {
+		return ia != nil
+	}
+	if ia != nil && ia != ib {
+		ta, tb := aws.TimeValue(ia.status.LaunchTime), aws.TimeValue(ib.status.LaunchTime)
+		if !ta.Equal(tb) {
+			return ta.Before(tb)
+		}
+		return ia.ID < ib.ID
+	}
+	return a < b
+}
@@ -0,0 +1,122 @@
+package instances
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testInstance builds an instance with a private address, in zone, launched minutes after a fixed time
+func testInstance(id string, ip string, zone string, minutes int) *instance {
+	return &instance{
+		ID: id,
+		status: &ec2.Instance{
+			InstanceId:       aws.String(id),
+			PrivateIpAddress: aws.String(ip),
+			Placement:        &ec2.Placement{AvailabilityZone: aws.String(zone)},
+			LaunchTime:       aws.Time(time.Date(2020, 1, 1, 0, minutes, 0, 0, time.UTC)),
+		},
+	}
+}
+
+func TestSelectMembers(t *testing.T) {
+	byAddress := make(map[string]*instance)
+	for _, i := range []*instance{
+		testInstance("i-a1", "10.0.1.1", "us-east-1a", 3),
+		testInstance("i-a2", "10.0.1.2", "us-east-1a", 1),
+		testInstance("i-a3", "10.0.1.3", "us-east-1a", 2),
+		testInstance("i-b1", "10.0.2.1", "us-east-1b", 5),
+		testInstance("i-b2", "10.0.2.2", "us-east-1b", 4),
+		testInstance("i-c1", "10.0.3.1", "us-east-1c", 6),
+		// Launched at the same time: the tie is broken by id
+		testInstance("i-d2", "10.0.4.2", "us-east-1d", 0),
+		testInstance("i-d1", "10.0.4.1", "us-east-1d", 0),
+	} {
+		byAddress[aws.StringValue(i.status.PrivateIpAddress)] = i
+	}
+
+	grid := []struct {
+		Values   []string
+		Max      int
+		Expected []string
+	}{
+		{
+			// Oldest first within a zone
+			Values:   []string{"10.0.1.1", "10.0.1.2", "10.0.1.3"},
+			Max:      2,
+			Expected: []string{"10.0.1.2", "10.0.1.3"},
+		},
+		{
+			// Spread across zones before taking a second from any zone
+			Values:   []string{"10.0.1.1", "10.0.1.2", "10.0.1.3", "10.0.2.1", "10.0.2.2", "10.0.3.1"},
+			Max:      3,
+			Expected: []string{"10.0.1.2", "10.0.2.2", "10.0.3.1"},
+		},
+		{
+			Values:   []string{"10.0.1.1", "10.0.1.2", "10.0.1.3", "10.0.2.1", "10.0.2.2", "10.0.3.1"},
+			Max:      4,
+			Expected: []string{"10.0.1.2", "10.0.1.3", "10.0.2.2", "10.0.3.1"},
+		},
+		{
+			Values:   []string{"10.0.4.2", "10.0.4.1"},
+			Max:      1,
+			Expected: []string{"10.0.4.1"},
+		},
+		{
+			// Addresses of unknown instances sort last, by address
+			Values:   []string{"192.168.0.2", "192.168.0.1", "10.0.1.1"},
+			Max:      2,
+			Expected: []string{"10.0.1.1", "192.168.0.1"},
+		},
+		{
+			Values:   []string{"10.0.1.1", "10.0.2.1"},
+			Max:      5,
+			Expected: []string{"10.0.1.1", "10.0.2.1"},
+		},
+	}
+	for _, g := range grid {
+		actual := selectMembers(g.Values, byAddress, g.Max)
+		if !reflect.DeepEqual(actual, g.Expected) {
+			t.Errorf("selectMembers(%v, %d): got %v, expected %v", g.Values, g.Max, actual, g.Expected)
+		}
+	}
+}
+
+func TestCapRecords(t *testing.T) {
+	instances := make(map[string]*instance)
+	for _, i := range []*instance{
+		testInstance("i-a1", "10.0.1.1", "us-east-1a", 1),
+		testInstance("i-a2", "10.0.1.2", "us-east-1a", 2),
+		testInstance("i-b1", "10.0.2.1", "us-east-1b", 3),
+	} {
+		instances[i.ID] = i
+	}
+
+	nodes := kope.ARecord("nodes.example.com")
+	masters := kope.ARecord("masters.example.com")
+	cname := kope.DNSRecordKey{Name: "api.example.com", Type: kope.DNSTypeCNAME}
+	records := map[kope.DNSRecordKey][]string{
+		nodes:   {"10.0.1.1", "10.0.1.2", "10.0.2.1"},
+		masters: {"10.0.1.1", "10.0.2.1"},
+		cname:   {"a.example.com", "b.example.com", "c.example.com"},
+	}
+
+	truncated := capRecords(records, instances, 2)
+
+	expectedTruncated := []DNSRecordTruncation{{Record: nodes.String(), Members: 3, Published: 2}}
+	if !reflect.DeepEqual(truncated, expectedTruncated) {
+		t.Errorf("truncated: got %+v, expected %+v", truncated, expectedTruncated)
+	}
+	expected := map[kope.DNSRecordKey][]string{
+		nodes:   {"10.0.1.1", "10.0.2.1"},
+		masters: {"10.0.1.1", "10.0.2.1"},
+		// Only A records are capped
+		cname: {"a.example.com", "b.example.com", "c.example.com"},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("records: got %v, expected %v", records, expected)
+	}
+}
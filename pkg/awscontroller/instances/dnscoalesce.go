@@ -0,0 +1,41 @@
+package instances
+
+import (
+	"github.com/golang/glog"
+	"time"
+)
+
+// bufferDNSChanges returns true if DNS changes should be held back rather than applied now, so that the
+// changes from a burst of instance churn (such as an autoscaling group scaling up) are applied as a single
+// batch.  The first changes seen start Policy.DNSCoalesceWindow, at the end of which we resync and apply
+// everything that has changed by then.  The caller must hold c.mutex.
+func (c *InstancesController) bufferDNSChanges(count int) bool {
+	window := c.policy.DNSCoalesceWindow
+	if window == 0 || c.policy.ReportOnly {
+		c.dnsFlushTime = time.Time{}
+		return false
+	}
+
+	now := time.Now()
+	if c.dnsFlushTime.IsZero() {
+		c.dnsFlushTime = now.Add(window)
+		glog.Infof("Buffering %d DNS changes for %s", count, window)
+		time.AfterFunc(window, c.triggerResync)
+		return true
+	}
+	if now.Before(c.dnsFlushTime) {
+		glog.V(2).Infof("Buffering %d DNS changes until %s", count, c.dnsFlushTime.Format(time.RFC3339))
+		return true
+	}
+	c.dnsFlushTime = time.Time{}
+	return false
+}
+
+// triggerResync starts a resync without waiting for the rest of the period
+func (c *InstancesController) triggerResync() {
+	select {
+	case c.resyncCh <- struct{}{}:
+	default:
+		// A resync is already pending
+	}
+}
@@ -0,0 +1,248 @@
+package instances
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/notify"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dnsVerifyInterval is how often we query the authoritative name servers for the changes not yet verified
+const dnsVerifyInterval = 10 * time.Second
+
+// pendingDNSRecord is an applied DNS change which the authoritative name servers have not yet been seen to serve
+type pendingDNSRecord struct {
+	// values are the values we applied; empty if the record set was removed
+	values   []string
+	deadline time.Time
+}
+
+// verifiableRecord returns true if we can check the record set by querying the authoritative name servers of
+// the zone: record sets with routing policies are answered differently depending on the client, private
+// record sets are in another zone, and aliases are answered with the target's addresses
+func verifiableRecord(k kope.DNSRecordKey) bool {
+	if k.SetIdentifier != "" || k.AliasHostedZoneID != "" || k.Private {
+		return false
+	}
+	switch k.Type {
+	case kope.DNSTypeA, kope.DNSTypeCNAME, kope.DNSTypeSRV:
+		return true
+	}
+	return false
+}
+
+// expectDNSChanges records the applied changes and removals for verification, if it is enabled by the
+// policy; the caller must hold c.mutex
+func (c *InstancesController) expectDNSChanges(changes map[kope.DNSRecordKey][]string, removed []kope.DNSRecordKey) {
+	window := c.policy.DNSVerifyWindow
+	if window == 0 {
+		c.dnsPending = nil
+		return
+	}
+	if c.dnsPending == nil {
+		c.dnsPending = make(map[kope.DNSRecordKey]*pendingDNSRecord)
+	}
+
+	deadline := time.Now().Add(window)
+	for k, v := range changes {
+		if verifiableRecord(k) {
+			c.dnsPending[k] = &pendingDNSRecord{values: v, deadline: deadline}
+		}
+	}
+	for _, k := range removed {
+		if verifiableRecord(k) {
+			c.dnsPending[k] = &pendingDNSRecord{deadline: deadline}
+		}
+	}
+}
+
+func (c *InstancesController) verifyLoop() {
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-time.After(dnsVerifyInterval):
+		}
+		c.verifyDNS(c.ctx)
+	}
+}
+
+// verifyDNS queries the authoritative name servers for the pending changes.  Changes which are served are
+// forgotten; those still not served after the verification window are reported, and forgotten from our DNS
+// state so that the next resync applies them again.
+func (c *InstancesController) verifyDNS(ctx context.Context) {
+	c.mutex.Lock()
+	dns := c.dns
+	policy := c.policy
+	nameServers := c.dnsNameServers
+	pending := make(map[kope.DNSRecordKey]*pendingDNSRecord)
+	for k, p := range c.dnsPending {
+		pending[k] = p
+	}
+	c.mutex.Unlock()
+
+	if dns == nil || len(pending) == 0 {
+		return
+	}
+
+	var err error
+	if len(policy.DNSVerifyNameServers) != 0 {
+		nameServers = policy.DNSVerifyNameServers
+	} else if len(nameServers) == 0 {
+		nameServers, err = c.findNameServers(ctx, dns)
+		if err == nil {
+			c.mutex.Lock()
+			if c.dns == dns {
+				c.dnsNameServers = nameServers
+			}
+			c.mutex.Unlock()
+		}
+		if err == kope.ErrPrivateZone {
+			// We can't query the name servers of a private zone from here, unless they are configured
+			glog.V(2).Infof("Not verifying %d DNS changes: the zone is private; set the name servers to verify against to enable verification", len(pending))
+			c.mutex.Lock()
+			if c.dns == dns {
+				for k, p := range pending {
+					if c.dnsPending[k] == p {
+						delete(c.dnsPending, k)
+					}
+				}
+			}
+			c.mutex.Unlock()
+			return
+		}
+	}
+
+	mismatches := make(map[kope.DNSRecordKey]string)
+	for k, p := range pending {
+		if err != nil {
+			mismatches[k] = fmt.Sprintf("cannot find the name servers of the zone: %v", err)
+			continue
+		}
+		if mismatch := queryNameServers(ctx, nameServers, k, p.values); mismatch != "" {
+			mismatches[k] = mismatch
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.dns != dns {
+		// The provider was replaced while we were querying
+		return
+	}
+	if c.dnsApplying {
+		// The results of the batch being applied are not yet recorded in our DNS state, so we check again
+		// on the next interval
+		return
+	}
+	now := time.Now()
+	for k, p := range pending {
+		if c.dnsPending[k] != p {
+			// Changed again while we were querying
+			continue
+		}
+		mismatch, found := mismatches[k]
+		if !found {
+			glog.V(2).Infof("Verified DNS record %s is served by the authoritative name servers", k)
+			delete(c.dnsPending, k)
+			continue
+		}
+		if now.Before(p.deadline) {
+			glog.V(4).Infof("DNS record %s not yet served: %s", k, mismatch)
+			continue
+		}
+
+		message := fmt.Sprintf("DNS record %s was not served by the authoritative name servers within %s: %s; will reapply it", k, policy.DNSVerifyWindow, mismatch)
+		glog.Warning(message)
+		c.Notifier.Notify(notify.ReasonDNSVerificationFailed, message)
+		delete(c.dnsPending, k)
+		if len(p.values) == 0 {
+			// A removal is no longer in our DNS state, so it must be remembered to be retried
+			if c.dnsRemovalRetries == nil {
+				c.dnsRemovalRetries = make(map[kope.DNSRecordKey]bool)
+			}
+			c.dnsRemovalRetries[k] = true
+		} else {
+			delete(c.dnsState, k)
+		}
+		c.dnsSynced = false
+	}
+}
+
+// findNameServers returns the authoritative name servers of the provider's zone, falling back to the
+// zone's NS records if the provider doesn't know them; kope.ErrPrivateZone is returned for a private zone
+func (c *InstancesController) findNameServers(ctx context.Context, dns kope.DNSProvider) ([]string, error) {
+	if lister, ok := dns.(kope.NameServerLister); ok {
+		nameServers, err := lister.ListNameServers(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(nameServers) != 0 {
+			return nameServers, nil
+		}
+	}
+
+	c.mutex.Lock()
+	zone := c.dnsZone
+	c.mutex.Unlock()
+	if !strings.Contains(zone, ".") {
+		return nil, fmt.Errorf("zone %q is not a DNS name, and its provider does not list its name servers", zone)
+	}
+
+	records, err := net.DefaultResolver.LookupNS(ctx, zone)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up NS records of %q: %v", zone, err)
+	}
+	var nameServers []string
+	for _, ns := range records {
+		nameServers = append(nameServers, strings.TrimSuffix(ns.Host, "."))
+	}
+	return nameServers, nil
+}
+
+// queryNameServers returns a description of the first name server whose answer for the record set doesn't
+// match the values, or "" if all match.  Name servers which are not authoritative for the name (e.g. because
+// it is in a delegated zone) are skipped.
+func queryNameServers(ctx context.Context, nameServers []string, k kope.DNSRecordKey, values []string) string {
+	want := normalizeDNSValues(k, values)
+	for _, server := range nameServers {
+		got, err := kope.QueryDNS(ctx, server, k.Name, k.Type)
+		if err == kope.ErrNotAuthoritative {
+			glog.V(4).Infof("Name server %s is not authoritative for %s", server, k)
+			continue
+		}
+		if err != nil {
+			return err.Error()
+		}
+		if !StringSlicesEqual(got, want) {
+			return fmt.Sprintf("%s answered %v, expected %v", server, got, want)
+		}
+	}
+	return ""
+}
+
+// normalizeDNSValues returns the values in the form returned by kope.QueryDNS
+func normalizeDNSValues(k kope.DNSRecordKey, values []string) []string {
+	var normalized []string
+	for _, v := range values {
+		switch k.Type {
+		case kope.DNSTypeCNAME:
+			v = strings.ToLower(strings.TrimSuffix(v, "."))
+		case kope.DNSTypeSRV:
+			fields := strings.Fields(v)
+			if len(fields) == 4 {
+				fields[3] = strings.ToLower(strings.TrimSuffix(fields[3], "."))
+				v = strings.Join(fields, " ")
+			}
+		}
+		normalized = append(normalized, v)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
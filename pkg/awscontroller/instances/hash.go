@@ -0,0 +1,83 @@
+package instances
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// hashedInstance holds the fields of an instance which affect how it is reconciled or the DNS records we
+// publish for it; if none of them has changed (and neither has the policy), the instance needs no work
+type hashedInstance struct {
+	State           string
+	SourceDestCheck *bool
+	Monitoring      string
+	MetadataOptions *ec2.InstanceMetadataOptionsResponse
+
+	// Tags determine the instance's role, DNS names and routing
+	Tags map[string]string
+
+	AvailabilityZone string
+	PrivateIP        string
+	PrivateDNSName   string
+	PublicIP         string
+	PublicDNSName    string
+	IPv6Addresses    []string
+
+	NetworkInterfaces []hashedNetworkInterface
+}
+
+type hashedNetworkInterface struct {
+	ID              string
+	DeviceIndex     int64
+	SourceDestCheck *bool
+}
+
+// instanceHash returns a hash of the fields of the instance which we act on
+func instanceHash(status *ec2.Instance) string {
+	h := &hashedInstance{
+		SourceDestCheck: status.SourceDestCheck,
+		MetadataOptions: status.MetadataOptions,
+		Tags:            make(map[string]string),
+		PrivateIP:       aws.StringValue(status.PrivateIpAddress),
+		PrivateDNSName:  aws.StringValue(status.PrivateDnsName),
+		PublicIP:        aws.StringValue(status.PublicIpAddress),
+		PublicDNSName:   aws.StringValue(status.PublicDnsName),
+	}
+	if status.Placement != nil {
+		h.AvailabilityZone = aws.StringValue(status.Placement.AvailabilityZone)
+	}
+	if status.State != nil {
+		h.State = aws.StringValue(status.State.Name)
+	}
+	if status.Monitoring != nil {
+		h.Monitoring = aws.StringValue(status.Monitoring.State)
+	}
+	for _, tag := range status.Tags {
+		h.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	for _, eni := range status.NetworkInterfaces {
+		hashed := hashedNetworkInterface{
+			ID:              aws.StringValue(eni.NetworkInterfaceId),
+			SourceDestCheck: eni.SourceDestCheck,
+		}
+		if eni.Attachment != nil {
+			hashed.DeviceIndex = aws.Int64Value(eni.Attachment.DeviceIndex)
+		}
+		for _, address := range eni.Ipv6Addresses {
+			h.IPv6Addresses = append(h.IPv6Addresses, aws.StringValue(address.Ipv6Address))
+		}
+		h.NetworkInterfaces = append(h.NetworkInterfaces, hashed)
+	}
+
+	// Maps are serialized with sorted keys, so the hash is stable
+	data, err := json.Marshal(h)
+	if err != nil {
+		// Not expected; an empty hash never matches, so the instance is always reconciled
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -1,96 +1,306 @@
 package instances
 
 import (
+	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
 	"github.com/kopeio/aws-controller/pkg/kope"
 	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
-	"k8s.io/kubernetes/pkg/util/runtime"
-	"k8s.io/kubernetes/pkg/util/wait"
+	"github.com/kopeio/aws-controller/pkg/kope/notify"
+	"github.com/kopeio/aws-controller/pkg/kope/reconcile"
+	"github.com/kopeio/aws-controller/pkg/kope/utils"
+	"k8s.io/apimachinery/pkg/util/runtime"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"text/template"
 	"time"
 )
 
+// The standard etcd ports
+const (
+	etcdClientPort = 2379
+	etcdPeerPort   = 2380
+)
+
+// pendingRequeueDelay is how soon a pending instance is checked again, rather than waiting for the next resync
+const pendingRequeueDelay = 15 * time.Second
+
+// unhealthyResyncs is the number of periods for which resyncs must fail before we report ourselves unhealthy
+const unhealthyResyncs = 3
+
+// defaultWorkers is the default number of instances reconciled in parallel
+const defaultWorkers = 10
+
 type InstancesController struct {
-	SourceDestCheck *bool
-	cloud           *kopeaws.AWSCloud
+	// Notifier is told when a failover record is moved to a different instance, or a DNS change fails verification
+	Notifier *notify.Notifier
 
-	period time.Duration
+	// StatePath is the file our state is saved to after each resync, and restored from by LoadState;
+	// if empty, state is not saved
+	StatePath string
 
+	// Workers is the number of instances reconciled in parallel; it must be set before Run
+	Workers int
+
+	// ShutdownTimeout is how long Stop waits for the in-flight resync and reconciles (including any DNS
+	// change batch) to finish, before aborting them
+	ShutdownTimeout time.Duration
+
+	cloud *kopeaws.AWSCloud
+	// cache is the instance list shared with other controllers
+	cache *kopeaws.InstanceCache
+
+	// queue holds the ids of instances that need to be reconciled;
+	// failures are requeued with backoff, independently of other instances
+	queue *reconcile.Controller
+
+	// mutex protects instances, policy, period and the DNS fields; it is not held during AWS or DNS
+	// provider calls, which can be slow, so that workers, Status and Healthz are not blocked by them
+	mutex     sync.Mutex
 	instances map[string]*instance
 	sequence  int
 
-	// dnsState holds the last configured DNS state
+	// policy is the desired state of instances
+	policy *Policy
+	// period is how often we relist and reconcile all instances
+	period time.Duration
+
+	// dnsState holds the last configured DNS state; it is nil until we know it, after a restart
+	// or a change of provider
 	dns      kope.DNSProvider
-	dnsState map[string][]string
+	dnsZone  string
+	dnsState map[kope.DNSRecordKey][]string
+	// savedDNSState is the DNS state restored by LoadState, until the provider for savedDNSZone is set
+	savedDNSZone  string
+	savedDNSState map[kope.DNSRecordKey][]string
+	// dnsDrift holds the DNS changes we would have made, in report-only mode
+	dnsDrift []DNSRecordDrift
+	// dnsSynced is true if the DNS records were configured for the current instances with dnsPolicy;
+	// any change of instance, policy or provider means they must be configured again.  It is set when the
+	// changes are computed, so that a change while they are being applied clears it.
+	dnsSynced bool
+	dnsPolicy *Policy
+	// dnsApplying is true while a batch of DNS changes is being applied, without holding mutex
+	dnsApplying bool
+	// dnsPending holds the applied DNS changes not yet served by the authoritative name servers, when
+	// Policy.DNSVerifyWindow is set; dnsNameServers caches the authoritative name servers of the zone
+	dnsPending     map[kope.DNSRecordKey]*pendingDNSRecord
+	dnsNameServers []string
+	// dnsRemovalRetries holds the removals which the authoritative name servers were not seen to serve
+	// within the verification window, to be deleted again
+	dnsRemovalRetries map[kope.DNSRecordKey]bool
+	// dnsTruncated lists the record sets truncated by Policy.MaxRecordsPerName
+	dnsTruncated []DNSRecordTruncation
+	// dnsFlushTime, if set, is when the DNS changes being held back by Policy.DNSCoalesceWindow are applied
+	dnsFlushTime time.Time
+
+	// unchanged is the number of instances skipped by the last resync, because nothing had changed
+	unchanged int
+
+	// startTime, lastSyncTime, lastError and lastErrorTime record resync results for Status and Healthz
+	startTime     time.Time
+	lastSyncTime  time.Time
+	lastError     error
+	lastErrorTime time.Time
 
-	// stopLock is used to enforce only a single call to Stop is active.
-	// Needed because we allow stopping through an http endpoint and
-	// allowing concurrent stoppers leads to stack traces.
+	// health records the results of our resyncs, for SyncStatus
+	health kope.SyncHealth
+
+	// stopLock protects shutdown, so that stopCh is only closed once (Stop can be called from the http
+	// endpoint as well as on exit), and so that Run does not start work after Stop has started waiting for it
 	stopLock sync.Mutex
 	shutdown bool
 	stopCh   chan struct{}
+
+	// resyncCh triggers a resync before the period has passed
+	resyncCh chan struct{}
+
+	// running tracks the resync loop, the DNS verification loop and the reconcile workers, which Stop waits for
+	running sync.WaitGroup
+
+	// ctx is cancelled by Stop once in-flight work has finished or ShutdownTimeout has passed,
+	// aborting any AWS calls still in flight
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
-func NewInstancesController(cloud *kopeaws.AWSCloud, period time.Duration, dns kope.DNSProvider) *InstancesController {
+func NewInstancesController(cloud *kopeaws.AWSCloud, cache *kopeaws.InstanceCache, period time.Duration, dns kope.DNSProvider) *InstancesController {
 	c := &InstancesController{
 		cloud:     cloud,
+		cache:     cache,
 		instances: make(map[string]*instance),
 		period:    period,
 		dns:       dns,
-		dnsState:  make(map[string][]string),
+		policy:    &Policy{},
+		Workers:   defaultWorkers,
+		stopCh:    make(chan struct{}),
+		resyncCh:  make(chan struct{}, 1),
+
+		ShutdownTimeout: kope.DefaultShutdownTimeout,
 	}
+	c.queue = reconcile.NewController("instances", c)
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 	return c
 }
 
 type instance struct {
 	ID       string
 	sequence int
-	status   *ec2.Instance
+	// status is our copy of the instance from the shared cache, which we update in place as we change it
+	status *ec2.Instance
+	// statusHash is the instanceHash of status as listed
+	statusHash string
+
+	// syncedHash and syncedPolicy are the instanceHash of the status and the policy when the instance was
+	// last reconciled successfully; a resync skips the instance if neither has changed
+	syncedHash   string
+	syncedPolicy *Policy
+
+	// drift lists the attributes not matching the desired state, as of the last sync
+	drift []string
+	// lastError is the error from the last sync, if it failed
+	lastError error
+
+	// disableApiTermination caches the attribute, which isn't returned by DescribeInstances, to avoid
+	// querying it on every sync; nil if unknown.  Out-of-band changes are only seen after a restart.
+	disableApiTermination *bool
+
+	// launchIndex is the instance's number within its role and zone, once assigned; see launchIndex
+	launchIndex *int
 }
 
 func (c *InstancesController) runLoop() {
-	go wait.Until(func() {
-		if err := c.runOnce(); err != nil {
+	for {
+		err := c.runOnce(c.ctx)
+		c.recordResult(err)
+		if err != nil {
 			runtime.HandleError(err)
+		} else if c.StatePath != "" {
+			if err := c.saveState(); err != nil {
+				runtime.HandleError(err)
+			}
+		}
+
+		// The period can be changed while we are running, so we don't use wait.Until
+		select {
+		case <-c.stopCh:
+			return
+		case <-c.resyncCh:
+		case <-time.After(utils.JitteredPeriod(c.getPeriod())):
 		}
-	}, c.period, c.stopCh)
+	}
 }
 
-// Stop stops the route controller.
+// Stop stops the route controller.  No new resyncs or reconciles are started, and Stop waits (for up to
+// ShutdownTimeout) for those in flight to finish, so that we don't stop in the middle of applying changes.
 func (c *InstancesController) Stop() error {
 	// Stop is invoked from the http endpoint.
 	c.stopLock.Lock()
 	defer c.stopLock.Unlock()
 
-	if !c.shutdown {
-		close(c.stopCh)
-		c.shutdown = true
+	if c.shutdown {
+		return fmt.Errorf("shutdown already in progress")
+	}
+	close(c.stopCh)
+	c.shutdown = true
+	defer c.cancel()
 
+	done := make(chan struct{})
+	go func() {
+		c.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		glog.V(2).Infof("in-flight work finished")
 		return nil
+	case <-time.After(c.ShutdownTimeout):
+		return fmt.Errorf("in-flight work did not finish within %s; aborting it", c.ShutdownTimeout)
 	}
-
-	return fmt.Errorf("shutdown already in progress")
 }
 
 func (c *InstancesController) Run() {
 	glog.Infof("starting aws controller")
 
-	go c.runLoop()
+	c.mutex.Lock()
+	c.startTime = time.Now()
+	c.mutex.Unlock()
+
+	// Stop waits for running, so we must not add to it once Stop has been called
+	c.stopLock.Lock()
+	if c.shutdown {
+		c.stopLock.Unlock()
+		return
+	}
+	c.running.Add(3)
+	c.stopLock.Unlock()
+
+	go func() {
+		defer c.running.Done()
+		c.runLoop()
+	}()
+	go func() {
+		defer c.running.Done()
+		c.verifyLoop()
+	}()
+	go func() {
+		defer c.running.Done()
+		c.queue.Run(c.ctx, c.stopCh, c.Workers)
+	}()
 
 	<-c.stopCh
 	glog.Infof("shutting down route controller")
+	c.running.Wait()
 }
 
-func (c *InstancesController) runOnce() error {
-	instances, err := c.cloud.DescribeInstances()
+func (c *InstancesController) runOnce(ctx context.Context) error {
+	instances, err := c.cache.List(ctx)
 	if err != nil {
 		return err
 	}
 
+	c.mutex.Lock()
+	c.updateInstances(instances)
+	c.unchanged = 0
+	for id, i := range c.instances {
+		if i.lastError == nil && i.syncedPolicy == c.policy && i.syncedHash != "" && i.syncedHash == i.statusHash {
+			c.unchanged++
+			continue
+		}
+		c.queue.Enqueue(id)
+
+		// Other ideas...
+		//   configure route53 name?
+		//   look for "failed nodes" that did not come up
+		//   related - maybe only do this poll very rarely, and most of the time be driven by node changes
+		//
+		// non-aws ideas:
+		//   manage node auto-updates
+	}
+
+	glog.Infof("Found %d instances (%d unchanged)", len(c.instances), c.unchanged)
+
+	dns := c.dns
+	dnsSynced := c.dnsSynced && c.dnsPolicy == c.policy && c.dnsState != nil
+	c.mutex.Unlock()
+
+	if dns == nil {
+		return nil
+	}
+	if dnsSynced {
+		glog.V(2).Infof("No instances changed; skipping DNS")
+		return nil
+	}
+	return c.configureDNS(ctx, dns)
+}
+
+// updateInstances records the listed instances, forgetting those no longer listed; the caller must hold c.mutex
+func (c *InstancesController) updateInstances(instances []*ec2.Instance) {
 	c.sequence = c.sequence + 1
 	sequence := c.sequence
 
@@ -109,106 +319,458 @@ func (c *InstancesController) runOnce() error {
 			c.instances[id] = i
 		}
 
-		i.status = awsInstance
+		i.status = copyInstance(awsInstance)
 		i.sequence = sequence
+		if hash := instanceHash(i.status); hash != i.statusHash {
+			i.statusHash = hash
+			c.dnsSynced = false
+		}
+	}
+
+	for _, i := range c.instances {
+		if i.sequence != sequence {
+			glog.Infof("Instance deleted: %q", i.ID)
+			delete(c.instances, i.ID)
+			c.dnsSynced = false
+		}
 	}
+}
 
+// DesiredDNSRecords lists the instances and returns the DNS records we would publish for them, without
+// reconciling the instances or applying the records
+func (c *InstancesController) DesiredDNSRecords(ctx context.Context) (map[kope.DNSRecordKey][]string, error) {
+	instances, err := c.cache.List(ctx)
 	if err != nil {
-		return fmt.Errorf("error doing EC2 describe instances: %v", err)
+		return nil, err
 	}
 
-	for _, i := range c.instances {
-		id := i.ID
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-		if i.sequence != sequence {
-			glog.Infof("Instance deleted: %q", id)
-			delete(c.instances, id)
-			continue
-		}
+	c.updateInstances(instances)
+	return c.desiredDNSRecords(c.instances), nil
+}
+
+// Reconcile reconciles a single instance, returning an error if it should be retried
+func (c *InstancesController) Reconcile(ctx context.Context, id string) (reconcile.Result, error) {
+	c.mutex.Lock()
+	i := c.instances[id]
+	var status *ec2.Instance
+	if i != nil {
+		status = i.status
+	}
+	c.mutex.Unlock()
+
+	if status == nil {
+		glog.V(2).Infof("Ignoring instance no longer found: %q", id)
+		return reconcile.Result{}, nil
+	}
 
-		canSetSourceDestCheck := false
-		instanceStateName := aws.StringValue(i.status.State.Name)
-		switch instanceStateName {
-		case "pending":
+	if aws.StringValue(status.State.Name) == ec2.InstanceStateNamePending {
+		refreshed, err := c.refreshInstance(ctx, i)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if aws.StringValue(refreshed.State.Name) == ec2.InstanceStateNamePending {
+			// Check back soon, so new instances don't wait for the next resync to be configured
 			glog.V(2).Infof("Ignoring pending instance: %q", id)
-		case "running":
-			canSetSourceDestCheck = true
-		case "shutting-down":
-		// ignore
-		case "terminated":
-		// ignore
-		case "stopping":
-			canSetSourceDestCheck = true
-		case "stopped":
-			canSetSourceDestCheck = true
-
-		default:
-			runtime.HandleError(fmt.Errorf("unknown instance state for instance %q: %q", id, instanceStateName))
-		}
-
-		if canSetSourceDestCheck && c.SourceDestCheck != nil && *c.SourceDestCheck != aws.BoolValue(i.status.SourceDestCheck) {
-			err := c.cloud.ConfigureInstanceSourceDestCheck(i.ID, *c.SourceDestCheck)
+			return reconcile.Result{RequeueAfter: pendingRequeueDelay}, nil
+		}
+		status = refreshed
+	}
+
+	policy := c.getPolicy()
+
+	canSetSourceDestCheck := false
+	canModifyInstance := false
+	instanceStateName := aws.StringValue(status.State.Name)
+	switch instanceStateName {
+	case "pending":
+		// handled above
+	case "running":
+		canSetSourceDestCheck = true
+		canModifyInstance = true
+	case "shutting-down":
+	// ignore
+	case "terminated":
+	// ignore
+	case "stopping":
+		canSetSourceDestCheck = true
+	case "stopped":
+		canSetSourceDestCheck = true
+		canModifyInstance = true
+
+	default:
+		runtime.HandleError(fmt.Errorf("unknown instance state for instance %q: %q", id, instanceStateName))
+	}
+
+	var drift []string
+	var errors []error
+	if canSetSourceDestCheck && policy.SourceDestCheck != nil {
+		sourceDestCheck := *policy.SourceDestCheck
+
+		if sourceDestCheck != aws.BoolValue(status.SourceDestCheck) && policy.ReportOnly {
+			drift = append(drift, "SourceDestCheck")
+		} else if sourceDestCheck != aws.BoolValue(status.SourceDestCheck) {
+			err := c.cloud.ConfigureInstanceSourceDestCheck(ctx, id, sourceDestCheck)
 			if err != nil {
-				runtime.HandleError(fmt.Errorf("failed to configure SourceDestCheck for instance %q: %v", i.ID, err))
+				errors = append(errors, fmt.Errorf("failed to configure SourceDestCheck for instance %q: %v", id, err))
+				drift = append(drift, "SourceDestCheck")
 			} else {
 				// Update the status in-place
-				i.status.SourceDestCheck = c.SourceDestCheck
+				c.mutex.Lock()
+				status.SourceDestCheck = aws.Bool(sourceDestCheck)
+				c.mutex.Unlock()
 			}
 		}
 
-		// Other ideas...
-		//   configure route53 name?
-		//   look for "failed nodes" that did not come up
-		//   related - maybe only do this poll very rarely, and most of the time be driven by node changes
-		//
-		// non-aws ideas:
-		//   automatically recycle nodes after a while (but not
-		//   manage node auto-updates
+		// CNIs that attach secondary ENIs need the attribute set on those ENIs too
+		for _, eni := range status.NetworkInterfaces {
+			if eni.Attachment != nil && aws.Int64Value(eni.Attachment.DeviceIndex) == 0 {
+				// The primary ENI is covered by the instance attribute
+				continue
+			}
+			if sourceDestCheck == aws.BoolValue(eni.SourceDestCheck) {
+				continue
+			}
+
+			eniID := aws.StringValue(eni.NetworkInterfaceId)
+			if policy.ReportOnly {
+				drift = append(drift, "SourceDestCheck/"+eniID)
+				continue
+			}
+			err := c.cloud.ConfigureNetworkInterfaceSourceDestCheck(ctx, id, eniID, sourceDestCheck)
+			if err != nil {
+				errors = append(errors, fmt.Errorf("failed to configure SourceDestCheck for network interface %q: %v", eniID, err))
+				drift = append(drift, "SourceDestCheck/"+eniID)
+			} else {
+				c.mutex.Lock()
+				eni.SourceDestCheck = aws.Bool(sourceDestCheck)
+				c.mutex.Unlock()
+			}
+		}
 	}
 
-	glog.Infof("Found %d instances", len(c.instances))
+	if canModifyInstance && policy.DetailedMonitoring != nil {
+		detailed := *policy.DetailedMonitoring
+
+		if detailed != isDetailedMonitoring(status) && policy.ReportOnly {
+			drift = append(drift, "DetailedMonitoring")
+		} else if detailed != isDetailedMonitoring(status) {
+			err := c.cloud.ConfigureInstanceMonitoring(ctx, id, detailed)
+			if err != nil {
+				errors = append(errors, fmt.Errorf("failed to configure detailed monitoring for instance %q: %v", id, err))
+				drift = append(drift, "DetailedMonitoring")
+			} else {
+				state := ec2.MonitoringStateDisabling
+				if detailed {
+					state = ec2.MonitoringStatePending
+				}
+				c.mutex.Lock()
+				status.Monitoring = &ec2.Monitoring{State: aws.String(state)}
+				c.mutex.Unlock()
+			}
+		}
+	}
+
+	if canModifyInstance && policy.MetadataOptions != nil && !policy.MetadataOptions.MatchesInstance(status) {
+		if policy.MetadataOptionsReportOnly || policy.ReportOnly {
+			glog.Warningf("Instance %q metadata options do not match (report-only): %s", id, utils.DebugString(status.MetadataOptions))
+			drift = append(drift, "MetadataOptions")
+		} else {
+			err := c.cloud.ConfigureInstanceMetadataOptions(ctx, id, policy.MetadataOptions)
+			if err != nil {
+				errors = append(errors, fmt.Errorf("failed to configure metadata options for instance %q: %v", id, err))
+				drift = append(drift, "MetadataOptions")
+			} else {
+				c.mutex.Lock()
+				if status.MetadataOptions == nil {
+					status.MetadataOptions = &ec2.InstanceMetadataOptionsResponse{}
+				}
+				if policy.MetadataOptions.HttpTokens != "" {
+					status.MetadataOptions.HttpTokens = aws.String(policy.MetadataOptions.HttpTokens)
+				}
+				if policy.MetadataOptions.HttpPutResponseHopLimit != 0 {
+					status.MetadataOptions.HttpPutResponseHopLimit = aws.Int64(policy.MetadataOptions.HttpPutResponseHopLimit)
+				}
+				c.mutex.Unlock()
+			}
+		}
+	}
+
+	if canModifyInstance && policy.TerminationProtection != nil {
+		if protect, found := policy.TerminationProtection[kopeaws.InstanceRole(status)]; found {
+			if drifted, err := c.syncTerminationProtection(ctx, i, protect, policy.ReportOnly); err != nil {
+				errors = append(errors, err)
+				drift = append(drift, "DisableApiTermination")
+			} else if drifted {
+				drift = append(drift, "DisableApiTermination")
+			}
+		}
+	}
+
+	if canModifyInstance && len(policy.RequiredTags) != 0 {
+		if drifted, err := c.syncRequiredTags(ctx, i, policy.RequiredTags, policy.ReportOnly); err != nil {
+			errors = append(errors, err)
+			drift = append(drift, "Tags")
+		} else if drifted {
+			drift = append(drift, "Tags")
+		}
+	}
+
+	var err error
+	if len(errors) == 1 {
+		err = errors[0]
+	} else if len(errors) > 1 {
+		err = fmt.Errorf("errors syncing instance %q: %v", id, errors)
+	}
+
+	c.mutex.Lock()
+	i.drift = drift
+	i.lastError = err
+	if err == nil {
+		i.syncedHash = instanceHash(status)
+		i.syncedPolicy = policy
+	}
+	c.mutex.Unlock()
+
+	return reconcile.Result{}, err
+}
 
-	if c.dns != nil {
-		err = c.configureDNS(c.instances)
+// refreshInstance updates the status of the instance from the shared cache (which relists if its list is
+// stale), returning the new status; an instance which has disappeared keeps its last status
+func (c *InstancesController) refreshInstance(ctx context.Context, i *instance) (*ec2.Instance, error) {
+	instances, err := c.cache.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, awsInstance := range instances {
+		if aws.StringValue(awsInstance.InstanceId) == i.ID {
+			i.status = copyInstance(awsInstance)
+			break
+		}
+	}
+	return i.status, nil
+}
+
+// copyInstance copies an instance from the shared cache, deeply enough that we can update it in place
+func copyInstance(awsInstance *ec2.Instance) *ec2.Instance {
+	status := *awsInstance
+
+	status.NetworkInterfaces = nil
+	for _, eni := range awsInstance.NetworkInterfaces {
+		eniCopy := *eni
+		status.NetworkInterfaces = append(status.NetworkInterfaces, &eniCopy)
+	}
+	status.Tags = nil
+	for _, tag := range awsInstance.Tags {
+		tagCopy := *tag
+		status.Tags = append(status.Tags, &tagCopy)
+	}
+	if awsInstance.MetadataOptions != nil {
+		options := *awsInstance.MetadataOptions
+		status.MetadataOptions = &options
+	}
+	return &status
+}
+
+// syncTerminationProtection sets DisableApiTermination on the instance to the desired value; with
+// reportOnly it only returns whether the value differs
+func (c *InstancesController) syncTerminationProtection(ctx context.Context, i *instance, protect bool, reportOnly bool) (bool, error) {
+	c.mutex.Lock()
+	cached := i.disableApiTermination
+	c.mutex.Unlock()
+
+	var actual bool
+	if cached != nil {
+		actual = *cached
+	} else {
+		var err error
+		actual, err = c.cloud.GetInstanceDisableApiTermination(ctx, i.ID)
 		if err != nil {
-			return err
+			return false, err
 		}
 	}
 
-	return nil
+	drifted := false
+	if actual != protect && reportOnly {
+		drifted = true
+	} else if actual != protect {
+		if err := c.cloud.ConfigureInstanceDisableApiTermination(ctx, i.ID, protect); err != nil {
+			return false, fmt.Errorf("failed to configure DisableApiTermination for instance %q: %v", i.ID, err)
+		}
+		actual = protect
+	}
+
+	c.mutex.Lock()
+	i.disableApiTermination = aws.Bool(actual)
+	c.mutex.Unlock()
+	return drifted, nil
 }
 
-func (c *InstancesController) configureDNS(instances map[string]*instance) error {
-	dnsState := make(map[string][]string)
+// syncRequiredTags adds or repairs any required tags which are missing or have the wrong value, and records
+// the instance's launch index in a tag; with reportOnly it only returns whether any required tags are missing
+func (c *InstancesController) syncRequiredTags(ctx context.Context, i *instance, requiredTags map[string]*template.Template, reportOnly bool) (bool, error) {
+	c.mutex.Lock()
+	data := c.buildTemplateData(i)
+	c.mutex.Unlock()
 
-	for _, i := range instances {
-		internalName, _ := kopeaws.FindTag(i.status, kopeaws.TagNameKubernetesDnsInternal)
-		if internalName != "" {
-			internalIP := aws.StringValue(i.status.PrivateIpAddress)
-			if internalIP != "" {
-				dnsState[internalName] = append(dnsState[internalName], internalIP)
-			}
+	missing := make(map[string]string)
+	for k, t := range requiredTags {
+		v, err := executeTemplate(t, data)
+		if err != nil {
+			return false, err
 		}
-		publicName, _ := kopeaws.FindTag(i.status, kopeaws.TagNameKubernetesDnsPublic)
-		if publicName != "" {
-			publicIP := aws.StringValue(i.status.PublicIpAddress)
-			if publicIP != "" {
-				dnsState[publicName] = append(dnsState[publicName], publicIP)
-			}
+		if actual, found := data.Tags[k]; !found || actual != v {
+			missing[k] = v
+		}
+	}
+	if reportOnly {
+		return len(missing) != 0, nil
+	}
+	if index := strconv.Itoa(data.LaunchIndex); data.Tags[kopeaws.TagNameLaunchIndex] != index {
+		missing[kopeaws.TagNameLaunchIndex] = index
+	}
+	if len(missing) == 0 {
+		return false, nil
+	}
+
+	if err := c.cloud.TagInstance(ctx, data.InstanceID, missing); err != nil {
+		return false, err
+	}
+
+	// Update the status in-place
+	c.mutex.Lock()
+	for _, tag := range i.status.Tags {
+		if v, found := missing[aws.StringValue(tag.Key)]; found {
+			tag.Value = aws.String(v)
+			delete(missing, aws.StringValue(tag.Key))
+		}
+	}
+	for k, v := range missing {
+		i.status.Tags = append(i.status.Tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	c.mutex.Unlock()
+	return false, nil
+}
+
+// isDetailedMonitoring returns true if detailed monitoring is enabled (or being enabled) on the instance
+func isDetailedMonitoring(status *ec2.Instance) bool {
+	if status.Monitoring == nil {
+		return false
+	}
+	state := aws.StringValue(status.Monitoring.State)
+	return state == ec2.MonitoringStateEnabled || state == ec2.MonitoringStatePending
+}
+
+// dnsChangeBatch is a batch of DNS changes computed under c.mutex, to be applied without holding it
+type dnsChangeBatch struct {
+	// dnsState is our DNS state once the batch has been applied
+	dnsState map[kope.DNSRecordKey][]string
+	// previous is our DNS state before the batch, for reporting failover changes
+	previous map[kope.DNSRecordKey][]string
+	changes  map[kope.DNSRecordKey][]string
+	removed  []kope.DNSRecordKey
+}
+
+// configureDNS configures the DNS records for the current instances with dns.  The provider calls can be
+// slow, so c.mutex is held only while computing the changes and while recording the results, and the
+// caller must not hold it.  If the provider is replaced in the meantime, the results are discarded; the
+// new provider is configured on the next resync.
+func (c *InstancesController) configureDNS(ctx context.Context, dns kope.DNSProvider) error {
+	owned, isOwned := dns.(kope.OwnedDNSProvider)
+
+	c.mutex.Lock()
+	listOwned := c.dnsState == nil && isOwned
+	c.mutex.Unlock()
+
+	var existing map[kope.DNSRecordKey][]string
+	if listOwned {
+		// Start from the records we published before we restarted, so we neither reapply them nor
+		// forget to remove those which are no longer needed
+		var err error
+		existing, err = owned.ListOwnedDNSRecords(ctx)
+		if err != nil {
+			return fmt.Errorf("error listing existing DNS records: %v", err)
 		}
 	}
 
-	var changes map[string][]string
+	c.mutex.Lock()
+	if c.dns != dns {
+		c.mutex.Unlock()
+		glog.Infof("DNS provider replaced; not configuring DNS for the previous provider")
+		return nil
+	}
+	if existing != nil && c.dnsState == nil {
+		glog.Infof("Found %d existing DNS records", len(existing))
+		c.dnsState = existing
+	}
+	c.dnsSynced = true
+	c.dnsPolicy = c.policy
+	batch, err := c.planDNSChanges(isOwned)
+	if err != nil || !c.dnsFlushTime.IsZero() {
+		c.dnsSynced = false
+	}
+	if batch != nil {
+		c.dnsApplying = true
+	}
+	c.mutex.Unlock()
+
+	if err != nil || batch == nil {
+		return err
+	}
+
+	err = c.applyDNSChanges(ctx, dns, owned, batch)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.dnsApplying = false
+	if c.dns != dns {
+		// The provider was replaced while we were applying the changes
+		return err
+	}
+	if err != nil {
+		c.dnsSynced = false
+		return err
+	}
+
+	for k, v := range batch.changes {
+		if previous := batch.previous[k]; k.Failover != "" && len(previous) != 0 {
+			c.Notifier.Notify(notify.ReasonDNSFailoverChanged, fmt.Sprintf("DNS failover record %s changed from %v to %v", k, previous, v))
+		}
+	}
+	for _, k := range batch.removed {
+		delete(c.dnsRemovalRetries, k)
+	}
+	c.expectDNSChanges(batch.changes, batch.removed)
+	c.dnsState = batch.dnsState
+	return nil
+}
+
+// planDNSChanges computes the changes from our DNS state to the records for the current instances.  It
+// returns nil if there is nothing to apply now: nothing changed, the changes are being buffered, or we
+// only report them.  The caller must hold c.mutex.
+func (c *InstancesController) planDNSChanges(isOwned bool) (*dnsChangeBatch, error) {
+	policy := c.policy
+	dnsState := c.desiredDNSRecords(c.instances)
+
+	var changes map[kope.DNSRecordKey][]string
+	var removed []kope.DNSRecordKey
 	if c.dnsState == nil {
 		if len(dnsState) == 0 {
 			glog.V(2).Infof("No dns configuration to apply")
 			c.dnsState = dnsState
-			return nil
+			return nil, nil
 		} else {
 			changes = dnsState
 		}
 	} else {
-		changes = make(map[string][]string)
+		changes = make(map[kope.DNSRecordKey][]string)
 		for k, v := range dnsState {
 			sort.Strings(v)
 			lastV := c.dnsState[k]
@@ -217,24 +779,389 @@ func (c *InstancesController) configureDNS(instances map[string]*instance) error
 				changes[k] = v
 			}
 		}
+		if isOwned {
+			// A record set whose attributes (e.g. its weight) changed is replaced by the change, so it
+			// must not also be deleted
+			applied := make(map[kope.DNSRecordKey]bool)
+			for k := range dnsState {
+				applied[recordIdentity(k)] = true
+			}
+			for k := range c.dnsState {
+				if _, found := dnsState[k]; !found && !applied[recordIdentity(k)] {
+					glog.V(2).Infof("DNS record %s is no longer needed", k)
+					removed = append(removed, k)
+				}
+			}
+			for k := range c.dnsRemovalRetries {
+				if _, found := c.dnsState[k]; found {
+					// Already being removed
+					continue
+				}
+				if _, found := dnsState[k]; found || applied[recordIdentity(k)] {
+					delete(c.dnsRemovalRetries, k)
+					continue
+				}
+				glog.V(2).Infof("Retrying removal of DNS record %s", k)
+				removed = append(removed, k)
+			}
+		}
 
-		if len(changes) == 0 {
+		if len(changes) == 0 && len(removed) == 0 {
 			glog.V(2).Infof("DNS configuration unchanged")
-			return nil
+			c.dnsFlushTime = time.Time{}
+			return nil, nil
+		}
+		if c.bufferDNSChanges(len(changes) + len(removed)) {
+			return nil, nil
+		}
+
+		if !policy.ReportOnly {
+			if err := checkMassChange(c.dnsState, changes, removed, policy); err != nil {
+				return nil, err
+			}
 		}
 	}
 
-	err := c.dns.ApplyDNSChanges(changes)
-	if err != nil {
-		return fmt.Errorf("error applying DNS changes: %v", err)
+	if policy.ReportOnly {
+		c.dnsDrift = dnsDrift(c.dnsState, changes, removed)
+		glog.Infof("Not applying %d DNS changes (report-only)", len(c.dnsDrift))
+		return nil, nil
+	}
+	c.dnsDrift = nil
+
+	return &dnsChangeBatch{
+		dnsState: dnsState,
+		previous: c.dnsState,
+		changes:  changes,
+		removed:  removed,
+	}, nil
+}
+
+// applyDNSChanges applies the batch with the provider; the caller must not hold c.mutex
+func (c *InstancesController) applyDNSChanges(ctx context.Context, dns kope.DNSProvider, owned kope.OwnedDNSProvider, batch *dnsChangeBatch) error {
+	if len(batch.changes) != 0 {
+		if err := dns.ApplyDNSChanges(ctx, batch.changes); err != nil {
+			return fmt.Errorf("error applying DNS changes: %v", err)
+		}
+
+		glog.V(2).Infof("Applied DNS changes to %d hosts", len(batch.changes))
 	}
 
-	glog.V(2).Infof("Applied DNS changes to %d hosts", len(changes))
+	// Removals are applied after changes, which may already have replaced the records (e.g. an A record with a CNAME)
+	if len(batch.removed) != 0 {
+		if err := owned.DeleteDNSRecords(ctx, batch.removed); err != nil {
+			return fmt.Errorf("error deleting DNS records: %v", err)
+		}
 
-	c.dnsState = dnsState
+		glog.V(2).Infof("Deleted %d DNS records", len(batch.removed))
+	}
 	return nil
 }
 
+// desiredDNSRecords computes the DNS records to publish for the instances; the caller must hold c.mutex
+func (c *InstancesController) desiredDNSRecords(instances map[string]*instance) map[kope.DNSRecordKey][]string {
+	policy := c.policy
+	dnsState := make(map[kope.DNSRecordKey][]string)
+	// publicHosts holds the public DNS names of the instances with each public name, for CNAMEs
+	publicHosts := make(map[string][]string)
+	publicNames := make(map[string]bool)
+
+	for _, i := range instances {
+		if reason, draining := kopeaws.FindTag(i.status, kopeaws.TagNameDraining); draining {
+			glog.V(2).Infof("Excluding draining instance %q from DNS: %s", i.ID, reason)
+			continue
+		}
+
+		internalName, internalRouting := c.dnsTagRecord(i, kopeaws.TagNameKubernetesDnsInternal)
+		if internalName != "" {
+			internalIP := aws.StringValue(i.status.PrivateIpAddress)
+			if internalIP != "" {
+				key := c.addressRecordKey(internalName, i, policy, internalRouting, false)
+				dnsState[key] = append(dnsState[key], internalIP)
+
+				if reverseName, ok := kope.ReverseName(internalIP); ok && policy.ReverseZoneName != "" && kope.InZone(reverseName, policy.ReverseZoneName) {
+					key := kope.DNSRecordKey{Name: reverseName, Type: kope.DNSTypePTR}
+					dnsState[key] = append(dnsState[key], internalName)
+				}
+			}
+		}
+		publicName, publicRouting := c.dnsTagRecord(i, kopeaws.TagNameKubernetesDnsPublic)
+		if publicName != "" {
+			publicNames[publicName] = true
+			publicIP := aws.StringValue(i.status.PublicIpAddress)
+			if publicIP != "" {
+				key := c.addressRecordKey(publicName, i, policy, publicRouting, true)
+				dnsState[key] = append(dnsState[key], publicIP)
+			}
+			// Names with routing options in their tag are always published as A records
+			if publicHost := aws.StringValue(i.status.PublicDnsName); publicHost != "" && publicRouting == nil {
+				publicHosts[publicName] = append(publicHosts[publicName], publicHost)
+			}
+		}
+		splitHorizonName, splitHorizonRouting := c.dnsTagRecord(i, kopeaws.TagNameKubernetesDnsSplitHorizon)
+		if splitHorizonName != "" {
+			publicNames[splitHorizonName] = true
+			if publicIP := aws.StringValue(i.status.PublicIpAddress); publicIP != "" {
+				key := c.addressRecordKey(splitHorizonName, i, policy, splitHorizonRouting, true)
+				dnsState[key] = append(dnsState[key], publicIP)
+			}
+			if internalIP := aws.StringValue(i.status.PrivateIpAddress); internalIP != "" && policy.PrivateZoneName != "" {
+				key := c.addressRecordKey(splitHorizonName, i, policy, splitHorizonRouting, false)
+				key.Private = true
+				dnsState[key] = append(dnsState[key], internalIP)
+			}
+		}
+		wildcardName, wildcardRouting := c.dnsTagRecord(i, kopeaws.TagNameKubernetesDnsWildcard)
+		if wildcardName != "" {
+			wildcardName = WildcardName(wildcardName)
+			ip := aws.StringValue(i.status.PublicIpAddress)
+			public := ip != ""
+			if public {
+				publicNames[wildcardName] = true
+			} else {
+				ip = aws.StringValue(i.status.PrivateIpAddress)
+			}
+			if ip != "" {
+				key := c.addressRecordKey(wildcardName, i, policy, wildcardRouting, public)
+				dnsState[key] = append(dnsState[key], ip)
+			}
+		}
+		if policy.RoleGroupDomain != "" && aws.StringValue(i.status.State.Name) == ec2.InstanceStateNameRunning {
+			role := kopeaws.InstanceRole(i.status)
+			internalIP := aws.StringValue(i.status.PrivateIpAddress)
+			if role != "" && internalIP != "" {
+				key := c.addressRecordKey(roleGroupName(role, policy.RoleGroupDomain), i, policy, nil, false)
+				dnsState[key] = append(dnsState[key], internalIP)
+			}
+		}
+	}
+
+	for k, v := range failoverRecords(instances, policy.HealthCheckPort) {
+		dnsState[k] = v
+	}
+
+	// A health check covers a single address, so where the routing options in their tags put several
+	// instances in the same health-checked record set, we keep the lowest address
+	for k, v := range dnsState {
+		if k.HealthCheckPort != 0 && len(v) > 1 {
+			sort.Strings(v)
+			glog.Warningf("Health checked record %s has %d addresses; publishing only %s", k, len(v), v[0])
+			dnsState[k] = v[:1]
+		}
+	}
+
+	if policy.EtcdSRVDomain != "" {
+		for k, v := range c.etcdSRVRecords(policy.EtcdSRVDomain, instances) {
+			dnsState[k] = v
+		}
+	}
+
+	c.dnsTruncated = nil
+	if policy.MaxRecordsPerName != 0 {
+		c.dnsTruncated = capRecords(dnsState, instances, policy.MaxRecordsPerName)
+	}
+
+	// CNAMEs can't be combined with latency-based routing
+	if policy.PublicCNAME && !policy.LatencyRouting {
+		for name, hosts := range publicHosts {
+			if len(hosts) != 1 {
+				// A CNAME has a single target
+				glog.Warningf("Publishing %q as A records: %d instances share the name", name, len(hosts))
+				continue
+			}
+			delete(dnsState, kope.ARecord(name))
+			dnsState[kope.DNSRecordKey{Name: name, Type: kope.DNSTypeCNAME}] = hosts
+		}
+	}
+
+	if policy.MultiValue && !policy.LatencyRouting {
+		dnsState = multiValueRecords(dnsState, publicNames, policy.HealthCheckPort)
+	}
+
+	removeRoutingConflicts(dnsState)
+
+	for _, v := range dnsState {
+		sort.Strings(v)
+	}
+	return dnsState
+}
+
+// massChangeMinimum is the fewest existing records which must be changed or removed before
+// Policy.MaxDNSChangePercent applies, so that small clusters can still replace their instances
+const massChangeMinimum = 3
+
+// checkMassChange returns an error if the changes and removals would modify more of the existing records
+// than the policy allows, which is more likely to be a fault (such as an empty instance listing) than
+// a real change; AllowMassDNSChanges overrides it
+func checkMassChange(existing map[kope.DNSRecordKey][]string, changes map[kope.DNSRecordKey][]string, removed []kope.DNSRecordKey, policy *Policy) error {
+	affected := len(removed)
+	for k := range changes {
+		if _, found := existing[k]; found {
+			affected++
+		}
+	}
+	if affected == 0 {
+		return nil
+	}
+
+	reason := ""
+	if policy.MaxDNSChanges != 0 && affected > policy.MaxDNSChanges {
+		reason = fmt.Sprintf("more than %d", policy.MaxDNSChanges)
+	} else if policy.MaxDNSChangePercent != 0 && affected >= massChangeMinimum && affected*100 > policy.MaxDNSChangePercent*len(existing) {
+		reason = fmt.Sprintf("more than %d%% of the %d", policy.MaxDNSChangePercent, len(existing))
+	}
+	if reason == "" {
+		return nil
+	}
+
+	if policy.AllowMassDNSChanges {
+		glog.Warningf("Changing or removing %d DNS records, %s existing records; allowed by override", affected, reason)
+		return nil
+	}
+	return fmt.Errorf("refusing to change or remove %d DNS records, %s existing records; set the mass change override if this is intended", affected, reason)
+}
+
+// roleGroupName returns the name of the group record for the instances with role, e.g. masters.<domain>
+func roleGroupName(role string, domain string) string {
+	return role + "s." + strings.TrimSuffix(domain, ".")
+}
+
+// WildcardName returns the wildcard DNS name for the value of a wildcard tag, which may omit the leading "*."
+func WildcardName(name string) string {
+	if strings.HasPrefix(name, "*.") {
+		return name
+	}
+	return "*." + name
+}
+
+// multiValueRecords replaces each simple A record set with a multi-value answer record set per value;
+// the values of public names are health checked (Route53 health checkers can't reach private addresses)
+func multiValueRecords(records map[kope.DNSRecordKey][]string, publicNames map[string]bool, healthCheckPort int) map[kope.DNSRecordKey][]string {
+	result := make(map[kope.DNSRecordKey][]string)
+	for key, values := range records {
+		if key.Type != kope.DNSTypeA || key.SetIdentifier != "" {
+			result[key] = values
+			continue
+		}
+		for _, value := range values {
+			multiValueKey := key
+			multiValueKey.SetIdentifier = value
+			multiValueKey.MultiValue = true
+			if publicNames[key.Name] && !key.Private {
+				multiValueKey.HealthCheckPort = healthCheckPort
+			}
+			result[multiValueKey] = []string{value}
+		}
+	}
+	return result
+}
+
+// addressRecordKey returns the key of the A record set for the instance's address under name, which is
+// per-region when latency-based routing is enabled; routing options from the instance's tag, if any,
+// override the policy, and their health checks apply only to public addresses
+func (c *InstancesController) addressRecordKey(name string, i *instance, policy *Policy, routing *tagRouting, public bool) kope.DNSRecordKey {
+	if routing != nil {
+		healthCheckPort := 0
+		if public {
+			healthCheckPort = policy.HealthCheckPort
+		}
+		return c.routedRecordKey(name, i, routing, healthCheckPort)
+	}
+
+	key := kope.ARecord(name)
+	if policy.LatencyRouting {
+		region := c.cloud.InstanceRegion(i.ID)
+		key.SetIdentifier = region
+		key.Region = region
+	}
+	return key
+}
+
+// failoverRecords builds the health-checked failover records for instances tagged with the failover
+// tags; if several instances have the same tag, the lowest instance id is used
+func failoverRecords(instances map[string]*instance, healthCheckPort int) map[kope.DNSRecordKey][]string {
+	records := make(map[kope.DNSRecordKey][]string)
+	chosen := make(map[kope.DNSRecordKey]string)
+	for _, i := range instances {
+		if _, draining := kopeaws.FindTag(i.status, kopeaws.TagNameDraining); draining {
+			continue
+		}
+		for tag, failover := range map[string]string{
+			kopeaws.TagNameKubernetesDnsFailoverPrimary:   kope.DNSFailoverPrimary,
+			kopeaws.TagNameKubernetesDnsFailoverSecondary: kope.DNSFailoverSecondary,
+		} {
+			name, _ := kopeaws.FindTag(i.status, tag)
+			if name == "" {
+				continue
+			}
+			// Route53 health checkers can only reach public addresses
+			ip := aws.StringValue(i.status.PublicIpAddress)
+			if ip == "" {
+				continue
+			}
+			key := kope.DNSRecordKey{
+				Name:            name,
+				Type:            kope.DNSTypeA,
+				SetIdentifier:   strings.ToLower(failover),
+				Failover:        failover,
+				HealthCheckPort: healthCheckPort,
+			}
+			if previous, found := chosen[key]; found {
+				glog.Warningf("Instances %q and %q are both tagged %s=%s; using the lower id", previous, i.ID, tag, name)
+				if previous < i.ID {
+					continue
+				}
+			}
+			chosen[key] = i.ID
+			records[key] = []string{ip}
+		}
+	}
+	return records
+}
+
+// etcdSRVRecords builds the SRV records for etcd DNS discovery (etcd --discovery-srv) from the
+// running masters, targeting their k8s.io/dns/internal name if set, else their private DNS name.  Masters
+// sharing an internal name (e.g. a round-robin name) share a single SRV value, as a record set cannot
+// hold duplicate values.
+func (c *InstancesController) etcdSRVRecords(domain string, instances map[string]*instance) map[kope.DNSRecordKey][]string {
+	var targets []string
+	seen := make(map[string]bool)
+	for _, i := range instances {
+		if kopeaws.InstanceRole(i.status) != kopeaws.RoleMaster || aws.StringValue(i.status.State.Name) != ec2.InstanceStateNameRunning {
+			continue
+		}
+		if _, draining := kopeaws.FindTag(i.status, kopeaws.TagNameDraining); draining {
+			continue
+		}
+		target := c.dnsTagName(i, kopeaws.TagNameKubernetesDnsInternal)
+		if target == "" {
+			target = aws.StringValue(i.status.PrivateDnsName)
+		}
+		target = strings.ToLower(strings.TrimSuffix(target, "."))
+		if target != "" && !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	sort.Strings(targets)
+
+	records := make(map[kope.DNSRecordKey][]string)
+	for service, port := range map[string]int{
+		"_etcd-server-ssl._tcp": etcdPeerPort,
+		"_etcd-client-ssl._tcp": etcdClientPort,
+	} {
+		key := kope.DNSRecordKey{Name: service + "." + domain, Type: kope.DNSTypeSRV}
+		for _, target := range targets {
+			// priority weight port target
+			records[key] = append(records[key], fmt.Sprintf("0 0 %d %s", port, target))
+		}
+	}
+	return records
+}
+
 func StringSlicesEqual(l, r []string) bool {
 	if len(l) != len(r) {
 		return false
@@ -0,0 +1,114 @@
+package instances
+
+import (
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"text/template"
+	"time"
+)
+
+// Policy is the desired state the InstancesController enforces on instances; nil / empty
+// fields leave the corresponding attribute unmanaged
+type Policy struct {
+	// ReportOnly records the changes which would be made to instances and DNS as drift, without making them.
+	// Other controllers are not affected.
+	ReportOnly bool
+	// SourceDestCheck, if set, is the desired source-dest-check of instances (and their secondary ENIs)
+	SourceDestCheck *bool
+	// DetailedMonitoring, if set, is the desired state of detailed (1-minute) CloudWatch monitoring
+	DetailedMonitoring *bool
+	// MetadataOptions, if set, are the desired instance metadata service options (e.g. requiring IMDSv2)
+	MetadataOptions *kopeaws.InstanceMetadataOptions
+	// MetadataOptionsReportOnly reports instances not matching MetadataOptions as drift, without changing them
+	MetadataOptionsReportOnly bool
+	// TerminationProtection maps instance roles to the desired DisableApiTermination setting;
+	// instances with other roles are left unmanaged
+	TerminationProtection map[string]bool
+	// RequiredTags holds tags (with templated values, see ParseTemplates) that every instance should have
+	RequiredTags map[string]*template.Template
+	// PublicCNAME publishes k8s.io/dns/public names as CNAMEs of the instance's public DNS name, rather
+	// than A records of its public IP; names shared by several instances are still published as A records
+	PublicCNAME bool
+	// EtcdSRVDomain, if set, is the domain under which we publish etcd discovery SRV records for the masters
+	EtcdSRVDomain string
+	// PrivateZoneName, if set, is the private zone of a split-horizon pair, in which we publish the internal
+	// IPs of split-horizon names; the DNS provider must publish Private record sets to that zone
+	PrivateZoneName string
+	// ReverseZoneName, if set, is the in-addr.arpa zone in which we publish a PTR record for the internal IP
+	// of each instance with an internal name; the DNS provider must publish PTR records to that zone
+	ReverseZoneName string
+	// RoleGroupDomain, if set, is the domain under which we publish a record for each instance role (e.g.
+	// masters.<domain>), holding the internal IPs of the running instances with that role
+	RoleGroupDomain string
+	// LatencyRouting publishes the internal and public names as latency-based record sets, one per
+	// region, so that clients resolve to the instances in their nearest region
+	LatencyRouting bool
+	// MultiValue publishes the internal and public names as multi-value answer record sets, one per
+	// address, with health checks of the public addresses; ignored if LatencyRouting is set
+	MultiValue bool
+	// HealthCheckPort is the port of the TCP health checks of failover records
+	HealthCheckPort int
+	// MaxDNSChanges and MaxDNSChangePercent, if set, limit how many of the existing DNS records a single
+	// reconcile may change or remove; larger changes are refused unless AllowMassDNSChanges is set
+	MaxDNSChanges       int
+	MaxDNSChangePercent int
+	AllowMassDNSChanges bool
+	// MaxRecordsPerName, if set, limits the addresses published in each A record set, keeping a stable subset
+	// spread across availability zones, so that responses stay within DNS response-size limits
+	MaxRecordsPerName int
+	// DNSCoalesceWindow, if set, is how long DNS changes are held back after they are first seen, so that
+	// the changes made during the window are applied as a single batch
+	DNSCoalesceWindow time.Duration
+	// DNSVerifyWindow, if set, is how long applied DNS changes may take to be served by the authoritative name
+	// servers of the zone; changes still not served are reported and applied again
+	DNSVerifyWindow time.Duration
+	// DNSVerifyNameServers, if set, are the name servers to verify against, rather than those of the zone
+	DNSVerifyNameServers []string
+}
+
+// getPolicy returns the current policy, which must not be modified
+func (c *InstancesController) getPolicy() *Policy {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.policy
+}
+
+// SetPolicy replaces the policy, and queues all known instances for reconciliation against it
+func (c *InstancesController) SetPolicy(policy *Policy) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.policy = policy
+	for id := range c.instances {
+		c.queue.Enqueue(id)
+	}
+}
+
+func (c *InstancesController) getPeriod() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.period
+}
+
+// SetPeriod changes how often all instances are relisted and reconciled, from the next resync
+func (c *InstancesController) SetPeriod(period time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.period = period
+}
+
+// SetDNSProvider replaces the DNS provider (nil to stop managing DNS) for the zone; all records are
+// applied to the new provider on the next resync, unless state saved for the zone was restored
+func (c *InstancesController) SetDNSProvider(dns kope.DNSProvider, zoneName string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.dns = dns
+	c.dnsZone = zoneName
+	c.dnsState = nil
+	c.dnsSynced = false
+	c.dnsPending = nil
+	c.dnsNameServers = nil
+	c.dnsFlushTime = time.Time{}
+	c.restoreDNSState()
+}
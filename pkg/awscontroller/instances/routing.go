@@ -0,0 +1,209 @@
+package instances
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Routing policies which may be set in the value of a DNS tag
+const (
+	RoutingPolicySimple     = "simple"
+	RoutingPolicyWeighted   = "weighted"
+	RoutingPolicyFailover   = "failover"
+	RoutingPolicyLatency    = "latency"
+	RoutingPolicyMultiValue = "multivalue"
+)
+
+// tagRouting is the routing policy of an instance's record, set in the value of its DNS tag after the
+// name, e.g. api.example.com;policy=weighted;weight=10 or api.example.com;policy=failover;role=primary.
+// It overrides the routing of the controller's policy for that record.
+type tagRouting struct {
+	// Policy is one of the RoutingPolicy constants
+	Policy string
+	// Weight is the relative weight of a weighted record
+	Weight int64
+	// Role is the kope.DNSFailoverPrimary or kope.DNSFailoverSecondary role of a failover record
+	Role string
+	// SetIdentifier, if set, overrides the default set identifier, so that several instances can share
+	// a record set
+	SetIdentifier string
+}
+
+// parseDNSTagValue splits the value of a DNS tag into the name and the routing options which follow it,
+// separated by semicolons; routing is nil if there are none
+func parseDNSTagValue(value string) (string, *tagRouting, error) {
+	parts := strings.Split(value, ";")
+	name := strings.TrimSpace(parts[0])
+	if len(parts) == 1 {
+		return name, nil, nil
+	}
+
+	routing := &tagRouting{}
+	weightSet := false
+	for _, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return "", nil, fmt.Errorf("expected key=value, got %q", part)
+		}
+		k, v := strings.ToLower(strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1])
+		switch k {
+		case "policy":
+			routing.Policy = strings.ToLower(v)
+		case "weight":
+			weight, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || weight < 0 || weight > 255 {
+				return "", nil, fmt.Errorf("invalid weight %q: must be between 0 and 255", v)
+			}
+			routing.Weight = weight
+			weightSet = true
+		case "role":
+			switch strings.ToLower(v) {
+			case "primary":
+				routing.Role = kope.DNSFailoverPrimary
+			case "secondary":
+				routing.Role = kope.DNSFailoverSecondary
+			default:
+				return "", nil, fmt.Errorf("invalid role %q: must be primary or secondary", v)
+			}
+		case "set":
+			routing.SetIdentifier = v
+		default:
+			return "", nil, fmt.Errorf("unknown option %q", k)
+		}
+	}
+
+	if routing.Policy == "" {
+		// The policy may be implied by its options
+		switch {
+		case routing.Role != "":
+			routing.Policy = RoutingPolicyFailover
+		case weightSet:
+			routing.Policy = RoutingPolicyWeighted
+		default:
+			return "", nil, fmt.Errorf("no policy specified")
+		}
+	}
+	switch routing.Policy {
+	case RoutingPolicySimple, RoutingPolicyLatency, RoutingPolicyMultiValue:
+	case RoutingPolicyWeighted:
+		if !weightSet {
+			return "", nil, fmt.Errorf("weighted policy requires a weight")
+		}
+	case RoutingPolicyFailover:
+		if routing.Role == "" {
+			return "", nil, fmt.Errorf("failover policy requires a role")
+		}
+	default:
+		return "", nil, fmt.Errorf("unknown policy %q", routing.Policy)
+	}
+	if routing.Role != "" && routing.Policy != RoutingPolicyFailover {
+		return "", nil, fmt.Errorf("role is only valid with the failover policy")
+	}
+	if weightSet && routing.Policy != RoutingPolicyWeighted {
+		return "", nil, fmt.Errorf("weight is only valid with the weighted policy")
+	}
+	if routing.SetIdentifier != "" && routing.Policy == RoutingPolicySimple {
+		return "", nil, fmt.Errorf("set is not valid with the simple policy")
+	}
+	return name, routing, nil
+}
+
+// routedRecordKey returns the key of the record set for the instance's address under name, with the
+// routing from its tag; healthCheckPort, if set, health checks failover and multi-value records
+// (Route53 health checkers can only reach public addresses)
+func (c *InstancesController) routedRecordKey(name string, i *instance, routing *tagRouting, healthCheckPort int) kope.DNSRecordKey {
+	key := kope.ARecord(name)
+	switch routing.Policy {
+	case RoutingPolicyWeighted:
+		key.SetIdentifier = i.ID
+		key.Weighted = true
+		key.Weight = routing.Weight
+	case RoutingPolicyFailover:
+		key.SetIdentifier = strings.ToLower(routing.Role)
+		key.Failover = routing.Role
+		key.HealthCheckPort = healthCheckPort
+	case RoutingPolicyLatency:
+		region := c.cloud.InstanceRegion(i.ID)
+		key.SetIdentifier = region
+		key.Region = region
+	case RoutingPolicyMultiValue:
+		key.SetIdentifier = i.ID
+		key.MultiValue = true
+		key.HealthCheckPort = healthCheckPort
+	}
+	if routing.SetIdentifier != "" {
+		key.SetIdentifier = routing.SetIdentifier
+	}
+	return key
+}
+
+// recordIdentity returns the fields of the key which identify a record set to the DNS provider; the other
+// fields (such as the weight) are attributes, which are replaced by applying a record set with the same
+// identity
+func recordIdentity(k kope.DNSRecordKey) kope.DNSRecordKey {
+	return kope.DNSRecordKey{Name: k.Name, Type: k.Type, SetIdentifier: k.SetIdentifier, Private: k.Private}
+}
+
+// recordRoutingPolicy returns the RoutingPolicy of the record set
+func recordRoutingPolicy(k kope.DNSRecordKey) string {
+	switch {
+	case k.SetIdentifier == "":
+		return RoutingPolicySimple
+	case k.Weighted:
+		return RoutingPolicyWeighted
+	case k.Failover != "":
+		return RoutingPolicyFailover
+	case k.Region != "":
+		return RoutingPolicyLatency
+	default:
+		return RoutingPolicyMultiValue
+	}
+}
+
+// removeRoutingConflicts removes the record sets whose routing policy differs from that of other record
+// sets with the same name and type (e.g. where one instance's tag has routing options and another's
+// does not), which Route53 would reject along with every other change in the batch.  Record sets with
+// the simple policy are kept; otherwise those of the first policy by name.
+func removeRoutingConflicts(dnsState map[kope.DNSRecordKey][]string) {
+	type nameType struct {
+		name    string
+		typ     string
+		private bool
+	}
+	policies := make(map[nameType]map[string]bool)
+	for k := range dnsState {
+		nt := nameType{k.Name, k.Type, k.Private}
+		if policies[nt] == nil {
+			policies[nt] = make(map[string]bool)
+		}
+		policies[nt][recordRoutingPolicy(k)] = true
+	}
+
+	for k := range dnsState {
+		nt := nameType{k.Name, k.Type, k.Private}
+		if len(policies[nt]) < 2 {
+			continue
+		}
+		keep := RoutingPolicySimple
+		if !policies[nt][keep] {
+			var names []string
+			for p := range policies[nt] {
+				names = append(names, p)
+			}
+			sort.Strings(names)
+			keep = names[0]
+		}
+		if policy := recordRoutingPolicy(k); policy != keep {
+			glog.Warningf("Not publishing DNS record %s: its %s routing conflicts with the %s routing of other records of %s", k, policy, keep, k.Name)
+			delete(dnsState, k)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package instances
+
+import (
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"reflect"
+	"testing"
+)
+
+func TestParseDNSTagValue(t *testing.T) {
+	grid := []struct {
+		Value   string
+		Name    string
+		Routing *tagRouting
+		Error   bool
+	}{
+		{Value: "api.example.com", Name: "api.example.com"},
+		{Value: " api.example.com ", Name: "api.example.com"},
+		{
+			Value:   "api.example.com;policy=weighted;weight=10",
+			Name:    "api.example.com",
+			Routing: &tagRouting{Policy: RoutingPolicyWeighted, Weight: 10},
+		},
+		{
+			Value:   "api.example.com; weight=0",
+			Name:    "api.example.com",
+			Routing: &tagRouting{Policy: RoutingPolicyWeighted, Weight: 0},
+		},
+		{
+			Value:   "api.example.com;role=Primary",
+			Name:    "api.example.com",
+			Routing: &tagRouting{Policy: RoutingPolicyFailover, Role: kope.DNSFailoverPrimary},
+		},
+		{
+			Value:   "api.example.com;policy=failover;role=secondary;set=backup",
+			Name:    "api.example.com",
+			Routing: &tagRouting{Policy: RoutingPolicyFailover, Role: kope.DNSFailoverSecondary, SetIdentifier: "backup"},
+		},
+		{
+			Value:   "api.example.com;POLICY=Latency;",
+			Name:    "api.example.com",
+			Routing: &tagRouting{Policy: RoutingPolicyLatency},
+		},
+		{
+			Value:   "api.example.com;policy=multivalue",
+			Name:    "api.example.com",
+			Routing: &tagRouting{Policy: RoutingPolicyMultiValue},
+		},
+		{
+			Value:   "api.example.com;policy=simple",
+			Name:    "api.example.com",
+			Routing: &tagRouting{Policy: RoutingPolicySimple},
+		},
+		{Value: "api.example.com;weight", Error: true},
+		{Value: "api.example.com;weight=256", Error: true},
+		{Value: "api.example.com;weight=-1", Error: true},
+		{Value: "api.example.com;weight=heavy", Error: true},
+		{Value: "api.example.com;role=tertiary", Error: true},
+		{Value: "api.example.com;colour=blue", Error: true},
+		{Value: "api.example.com;set=a", Error: true},
+		{Value: "api.example.com;policy=geolocation", Error: true},
+		{Value: "api.example.com;policy=weighted", Error: true},
+		{Value: "api.example.com;policy=failover", Error: true},
+		{Value: "api.example.com;policy=latency;role=primary", Error: true},
+		{Value: "api.example.com;policy=latency;weight=10", Error: true},
+		{Value: "api.example.com;policy=simple;set=a", Error: true},
+	}
+	for _, g := range grid {
+		name, routing, err := parseDNSTagValue(g.Value)
+		if g.Error {
+			if err == nil {
+				t.Errorf("parseDNSTagValue(%q): expected error, got %q %+v", g.Value, name, routing)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseDNSTagValue(%q): unexpected error: %v", g.Value, err)
+			continue
+		}
+		if name != g.Name {
+			t.Errorf("parseDNSTagValue(%q): name %q, expected %q", g.Value, name, g.Name)
+		}
+		if !reflect.DeepEqual(routing, g.Routing) {
+			t.Errorf("parseDNSTagValue(%q): routing %+v, expected %+v", g.Value, routing, g.Routing)
+		}
+	}
+}
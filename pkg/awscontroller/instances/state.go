@@ -0,0 +1,156 @@
+package instances
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateVersion is the version of the saved state format; state with another version is ignored
+const stateVersion = 1
+
+// maxSavedStateAge is the age beyond which saved state is ignored, so that after a long outage we
+// reconcile everything rather than trusting state which may have been changed behind our back
+const maxSavedStateAge = time.Hour
+
+// savedState is the state written to StatePath, so that a restarted controller knows what it last applied
+type savedState struct {
+	Version   int       `json:"version"`
+	SavedTime time.Time `json:"savedTime"`
+
+	Instances []savedInstance `json:"instances,omitempty"`
+
+	// DNSZone is the zone which DNSRecords were applied to; DNSRecords is only saved once it is known
+	DNSZone    string           `json:"dnsZone,omitempty"`
+	DNSRecords []savedDNSRecord `json:"dnsRecords,omitempty"`
+}
+
+// savedInstance holds the attributes we cache for an instance, which would otherwise be queried again
+type savedInstance struct {
+	ID                    string   `json:"id"`
+	DisableApiTermination *bool    `json:"disableApiTermination,omitempty"`
+	LaunchIndex           *int     `json:"launchIndex,omitempty"`
+	Drift                 []string `json:"drift,omitempty"`
+}
+
+type savedDNSRecord struct {
+	Key    kope.DNSRecordKey `json:"key"`
+	Values []string          `json:"values"`
+}
+
+// LoadState restores the state saved to StatePath, if it exists and is recent; it must be called before Run
+func (c *InstancesController) LoadState() error {
+	if c.StatePath == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(c.StatePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			glog.Infof("No saved state found at %q", c.StatePath)
+			return nil
+		}
+		return fmt.Errorf("error reading state from %q: %v", c.StatePath, err)
+	}
+
+	state := &savedState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return fmt.Errorf("error parsing state from %q: %v", c.StatePath, err)
+	}
+	if state.Version != stateVersion {
+		glog.Warningf("Ignoring saved state with version %d", state.Version)
+		return nil
+	}
+	if age := time.Since(state.SavedTime); age > maxSavedStateAge {
+		glog.Infof("Ignoring saved state from %s ago", age)
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, saved := range state.Instances {
+		// The instances are replaced or deleted by the first resync, which fills in their status
+		c.instances[saved.ID] = &instance{
+			ID:                    saved.ID,
+			disableApiTermination: saved.DisableApiTermination,
+			launchIndex:           saved.LaunchIndex,
+			drift:                 saved.Drift,
+		}
+	}
+
+	if state.DNSRecords != nil {
+		c.savedDNSZone = state.DNSZone
+		c.savedDNSState = make(map[kope.DNSRecordKey][]string)
+		for _, r := range state.DNSRecords {
+			c.savedDNSState[r.Key] = r.Values
+		}
+		c.restoreDNSState()
+	}
+
+	glog.Infof("Restored state of %d instances and %d DNS records, saved at %s", len(state.Instances), len(state.DNSRecords), state.SavedTime.Format(time.RFC3339))
+	return nil
+}
+
+// restoreDNSState uses the saved DNS state if it is for the current zone and we don't yet know better;
+// the caller must hold c.mutex
+func (c *InstancesController) restoreDNSState() {
+	if c.savedDNSState == nil || c.dnsState != nil || c.dns == nil || c.savedDNSZone != c.dnsZone {
+		return
+	}
+	c.dnsState = c.savedDNSState
+	c.savedDNSState = nil
+}
+
+// saveState writes our state to StatePath, replacing the file atomically
+func (c *InstancesController) saveState() error {
+	c.mutex.Lock()
+	state := &savedState{
+		Version:   stateVersion,
+		SavedTime: time.Now(),
+	}
+	for _, i := range c.instances {
+		state.Instances = append(state.Instances, savedInstance{
+			ID:                    i.ID,
+			DisableApiTermination: i.disableApiTermination,
+			LaunchIndex:           i.launchIndex,
+			Drift:                 i.drift,
+		})
+	}
+	if c.dnsState != nil {
+		state.DNSZone = c.dnsZone
+		state.DNSRecords = []savedDNSRecord{}
+		for k, v := range c.dnsState {
+			state.DNSRecords = append(state.DNSRecords, savedDNSRecord{Key: k, Values: v})
+		}
+	}
+	c.mutex.Unlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("error serializing state: %v", err)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(c.StatePath), filepath.Base(c.StatePath)+".tmp")
+	if err != nil {
+		return fmt.Errorf("error creating state file: %v", err)
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.StatePath)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("error writing state to %q: %v", c.StatePath, err)
+	}
+	glog.V(4).Infof("Saved state to %q", c.StatePath)
+	return nil
+}
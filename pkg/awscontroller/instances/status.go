@@ -0,0 +1,175 @@
+package instances
+
+import (
+	"fmt"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"github.com/kopeio/aws-controller/pkg/kope/reconcile"
+	"sort"
+	"time"
+)
+
+// ReconcileStatus summarizes the results of reconciliation, for reporting controller health
+type ReconcileStatus struct {
+	// LastSyncTime is the time of the last successful full resync
+	LastSyncTime time.Time `json:"lastSyncTime,omitempty"`
+
+	// Instances is the number of cluster instances found
+	Instances int `json:"instances"`
+	// Converged is the number of instances whose configuration matches the desired state
+	Converged int `json:"converged"`
+	// Unchanged is the number of instances the last resync skipped, because nothing had changed since they were reconciled
+	Unchanged int `json:"unchanged"`
+
+	// Drift describes the instances whose configuration does not (yet) match the desired state
+	Drift []InstanceDrift `json:"drift,omitempty"`
+
+	// DNSRecords is the number of DNS names we are managing
+	DNSRecords int `json:"dnsRecords"`
+	// DNSTruncated lists the DNS names which are shared by more instances than we publish
+	DNSTruncated []DNSRecordTruncation `json:"dnsTruncated,omitempty"`
+	// DNSDrift describes the DNS changes which have not been applied, in report-only mode
+	DNSDrift []DNSRecordDrift `json:"dnsDrift,omitempty"`
+	// DNSPropagation reports how long DNS changes took to propagate, if we are waiting for them
+	DNSPropagation *kopeaws.Route53PropagationStats `json:"dnsPropagation,omitempty"`
+
+	// Reconcile counts the reconciles of individual instances
+	Reconcile reconcile.Stats `json:"reconcile"`
+
+	// LastError is the most recent resync error, cleared on success
+	LastError     string    `json:"lastError,omitempty"`
+	LastErrorTime time.Time `json:"lastErrorTime,omitempty"`
+}
+
+// InstanceDrift describes how an instance differs from the desired state
+type InstanceDrift struct {
+	ID string `json:"id"`
+	// Fields lists the attributes which differ
+	Fields []string `json:"fields"`
+	// LastError is the most recent error reconciling the instance
+	LastError string `json:"lastError,omitempty"`
+}
+
+// DNSRecordDrift describes a DNS record set which does not match the desired state
+type DNSRecordDrift struct {
+	Record string `json:"record"`
+	// Current is the last known value, or empty if the record set is not known to exist
+	Current []string `json:"current,omitempty"`
+	// Desired is the value we would apply, or empty if we would remove the record set
+	Desired []string `json:"desired,omitempty"`
+}
+
+// dnsDrift describes the changes and removals of DNS record sets, sorted by record
+func dnsDrift(current map[kope.DNSRecordKey][]string, changes map[kope.DNSRecordKey][]string, removed []kope.DNSRecordKey) []DNSRecordDrift {
+	var drift []DNSRecordDrift
+	for k, v := range changes {
+		drift = append(drift, DNSRecordDrift{Record: k.String(), Current: current[k], Desired: v})
+	}
+	for _, k := range removed {
+		drift = append(drift, DNSRecordDrift{Record: k.String(), Current: current[k]})
+	}
+	sort.Slice(drift, func(a, b int) bool { return drift[a].Record < drift[b].Record })
+	return drift
+}
+
+// Status returns a snapshot of the reconcile results
+func (c *InstancesController) Status() *ReconcileStatus {
+	stats := c.queue.Stats()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	status := &ReconcileStatus{
+		LastSyncTime:  c.lastSyncTime,
+		Instances:     len(c.instances),
+		Unchanged:     c.unchanged,
+		DNSRecords:    len(c.dnsState),
+		DNSTruncated:  c.dnsTruncated,
+		DNSDrift:      c.dnsDrift,
+		Reconcile:     stats,
+		LastErrorTime: c.lastErrorTime,
+	}
+	if c.lastError != nil {
+		status.LastError = c.lastError.Error()
+	}
+	if propagation := kopeaws.GetRoute53PropagationStats(); propagation.Changes != 0 || propagation.Timeouts != 0 {
+		status.DNSPropagation = &propagation
+	}
+
+	for _, i := range c.instances {
+		if len(i.drift) == 0 {
+			status.Converged++
+			continue
+		}
+
+		drift := InstanceDrift{
+			ID:     i.ID,
+			Fields: i.drift,
+		}
+		if i.lastError != nil {
+			drift.LastError = i.lastError.Error()
+		}
+		status.Drift = append(status.Drift, drift)
+	}
+	sort.Slice(status.Drift, func(a, b int) bool { return status.Drift[a].ID < status.Drift[b].ID })
+
+	return status
+}
+
+// recordResult records the outcome of a full resync
+func (c *InstancesController) recordResult(err error) {
+	c.health.Record(err)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err != nil {
+		c.lastError = err
+		c.lastErrorTime = time.Now()
+	} else {
+		c.lastError = nil
+		c.lastSyncTime = time.Now()
+	}
+}
+
+// SyncStatus returns the results of our resyncs
+func (c *InstancesController) SyncStatus() *kope.SyncStatus {
+	return c.health.Status()
+}
+
+// Healthz returns an error if resyncs have been failing for unhealthyResyncs periods
+func (c *InstancesController) Healthz() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.lastError == nil {
+		return nil
+	}
+	since := c.lastSyncTime
+	if since.IsZero() {
+		since = c.startTime
+	}
+	if time.Since(since) < unhealthyResyncs*c.period {
+		return nil
+	}
+	return fmt.Errorf("instances not resynced since %s: %v", since.Format(time.RFC3339), c.lastError)
+}
+
+// DNSRecords returns a copy of the DNS records we last applied (empty if we are not managing DNS), or
+// nil if we don't yet know them
+func (c *InstancesController) DNSRecords() map[kope.DNSRecordKey][]string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	records := make(map[kope.DNSRecordKey][]string)
+	if c.dns == nil {
+		return records
+	}
+	if c.dnsState == nil {
+		return nil
+	}
+	for k, v := range c.dnsState {
+		records[k] = append([]string(nil), v...)
+	}
+	return records
+}
@@ -0,0 +1,170 @@
+package instances
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// instanceTemplateData is the data available to templates evaluated against an instance,
+// e.g. {{.ClusterID}}-{{.Role}}-{{.Zone}}
+type instanceTemplateData struct {
+	ClusterID    string
+	InstanceID   string
+	InstanceType string
+	Region       string
+	Zone         string
+	// AZ is an alias for Zone
+	AZ   string
+	Role string
+	// LaunchIndex numbers the instances of each role in each zone from 0, reusing the
+	// lowest free number; an instance keeps its number, which is recorded in a tag
+	LaunchIndex    int
+	PrivateIP      string
+	PrivateDNSName string
+	// Tags holds the instance's current tags, e.g. {{index .Tags "Name"}}
+	Tags map[string]string
+}
+
+// buildTemplateData builds the template data for the instance; the caller must hold c.mutex
+func (c *InstancesController) buildTemplateData(i *instance) *instanceTemplateData {
+	status := i.status
+	id := aws.StringValue(status.InstanceId)
+	data := &instanceTemplateData{
+		ClusterID:      c.cloud.ClusterID(),
+		InstanceID:     id,
+		InstanceType:   aws.StringValue(status.InstanceType),
+		Region:         c.cloud.InstanceRegion(id),
+		Role:           kopeaws.InstanceRole(status),
+		PrivateIP:      aws.StringValue(status.PrivateIpAddress),
+		PrivateDNSName: aws.StringValue(status.PrivateDnsName),
+		Tags:           make(map[string]string),
+	}
+	if status.Placement != nil {
+		data.Zone = aws.StringValue(status.Placement.AvailabilityZone)
+		data.AZ = data.Zone
+	}
+	data.LaunchIndex = c.launchIndex(i)
+	for _, tag := range status.Tags {
+		data.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return data
+}
+
+// launchIndex returns the instance's index among the live instances with the same role and zone: the
+// index recorded in its tag if that is still free, else the lowest unused index; the caller must hold c.mutex
+func (c *InstancesController) launchIndex(i *instance) int {
+	if i.launchIndex != nil {
+		return *i.launchIndex
+	}
+
+	group := launchGroup(i.status)
+	used := make(map[int]bool)
+	for _, other := range c.instances {
+		if other == i || launchGroup(other.status) != group {
+			continue
+		}
+		switch aws.StringValue(other.status.State.Name) {
+		case "shutting-down", "terminated":
+			continue
+		}
+		if other.launchIndex != nil {
+			used[*other.launchIndex] = true
+		} else if index, found := taggedLaunchIndex(other.status); found {
+			// Not yet numbered since we started, but it will claim its tagged index
+			used[index] = true
+		}
+	}
+
+	index, found := taggedLaunchIndex(i.status)
+	if !found || used[index] {
+		index = 0
+		for used[index] {
+			index++
+		}
+	}
+	i.launchIndex = &index
+	return index
+}
+
+// taggedLaunchIndex returns the launch index recorded in the instance's tag, if it has a valid one
+func taggedLaunchIndex(status *ec2.Instance) (int, bool) {
+	value, found := kopeaws.FindTag(status, kopeaws.TagNameLaunchIndex)
+	if !found {
+		return 0, false
+	}
+	index, err := strconv.Atoi(value)
+	if err != nil || index < 0 {
+		return 0, false
+	}
+	return index, true
+}
+
+// launchGroup identifies the instances which are numbered together by launchIndex
+func launchGroup(status *ec2.Instance) string {
+	zone := ""
+	if status.Placement != nil {
+		zone = aws.StringValue(status.Placement.AvailabilityZone)
+	}
+	return kopeaws.InstanceRole(status) + "/" + zone
+}
+
+// ParseTemplates parses each value as a template for evaluation against instances
+func ParseTemplates(values map[string]string) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template)
+	for k, v := range values {
+		t, err := template.New(k).Option("missingkey=error").Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing template for %q: %v", k, err)
+		}
+		templates[k] = t
+	}
+	return templates, nil
+}
+
+// dnsTagName returns the DNS name in the instance's tag, or "" if it is not set.  The caller must hold c.mutex.
+func (c *InstancesController) dnsTagName(i *instance, tagName string) string {
+	name, _ := c.dnsTagRecord(i, tagName)
+	return name
+}
+
+// dnsTagRecord returns the DNS name in the instance's tag, or "" if it is not set, and the routing options
+// which may follow it (see parseDNSTagValue).  The tag value may be a template (e.g.
+// {{.InstanceID}}.nodes.example.com), so that instances sharing a launch template can be given unique names;
+// an invalid template or routing option is logged and the tag ignored.  The caller must hold c.mutex.
+func (c *InstancesController) dnsTagRecord(i *instance, tagName string) (string, *tagRouting) {
+	value, _ := kopeaws.FindTag(i.status, tagName)
+	if strings.Contains(value, "{{") {
+		t, err := template.New(tagName).Option("missingkey=error").Parse(value)
+		if err != nil {
+			glog.Warningf("Ignoring invalid template in tag %s=%q of instance %q: %v", tagName, value, i.ID, err)
+			return "", nil
+		}
+		value, err = executeTemplate(t, c.buildTemplateData(i))
+		if err != nil {
+			glog.Warningf("Ignoring tag %s of instance %q: %v", tagName, i.ID, err)
+			return "", nil
+		}
+	}
+
+	name, routing, err := parseDNSTagValue(value)
+	if err != nil {
+		glog.Warningf("Ignoring invalid routing options in tag %s=%q of instance %q: %v", tagName, value, i.ID, err)
+		return "", nil
+	}
+	return name, routing
+}
+
+func executeTemplate(t *template.Template, data *instanceTemplateData) (string, error) {
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("error evaluating template %q for instance %q: %v", t.Name(), data.InstanceID, err)
+	}
+	return b.String(), nil
+}
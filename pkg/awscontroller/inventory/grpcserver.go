@@ -0,0 +1,157 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/apis/inventory/v1alpha1"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"net"
+	"sync"
+)
+
+// GRPCServer serves the Inventory gRPC service, so that other components can query our view of the
+// cluster rather than each describing the instances themselves
+type GRPCServer struct {
+	v1alpha1.UnimplementedInventoryServer
+
+	instances InstanceLister
+	dns       DNSRecordLister
+
+	listener net.Listener
+	server   *grpc.Server
+
+	// stopLock protects shutdown, so that the server is only stopped once
+	stopLock sync.Mutex
+	shutdown bool
+}
+
+// NewGRPCServer listens on the address; dns may be nil if we are not managing DNS.  The API is served without
+// TLS or authentication, so an address without a host (e.g. ":10247") listens on localhost only; other
+// interfaces must be named explicitly (e.g. "0.0.0.0:10247").
+func NewGRPCServer(address string, instances InstanceLister, dns DNSRecordLister) (*GRPCServer, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inventory gRPC address %q: %v", address, err)
+	}
+	if host == "" {
+		address = net.JoinHostPort("localhost", port)
+	} else if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		glog.Warningf("serving the inventory gRPC API on %s without TLS or authentication", address)
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %q: %v", address, err)
+	}
+
+	s := &GRPCServer{
+		instances: instances,
+		dns:       dns,
+		listener:  listener,
+		server:    grpc.NewServer(),
+	}
+	v1alpha1.RegisterInventoryServer(s.server, s)
+	return s, nil
+}
+
+func (s *GRPCServer) Run() {
+	glog.Infof("serving inventory gRPC API on %s", s.listener.Addr())
+
+	// Serve returns nil once Stop is called
+	if err := s.server.Serve(s.listener); err != nil {
+		runtime.HandleError(fmt.Errorf("error serving inventory gRPC API: %v", err))
+	}
+	glog.Infof("shutting down inventory gRPC API")
+}
+
+// NeedLeaderElection returns false: standby replicas serve the instances too, though not the DNS records
+func (s *GRPCServer) NeedLeaderElection() bool {
+	return false
+}
+
+// Stop stops the server, waiting for in-flight calls to complete.
+func (s *GRPCServer) Stop() error {
+	s.stopLock.Lock()
+	defer s.stopLock.Unlock()
+
+	if !s.shutdown {
+		s.server.GracefulStop()
+		s.shutdown = true
+
+		return nil
+	}
+
+	return fmt.Errorf("shutdown already in progress")
+}
+
+func (s *GRPCServer) ListInstances(ctx context.Context, request *v1alpha1.ListInstancesRequest) (*v1alpha1.ListInstancesResponse, error) {
+	filter := &InstanceFilter{}
+	if f := request.GetFilter(); f != nil {
+		filter.Role = f.Role
+		filter.State = f.State
+		filter.Tags = f.Tags
+	}
+
+	instances, err := listInstances(ctx, s.instances, filter)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "error listing instances: %v", err)
+	}
+
+	response := &v1alpha1.ListInstancesResponse{}
+	for _, i := range instances {
+		response.Instances = append(response.Instances, toProtoInstance(i))
+	}
+	return response, nil
+}
+
+func (s *GRPCServer) ListDNSRecords(ctx context.Context, request *v1alpha1.ListDNSRecordsRequest) (*v1alpha1.ListDNSRecordsResponse, error) {
+	records, known := listDNSRecords(s.dns)
+	if !known {
+		return nil, status.Errorf(codes.Unavailable, "DNS records are not yet known")
+	}
+
+	response := &v1alpha1.ListDNSRecordsResponse{}
+	for _, r := range records {
+		response.Records = append(response.Records, &v1alpha1.DNSRecord{
+			Name:              r.Key.Name,
+			Type:              r.Key.Type,
+			SetIdentifier:     r.Key.SetIdentifier,
+			Failover:          r.Key.Failover,
+			Region:            r.Key.Region,
+			MultiValue:        r.Key.MultiValue,
+			HealthCheckPort:   int32(r.Key.HealthCheckPort),
+			AliasHostedZoneId: r.Key.AliasHostedZoneID,
+			Values:            append([]string(nil), r.Values...),
+		})
+	}
+	return response, nil
+}
+
+func toProtoInstance(i *kope.Instance) *v1alpha1.Instance {
+	instance := &v1alpha1.Instance{
+		Id:             i.ID,
+		Region:         i.Region,
+		Zone:           i.Zone,
+		State:          i.State,
+		Role:           i.Role,
+		PrivateIp:      i.PrivateIP,
+		PrivateDnsName: i.PrivateDNSName,
+		PublicIp:       i.PublicIP,
+		PublicDnsName:  i.PublicDNSName,
+		IpForwarding:   i.IPForwarding,
+		Tags:           make(map[string]string),
+	}
+	for k, v := range i.Tags {
+		instance.Tags[k] = v
+	}
+	if !i.LaunchTime.IsZero() {
+		instance.LaunchTime = timestamppb.New(i.LaunchTime)
+	}
+	return instance
+}
@@ -0,0 +1,83 @@
+package inventory
+
+import (
+	"context"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"sort"
+)
+
+// InstanceLister lists the cluster instances; it is implemented by kope.Cloud, and by the shared instance cache
+type InstanceLister interface {
+	ListInstances(ctx context.Context) ([]*kope.Instance, error)
+}
+
+// DNSRecordLister returns the DNS records applied for the instances, or nil if they are not yet known
+type DNSRecordLister interface {
+	DNSRecords() map[kope.DNSRecordKey][]string
+}
+
+// InstanceFilter selects instances; empty fields match every instance
+type InstanceFilter struct {
+	Role  string
+	State string
+	// Tags must all be present on the instance, with the same values
+	Tags map[string]string
+}
+
+// Matches returns true if the instance is selected by the filter
+func (f *InstanceFilter) Matches(i *kope.Instance) bool {
+	if f.Role != "" && i.Role != f.Role {
+		return false
+	}
+	if f.State != "" && i.State != f.State {
+		return false
+	}
+	for k, v := range f.Tags {
+		if actual, found := i.Tag(k); !found || actual != v {
+			return false
+		}
+	}
+	return true
+}
+
+// listInstances returns the instances matching the filter, sorted by id
+func listInstances(ctx context.Context, lister InstanceLister, filter *InstanceFilter) ([]*kope.Instance, error) {
+	instances, err := lister.ListInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []*kope.Instance
+	for _, i := range instances {
+		if filter.Matches(i) {
+			matched = append(matched, i)
+		}
+	}
+	sort.Slice(matched, func(a, b int) bool { return matched[a].ID < matched[b].ID })
+	return matched, nil
+}
+
+// dnsRecord is a DNS record set, with its values
+type dnsRecord struct {
+	Key    kope.DNSRecordKey
+	Values []string
+}
+
+// listDNSRecords returns the DNS records, sorted by key, or false if they are not yet known (as on a
+// standby replica); lister may be nil if we are not managing DNS
+func listDNSRecords(lister DNSRecordLister) ([]dnsRecord, bool) {
+	if lister == nil {
+		return nil, true
+	}
+
+	all := lister.DNSRecords()
+	if all == nil {
+		return nil, false
+	}
+	var records []dnsRecord
+	for k, v := range all {
+		records = append(records, dnsRecord{Key: k, Values: v})
+	}
+	sort.Slice(records, func(a, b int) bool { return records[a].Key.String() < records[b].Key.String() })
+	return records, true
+}
@@ -0,0 +1,229 @@
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The paths of the REST API
+const (
+	InstancesPath  = "/api/v1/instances"
+	DNSRecordsPath = "/api/v1/dns"
+)
+
+// Page sizes of the REST API; a request may ask for a smaller page with the limit parameter
+const (
+	defaultPageSize = 500
+	maxPageSize     = 1000
+)
+
+// InstanceList is a page of the response to InstancesPath
+type InstanceList struct {
+	Items []Instance `json:"items"`
+	// Continue is passed as the continue parameter to fetch the next page; it is empty on the last page
+	Continue string `json:"continue,omitempty"`
+}
+
+type Instance struct {
+	ID     string `json:"id"`
+	Region string `json:"region,omitempty"`
+	Zone   string `json:"zone,omitempty"`
+	State  string `json:"state"`
+	Role   string `json:"role,omitempty"`
+
+	PrivateIP      string `json:"privateIP,omitempty"`
+	PrivateDNSName string `json:"privateDNSName,omitempty"`
+	PublicIP       string `json:"publicIP,omitempty"`
+	PublicDNSName  string `json:"publicDNSName,omitempty"`
+
+	IPForwarding bool              `json:"ipForwarding"`
+	LaunchTime   *time.Time        `json:"launchTime,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+}
+
+// DNSRecordList is a page of the response to DNSRecordsPath
+type DNSRecordList struct {
+	Items []DNSRecord `json:"items"`
+	// Continue is passed as the continue parameter to fetch the next page; it is empty on the last page
+	Continue string `json:"continue,omitempty"`
+}
+
+type DNSRecord struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	SetIdentifier     string `json:"setIdentifier,omitempty"`
+	Failover          string `json:"failover,omitempty"`
+	Region            string `json:"region,omitempty"`
+	MultiValue        bool   `json:"multiValue,omitempty"`
+	HealthCheckPort   int    `json:"healthCheckPort,omitempty"`
+	AliasHostedZoneID string `json:"aliasHostedZoneID,omitempty"`
+
+	Values []string `json:"values"`
+}
+
+// RESTHandler serves the instances and DNS records as JSON, for scripts and dashboards.  Instances can be
+// filtered with the role and state parameters, and tag=<key>=<value> (repeated to require several tags).
+// Both lists are sorted, and paged with the limit and continue parameters.
+type RESTHandler struct {
+	instances InstanceLister
+	dns       DNSRecordLister
+}
+
+// NewRESTHandler builds the handler; dns may be nil if we are not managing DNS
+func NewRESTHandler(instances InstanceLister, dns DNSRecordLister) *RESTHandler {
+	return &RESTHandler{
+		instances: instances,
+		dns:       dns,
+	}
+}
+
+func (h *RESTHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := defaultPageSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxPageSize {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxPageSize), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	continueFrom := r.URL.Query().Get("continue")
+
+	switch r.URL.Path {
+	case InstancesPath:
+		h.serveInstances(w, r, limit, continueFrom)
+	case DNSRecordsPath:
+		h.serveDNSRecords(w, limit, continueFrom)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *RESTHandler) serveInstances(w http.ResponseWriter, r *http.Request, limit int, continueFrom string) {
+	query := r.URL.Query()
+	filter := &InstanceFilter{
+		Role:  query.Get("role"),
+		State: query.Get("state"),
+	}
+	for _, tag := range query["tag"] {
+		tokens := strings.SplitN(tag, "=", 2)
+		if len(tokens) != 2 || tokens[0] == "" {
+			http.Error(w, fmt.Sprintf("expected tag=<key>=<value>, got %q", tag), http.StatusBadRequest)
+			return
+		}
+		if filter.Tags == nil {
+			filter.Tags = make(map[string]string)
+		}
+		filter.Tags[tokens[0]] = tokens[1]
+	}
+
+	instances, err := listInstances(r.Context(), h.instances, filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error listing instances: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	start, end, next := page(len(instances), func(i int) string { return instances[i].ID }, continueFrom, limit)
+	list := &InstanceList{
+		Items:    []Instance{},
+		Continue: next,
+	}
+	for _, i := range instances[start:end] {
+		list.Items = append(list.Items, NewInstance(i))
+	}
+	writeJSON(w, list)
+}
+
+func (h *RESTHandler) serveDNSRecords(w http.ResponseWriter, limit int, continueFrom string) {
+	records, known := listDNSRecords(h.dns)
+	if !known {
+		http.Error(w, "DNS records are not yet known", http.StatusServiceUnavailable)
+		return
+	}
+
+	start, end, next := page(len(records), func(i int) string { return records[i].Key.String() }, continueFrom, limit)
+	list := &DNSRecordList{
+		Items:    []DNSRecord{},
+		Continue: next,
+	}
+	for _, r := range records[start:end] {
+		list.Items = append(list.Items, NewDNSRecord(r.Key, r.Values))
+	}
+	writeJSON(w, list)
+}
+
+// page returns the bounds of the page of n sorted items starting at the item with key continueFrom (or the
+// first item with a later key, if it has since gone), and the key starting the next page if there is one
+func page(n int, key func(i int) string, continueFrom string, limit int) (int, int, string) {
+	start := 0
+	if continueFrom != "" {
+		start = sort.Search(n, func(i int) bool { return key(i) >= continueFrom })
+	}
+	end := start + limit
+	if end >= n {
+		return start, n, ""
+	}
+	return start, end, key(end)
+}
+
+// NewInstance converts a cloud instance to its representation in the REST API
+func NewInstance(i *kope.Instance) Instance {
+	instance := Instance{
+		ID:             i.ID,
+		Region:         i.Region,
+		Zone:           i.Zone,
+		State:          i.State,
+		Role:           i.Role,
+		PrivateIP:      i.PrivateIP,
+		PrivateDNSName: i.PrivateDNSName,
+		PublicIP:       i.PublicIP,
+		PublicDNSName:  i.PublicDNSName,
+		IPForwarding:   i.IPForwarding,
+		Tags:           i.Tags,
+	}
+	if !i.LaunchTime.IsZero() {
+		launchTime := i.LaunchTime
+		instance.LaunchTime = &launchTime
+	}
+	return instance
+}
+
+// NewDNSRecord converts a DNS record set to its representation in the REST API
+func NewDNSRecord(key kope.DNSRecordKey, values []string) DNSRecord {
+	return DNSRecord{
+		Name:              key.Name,
+		Type:              key.Type,
+		SetIdentifier:     key.SetIdentifier,
+		Failover:          key.Failover,
+		Region:            key.Region,
+		MultiValue:        key.MultiValue,
+		HealthCheckPort:   key.HealthCheckPort,
+		AliasHostedZoneID: key.AliasHostedZoneID,
+		Values:            values,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error serializing response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(data); err != nil {
+		glog.V(2).Infof("error writing response: %v", err)
+	}
+}
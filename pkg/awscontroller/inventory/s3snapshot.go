@@ -0,0 +1,168 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ObjectStore reads and writes objects; it is implemented by kopeaws.AWSCloud with S3
+type ObjectStore interface {
+	PutS3Object(ctx context.Context, bucket string, key string, body []byte, contentType string) error
+	// LatestS3Object returns the object with the greatest key under prefix, or nil if there are none
+	LatestS3Object(ctx context.Context, bucket string, prefix string) ([]byte, error)
+}
+
+// Snapshot is the inventory snapshot we upload
+type Snapshot struct {
+	ClusterID string    `json:"clusterID"`
+	Time      time.Time `json:"time"`
+
+	Instances  []Instance  `json:"instances"`
+	DNSRecords []DNSRecord `json:"dnsRecords"`
+}
+
+// S3Snapshotter uploads a snapshot of the cluster instances and applied DNS records to S3 whenever they
+// change, as an audit trail of the cluster topology.  Snapshots are written under
+// <prefix>/<cluster-id>/<timestamp>.json; the bucket's lifecycle rules should expire old snapshots.  On
+// start we compare against the latest snapshot in the bucket, so that a restart does not upload a duplicate.
+type S3Snapshotter struct {
+	instances InstanceLister
+	dns       DNSRecordLister
+	store     ObjectStore
+	clusterID string
+	bucket    string
+	prefix    string
+
+	// uploaded is the content (without the time) of the last snapshot we uploaded
+	uploaded []byte
+	// seeded is set once we have tried to set uploaded from the latest snapshot in the bucket
+	seeded bool
+
+	*kope.PeriodicController
+}
+
+// NewS3Snapshotter builds an S3Snapshotter uploading to destination, s3://bucket/prefix; dns may be nil if we
+// are not managing DNS
+func NewS3Snapshotter(instances InstanceLister, dns DNSRecordLister, store ObjectStore, clusterID string, destination string, period time.Duration) (*S3Snapshotter, error) {
+	bucket, prefix, err := ParseS3Destination(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &S3Snapshotter{
+		instances: instances,
+		dns:       dns,
+		store:     store,
+		clusterID: clusterID,
+		bucket:    bucket,
+		prefix:    prefix,
+	}
+	c.PeriodicController = kope.NewPeriodicController("S3 inventory snapshotter", period, c.runOnce)
+	return c, nil
+}
+
+// ParseS3Destination parses an s3://bucket/prefix destination into its bucket and its prefix, without
+// leading or trailing slashes
+func ParseS3Destination(destination string) (string, string, error) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid snapshot destination %q: %v", destination, err)
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return "", "", fmt.Errorf("invalid snapshot destination %q: expected s3://bucket/prefix", destination)
+	}
+	return u.Host, strings.Trim(u.Path, "/"), nil
+}
+
+// keyPrefix is the prefix of the keys of our snapshots, ending in a slash
+func (c *S3Snapshotter) keyPrefix() string {
+	key := c.prefix
+	if key != "" {
+		key += "/"
+	}
+	return key + c.clusterID + "/"
+}
+
+// seed sets uploaded from the latest snapshot in the bucket, uploaded before we were restarted.  Errors are
+// only logged: the worst outcome is that we upload a snapshot identical to the latest one.
+func (c *S3Snapshotter) seed(ctx context.Context) {
+	body, err := c.store.LatestS3Object(ctx, c.bucket, c.keyPrefix())
+	if err != nil {
+		glog.Warningf("Unable to read the latest inventory snapshot; the next snapshot may duplicate it: %v", err)
+		return
+	}
+	if body == nil {
+		return
+	}
+
+	latest := &Snapshot{}
+	if err := json.Unmarshal(body, latest); err != nil {
+		glog.Warningf("Unable to parse the latest inventory snapshot; the next snapshot may duplicate it: %v", err)
+		return
+	}
+	latest.Time = time.Time{}
+	content, err := json.Marshal(latest)
+	if err != nil {
+		glog.Warningf("Unable to serialize the latest inventory snapshot; the next snapshot may duplicate it: %v", err)
+		return
+	}
+	c.uploaded = content
+}
+
+func (c *S3Snapshotter) runOnce(ctx context.Context) error {
+	instances, err := listInstances(ctx, c.instances, &InstanceFilter{})
+	if err != nil {
+		return err
+	}
+	records, known := listDNSRecords(c.dns)
+	if !known {
+		glog.V(4).Infof("DNS records not yet known; not taking an inventory snapshot")
+		return nil
+	}
+
+	if !c.seeded {
+		c.seed(ctx)
+		c.seeded = true
+	}
+
+	snapshot := &Snapshot{
+		ClusterID:  c.clusterID,
+		Instances:  []Instance{},
+		DNSRecords: []DNSRecord{},
+	}
+	for _, i := range instances {
+		snapshot.Instances = append(snapshot.Instances, NewInstance(i))
+	}
+	for _, r := range records {
+		snapshot.DNSRecords = append(snapshot.DNSRecords, NewDNSRecord(r.Key, r.Values))
+	}
+
+	// We compare the content without the time, which changes on every resync
+	content, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("error serializing inventory snapshot: %v", err)
+	}
+	if c.uploaded != nil && bytes.Equal(content, c.uploaded) {
+		return nil
+	}
+
+	snapshot.Time = time.Now().UTC()
+	body, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error serializing inventory snapshot: %v", err)
+	}
+	key := c.keyPrefix() + snapshot.Time.Format("20060102T150405Z") + ".json"
+	if err := c.store.PutS3Object(ctx, c.bucket, key, body, "application/json"); err != nil {
+		return err
+	}
+	glog.V(2).Infof("Uploaded inventory snapshot of %d instances and %d DNS records to s3://%s/%s", len(snapshot.Instances), len(snapshot.DNSRecords), c.bucket, key)
+	c.uploaded = content
+	return nil
+}
@@ -0,0 +1,109 @@
+package inventory
+
+import (
+	"context"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseS3Destination(t *testing.T) {
+	grid := []struct {
+		Destination string
+		Bucket      string
+		Prefix      string
+		Error       bool
+	}{
+		{Destination: "s3://bucket", Bucket: "bucket"},
+		{Destination: "s3://bucket/", Bucket: "bucket"},
+		{Destination: "s3://bucket/inventory", Bucket: "bucket", Prefix: "inventory"},
+		{Destination: "s3://bucket/clusters/a/inventory/", Bucket: "bucket", Prefix: "clusters/a/inventory"},
+		{Destination: "s3://bucket//inventory//", Bucket: "bucket", Prefix: "inventory"},
+		{Destination: "bucket/inventory", Error: true},
+		{Destination: "https://bucket.s3.amazonaws.com/inventory", Error: true},
+		{Destination: "s3:///inventory", Error: true},
+		{Destination: "s3://%zz/inventory", Error: true},
+	}
+	for _, g := range grid {
+		bucket, prefix, err := ParseS3Destination(g.Destination)
+		if g.Error {
+			if err == nil {
+				t.Errorf("ParseS3Destination(%q): expected error, got %q %q", g.Destination, bucket, prefix)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseS3Destination(%q): unexpected error: %v", g.Destination, err)
+			continue
+		}
+		if bucket != g.Bucket || prefix != g.Prefix {
+			t.Errorf("ParseS3Destination(%q): got %q %q, expected %q %q", g.Destination, bucket, prefix, g.Bucket, g.Prefix)
+		}
+	}
+}
+
+// memoryObjectStore is an ObjectStore holding the objects written to it, in order
+type memoryObjectStore struct {
+	keys   []string
+	bodies [][]byte
+}
+
+func (s *memoryObjectStore) PutS3Object(ctx context.Context, bucket string, key string, body []byte, contentType string) error {
+	s.keys = append(s.keys, bucket+"/"+key)
+	s.bodies = append(s.bodies, body)
+	return nil
+}
+
+func (s *memoryObjectStore) LatestS3Object(ctx context.Context, bucket string, prefix string) ([]byte, error) {
+	for i := len(s.keys) - 1; i >= 0; i-- {
+		if strings.HasPrefix(s.keys[i], bucket+"/"+prefix) {
+			return s.bodies[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// staticInstanceLister lists a fixed set of instances
+type staticInstanceLister []*kope.Instance
+
+func (l staticInstanceLister) ListInstances(ctx context.Context) ([]*kope.Instance, error) {
+	return l, nil
+}
+
+func TestS3SnapshotterRestart(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryObjectStore{}
+	instances := staticInstanceLister{
+		{ID: "i-1", State: kope.InstanceStateRunning, PrivateIP: "10.0.0.1", LaunchTime: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), Tags: map[string]string{"Name": "node1"}},
+	}
+
+	for i := 0; i < 2; i++ {
+		// A new snapshotter, as after a restart, must not upload an unchanged snapshot again
+		c, err := NewS3Snapshotter(instances, nil, store, "cluster", "s3://bucket/inventory", time.Minute)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := c.runOnce(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(store.keys) != 1 {
+			t.Fatalf("after %d runs, uploaded %v, expected a single snapshot", i+1, store.keys)
+		}
+		if !strings.HasPrefix(store.keys[0], "bucket/inventory/cluster/") {
+			t.Errorf("uploaded %q, expected it under bucket/inventory/cluster/", store.keys[0])
+		}
+	}
+
+	changed := append(instances, &kope.Instance{ID: "i-2", State: kope.InstanceStateRunning, PrivateIP: "10.0.0.2"})
+	c, err := NewS3Snapshotter(changed, nil, store, "cluster", "s3://bucket/inventory", time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.runOnce(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(store.keys) != 2 {
+		t.Errorf("uploaded %v, expected a second snapshot after a change", store.keys)
+	}
+}
@@ -0,0 +1,142 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ParameterStore stores parameters by path; it is implemented by kopeaws.AWSCloud with the SSM Parameter Store
+type ParameterStore interface {
+	PutSSMParameter(ctx context.Context, name string, value string) error
+	ListSSMParameters(ctx context.Context, path string) (map[string]string, error)
+	DeleteSSMParameters(ctx context.Context, names []string) error
+}
+
+// SSMPublisher publishes the cluster instances to the SSM Parameter Store, so that bootstrap scripts and Lambda
+// functions can discover the cluster with ssm:GetParametersByPath rather than EC2 describe permissions.  Each
+// instance with a role is written as JSON to <path>/<role>s/<instance-id> (e.g. <path>/masters/i-0123...);
+// parameters are only written when they change, and those of instances which have gone are deleted.  Other
+// parameters under the path, whose names are not of that form, are left alone.
+type SSMPublisher struct {
+	instances InstanceLister
+	store     ParameterStore
+	path      string
+
+	// published holds the parameters we know to exist, with their values; nil until they are listed
+	published map[string]string
+
+	*kope.PeriodicController
+}
+
+func NewSSMPublisher(instances InstanceLister, store ParameterStore, path string, period time.Duration) (*SSMPublisher, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("invalid SSM parameter path %q: must start with /", path)
+	}
+
+	c := &SSMPublisher{
+		instances: instances,
+		store:     store,
+		path:      strings.TrimSuffix(path, "/"),
+	}
+	c.PeriodicController = kope.NewPeriodicController("SSM inventory publisher", period, c.runOnce)
+	return c, nil
+}
+
+func (c *SSMPublisher) runOnce(ctx context.Context) error {
+	desired, err := c.desiredParameters(ctx)
+	if err != nil {
+		return err
+	}
+
+	if c.published == nil {
+		existing, err := c.store.ListSSMParameters(ctx, c.path)
+		if err != nil {
+			return err
+		}
+		// Other parameters may share the path; we only ever replace or delete our own
+		published := make(map[string]string)
+		for name, value := range existing {
+			if c.isOwnedParameter(name) {
+				published[name] = value
+			}
+		}
+		c.published = published
+	}
+
+	var names []string
+	for name := range desired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := desired[name]
+		if current, found := c.published[name]; found && current == value {
+			continue
+		}
+		if err := c.store.PutSSMParameter(ctx, name, value); err != nil {
+			return err
+		}
+		glog.V(2).Infof("Published SSM parameter %q", name)
+		c.published[name] = value
+	}
+
+	var removed []string
+	for name := range c.published {
+		if _, found := desired[name]; !found {
+			removed = append(removed, name)
+		}
+	}
+	if len(removed) != 0 {
+		sort.Strings(removed)
+		if err := c.store.DeleteSSMParameters(ctx, removed); err != nil {
+			return err
+		}
+		glog.V(2).Infof("Deleted SSM parameters %v", removed)
+		for _, name := range removed {
+			delete(c.published, name)
+		}
+	}
+	return nil
+}
+
+// ownedParameterPattern matches the part of a parameter name after our path which we own: <role>s/<instance-id>
+var ownedParameterPattern = regexp.MustCompile(`^[^/]+s/i-[0-9a-f]+$`)
+
+// isOwnedParameter returns true if the parameter is one we publish, <path>/<role>s/<instance-id>
+func (c *SSMPublisher) isOwnedParameter(name string) bool {
+	if !strings.HasPrefix(name, c.path+"/") {
+		return false
+	}
+	return ownedParameterPattern.MatchString(strings.TrimPrefix(name, c.path+"/"))
+}
+
+// desiredParameters returns the parameters describing the instances, by name
+func (c *SSMPublisher) desiredParameters(ctx context.Context) (map[string]string, error) {
+	instances, err := listInstances(ctx, c.instances, &InstanceFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	parameters := make(map[string]string)
+	for _, i := range instances {
+		if i.Role == "" || i.State == kope.InstanceStateTerminated {
+			continue
+		}
+		instance := NewInstance(i)
+		// Tags would quickly exceed the size limit of a parameter
+		instance.Tags = nil
+		value, err := json.Marshal(instance)
+		if err != nil {
+			return nil, fmt.Errorf("error serializing instance %q: %v", i.ID, err)
+		}
+		parameters[c.path+"/"+i.Role+"s/"+i.ID] = string(value)
+	}
+	return parameters, nil
+}
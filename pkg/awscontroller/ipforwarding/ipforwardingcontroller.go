@@ -0,0 +1,84 @@
+package ipforwarding
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"sort"
+	"time"
+)
+
+// IPForwardingController enables IP forwarding on the cluster's running instances, so that they can
+// route pod traffic (e.g. for routes-based networking).  It works against any kope.Cloud; on AWS the
+// instances controller already does this, by disabling source-dest-check.
+type IPForwardingController struct {
+	// Roles limits the instances to those with these roles; if empty, all instances are configured
+	Roles []string
+
+	cloud kope.Cloud
+
+	// mustStop holds the running instances on which the cloud can only enable IP forwarding once they are
+	// stopped; we report them, rather than retrying
+	mustStop map[string]bool
+
+	*kope.PeriodicController
+}
+
+func NewIPForwardingController(cloud kope.Cloud, period time.Duration) *IPForwardingController {
+	c := &IPForwardingController{
+		cloud: cloud,
+	}
+	c.PeriodicController = kope.NewPeriodicController("IP forwarding controller", period, c.runOnce)
+	return c
+}
+
+func (c *IPForwardingController) runOnce(ctx context.Context) error {
+	instances, err := c.cloud.ListInstances(ctx)
+	if err != nil {
+		return err
+	}
+
+	mustStop := make(map[string]bool)
+	for _, i := range instances {
+		if i.State != kope.InstanceStateRunning || i.IPForwarding || !c.hasRole(i) {
+			continue
+		}
+		if c.mustStop[i.ID] {
+			mustStop[i.ID] = true
+			continue
+		}
+		err := c.cloud.SetInstanceIPForwarding(ctx, i.ID, true)
+		if err == kope.ErrInstanceMustBeStopped {
+			glog.Warningf("IP forwarding can only be enabled on instance %q once it is stopped", i.ID)
+			mustStop[i.ID] = true
+			continue
+		}
+		if err != nil {
+			// Continue with the other instances
+			runtime.HandleError(err)
+		}
+	}
+	c.mustStop = mustStop
+
+	var warnings []string
+	for id := range mustStop {
+		warnings = append(warnings, fmt.Sprintf("instance %s needs IP forwarding, which can only be enabled while it is stopped", id))
+	}
+	sort.Strings(warnings)
+	c.SetWarnings(warnings)
+	return nil
+}
+
+func (c *IPForwardingController) hasRole(i *kope.Instance) bool {
+	if len(c.Roles) == 0 {
+		return true
+	}
+	for _, r := range c.Roles {
+		if r == i.Role {
+			return true
+		}
+	}
+	return false
+}
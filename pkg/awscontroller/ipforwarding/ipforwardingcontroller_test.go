@@ -0,0 +1,48 @@
+package ipforwarding
+
+import (
+	"context"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/fakecloud"
+	"testing"
+	"time"
+)
+
+func TestIPForwarding(t *testing.T) {
+	grid := []struct {
+		Roles []string
+		// Expected maps each instance id to whether it should forward
+		Expected map[string]bool
+	}{
+		{
+			Expected: map[string]bool{"i-master": true, "i-node": true, "i-stopped": false, "i-forwarding": true},
+		},
+		{
+			Roles:    []string{"node"},
+			Expected: map[string]bool{"i-master": false, "i-node": true, "i-stopped": false, "i-forwarding": true},
+		},
+	}
+	for _, g := range grid {
+		cloud := fakecloud.NewFakeCloud("cluster.example.com")
+		for _, i := range []*kope.Instance{
+			{ID: "i-master", Role: "master", State: kope.InstanceStateRunning},
+			{ID: "i-node", Role: "node", State: kope.InstanceStateRunning},
+			{ID: "i-stopped", Role: "node", State: kope.InstanceStateStopped},
+			{ID: "i-forwarding", Role: "master", State: kope.InstanceStateRunning, IPForwarding: true},
+		} {
+			i.Tags = map[string]string{}
+			cloud.AddInstance(i)
+		}
+
+		c := NewIPForwardingController(cloud, time.Minute)
+		c.Roles = g.Roles
+		if err := c.runOnce(context.Background()); err != nil {
+			t.Fatalf("roles %v: unexpected error: %v", g.Roles, err)
+		}
+		for id, expected := range g.Expected {
+			if actual := cloud.Instance(id).IPForwarding; actual != expected {
+				t.Errorf("roles %v: instance %s has IP forwarding %v, expected %v", g.Roles, id, actual, expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package ipv6
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"github.com/kopeio/aws-controller/pkg/kope/kubeutils"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AnnotationIPv6Addresses records the IPv6 addresses of the node's primary network interface
+const AnnotationIPv6Addresses = "aws-controller.kope.io/ipv6-addresses"
+
+// IPv6Controller assigns an IPv6 address to the primary network interface of each node's
+// instance, if the instance is in a dual-stack subnet, and records the node's IPv6 addresses
+// in an annotation.
+type IPv6Controller struct {
+	cloud *kopeaws.AWSCloud
+	// cache is the instance list shared with other controllers
+	cache      *kopeaws.InstanceCache
+	kubernetes kubernetes.Interface
+
+	*kope.PeriodicController
+}
+
+func NewIPv6Controller(cloud *kopeaws.AWSCloud, cache *kopeaws.InstanceCache, kubernetes kubernetes.Interface, period time.Duration) *IPv6Controller {
+	c := &IPv6Controller{
+		cloud:      cloud,
+		cache:      cache,
+		kubernetes: kubernetes,
+	}
+	c.PeriodicController = kope.NewPeriodicController("IPv6 controller", period, c.runOnce)
+	return c
+}
+
+func (c *IPv6Controller) runOnce(ctx context.Context) error {
+	instances, err := c.cache.List(ctx)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]*ec2.Instance)
+	for _, i := range instances {
+		byID[aws.StringValue(i.InstanceId)] = i
+	}
+
+	nodes, err := c.kubernetes.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing nodes: %v", err)
+	}
+
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		instance := byID[kubeutils.InstanceIDFromProviderID(node.Spec.ProviderID)]
+		if instance == nil || aws.StringValue(instance.State.Name) != ec2.InstanceStateNameRunning {
+			continue
+		}
+		if err := c.syncNode(ctx, node, instance); err != nil {
+			runtime.HandleError(err)
+		}
+	}
+	return nil
+}
+
+func (c *IPv6Controller) syncNode(ctx context.Context, node *v1.Node, instance *ec2.Instance) error {
+	id := aws.StringValue(instance.InstanceId)
+
+	var primary *ec2.InstanceNetworkInterface
+	for _, eni := range instance.NetworkInterfaces {
+		if eni.Attachment != nil && aws.Int64Value(eni.Attachment.DeviceIndex) == 0 {
+			primary = eni
+		}
+	}
+	if primary == nil {
+		return fmt.Errorf("primary network interface not found for instance %q", id)
+	}
+
+	var addresses []string
+	for _, a := range primary.Ipv6Addresses {
+		addresses = append(addresses, aws.StringValue(a.Ipv6Address))
+	}
+
+	if len(addresses) == 0 {
+		dualStack, err := c.cloud.IsDualStackSubnet(ctx, id, aws.StringValue(primary.SubnetId))
+		if err != nil {
+			return err
+		}
+		if !dualStack {
+			return nil
+		}
+
+		addresses, err = c.cloud.AssignIPv6Address(ctx, id, aws.StringValue(primary.NetworkInterfaceId))
+		if err != nil {
+			return err
+		}
+	}
+
+	sort.Strings(addresses)
+	annotation := strings.Join(addresses, ",")
+	if node.Annotations[AnnotationIPv6Addresses] == annotation {
+		return nil
+	}
+	return kubeutils.AnnotateNode(ctx, c.kubernetes, node.Name, map[string]string{AnnotationIPv6Addresses: annotation})
+}
@@ -0,0 +1,165 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"github.com/kopeio/aws-controller/pkg/kope/kubeutils"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	"sync"
+	"time"
+)
+
+// heartbeatInterval is how often we extend the lifecycle hook timeout while draining
+const heartbeatInterval = time.Minute
+
+// LifecycleController consumes autoscaling lifecycle hook notifications from an SQS queue.
+// For each instance being terminated, it withdraws the instance from DNS, cordons and drains the
+// node, and then completes the lifecycle action so that the autoscaling group can proceed.  Stop aborts
+// any terminations in progress; their messages are redelivered once their visibility timeout expires.
+type LifecycleController struct {
+	// DrainOptions configures how nodes are drained
+	DrainOptions kubeutils.DrainOptions
+
+	cloud      *kopeaws.AWSCloud
+	kubernetes kubernetes.Interface
+	queueURL   string
+
+	// mutex protects inFlight
+	mutex sync.Mutex
+	// inFlight holds the instances currently being drained, so redelivered messages are ignored
+	inFlight map[string]bool
+
+	*kope.PeriodicController
+}
+
+func NewLifecycleController(cloud *kopeaws.AWSCloud, kubernetes kubernetes.Interface, queueURL string) *LifecycleController {
+	c := &LifecycleController{
+		DrainOptions: kubeutils.DrainOptions{
+			Timeout: 10 * time.Minute,
+		},
+		cloud:      cloud,
+		kubernetes: kubernetes,
+		queueURL:   queueURL,
+		inFlight:   make(map[string]bool),
+	}
+	// Each iteration long-polls the queue, so we don't need a period
+	c.PeriodicController = kope.NewPeriodicController("lifecycle controller", time.Second, c.runOnce)
+	return c
+}
+
+func (c *LifecycleController) Run() {
+	glog.Infof("consuming lifecycle messages from %q", c.queueURL)
+	c.PeriodicController.Run()
+}
+
+func (c *LifecycleController) runOnce(ctx context.Context) error {
+	// Keep messages hidden while we drain, so they aren't redelivered
+	visibilityTimeout := c.DrainOptions.Timeout + 5*time.Minute
+
+	messages, err := c.cloud.ReceiveLifecycleMessages(ctx, c.queueURL, visibilityTimeout)
+	if err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		if message.LifecycleTransition != kopeaws.LifecycleTransitionTerminating {
+			glog.V(2).Infof("Discarding lifecycle message: event=%q transition=%q", message.Event, message.LifecycleTransition)
+			if err := c.cloud.DeleteLifecycleMessage(ctx, c.queueURL, message); err != nil {
+				runtime.HandleError(err)
+			}
+			continue
+		}
+
+		c.mutex.Lock()
+		inFlight := c.inFlight[message.EC2InstanceID]
+		c.inFlight[message.EC2InstanceID] = true
+		c.mutex.Unlock()
+		if inFlight {
+			glog.V(2).Infof("Instance %q is already being drained", message.EC2InstanceID)
+			continue
+		}
+
+		go func(message *kopeaws.LifecycleMessage) {
+			defer func() {
+				c.mutex.Lock()
+				delete(c.inFlight, message.EC2InstanceID)
+				c.mutex.Unlock()
+			}()
+
+			if err := c.handleTermination(ctx, message); err != nil {
+				runtime.HandleError(err)
+			}
+		}(message)
+	}
+
+	return nil
+}
+
+func (c *LifecycleController) handleTermination(ctx context.Context, message *kopeaws.LifecycleMessage) error {
+	id := message.EC2InstanceID
+
+	instance, err := c.cloud.DescribeInstance(ctx, id)
+	if err != nil {
+		return err
+	}
+	if clusterID, _ := kopeaws.FindTag(instance, kopeaws.TagNameKubernetesCluster); clusterID != c.cloud.ClusterID() {
+		// Leave the message for the controller of the other cluster
+		glog.Warningf("Ignoring lifecycle message for instance %q in cluster %q", id, clusterID)
+		return nil
+	}
+
+	glog.Infof("Instance %q is terminating (group %q); draining", id, message.AutoScalingGroupName)
+
+	// Withdraw from DNS first; we still drain if this fails
+	if err := c.cloud.TagInstance(ctx, id, map[string]string{kopeaws.TagNameDraining: "asg-lifecycle"}); err != nil {
+		runtime.HandleError(err)
+	}
+
+	nodeName, err := kubeutils.FindNodeForInstance(ctx, c.kubernetes, id, aws.StringValue(instance.PrivateDnsName))
+	if err != nil {
+		return err
+	}
+
+	if nodeName == "" {
+		glog.Warningf("No node found for instance %q; not draining", id)
+	} else if err := c.drain(ctx, message, nodeName); err != nil {
+		// The instance is being terminated regardless; don't hold up the group any longer
+		runtime.HandleError(fmt.Errorf("error draining node %q (proceeding with termination): %v", nodeName, err))
+	}
+
+	if err := c.cloud.CompleteLifecycleAction(ctx, message); err != nil {
+		return err
+	}
+	return c.cloud.DeleteLifecycleMessage(ctx, c.queueURL, message)
+}
+
+// drain cordons and drains the node, sending lifecycle heartbeats so the hook doesn't time out
+func (c *LifecycleController) drain(ctx context.Context, message *kopeaws.LifecycleMessage, nodeName string) error {
+	if err := kubeutils.CordonNode(ctx, c.kubernetes, nodeName); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := c.cloud.RecordLifecycleActionHeartbeat(ctx, message); err != nil {
+					runtime.HandleError(err)
+				}
+			}
+		}
+	}()
+
+	return kubeutils.DrainNode(ctx, c.kubernetes, nodeName, c.DrainOptions)
+}
@@ -0,0 +1,181 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"github.com/kopeio/aws-controller/pkg/kope/kubeutils"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	"strings"
+	"time"
+)
+
+// The scheduled event codes which take an instance out of service
+var disruptiveEventCodes = map[string]bool{
+	ec2.EventCodeInstanceReboot:     true,
+	ec2.EventCodeSystemReboot:       true,
+	ec2.EventCodeSystemMaintenance:  true,
+	ec2.EventCodeInstanceRetirement: true,
+	ec2.EventCodeInstanceStop:       true,
+}
+
+// The prefix of the draining tag value we set, so we only restore instances we withdrew
+const drainingReasonPrefix = "maintenance-"
+
+// MaintenanceController watches for EC2 scheduled events (reboots, retirements, maintenance)
+// on cluster instances, and cordons the corresponding nodes ahead of the maintenance window.
+// Nodes are left cordoned after the maintenance completes, for the operator to uncordon.
+type MaintenanceController struct {
+	// WithdrawFromDNSBefore is how long before the maintenance window an instance is
+	// withdrawn from DNS (by tagging it as draining); zero disables DNS withdrawal
+	WithdrawFromDNSBefore time.Duration
+
+	cloud *kopeaws.AWSCloud
+
+	// cache is the instance list shared with other controllers
+
+	cache      *kopeaws.InstanceCache
+	kubernetes kubernetes.Interface
+
+	// cordoned records the events we have already reacted to, keyed by instance id and event
+	cordoned map[string]bool
+	// withdrawn records the instances we have withdrawn from DNS
+	withdrawn map[string]bool
+
+	*kope.PeriodicController
+}
+
+func NewMaintenanceController(cloud *kopeaws.AWSCloud, cache *kopeaws.InstanceCache, kubernetes kubernetes.Interface, period time.Duration) *MaintenanceController {
+	c := &MaintenanceController{
+		cloud:      cloud,
+		cache:      cache,
+		kubernetes: kubernetes,
+		cordoned:   make(map[string]bool),
+		withdrawn:  make(map[string]bool),
+	}
+	c.PeriodicController = kope.NewPeriodicController("maintenance controller", period, c.runOnce)
+	return c
+}
+
+func (c *MaintenanceController) runOnce(ctx context.Context) error {
+	instances, err := c.cache.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[string]*ec2.Instance)
+	var ids []string
+	for _, i := range instances {
+		if aws.StringValue(i.State.Name) != ec2.InstanceStateNameRunning {
+			continue
+		}
+		id := aws.StringValue(i.InstanceId)
+		byID[id] = i
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	statuses, err := c.cloud.DescribeInstanceStatus(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	scheduled := 0
+	pending := make(map[string]bool)
+	for _, status := range statuses {
+		id := aws.StringValue(status.InstanceId)
+		for _, event := range status.Events {
+			if !isPending(event) {
+				continue
+			}
+			scheduled++
+			pending[id] = true
+
+			if err := c.handleEvent(ctx, byID[id], event); err != nil {
+				runtime.HandleError(err)
+			}
+		}
+	}
+
+	// Restore instances to DNS once their maintenance has completed
+	for id, i := range byID {
+		reason, _ := kopeaws.FindTag(i, kopeaws.TagNameDraining)
+		if pending[id] || !strings.HasPrefix(reason, drainingReasonPrefix) {
+			continue
+		}
+		glog.Infof("Scheduled maintenance of instance %q has completed; restoring to DNS", id)
+		if err := c.cloud.UntagInstance(ctx, id, []string{kopeaws.TagNameDraining}); err != nil {
+			runtime.HandleError(err)
+			continue
+		}
+		delete(c.withdrawn, id)
+	}
+
+	glog.V(2).Infof("Found %d pending scheduled events", scheduled)
+	return nil
+}
+
+// isPending returns true if the event is a disruptive event which has not completed or been cancelled
+func isPending(event *ec2.InstanceStatusEvent) bool {
+	if !disruptiveEventCodes[aws.StringValue(event.Code)] {
+		return false
+	}
+	description := aws.StringValue(event.Description)
+	if strings.HasPrefix(description, "[Completed]") || strings.HasPrefix(description, "[Canceled]") {
+		return false
+	}
+	return true
+}
+
+func (c *MaintenanceController) handleEvent(ctx context.Context, instance *ec2.Instance, event *ec2.InstanceStatusEvent) error {
+	if instance == nil {
+		return nil
+	}
+	id := aws.StringValue(instance.InstanceId)
+	code := aws.StringValue(event.Code)
+	notBefore := aws.TimeValue(event.NotBefore)
+
+	if c.WithdrawFromDNSBefore != 0 && !c.withdrawn[id] && time.Until(notBefore) <= c.WithdrawFromDNSBefore {
+		glog.Infof("Withdrawing instance %q from DNS ahead of %s at %s", id, code, notBefore)
+		if err := c.cloud.TagInstance(ctx, id, map[string]string{kopeaws.TagNameDraining: drainingReasonPrefix + code}); err != nil {
+			return err
+		}
+		c.withdrawn[id] = true
+	}
+
+	key := id + "/" + aws.StringValue(event.InstanceEventId) + "/" + code
+	if c.cordoned[key] {
+		return nil
+	}
+
+	nodeName, err := kubeutils.FindNodeForInstance(ctx, c.kubernetes, id, aws.StringValue(instance.PrivateDnsName))
+	if err != nil {
+		return err
+	}
+	if nodeName == "" {
+		glog.Warningf("No node found for instance %q with scheduled %s", id, code)
+		return nil
+	}
+
+	glog.Infof("Instance %q (node %q) has scheduled %s at %s: %s", id, nodeName, code, notBefore, aws.StringValue(event.Description))
+
+	if err := kubeutils.CordonNode(ctx, c.kubernetes, nodeName); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("EC2 scheduled %s for instance %s at %s: %s", code, id, notBefore.Format(time.RFC3339), aws.StringValue(event.Description))
+	if err := kubeutils.RecordNodeEvent(ctx, c.kubernetes, nodeName, v1.EventTypeWarning, "ScheduledMaintenance", message); err != nil {
+		return err
+	}
+
+	c.cordoned[key] = true
+	return nil
+}
@@ -0,0 +1,205 @@
+package masterendpoints
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// The value of the endpointslice.kubernetes.io/managed-by label on slices we manage
+const managedBy = "aws-controller.kope.io"
+
+// MasterEndpointsController maintains a selector-less Service, and an EndpointSlice holding the
+// private IPs of the master instances, so in-cluster components have a load-balanced path to the
+// control plane which doesn't depend on DNS.
+type MasterEndpointsController struct {
+	// Namespace and Name identify the Service (and EndpointSlice)
+	Namespace string
+	Name      string
+	// Port is the port the API server listens on
+	Port int32
+
+	cloud *kopeaws.AWSCloud
+
+	// cache is the instance list shared with other controllers
+
+	cache      *kopeaws.InstanceCache
+	kubernetes kubernetes.Interface
+
+	*kope.PeriodicController
+}
+
+func NewMasterEndpointsController(cloud *kopeaws.AWSCloud, cache *kopeaws.InstanceCache, kubernetes kubernetes.Interface, period time.Duration) *MasterEndpointsController {
+	c := &MasterEndpointsController{
+		Namespace:  metav1.NamespaceSystem,
+		Name:       "kubernetes-masters",
+		Port:       443,
+		cloud:      cloud,
+		cache:      cache,
+		kubernetes: kubernetes,
+	}
+	c.PeriodicController = kope.NewPeriodicController("master endpoints controller", period, c.runOnce)
+	return c
+}
+
+func (c *MasterEndpointsController) Run() {
+	glog.Infof("maintaining master endpoints in %s/%s", c.Namespace, c.Name)
+	c.PeriodicController.Run()
+}
+
+func (c *MasterEndpointsController) runOnce(ctx context.Context) error {
+	instances, err := c.cache.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	var endpoints []discovery.Endpoint
+	for _, i := range instances {
+		if kopeaws.InstanceRole(i) != kopeaws.RoleMaster {
+			continue
+		}
+		state := aws.StringValue(i.State.Name)
+		ip := aws.StringValue(i.PrivateIpAddress)
+		if ip == "" || (state != ec2.InstanceStateNameRunning && state != ec2.InstanceStateNamePending) {
+			continue
+		}
+
+		_, draining := kopeaws.FindTag(i, kopeaws.TagNameDraining)
+		ready := state == ec2.InstanceStateNameRunning && !draining
+		endpoint := discovery.Endpoint{
+			Addresses:  []string{ip},
+			Conditions: discovery.EndpointConditions{Ready: aws.Bool(ready)},
+		}
+		if i.Placement != nil {
+			endpoint.Zone = i.Placement.AvailabilityZone
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Slice(endpoints, func(a, b int) bool { return endpoints[a].Addresses[0] < endpoints[b].Addresses[0] })
+
+	if err := c.ensureService(ctx); err != nil {
+		return err
+	}
+	if err := c.ensureEndpointSlice(ctx, endpoints); err != nil {
+		return err
+	}
+
+	glog.V(2).Infof("Published %d master endpoints", len(endpoints))
+	return nil
+}
+
+func (c *MasterEndpointsController) ensureService(ctx context.Context) error {
+	services := c.kubernetes.CoreV1().Services(c.Namespace)
+
+	ports := []v1.ServicePort{
+		{
+			Name:       "https",
+			Protocol:   v1.ProtocolTCP,
+			Port:       c.Port,
+			TargetPort: intstr.FromInt(int(c.Port)),
+		},
+	}
+
+	existing, err := services.Get(ctx, c.Name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("error fetching service %s/%s: %v", c.Namespace, c.Name, err)
+		}
+
+		glog.Infof("Creating service %s/%s", c.Namespace, c.Name)
+		service := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      c.Name,
+				Namespace: c.Namespace,
+			},
+			Spec: v1.ServiceSpec{
+				Ports: ports,
+			},
+		}
+		if _, err := services.Create(ctx, service, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("error creating service %s/%s: %v", c.Namespace, c.Name, err)
+		}
+		return nil
+	}
+
+	if reflect.DeepEqual(existing.Spec.Ports, ports) && existing.Spec.Selector == nil {
+		return nil
+	}
+
+	glog.Infof("Updating service %s/%s", c.Namespace, c.Name)
+	existing.Spec.Ports = ports
+	existing.Spec.Selector = nil
+	if _, err := services.Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("error updating service %s/%s: %v", c.Namespace, c.Name, err)
+	}
+	return nil
+}
+
+func (c *MasterEndpointsController) ensureEndpointSlice(ctx context.Context, endpoints []discovery.Endpoint) error {
+	slices := c.kubernetes.DiscoveryV1().EndpointSlices(c.Namespace)
+
+	ports := []discovery.EndpointPort{
+		{
+			Name:     aws.String("https"),
+			Protocol: protocolPtr(v1.ProtocolTCP),
+			Port:     aws.Int32(c.Port),
+		},
+	}
+	labels := map[string]string{
+		discovery.LabelServiceName: c.Name,
+		discovery.LabelManagedBy:   managedBy,
+	}
+
+	existing, err := slices.Get(ctx, c.Name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("error fetching endpointslice %s/%s: %v", c.Namespace, c.Name, err)
+		}
+
+		glog.Infof("Creating endpointslice %s/%s", c.Namespace, c.Name)
+		slice := &discovery.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      c.Name,
+				Namespace: c.Namespace,
+				Labels:    labels,
+			},
+			AddressType: discovery.AddressTypeIPv4,
+			Endpoints:   endpoints,
+			Ports:       ports,
+		}
+		if _, err := slices.Create(ctx, slice, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("error creating endpointslice %s/%s: %v", c.Namespace, c.Name, err)
+		}
+		return nil
+	}
+
+	if reflect.DeepEqual(existing.Endpoints, endpoints) && reflect.DeepEqual(existing.Ports, ports) && reflect.DeepEqual(existing.Labels, labels) {
+		return nil
+	}
+
+	glog.Infof("Updating endpointslice %s/%s with %d endpoints", c.Namespace, c.Name, len(endpoints))
+	existing.Labels = labels
+	existing.Endpoints = endpoints
+	existing.Ports = ports
+	if _, err := slices.Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("error updating endpointslice %s/%s: %v", c.Namespace, c.Name, err)
+	}
+	return nil
+}
+
+func protocolPtr(p v1.Protocol) *v1.Protocol {
+	return &p
+}
@@ -0,0 +1,202 @@
+package natfailover
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"github.com/kopeio/aws-controller/pkg/kope/notify"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"sort"
+	"time"
+)
+
+// NATFailoverController monitors the cluster's NAT instances (tagged k8s.io/role/nat) and NAT
+// gateways, and repoints the default route of any cluster route table whose NAT has failed at a
+// healthy standby in the same region.  NAT instances must forward traffic, so it also keeps
+// SourceDestCheck disabled on them.
+type NATFailoverController struct {
+	// FailureThreshold is the number of consecutive failed checks before a NAT is considered failed
+	FailureThreshold int
+	// Notifier is told about each failover
+	Notifier *notify.Notifier
+
+	cloud *kopeaws.AWSCloud
+
+	// cache is the instance list shared with other controllers
+
+	cache *kopeaws.InstanceCache
+
+	// failures counts the consecutive failed checks of each NAT, by instance or gateway id
+	failures map[string]int
+
+	*kope.PeriodicController
+}
+
+// natTarget is a NAT instance or gateway which a default route can point at
+type natTarget struct {
+	id      string
+	region  string
+	healthy bool
+}
+
+func NewNATFailoverController(cloud *kopeaws.AWSCloud, cache *kopeaws.InstanceCache, period time.Duration) *NATFailoverController {
+	c := &NATFailoverController{
+		FailureThreshold: 2,
+		cloud:            cloud,
+		cache:            cache,
+		failures:         make(map[string]int),
+	}
+	c.PeriodicController = kope.NewPeriodicController("NAT failover controller", period, c.runOnce)
+	return c
+}
+
+func (c *NATFailoverController) runOnce(ctx context.Context) error {
+	targets, err := c.checkTargets(ctx)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	routeTables, err := c.cloud.ListRouteTables(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Count the route tables using each target, so standbys are spread evenly
+	load := make(map[string]int)
+	for _, rt := range routeTables {
+		load[rt.DefaultTarget]++
+	}
+
+	for _, rt := range routeTables {
+		current := targets[rt.DefaultTarget]
+		if current == nil && !rt.DefaultBlackhole {
+			// Not routed through one of our NATs
+			continue
+		}
+		if current != nil && !rt.DefaultBlackhole && c.failures[current.id] < c.FailureThreshold {
+			continue
+		}
+
+		standby := chooseStandby(targets, load, rt.Region)
+		if standby == nil {
+			glog.Warningf("Default route of %q points at failed NAT %q, but no healthy standby is available", rt.ID, rt.DefaultTarget)
+			continue
+		}
+
+		glog.Warningf("NAT %q for route table %q has failed; failing over to %q", rt.DefaultTarget, rt.ID, standby.id)
+		if err := c.cloud.ReplaceDefaultRoute(ctx, rt, standby.id); err != nil {
+			runtime.HandleError(err)
+			continue
+		}
+		c.Notifier.Notify(notify.ReasonNATFailover, fmt.Sprintf("NAT %s for route table %s failed; failed over to %s", rt.DefaultTarget, rt.ID, standby.id))
+		load[rt.DefaultTarget]--
+		load[standby.id]++
+	}
+
+	return nil
+}
+
+// checkTargets finds and health-checks our NAT instances and gateways, returning them by id
+func (c *NATFailoverController) checkTargets(ctx context.Context) (map[string]*natTarget, error) {
+	targets := make(map[string]*natTarget)
+
+	instances, err := c.cache.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, i := range instances {
+		if kopeaws.InstanceRole(i) != kopeaws.RoleNAT {
+			continue
+		}
+		id := aws.StringValue(i.InstanceId)
+		running := aws.StringValue(i.State.Name) == "running"
+		targets[id] = &natTarget{
+			id:      id,
+			region:  c.cloud.InstanceRegion(id),
+			healthy: running,
+		}
+		if running {
+			ids = append(ids, id)
+			if aws.BoolValue(i.SourceDestCheck) {
+				glog.Infof("Disabling SourceDestCheck on NAT instance %q", id)
+				if err := c.cloud.ConfigureInstanceSourceDestCheck(ctx, id, false); err != nil {
+					runtime.HandleError(err)
+				}
+			}
+		}
+	}
+
+	if len(ids) != 0 {
+		statuses, err := c.cloud.DescribeInstanceStatus(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, status := range statuses {
+			t := targets[aws.StringValue(status.InstanceId)]
+			if t == nil {
+				continue
+			}
+			if status.SystemStatus != nil && aws.StringValue(status.SystemStatus.Status) == ec2.SummaryStatusImpaired {
+				t.healthy = false
+			}
+			if status.InstanceStatus != nil && aws.StringValue(status.InstanceStatus.Status) == ec2.SummaryStatusImpaired {
+				t.healthy = false
+			}
+		}
+	}
+
+	natGateways, err := c.cloud.ListNATGateways(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range natGateways {
+		if g.State == ec2.NatGatewayStateDeleted {
+			continue
+		}
+		targets[g.ID] = &natTarget{
+			id:      g.ID,
+			region:  g.Region,
+			healthy: g.State == ec2.NatGatewayStateAvailable,
+		}
+	}
+
+	failures := make(map[string]int)
+	for id, t := range targets {
+		if !t.healthy {
+			failures[id] = c.failures[id] + 1
+			glog.V(2).Infof("NAT %q failed health check (%d consecutive)", id, failures[id])
+		}
+	}
+	c.failures = failures
+
+	return targets, nil
+}
+
+// chooseStandby returns the healthy NAT in the region serving the fewest route tables, or nil if there is none
+func chooseStandby(targets map[string]*natTarget, load map[string]int, region string) *natTarget {
+	var candidates []*natTarget
+	for _, t := range targets {
+		if t.healthy && t.region == region {
+			candidates = append(candidates, t)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if load[candidates[i].id] != load[candidates[j].id] {
+			return load[candidates[i].id] < load[candidates[j].id]
+		}
+		return candidates[i].id < candidates[j].id
+	})
+	return candidates[0]
+}
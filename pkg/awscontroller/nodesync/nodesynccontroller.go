@@ -0,0 +1,172 @@
+package nodesync
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"github.com/kopeio/aws-controller/pkg/kope/kubeutils"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The annotations we set on nodes describing their instances
+const (
+	AnnotationInstanceID     = "aws-controller.kope.io/instance-id"
+	AnnotationInstanceType   = "aws-controller.kope.io/instance-type"
+	AnnotationImageID        = "aws-controller.kope.io/image-id"
+	AnnotationLifecycle      = "aws-controller.kope.io/lifecycle"
+	AnnotationZone           = "aws-controller.kope.io/availability-zone"
+	AnnotationSubnetID       = "aws-controller.kope.io/subnet-id"
+	AnnotationSecurityGroups = "aws-controller.kope.io/security-groups"
+)
+
+// NodeSyncController synchronizes information between Kubernetes Node objects and the EC2
+// instances backing them.  Nodes are matched to managed instances by provider id.
+type NodeSyncController struct {
+	// LabelTags maps node label keys to the instance tag keys they are copied to
+	LabelTags map[string]string
+	// AnnotationTags maps node annotation keys to the instance tag keys they are copied to
+	AnnotationTags map[string]string
+	// AnnotateNodes annotates nodes with facts about their instances, so tooling can use them without AWS credentials
+	AnnotateNodes bool
+
+	cloud *kopeaws.AWSCloud
+
+	// cache is the instance list shared with other controllers
+
+	cache      *kopeaws.InstanceCache
+	kubernetes kubernetes.Interface
+
+	*kope.PeriodicController
+}
+
+func NewNodeSyncController(cloud *kopeaws.AWSCloud, cache *kopeaws.InstanceCache, kubernetes kubernetes.Interface, period time.Duration) *NodeSyncController {
+	c := &NodeSyncController{
+		cloud:      cloud,
+		cache:      cache,
+		kubernetes: kubernetes,
+	}
+	c.PeriodicController = kope.NewPeriodicController("node sync controller", period, c.runOnce)
+	return c
+}
+
+func (c *NodeSyncController) runOnce(ctx context.Context) error {
+	instances, err := c.cache.List(ctx)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]*ec2.Instance)
+	for _, i := range instances {
+		byID[aws.StringValue(i.InstanceId)] = i
+	}
+
+	nodes, err := c.kubernetes.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing nodes: %v", err)
+	}
+
+	matched := 0
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		instance := byID[kubeutils.InstanceIDFromProviderID(node.Spec.ProviderID)]
+		if instance == nil || aws.StringValue(instance.State.Name) != ec2.InstanceStateNameRunning {
+			continue
+		}
+		matched++
+
+		if err := c.syncTags(ctx, node, instance); err != nil {
+			runtime.HandleError(err)
+		}
+		if c.AnnotateNodes {
+			if err := c.syncAnnotations(ctx, node, instance); err != nil {
+				runtime.HandleError(err)
+			}
+		}
+	}
+
+	glog.V(2).Infof("Synchronized %d nodes with their instances", matched)
+	return nil
+}
+
+// syncTags copies the configured labels and annotations of the node onto its instance as tags,
+// removing the tags when the label or annotation is removed
+func (c *NodeSyncController) syncTags(ctx context.Context, node *v1.Node, instance *ec2.Instance) error {
+	id := aws.StringValue(instance.InstanceId)
+
+	set := make(map[string]string)
+	var remove []string
+	reconcile := func(values map[string]string, mapping map[string]string) {
+		for k, tagKey := range mapping {
+			desired, wanted := values[k]
+			actual, found := kopeaws.FindTag(instance, tagKey)
+			if wanted && (!found || actual != desired) {
+				set[tagKey] = desired
+			} else if !wanted && found {
+				remove = append(remove, tagKey)
+			}
+		}
+	}
+	reconcile(node.Labels, c.LabelTags)
+	reconcile(node.Annotations, c.AnnotationTags)
+
+	if len(set) != 0 {
+		glog.V(2).Infof("Copying node %q metadata to instance %q tags: %v", node.Name, id, set)
+		if err := c.cloud.TagInstance(ctx, id, set); err != nil {
+			return err
+		}
+	}
+	if len(remove) != 0 {
+		sort.Strings(remove)
+		if err := c.cloud.UntagInstance(ctx, id, remove); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncAnnotations annotates the node with facts about its instance
+func (c *NodeSyncController) syncAnnotations(ctx context.Context, node *v1.Node, instance *ec2.Instance) error {
+	lifecycle := aws.StringValue(instance.InstanceLifecycle)
+	if lifecycle == "" {
+		lifecycle = "on-demand"
+	}
+
+	var securityGroups []string
+	for _, sg := range instance.SecurityGroups {
+		securityGroups = append(securityGroups, aws.StringValue(sg.GroupId))
+	}
+	sort.Strings(securityGroups)
+
+	desired := map[string]string{
+		AnnotationInstanceID:     aws.StringValue(instance.InstanceId),
+		AnnotationInstanceType:   aws.StringValue(instance.InstanceType),
+		AnnotationImageID:        aws.StringValue(instance.ImageId),
+		AnnotationLifecycle:      lifecycle,
+		AnnotationSubnetID:       aws.StringValue(instance.SubnetId),
+		AnnotationSecurityGroups: strings.Join(securityGroups, ","),
+	}
+	if instance.Placement != nil {
+		desired[AnnotationZone] = aws.StringValue(instance.Placement.AvailabilityZone)
+	}
+
+	changed := make(map[string]string)
+	for k, v := range desired {
+		if node.Annotations[k] != v {
+			changed[k] = v
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	return kubeutils.AnnotateNode(ctx, c.kubernetes, node.Name, changed)
+}
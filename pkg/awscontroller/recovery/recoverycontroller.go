@@ -0,0 +1,95 @@
+package recovery
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"time"
+)
+
+// RecoveryController maintains CloudWatch alarms with the ec2:recover action for master instances,
+// so that a master on a failed host is automatically recovered onto new hardware (keeping its
+// instance id, IPs and EBS volumes).  Alarms are deleted once their instance is terminated.
+type RecoveryController struct {
+	// Roles lists the instance roles which get recovery alarms
+	Roles []string
+
+	cloud *kopeaws.AWSCloud
+
+	// cache is the instance list shared with other controllers
+
+	cache *kopeaws.InstanceCache
+
+	*kope.PeriodicController
+}
+
+func NewRecoveryController(cloud *kopeaws.AWSCloud, cache *kopeaws.InstanceCache, period time.Duration) *RecoveryController {
+	c := &RecoveryController{
+		Roles: []string{kopeaws.RoleMaster},
+		cloud: cloud,
+		cache: cache,
+	}
+	c.PeriodicController = kope.NewPeriodicController("recovery alarm controller", period, c.runOnce)
+	return c
+}
+
+func (c *RecoveryController) runOnce(ctx context.Context) error {
+	instances, err := c.cache.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	alarms, err := c.cloud.ListRecoveryAlarms(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Terminated instances linger in DescribeInstances for a while, so only live instances need alarms
+	wanted := make(map[string]bool)
+	for _, i := range instances {
+		state := aws.StringValue(i.State.Name)
+		if state == ec2.InstanceStateNameTerminated || state == ec2.InstanceStateNameShuttingDown {
+			continue
+		}
+		if c.hasRecoverableRole(i) {
+			wanted[aws.StringValue(i.InstanceId)] = true
+		}
+	}
+
+	existing := make(map[string]bool)
+	for _, alarm := range alarms {
+		if wanted[alarm.InstanceID] {
+			existing[alarm.InstanceID] = true
+			continue
+		}
+		if err := c.cloud.DeleteRecoveryAlarm(ctx, alarm); err != nil {
+			runtime.HandleError(err)
+		}
+	}
+
+	for id := range wanted {
+		if existing[id] {
+			continue
+		}
+		if err := c.cloud.PutRecoveryAlarm(ctx, id); err != nil {
+			runtime.HandleError(err)
+		}
+	}
+
+	glog.V(2).Infof("Maintaining recovery alarms for %d instances", len(wanted))
+	return nil
+}
+
+func (c *RecoveryController) hasRecoverableRole(i *ec2.Instance) bool {
+	role := kopeaws.InstanceRole(i)
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package recycle
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"github.com/kopeio/aws-controller/pkg/kope/kubeutils"
+	"github.com/kopeio/aws-controller/pkg/kope/notify"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The draining tag value marking instances we are recycling; the tag persists our progress across restarts
+const drainingReason = "recycle"
+
+// RecycleController replaces instances older than a maximum age: it cordons and drains the
+// node, then terminates the instance (for its autoscaling group to replace).  The number of
+// concurrent recycles in each availability zone is limited.
+type RecycleController struct {
+	// MaxAge is the age beyond which instances are recycled
+	MaxAge time.Duration
+	// MaxConcurrentPerZone limits the instances being recycled at once in each availability zone
+	MaxConcurrentPerZone int
+	// Roles lists the instance roles which are recycled
+	Roles []string
+	// DrainOptions configures how nodes are drained
+	DrainOptions kubeutils.DrainOptions
+	// Notifier is told about each instance recycled
+	Notifier *notify.Notifier
+
+	cloud      kope.Cloud
+	kubernetes kubernetes.Interface
+
+	// mutex protects inFlight
+	mutex sync.Mutex
+	// inFlight holds the instances currently being recycled by this process
+	inFlight map[string]bool
+
+	*kope.PeriodicController
+}
+
+func NewRecycleController(cloud kope.Cloud, kubernetes kubernetes.Interface, maxAge time.Duration, period time.Duration) *RecycleController {
+	c := &RecycleController{
+		MaxAge:               maxAge,
+		MaxConcurrentPerZone: 1,
+		Roles:                []string{kopeaws.RoleNode},
+		DrainOptions: kubeutils.DrainOptions{
+			Timeout: 10 * time.Minute,
+		},
+		cloud:      cloud,
+		kubernetes: kubernetes,
+		inFlight:   make(map[string]bool),
+	}
+	c.PeriodicController = kope.NewPeriodicController("recycle controller", period, c.runOnce)
+	return c
+}
+
+func (c *RecycleController) Run() {
+	glog.Infof("recycling instances older than %v", c.MaxAge)
+	c.PeriodicController.Run()
+}
+
+func (c *RecycleController) runOnce(ctx context.Context) error {
+	instances, err := c.cloud.ListInstances(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Count the recycles already in progress in each zone, and resume any we aren't running
+	recycling := make(map[string]int)
+	var candidates []*kope.Instance
+	for _, i := range instances {
+		if reason, _ := i.Tag(kopeaws.TagNameDraining); reason == drainingReason {
+			if i.State == kope.InstanceStateRunning || i.State == kope.InstanceStateTerminating {
+				recycling[i.Zone]++
+			}
+			if i.State == kope.InstanceStateRunning {
+				c.startRecycle(ctx, i)
+			}
+			continue
+		}
+
+		if i.State != kope.InstanceStateRunning || !c.isRecyclable(i) {
+			continue
+		}
+		if time.Since(i.LaunchTime) > c.MaxAge {
+			candidates = append(candidates, i)
+		}
+	}
+
+	// Recycle the oldest first
+	sort.Slice(candidates, func(a, b int) bool {
+		return candidates[a].LaunchTime.Before(candidates[b].LaunchTime)
+	})
+
+	for _, i := range candidates {
+		if recycling[i.Zone] >= c.MaxConcurrentPerZone {
+			glog.V(2).Infof("Deferring recycle of instance %q; %d recycles already in progress in %s", i.ID, recycling[i.Zone], i.Zone)
+			continue
+		}
+
+		glog.Infof("Recycling instance %q, launched at %s", i.ID, i.LaunchTime)
+		if err := c.cloud.TagInstance(ctx, i.ID, map[string]string{kopeaws.TagNameDraining: drainingReason}); err != nil {
+			runtime.HandleError(err)
+			continue
+		}
+		recycling[i.Zone]++
+		c.startRecycle(ctx, i)
+	}
+
+	return nil
+}
+
+func (c *RecycleController) isRecyclable(i *kope.Instance) bool {
+	for _, r := range c.Roles {
+		if r == i.Role {
+			return true
+		}
+	}
+	return false
+}
+
+// startRecycle drains and terminates the instance in the background, unless that is already in progress
+func (c *RecycleController) startRecycle(ctx context.Context, i *kope.Instance) {
+	id := i.ID
+
+	c.mutex.Lock()
+	inFlight := c.inFlight[id]
+	c.inFlight[id] = true
+	c.mutex.Unlock()
+	if inFlight {
+		return
+	}
+
+	go func() {
+		defer func() {
+			c.mutex.Lock()
+			delete(c.inFlight, id)
+			c.mutex.Unlock()
+		}()
+
+		if err := c.recycle(ctx, i); err != nil {
+			// We will retry on the next run, because the instance is still tagged
+			runtime.HandleError(err)
+		}
+	}()
+}
+
+func (c *RecycleController) recycle(ctx context.Context, i *kope.Instance) error {
+	id := i.ID
+
+	nodeName, err := kubeutils.FindNodeForInstance(ctx, c.kubernetes, id, i.PrivateDNSName)
+	if err != nil {
+		return err
+	}
+
+	if nodeName == "" {
+		glog.Warningf("No node found for instance %q; terminating without draining", id)
+	} else {
+		if err := kubeutils.CordonNode(ctx, c.kubernetes, nodeName); err != nil {
+			return err
+		}
+		if err := kubeutils.DrainNode(ctx, c.kubernetes, nodeName, c.DrainOptions); err != nil {
+			return fmt.Errorf("error draining node %q for recycle: %v", nodeName, err)
+		}
+	}
+
+	if err := c.cloud.TerminateInstance(ctx, id); err != nil {
+		return err
+	}
+	c.Notifier.Notify(notify.ReasonInstanceRecycled, fmt.Sprintf("Recycled instance %s (node %q), launched at %s", id, nodeName, i.LaunchTime.Format(time.RFC3339)))
+	return nil
+}
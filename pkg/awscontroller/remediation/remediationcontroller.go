@@ -0,0 +1,258 @@
+package remediation
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"github.com/kopeio/aws-controller/pkg/kope/kubeutils"
+	"github.com/kopeio/aws-controller/pkg/kope/notify"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	"strings"
+	"time"
+)
+
+// The actions we can take against an instance failing its status checks
+const (
+	ActionReboot    = "reboot"
+	ActionTerminate = "terminate"
+)
+
+// The taint we apply to nodes whose instances have impaired system status
+const TaintKeyImpaired = "aws-controller.kope.io/impaired"
+
+// RemediationController watches the EC2 system and instance status checks of cluster instances,
+// and reboots or terminates instances whose checks have been failing for longer than a threshold.
+// When an instance is terminated its (now stale) Node object is also deleted.
+//
+// It can also taint the nodes of instances with impaired system status (i.e. a sick host), so
+// workloads stop being scheduled there even before Kubernetes notices a problem.
+type RemediationController struct {
+	// Action is the remediation to apply: ActionReboot or ActionTerminate, or "" to take no action
+	Action string
+	// TaintImpaired applies a NoSchedule taint to nodes whose instances have impaired system status,
+	// removing it on recovery
+	TaintImpaired bool
+	// FailureThreshold is how long status checks must be failing before we act
+	FailureThreshold time.Duration
+	// ReportOnly logs (and records node events for) the remediation we would apply, without applying it
+	ReportOnly bool
+	// Notifier is told about each remediation applied
+	Notifier *notify.Notifier
+
+	cloud *kopeaws.AWSCloud
+
+	// cache is the instance list shared with other controllers
+
+	cache      *kopeaws.InstanceCache
+	kubernetes kubernetes.Interface
+
+	// failingSince records when we first saw each instance failing its status checks
+	failingSince map[string]time.Time
+
+	*kope.PeriodicController
+}
+
+func NewRemediationController(cloud *kopeaws.AWSCloud, cache *kopeaws.InstanceCache, kubernetes kubernetes.Interface, action string, period time.Duration) (*RemediationController, error) {
+	if action != "" && action != ActionReboot && action != ActionTerminate {
+		return nil, fmt.Errorf("unknown remediation action %q (expected %q or %q)", action, ActionReboot, ActionTerminate)
+	}
+
+	c := &RemediationController{
+		Action:           action,
+		FailureThreshold: 10 * time.Minute,
+		cloud:            cloud,
+		cache:            cache,
+		kubernetes:       kubernetes,
+		failingSince:     make(map[string]time.Time),
+	}
+	c.PeriodicController = kope.NewPeriodicController("status check remediation controller", period, c.runOnce)
+	return c, nil
+}
+
+func (c *RemediationController) Run() {
+	glog.Infof("remediating failed status checks (action %q, taint %v, report-only %v)", c.Action, c.TaintImpaired, c.ReportOnly)
+	c.PeriodicController.Run()
+}
+
+func (c *RemediationController) runOnce(ctx context.Context) error {
+	instances, err := c.cache.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[string]*ec2.Instance)
+	var ids []string
+	for _, i := range instances {
+		if aws.StringValue(i.State.Name) != ec2.InstanceStateNameRunning {
+			continue
+		}
+		id := aws.StringValue(i.InstanceId)
+		byID[id] = i
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	statuses, err := c.cloud.DescribeInstanceStatus(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	failing := make(map[string]bool)
+	systemImpaired := make(map[string]bool)
+	for _, status := range statuses {
+		id := aws.StringValue(status.InstanceId)
+		failed := failedChecks(status)
+		if len(failed) == 0 || byID[id] == nil {
+			continue
+		}
+		failing[id] = true
+		if failed[0] == "system" {
+			systemImpaired[id] = true
+		}
+
+		if c.Action == "" {
+			continue
+		}
+
+		since, found := c.failingSince[id]
+		if !found {
+			glog.Warningf("Instance %q is failing status checks: %s", id, strings.Join(failed, ","))
+			c.failingSince[id] = now
+			continue
+		}
+		if now.Sub(since) < c.FailureThreshold {
+			continue
+		}
+
+		if err := c.remediate(ctx, byID[id], failed, since); err != nil {
+			runtime.HandleError(err)
+			continue
+		}
+		// Give the remediation a full threshold to take effect before acting again
+		c.failingSince[id] = now
+	}
+
+	for id := range c.failingSince {
+		if !failing[id] {
+			glog.Infof("Instance %q is no longer failing status checks", id)
+			delete(c.failingSince, id)
+		}
+	}
+
+	if c.TaintImpaired {
+		if err := c.syncTaints(ctx, byID, systemImpaired); err != nil {
+			return err
+		}
+	}
+
+	glog.V(2).Infof("Found %d instances failing status checks", len(failing))
+	return nil
+}
+
+// syncTaints taints the nodes of instances with impaired system status, and untaints the others
+func (c *RemediationController) syncTaints(ctx context.Context, byID map[string]*ec2.Instance, systemImpaired map[string]bool) error {
+	nodes, err := c.kubernetes.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing nodes: %v", err)
+	}
+
+	taint := v1.Taint{
+		Key:    TaintKeyImpaired,
+		Value:  "system",
+		Effect: v1.TaintEffectNoSchedule,
+	}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		id := kubeutils.InstanceIDFromProviderID(node.Spec.ProviderID)
+		if byID[id] == nil {
+			continue
+		}
+
+		impaired := systemImpaired[id]
+		if kubeutils.HasNodeTaint(node, taint.Key, taint.Effect) == impaired {
+			continue
+		}
+		if impaired && c.ReportOnly {
+			glog.Warningf("Would taint node %q of impaired instance %q (report-only)", node.Name, id)
+			continue
+		}
+		if err := kubeutils.SetNodeTaint(ctx, c.kubernetes, node.Name, taint, impaired); err != nil {
+			runtime.HandleError(err)
+		}
+	}
+	return nil
+}
+
+// failedChecks returns the names of the status checks which are impaired
+func failedChecks(status *ec2.InstanceStatus) []string {
+	var failed []string
+	if status.SystemStatus != nil && aws.StringValue(status.SystemStatus.Status) == ec2.SummaryStatusImpaired {
+		failed = append(failed, "system")
+	}
+	if status.InstanceStatus != nil && aws.StringValue(status.InstanceStatus.Status) == ec2.SummaryStatusImpaired {
+		failed = append(failed, "instance")
+	}
+	return failed
+}
+
+func (c *RemediationController) remediate(ctx context.Context, instance *ec2.Instance, failed []string, since time.Time) error {
+	id := aws.StringValue(instance.InstanceId)
+
+	nodeName, err := kubeutils.FindNodeForInstance(ctx, c.kubernetes, id, aws.StringValue(instance.PrivateDnsName))
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Instance %s has been failing %s status checks since %s", id, strings.Join(failed, ","), since.Format(time.RFC3339))
+	if c.ReportOnly {
+		glog.Warningf("%s; would %s it (report-only)", message, c.Action)
+	} else {
+		glog.Warningf("%s; applying %s", message, c.Action)
+	}
+
+	if nodeName != "" {
+		reason := "StatusCheckRemediation"
+		if c.ReportOnly {
+			reason = "StatusCheckRemediationSkipped"
+		}
+		if err := kubeutils.RecordNodeEvent(ctx, c.kubernetes, nodeName, v1.EventTypeWarning, reason, message+"; action "+c.Action); err != nil {
+			return err
+		}
+	}
+
+	if c.ReportOnly {
+		return nil
+	}
+
+	switch c.Action {
+	case ActionReboot:
+		if err := c.cloud.RebootInstance(ctx, id); err != nil {
+			return err
+		}
+		c.Notifier.Notify(notify.ReasonInstanceRemediated, message+"; rebooted it")
+		return nil
+
+	case ActionTerminate:
+		if err := c.cloud.TerminateInstance(ctx, id); err != nil {
+			return err
+		}
+		c.Notifier.Notify(notify.ReasonInstanceRemediated, message+"; terminated it")
+		if nodeName != "" {
+			return kubeutils.DeleteNode(ctx, c.kubernetes, nodeName)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown remediation action %q", c.Action)
+	}
+}
@@ -0,0 +1,199 @@
+package secondaryips
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"github.com/kopeio/aws-controller/pkg/kope/kubeutils"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Annotations on nodes overriding the capacity targets
+const (
+	// AnnotationNetworkInterfaces is the number of network interfaces (including the primary) the instance should have
+	AnnotationNetworkInterfaces = "aws-controller.kope.io/network-interfaces"
+	// AnnotationSecondaryIPs is the number of secondary private IPs the instance should have, across all its interfaces
+	AnnotationSecondaryIPs = "aws-controller.kope.io/secondary-ips"
+)
+
+// SecondaryIPController pre-provisions network interfaces and secondary private IPs on the
+// instances of nodes, for CNI plugins which allocate pod addresses from them.  Interfaces are
+// added as needed to hold the secondary IPs, up to the limits of the instance type.
+type SecondaryIPController struct {
+	// NetworkInterfaces is the default number of network interfaces per instance (including the primary)
+	NetworkInterfaces int64
+	// SecondaryIPs is the default number of secondary private IPs per instance
+	SecondaryIPs int64
+
+	cloud *kopeaws.AWSCloud
+
+	// cache is the instance list shared with other controllers
+
+	cache      *kopeaws.InstanceCache
+	kubernetes kubernetes.Interface
+
+	*kope.PeriodicController
+}
+
+func NewSecondaryIPController(cloud *kopeaws.AWSCloud, cache *kopeaws.InstanceCache, kubernetes kubernetes.Interface, period time.Duration) *SecondaryIPController {
+	c := &SecondaryIPController{
+		NetworkInterfaces: 1,
+		cloud:             cloud,
+		cache:             cache,
+		kubernetes:        kubernetes,
+	}
+	c.PeriodicController = kope.NewPeriodicController("secondary IP controller", period, c.runOnce)
+	return c
+}
+
+func (c *SecondaryIPController) runOnce(ctx context.Context) error {
+	instances, err := c.cache.List(ctx)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]*ec2.Instance)
+	for _, i := range instances {
+		byID[aws.StringValue(i.InstanceId)] = i
+	}
+
+	nodes, err := c.kubernetes.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing nodes: %v", err)
+	}
+
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		instance := byID[kubeutils.InstanceIDFromProviderID(node.Spec.ProviderID)]
+		if instance == nil || aws.StringValue(instance.State.Name) != ec2.InstanceStateNameRunning {
+			continue
+		}
+		if err := c.syncInstance(ctx, node, instance); err != nil {
+			runtime.HandleError(err)
+		}
+	}
+	return nil
+}
+
+// targets returns the desired number of interfaces and secondary IPs for the node
+func (c *SecondaryIPController) targets(node *v1.Node) (int64, int64, error) {
+	interfaces := c.NetworkInterfaces
+	secondaryIPs := c.SecondaryIPs
+	if s, found := node.Annotations[AnnotationNetworkInterfaces]; found {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("node %q has invalid %s annotation %q", node.Name, AnnotationNetworkInterfaces, s)
+		}
+		interfaces = n
+	}
+	if s, found := node.Annotations[AnnotationSecondaryIPs]; found {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("node %q has invalid %s annotation %q", node.Name, AnnotationSecondaryIPs, s)
+		}
+		secondaryIPs = n
+	}
+	return interfaces, secondaryIPs, nil
+}
+
+func (c *SecondaryIPController) syncInstance(ctx context.Context, node *v1.Node, instance *ec2.Instance) error {
+	id := aws.StringValue(instance.InstanceId)
+
+	wantInterfaces, wantIPs, err := c.targets(node)
+	if err != nil {
+		return err
+	}
+
+	limits, err := c.cloud.InstanceNetworkLimits(ctx, instance)
+	if err != nil {
+		return err
+	}
+	perInterface := limits.IPv4PerInterface - 1
+
+	// Add interfaces if the existing ones can't hold the secondary IPs
+	if perInterface > 0 {
+		needed := (wantIPs + perInterface - 1) / perInterface
+		if needed > wantInterfaces {
+			wantInterfaces = needed
+		}
+	}
+	if wantInterfaces > limits.MaxInterfaces {
+		glog.Warningf("Instance %q (%s) supports at most %d network interfaces; wanted %d", id, aws.StringValue(instance.InstanceType), limits.MaxInterfaces, wantInterfaces)
+		wantInterfaces = limits.MaxInterfaces
+	}
+
+	var interfaces []*ec2.InstanceNetworkInterface
+	for _, eni := range instance.NetworkInterfaces {
+		if eni.Attachment != nil {
+			interfaces = append(interfaces, eni)
+		}
+	}
+	if len(interfaces) == 0 {
+		return fmt.Errorf("instance %q has no attached network interfaces", id)
+	}
+	sort.Slice(interfaces, func(i, j int) bool {
+		return aws.Int64Value(interfaces[i].Attachment.DeviceIndex) < aws.Int64Value(interfaces[j].Attachment.DeviceIndex)
+	})
+
+	if int64(len(interfaces)) < wantInterfaces {
+		deviceIndex := aws.Int64Value(interfaces[len(interfaces)-1].Attachment.DeviceIndex) + 1
+		if _, err := c.cloud.AttachSecondaryNetworkInterface(ctx, instance, deviceIndex); err != nil {
+			return err
+		}
+		// Add one interface per sync; IPs are assigned once the instance reports it
+		return nil
+	}
+
+	if int64(len(interfaces)) > wantInterfaces {
+		// Only remove interfaces we attached, starting with the highest device index, and only once they
+		// have no secondary IPs, which may be in use by pods
+		last := interfaces[len(interfaces)-1]
+		if secondaryIPs := len(last.PrivateIpAddresses) - 1; secondaryIPs > 0 {
+			glog.V(2).Infof("Not detaching network interface %q from %q: it still has %d secondary IPs", aws.StringValue(last.NetworkInterfaceId), id, secondaryIPs)
+		} else {
+			managed, err := c.cloud.ListSecondaryNetworkInterfaces(ctx, id)
+			if err != nil {
+				return err
+			}
+			for _, eniID := range managed {
+				if eniID == aws.StringValue(last.NetworkInterfaceId) {
+					return c.cloud.DetachSecondaryNetworkInterface(ctx, id, last)
+				}
+			}
+		}
+	}
+
+	// Secondary IPs are only ever added: we can't know which are in use by pods, so
+	// releasing them is left to the CNI plugin
+	var haveIPs int64
+	for _, eni := range interfaces {
+		haveIPs += int64(len(eni.PrivateIpAddresses)) - 1
+	}
+	missing := wantIPs - haveIPs
+	for _, eni := range interfaces {
+		if missing <= 0 {
+			break
+		}
+		free := perInterface - (int64(len(eni.PrivateIpAddresses)) - 1)
+		if free <= 0 {
+			continue
+		}
+		if free > missing {
+			free = missing
+		}
+		if err := c.cloud.AssignSecondaryPrivateIPs(ctx, id, aws.StringValue(eni.NetworkInterfaceId), free); err != nil {
+			return err
+		}
+		missing -= free
+	}
+	return nil
+}
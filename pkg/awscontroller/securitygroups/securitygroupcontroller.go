@@ -0,0 +1,217 @@
+package securitygroups
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/apis/awscontroller/v1alpha1"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SecurityGroupController converges the ingress rules of the cluster's security groups to a
+// declared rule set, adding missing rules and (optionally) pruning undeclared ones.  Only the
+// groups which have declared rules are managed.
+type SecurityGroupController struct {
+	// NodeSecurityGroup is the id or name of the node security group; if set, its self-referencing
+	// all-traffic rule (needed for pod traffic between nodes) is always enforced, even in dry-run
+	NodeSecurityGroup string
+
+	cloud *kopeaws.AWSCloud
+
+	// mutex protects spec
+	mutex sync.Mutex
+	spec  *v1alpha1.SecurityGroupsSpec
+
+	*kope.PeriodicController
+}
+
+func NewSecurityGroupController(cloud *kopeaws.AWSCloud, period time.Duration) *SecurityGroupController {
+	c := &SecurityGroupController{
+		cloud: cloud,
+		spec:  &v1alpha1.SecurityGroupsSpec{},
+	}
+	c.PeriodicController = kope.NewPeriodicController("security group controller", period, c.runOnce)
+	return c
+}
+
+// ValidateSpec returns an error if any of the declared rules is invalid
+func ValidateSpec(spec *v1alpha1.SecurityGroupsSpec) error {
+	for i := range spec.Rules {
+		r := &spec.Rules[i]
+		if r.Group == "" {
+			return fmt.Errorf("security group rule %d has no group", i)
+		}
+		if (r.SourceGroup == "") == (r.CIDR == "") {
+			return fmt.Errorf("security group rule %d (for %q) must have exactly one of sourceGroup and cidr", i, r.Group)
+		}
+	}
+	return nil
+}
+
+// SetSpec replaces the declared rules, taking effect on the next sync
+func (c *SecurityGroupController) SetSpec(spec *v1alpha1.SecurityGroupsSpec) error {
+	if err := ValidateSpec(spec); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.spec = spec
+	return nil
+}
+
+func (c *SecurityGroupController) getSpec() *v1alpha1.SecurityGroupsSpec {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.spec
+}
+
+func (c *SecurityGroupController) runOnce(ctx context.Context) error {
+	spec := c.getSpec()
+	if len(spec.Rules) == 0 && c.NodeSecurityGroup == "" {
+		return nil
+	}
+
+	// Resolve group names, caching within this sync
+	ids := make(map[string]string)
+	resolve := func(ref string) (string, error) {
+		if id, found := ids[ref]; found {
+			return id, nil
+		}
+		id, err := c.cloud.ResolveSecurityGroup(ctx, ref)
+		if err != nil {
+			return "", err
+		}
+		ids[ref] = id
+		return id, nil
+	}
+
+	desired := make(map[string][]*kopeaws.SecurityGroupRule)
+	for _, r := range spec.Rules {
+		groupID, err := resolve(r.Group)
+		if err != nil {
+			return err
+		}
+		rule := &kopeaws.SecurityGroupRule{
+			GroupID:     groupID,
+			Protocol:    kopeaws.NormalizeProtocol(r.Protocol),
+			FromPort:    r.FromPort,
+			ToPort:      r.ToPort,
+			CIDR:        r.CIDR,
+			Description: r.Description,
+		}
+		if r.SourceGroup != "" {
+			rule.SourceGroupID, err = resolve(r.SourceGroup)
+			if err != nil {
+				return err
+			}
+		}
+		desired[groupID] = append(desired[groupID], rule)
+	}
+
+	if c.NodeSecurityGroup != "" {
+		rule, err := c.ensureNodeSelfRule(ctx, resolve)
+		if err != nil {
+			runtime.HandleError(err)
+		} else if desired[rule.GroupID] != nil {
+			// Protect the rule from pruning if the node group also has declared rules
+			desired[rule.GroupID] = append(desired[rule.GroupID], rule)
+		}
+	}
+
+	var groups []string
+	for groupID := range desired {
+		groups = append(groups, groupID)
+	}
+	sort.Strings(groups)
+
+	for _, groupID := range groups {
+		if err := c.syncGroup(ctx, groupID, desired[groupID], spec); err != nil {
+			runtime.HandleError(err)
+		}
+	}
+	return nil
+}
+
+func (c *SecurityGroupController) syncGroup(ctx context.Context, groupID string, desired []*kopeaws.SecurityGroupRule, spec *v1alpha1.SecurityGroupsSpec) error {
+	actual, err := c.cloud.ListIngressRules(ctx, groupID)
+	if err != nil {
+		return err
+	}
+
+	actualKeys := make(map[string]bool)
+	for _, r := range actual {
+		actualKeys[r.Key()] = true
+	}
+	desiredKeys := make(map[string]bool)
+	for _, r := range desired {
+		desiredKeys[r.Key()] = true
+	}
+
+	for _, r := range desired {
+		if actualKeys[r.Key()] {
+			continue
+		}
+		actualKeys[r.Key()] = true
+		if spec.DryRun {
+			glog.Infof("Would authorize ingress %s (dry-run)", r)
+			continue
+		}
+		if err := c.cloud.AuthorizeIngress(ctx, r); err != nil {
+			return err
+		}
+	}
+
+	if !spec.Prune {
+		return nil
+	}
+	for _, r := range actual {
+		if desiredKeys[r.Key()] {
+			continue
+		}
+		if spec.DryRun {
+			glog.Infof("Would revoke ingress %s (dry-run)", r)
+			continue
+		}
+		if err := c.cloud.RevokeIngress(ctx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureNodeSelfRule re-adds the node group's self-referencing rule if it has been removed
+func (c *SecurityGroupController) ensureNodeSelfRule(ctx context.Context, resolve func(string) (string, error)) (*kopeaws.SecurityGroupRule, error) {
+	groupID, err := resolve(c.NodeSecurityGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := &kopeaws.SecurityGroupRule{
+		GroupID:       groupID,
+		Protocol:      kopeaws.NormalizeProtocol("all"),
+		SourceGroupID: groupID,
+		Description:   "node to node traffic",
+	}
+
+	actual, err := c.cloud.ListIngressRules(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range actual {
+		if r.Key() == rule.Key() {
+			return rule, nil
+		}
+	}
+
+	glog.Warningf("Self-referencing rule missing from node security group %q; re-adding", groupID)
+	if err := c.cloud.AuthorizeIngress(ctx, rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
@@ -0,0 +1,213 @@
+package selftest
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Addresses from TEST-NET-1 (RFC 5737), which are never routable
+const (
+	testAddress1 = "192.0.2.1"
+	testAddress2 = "192.0.2.2"
+)
+
+// SelfTest exercises the AWS code paths used by the controllers against sandbox resources,
+// validating credentials, permissions and connectivity before the controller is trusted with
+// production resources.  Every mutation it makes is reverted.
+type SelfTest struct {
+	Cloud *kopeaws.AWSCloud
+
+	// SandboxTag is the tag marking instances whose attributes may be modified by the test;
+	// if no cluster instance carries the tag, the attribute test is skipped
+	SandboxTag string
+
+	// DNS is the provider for the zone to test; if nil the DNS test is skipped
+	DNS *kopeaws.Route53DNSProvider
+	// DNSPrefix is the name of the test record to create, relative to the zone
+	DNSPrefix string
+}
+
+// Run runs each test in turn, returning an error if any failed
+func (t *SelfTest) Run(ctx context.Context) error {
+	var failed []string
+
+	tests := []struct {
+		name string
+		f    func(ctx context.Context) error
+	}{
+		{"describe-instances", t.testDescribeInstances},
+		{"source-dest-check", t.testSourceDestCheck},
+		{"dns", t.testDNS},
+	}
+	for _, test := range tests {
+		glog.Infof("selftest %s: starting", test.name)
+		if err := test.f(ctx); err != nil {
+			glog.Errorf("selftest %s: FAILED: %v", test.name, err)
+			failed = append(failed, test.name)
+		} else {
+			glog.Infof("selftest %s: passed", test.name)
+		}
+	}
+
+	if len(failed) != 0 {
+		return fmt.Errorf("selftests failed: %s", strings.Join(failed, ","))
+	}
+	return nil
+}
+
+func (t *SelfTest) testDescribeInstances(ctx context.Context) error {
+	instances, err := t.Cloud.DescribeInstances(ctx)
+	if err != nil {
+		return err
+	}
+	if len(instances) == 0 {
+		return fmt.Errorf("no instances found for cluster %q", t.Cloud.ClusterID())
+	}
+	glog.Infof("Found %d instances for cluster %q", len(instances), t.Cloud.ClusterID())
+	return nil
+}
+
+// testSourceDestCheck toggles SourceDestCheck on a sandbox instance, verifies it, and restores it
+func (t *SelfTest) testSourceDestCheck(ctx context.Context) error {
+	if t.SandboxTag == "" {
+		glog.Infof("No sandbox tag configured; skipping")
+		return nil
+	}
+
+	all, err := t.Cloud.DescribeInstances(ctx)
+	if err != nil {
+		return err
+	}
+
+	var sandbox *ec2.Instance
+	for _, i := range all {
+		if _, found := kopeaws.FindTag(i, t.SandboxTag); found && aws.StringValue(i.State.Name) == "running" {
+			sandbox = i
+			break
+		}
+	}
+	if sandbox == nil {
+		glog.Warningf("No running instance found with sandbox tag %q; skipping", t.SandboxTag)
+		return nil
+	}
+
+	id := aws.StringValue(sandbox.InstanceId)
+	original := aws.BoolValue(sandbox.SourceDestCheck)
+
+	glog.Infof("Toggling SourceDestCheck on sandbox instance %q", id)
+	if err := t.Cloud.ConfigureInstanceSourceDestCheck(ctx, id, !original); err != nil {
+		return err
+	}
+
+	verifyErr := t.verifySourceDestCheck(ctx, id, !original)
+
+	if err := t.Cloud.ConfigureInstanceSourceDestCheck(ctx, id, original); err != nil {
+		return fmt.Errorf("error restoring SourceDestCheck on %q to %v: %v", id, original, err)
+	}
+	if verifyErr != nil {
+		return verifyErr
+	}
+	return t.verifySourceDestCheck(ctx, id, original)
+}
+
+func (t *SelfTest) verifySourceDestCheck(ctx context.Context, id string, expected bool) error {
+	i, err := t.Cloud.DescribeInstance(ctx, id)
+	if err != nil {
+		return err
+	}
+	if actual := aws.BoolValue(i.SourceDestCheck); actual != expected {
+		return fmt.Errorf("SourceDestCheck on %q was %v, expected %v", id, actual, expected)
+	}
+	return nil
+}
+
+// testDNS creates, mutates, verifies, and deletes a test record
+func (t *SelfTest) testDNS(ctx context.Context) error {
+	if t.DNS == nil {
+		glog.Infof("No DNS zone configured; skipping")
+		return nil
+	}
+
+	zoneName, err := t.DNS.ZoneName(ctx)
+	if err != nil {
+		return err
+	}
+
+	name := kope.ARecord(t.DNSPrefix + "." + zoneName)
+	existing, err := t.DNS.ListRecords(ctx, name)
+	if err != nil {
+		return err
+	}
+	if len(existing) != 0 {
+		return fmt.Errorf("test record %q already exists (%v); refusing to overwrite it", name, existing)
+	}
+
+	applied := []string{testAddress1}
+	err = t.DNS.ApplyDNSChanges(ctx, map[kope.DNSRecordKey][]string{name: applied})
+	if err == nil {
+		err = t.verifyRecord(ctx, name, applied)
+	}
+	if err == nil {
+		applied = []string{testAddress1, testAddress2}
+		err = t.DNS.ApplyDNSChanges(ctx, map[kope.DNSRecordKey][]string{name: applied})
+		if err == nil {
+			err = t.verifyRecord(ctx, name, applied)
+		}
+	}
+
+	// Always clean up whatever we managed to create
+	values, listErr := t.DNS.ListRecords(ctx, name)
+	if listErr != nil {
+		return fmt.Errorf("error listing test record %q for cleanup: %v", name, listErr)
+	}
+	if len(values) != 0 {
+		glog.Infof("Deleting test record %q", name)
+		if deleteErr := t.DNS.DeleteRecords(ctx, map[kope.DNSRecordKey][]string{name: values}); deleteErr != nil {
+			return fmt.Errorf("error deleting test record %q: %v", name, deleteErr)
+		}
+	}
+
+	return err
+}
+
+func (t *SelfTest) verifyRecord(ctx context.Context, name kope.DNSRecordKey, expected []string) error {
+	// The Route53 API is consistent once a change is accepted, but retry briefly anyway
+	var actual []string
+	for attempt := 0; attempt < 5; attempt++ {
+		var err error
+		actual, err = t.DNS.ListRecords(ctx, name)
+		if err != nil {
+			return err
+		}
+		if sameValues(actual, expected) {
+			return nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+	return fmt.Errorf("record %q had values %v, expected %v", name, actual, expected)
+}
+
+// sameValues returns true if l and r contain the same values, ignoring order
+func sameValues(l, r []string) bool {
+	if len(l) != len(r) {
+		return false
+	}
+	l = append([]string(nil), l...)
+	r = append([]string(nil), r...)
+	sort.Strings(l)
+	sort.Strings(r)
+	for i := range l {
+		if l[i] != r[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,360 @@
+package servicedns
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/awscontroller/instances"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AnnotationHostname is set on a Service to the comma-separated DNS names to publish for it
+const AnnotationHostname = "aws-controller.kope.io/hostname"
+
+// ServiceDNSController publishes DNS records for Services annotated with AnnotationHostname.  A
+// LoadBalancer Service is published as a CNAME of its load balancer's hostname (or A records of its
+// addresses); a NodePort Service is published as A records of the addresses of the ready nodes.
+//
+// Services and nodes are watched, so records follow their changes.  If the DNS provider records ownership,
+// the records of a Service are deleted when it (or its annotation) is removed, even across restarts.
+type ServiceDNSController struct {
+	// InternalNodeAddresses publishes the internal addresses of the nodes for NodePort Services, rather
+	// than their external addresses
+	InternalNodeAddresses bool
+
+	kubernetes kubernetes.Interface
+	dns        kope.DNSProvider
+	period     time.Duration
+
+	// services and nodes are populated by the informers started by Run
+	services corelisters.ServiceLister
+	nodes    corelisters.NodeLister
+	// changed is signalled when a service or node changes
+	changed chan struct{}
+
+	// published holds the records we last published
+	published map[kope.DNSRecordKey][]string
+	// listedOwned is set once we have added the records we own to published, so that records of services
+	// removed while we were not running are deleted
+	listedOwned bool
+
+	// health records the results of our resyncs
+	health kope.SyncHealth
+
+	// shutdownTimeout is how long Stop waits for the in-flight sync to finish, before aborting it
+	shutdownTimeout time.Duration
+	// running tracks the sync loop, which Stop waits for
+	running sync.WaitGroup
+
+	// stopLock protects shutdown, so that stopCh is only closed once and the sync loop is not started
+	// once Stop has started waiting for running
+	stopLock sync.Mutex
+	shutdown bool
+	stopCh   chan struct{}
+
+	// ctx is cancelled by Stop once the in-flight sync has finished or shutdownTimeout has passed,
+	// aborting any calls still in flight
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewServiceDNSController(kubernetes kubernetes.Interface, dns kope.DNSProvider, period time.Duration) *ServiceDNSController {
+	c := &ServiceDNSController{
+		kubernetes: kubernetes,
+		dns:        dns,
+		period:     period,
+		published:  make(map[kope.DNSRecordKey][]string),
+		changed:    make(chan struct{}, 1),
+		stopCh:     make(chan struct{}),
+
+		shutdownTimeout: kope.DefaultShutdownTimeout,
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+func (c *ServiceDNSController) Run() {
+	glog.Infof("starting service DNS controller")
+
+	factory := informers.NewSharedInformerFactory(c.kubernetes, 0)
+	services := factory.Core().V1().Services()
+	nodes := factory.Core().V1().Nodes()
+	c.services = services.Lister()
+	c.nodes = nodes.Lister()
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.queueSync()
+		},
+		UpdateFunc: func(old, obj interface{}) {
+			c.queueSync()
+		},
+		DeleteFunc: func(obj interface{}) {
+			c.queueSync()
+		},
+	}
+	for _, informer := range []cache.SharedIndexInformer{services.Informer(), nodes.Informer()} {
+		if _, err := informer.AddEventHandler(handler); err != nil {
+			runtime.HandleError(fmt.Errorf("error watching services: %v", err))
+			return
+		}
+	}
+	factory.Start(c.stopCh)
+
+	if cache.WaitForCacheSync(c.stopCh, services.Informer().HasSynced, nodes.Informer().HasSynced) {
+		c.stopLock.Lock()
+		if !c.shutdown {
+			c.running.Add(1)
+			go func() {
+				defer c.running.Done()
+				c.syncLoop()
+			}()
+		}
+		c.stopLock.Unlock()
+	}
+
+	<-c.stopCh
+	glog.Infof("shutting down service DNS controller")
+	c.running.Wait()
+}
+
+// Stop stops the service DNS controller.  No new syncs are started, and Stop waits (for up to the shutdown
+// timeout) for the one in flight to finish, so that we don't stop in the middle of publishing records.
+func (c *ServiceDNSController) Stop() error {
+	c.stopLock.Lock()
+	defer c.stopLock.Unlock()
+
+	if c.shutdown {
+		return fmt.Errorf("shutdown already in progress")
+	}
+	close(c.stopCh)
+	c.shutdown = true
+	defer c.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		c.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(c.shutdownTimeout):
+		return fmt.Errorf("in-flight sync did not finish within %s; aborting it", c.shutdownTimeout)
+	}
+}
+
+// SetShutdownTimeout sets how long Stop waits for the in-flight sync to finish; it must be called before Run
+func (c *ServiceDNSController) SetShutdownTimeout(timeout time.Duration) {
+	c.shutdownTimeout = timeout
+}
+
+// SyncStatus returns the results of our resyncs
+func (c *ServiceDNSController) SyncStatus() *kope.SyncStatus {
+	return c.health.Status()
+}
+
+// queueSync requests a sync, if one is not already pending
+func (c *ServiceDNSController) queueSync() {
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// syncLoop syncs whenever a service or node changes, and every period (retrying failures), until stopped
+func (c *ServiceDNSController) syncLoop() {
+	ticker := time.NewTicker(c.period)
+	defer ticker.Stop()
+
+	for {
+		err := c.runOnce(c.ctx)
+		c.health.Record(err)
+		if err != nil {
+			runtime.HandleError(err)
+		}
+
+		select {
+		case <-c.changed:
+		case <-ticker.C:
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+func (c *ServiceDNSController) runOnce(ctx context.Context) error {
+	owned, isOwned := c.dns.(kope.OwnedDNSProvider)
+	if isOwned && !c.listedOwned {
+		records, err := owned.ListOwnedDNSRecords(ctx)
+		if err != nil {
+			return fmt.Errorf("error listing service DNS records: %v", err)
+		}
+		for k, v := range records {
+			c.published[k] = v
+		}
+		c.listedOwned = true
+	}
+
+	services, err := c.services.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("error listing services: %v", err)
+	}
+
+	// nodeAddresses is only listed if there are NodePort services
+	var nodeAddresses []string
+
+	records := make(map[kope.DNSRecordKey][]string)
+	for _, service := range services {
+		names := hostnames(service)
+		if len(names) == 0 {
+			continue
+		}
+
+		var key func(name string) kope.DNSRecordKey
+		var values []string
+		switch service.Spec.Type {
+		case v1.ServiceTypeLoadBalancer:
+			key, values = loadBalancerRecords(service)
+		case v1.ServiceTypeNodePort:
+			if nodeAddresses == nil {
+				nodeAddresses, err = c.listNodeAddresses()
+				if err != nil {
+					return err
+				}
+			}
+			key, values = kope.ARecord, nodeAddresses
+		default:
+			glog.Warningf("Ignoring %s annotation on service %s/%s of type %s", AnnotationHostname, service.Namespace, service.Name, service.Spec.Type)
+			continue
+		}
+		if len(values) == 0 {
+			glog.V(2).Infof("Service %s/%s has no addresses yet", service.Namespace, service.Name)
+			continue
+		}
+
+		for _, name := range names {
+			k := key(name)
+			if existing, found := records[k]; found && !instances.StringSlicesEqual(existing, values) {
+				glog.Warningf("DNS name %q is claimed by multiple services; ignoring service %s/%s", name, service.Namespace, service.Name)
+				continue
+			}
+			records[k] = values
+		}
+	}
+
+	changes := make(map[kope.DNSRecordKey][]string)
+	for k, v := range records {
+		if !instances.StringSlicesEqual(c.published[k], v) {
+			glog.V(2).Infof("DNS change %s: %v -> %v", k, c.published[k], v)
+			changes[k] = v
+		}
+	}
+	if len(changes) != 0 {
+		if err := c.dns.ApplyDNSChanges(ctx, changes); err != nil {
+			return fmt.Errorf("error applying service DNS changes: %v", err)
+		}
+		for k, v := range changes {
+			c.published[k] = v
+		}
+	}
+
+	var removed []kope.DNSRecordKey
+	for k := range c.published {
+		if _, found := records[k]; !found {
+			removed = append(removed, k)
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	if !isOwned {
+		glog.Warningf("Not removing DNS records %v: the DNS provider does not record ownership", removed)
+	} else if err := owned.DeleteDNSRecords(ctx, removed); err != nil {
+		return fmt.Errorf("error removing service DNS records: %v", err)
+	}
+	for _, k := range removed {
+		delete(c.published, k)
+	}
+	return nil
+}
+
+// hostnames returns the DNS names in the service's hostname annotation
+func hostnames(service *v1.Service) []string {
+	var names []string
+	for _, name := range strings.Split(service.Annotations[AnnotationHostname], ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// loadBalancerRecords returns the record type and values for a LoadBalancer service: a CNAME of the load
+// balancer's hostname (as for an ELB), else A records of its addresses
+func loadBalancerRecords(service *v1.Service) (func(name string) kope.DNSRecordKey, []string) {
+	var ips []string
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if ingress.Hostname != "" {
+			return cnameRecord, []string{ingress.Hostname}
+		}
+		if ingress.IP != "" {
+			ips = append(ips, ingress.IP)
+		}
+	}
+	sort.Strings(ips)
+	return kope.ARecord, ips
+}
+
+func cnameRecord(name string) kope.DNSRecordKey {
+	return kope.DNSRecordKey{Name: name, Type: kope.DNSTypeCNAME}
+}
+
+// listNodeAddresses returns the sorted addresses of the ready, schedulable nodes
+func (c *ServiceDNSController) listNodeAddresses() ([]string, error) {
+	nodes, err := c.nodes.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error listing nodes: %v", err)
+	}
+
+	addressType := v1.NodeExternalIP
+	if c.InternalNodeAddresses {
+		addressType = v1.NodeInternalIP
+	}
+
+	addresses := []string{}
+	for _, node := range nodes {
+		if node.Spec.Unschedulable || !isReady(node) {
+			continue
+		}
+		for _, address := range node.Status.Addresses {
+			if address.Type == addressType && !strings.Contains(address.Address, ":") {
+				addresses = append(addresses, address.Address)
+			}
+		}
+	}
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+func isReady(node *v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
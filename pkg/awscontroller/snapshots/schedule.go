@@ -0,0 +1,34 @@
+package snapshots
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// minimumInterval guards against schedules which would snapshot on every sync
+const minimumInterval = time.Hour
+
+var namedSchedules = map[string]time.Duration{
+	"hourly": time.Hour,
+	"daily":  24 * time.Hour,
+	"weekly": 7 * 24 * time.Hour,
+}
+
+// ParseSchedule parses the value of the schedule tag: either one of hourly, daily or weekly, or a
+// duration (e.g. 6h) between snapshots
+func ParseSchedule(s string) (time.Duration, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	if interval, found := namedSchedules[s]; found {
+		return interval, nil
+	}
+
+	interval, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid snapshot schedule %q: expected hourly, daily, weekly or a duration", s)
+	}
+	if interval < minimumInterval {
+		return 0, fmt.Errorf("invalid snapshot schedule %q: must be at least %v", s, minimumInterval)
+	}
+	return interval, nil
+}
@@ -0,0 +1,114 @@
+package snapshots
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// TagNameSchedule marks volumes to be snapshotted; the value is parsed by ParseSchedule
+	TagNameSchedule = "k8s.io/snapshot/schedule"
+	// TagNameRetain overrides the number of snapshots retained for a volume
+	TagNameRetain = "k8s.io/snapshot/retain"
+)
+
+// SnapshotController snapshots the cluster's volumes according to the schedule in their tags,
+// and deletes all but the most recent snapshots of each volume.
+type SnapshotController struct {
+	// Retain is the number of snapshots kept for each volume, unless overridden by a tag
+	Retain int
+
+	cloud *kopeaws.AWSCloud
+
+	*kope.PeriodicController
+}
+
+func NewSnapshotController(cloud *kopeaws.AWSCloud, period time.Duration) *SnapshotController {
+	c := &SnapshotController{
+		Retain: 7,
+		cloud:  cloud,
+	}
+	c.PeriodicController = kope.NewPeriodicController("snapshot controller", period, c.runOnce)
+	return c
+}
+
+func (c *SnapshotController) runOnce(ctx context.Context) error {
+	volumes, err := c.cloud.ListVolumes(ctx)
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := c.cloud.ListScheduledSnapshots(ctx)
+	if err != nil {
+		return err
+	}
+	byVolume := make(map[string][]*kopeaws.Snapshot)
+	for _, s := range snapshots {
+		byVolume[s.VolumeID] = append(byVolume[s.VolumeID], s)
+	}
+	for _, l := range byVolume {
+		// newest first
+		sort.Slice(l, func(i, j int) bool { return l[i].Started.After(l[j].Started) })
+	}
+
+	now := time.Now()
+	for _, v := range volumes {
+		schedule, found := v.Tags[TagNameSchedule]
+		if !found {
+			continue
+		}
+		if err := c.syncVolume(ctx, v, schedule, byVolume[v.ID], now); err != nil {
+			runtime.HandleError(err)
+		}
+	}
+	return nil
+}
+
+func (c *SnapshotController) syncVolume(ctx context.Context, v *kopeaws.Volume, schedule string, snapshots []*kopeaws.Snapshot, now time.Time) error {
+	interval, err := ParseSchedule(schedule)
+	if err != nil {
+		return fmt.Errorf("volume %q: %v", v.ID, err)
+	}
+
+	retain := c.Retain
+	if s, found := v.Tags[TagNameRetain]; found {
+		retain, err = strconv.Atoi(s)
+		if err != nil || retain < 1 {
+			return fmt.Errorf("volume %q: invalid %s tag %q", v.ID, TagNameRetain, s)
+		}
+	}
+
+	if len(snapshots) == 0 || now.Sub(snapshots[0].Started) >= interval {
+		description := fmt.Sprintf("Scheduled snapshot of %s (%s); created by aws-controller", v.ID, schedule)
+		snapshotID, err := c.cloud.SnapshotVolume(ctx, v, description, map[string]string{kopeaws.TagNameSnapshotVolume: v.ID})
+		if err != nil {
+			return err
+		}
+		glog.Infof("Created scheduled snapshot %q of volume %q", snapshotID, v.ID)
+		// The new snapshot counts towards retention on the next sync, once it is listed
+		retain--
+	}
+
+	// Only prune completed snapshots, so that a failing snapshot never replaces a good one
+	kept := 0
+	for _, s := range snapshots {
+		if s.State != "completed" {
+			continue
+		}
+		kept++
+		if kept <= retain {
+			continue
+		}
+		if err := c.cloud.DeleteSnapshot(ctx, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
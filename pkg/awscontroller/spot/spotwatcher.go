@@ -0,0 +1,153 @@
+package spot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"github.com/kopeio/aws-controller/pkg/kope/kubeutils"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	"time"
+)
+
+// The instance metadata paths for spot notices; both return 404 until a notice is issued
+const (
+	metadataInstanceAction = "spot/instance-action"
+	metadataRebalance      = "events/recommendations/rebalance"
+)
+
+// defaultDrainTimeout bounds the drain when the notice does not carry a deadline
+const defaultDrainTimeout = 5 * time.Minute
+
+type instanceAction struct {
+	Action string    `json:"action"`
+	Time   time.Time `json:"time"`
+}
+
+type rebalanceRecommendation struct {
+	NoticeTime time.Time `json:"noticeTime"`
+}
+
+// SpotInterruptionWatcher runs on each node (in agent mode), polling the instance metadata
+// for spot interruption notices and rebalance recommendations.  It reacts by withdrawing the
+// instance from DNS (by tagging it as draining) and cordoning and draining the node, ahead of
+// the two-minute interruption deadline.
+type SpotInterruptionWatcher struct {
+	// DrainOnRebalance also reacts to rebalance recommendations, not only to interruption notices
+	DrainOnRebalance bool
+	// DrainGracePeriod overrides the termination grace period of pods, if non-zero,
+	// so that they can terminate before the interruption deadline
+	DrainGracePeriod time.Duration
+
+	cloud      *kopeaws.AWSCloud
+	kubernetes kubernetes.Interface
+	nodeName   string
+
+	// handled is set once we have drained the node
+	handled bool
+	// rebalanceLogged is set once we have logged an (ignored) rebalance recommendation
+	rebalanceLogged bool
+
+	*kope.PeriodicController
+}
+
+// NewSpotInterruptionWatcher builds a watcher for the instance we are running on;
+// if nodeName is empty the node is found by instance id.
+func NewSpotInterruptionWatcher(cloud *kopeaws.AWSCloud, kubernetes kubernetes.Interface, nodeName string, period time.Duration) *SpotInterruptionWatcher {
+	w := &SpotInterruptionWatcher{
+		cloud:      cloud,
+		kubernetes: kubernetes,
+		nodeName:   nodeName,
+	}
+	w.PeriodicController = kope.NewPeriodicController("spot interruption watcher", period, w.runOnce)
+	return w
+}
+
+func (w *SpotInterruptionWatcher) runOnce(ctx context.Context) error {
+	if w.handled {
+		return nil
+	}
+
+	value, found, err := w.cloud.GetMetadata(ctx, metadataInstanceAction)
+	if err != nil {
+		return err
+	}
+	if found {
+		action := &instanceAction{}
+		if err := json.Unmarshal([]byte(value), action); err != nil {
+			return fmt.Errorf("error parsing spot instance action %q: %v", value, err)
+		}
+		glog.Warningf("Received spot interruption notice: %s at %s", action.Action, action.Time)
+		return w.drain(ctx, "spot-interruption", action.Time)
+	}
+
+	value, found, err = w.cloud.GetMetadata(ctx, metadataRebalance)
+	if err != nil {
+		return err
+	}
+	if found {
+		recommendation := &rebalanceRecommendation{}
+		if err := json.Unmarshal([]byte(value), recommendation); err != nil {
+			return fmt.Errorf("error parsing spot rebalance recommendation %q: %v", value, err)
+		}
+		if !w.DrainOnRebalance {
+			if !w.rebalanceLogged {
+				glog.Warningf("Received spot rebalance recommendation at %s; ignoring", recommendation.NoticeTime)
+				w.rebalanceLogged = true
+			}
+			return nil
+		}
+		glog.Warningf("Received spot rebalance recommendation at %s", recommendation.NoticeTime)
+		return w.drain(ctx, "spot-rebalance", time.Time{})
+	}
+
+	return nil
+}
+
+// drain withdraws the instance from DNS, and cordons and drains the node before deadline
+func (w *SpotInterruptionWatcher) drain(ctx context.Context, reason string, deadline time.Time) error {
+	// Withdraw from DNS first, so clients stop being directed here as soon as possible;
+	// we still drain the node if this fails
+	instanceID := w.cloud.InstanceID()
+	if err := w.cloud.TagInstance(ctx, instanceID, map[string]string{kopeaws.TagNameDraining: reason}); err != nil {
+		runtime.HandleError(fmt.Errorf("error withdrawing instance %q from DNS: %v", instanceID, err))
+	}
+
+	nodeName := w.nodeName
+	if nodeName == "" {
+		var privateDNSName string
+		if self := w.cloud.Self(); self != nil && self.PrivateDnsName != nil {
+			privateDNSName = *self.PrivateDnsName
+		}
+
+		var err error
+		nodeName, err = kubeutils.FindNodeForInstance(ctx, w.kubernetes, instanceID, privateDNSName)
+		if err != nil {
+			return err
+		}
+		if nodeName == "" {
+			return fmt.Errorf("unable to find node for instance %q", instanceID)
+		}
+	}
+
+	if err := kubeutils.CordonNode(ctx, w.kubernetes, nodeName); err != nil {
+		return err
+	}
+
+	options := kubeutils.DrainOptions{
+		GracePeriod: w.DrainGracePeriod,
+		Timeout:     defaultDrainTimeout,
+	}
+	if !deadline.IsZero() {
+		options.Timeout = time.Until(deadline)
+	}
+	if err := kubeutils.DrainNode(ctx, w.kubernetes, nodeName, options); err != nil {
+		return err
+	}
+
+	w.handled = true
+	return nil
+}
@@ -0,0 +1,211 @@
+package targetgroups
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/kopeaws"
+	"github.com/kopeio/aws-controller/pkg/kope/kubeutils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Binding selects the instances which should be registered in a target group
+type Binding struct {
+	TargetGroupARN string
+
+	// Role selects instances by their k8s.io/role/<role> tag
+	Role string
+	// NodeLabel and NodeLabelValue select instances whose node has the label (with the value, if not empty)
+	NodeLabel      string
+	NodeLabelValue string
+}
+
+// ParseBinding parses a binding of the form <arn>=role:<role> or <arn>=node-label:<key>[=<value>]
+func ParseBinding(targetGroupARN string, selector string) (*Binding, error) {
+	b := &Binding{TargetGroupARN: targetGroupARN}
+	switch {
+	case strings.HasPrefix(selector, "role:"):
+		b.Role = strings.TrimPrefix(selector, "role:")
+	case strings.HasPrefix(selector, "node-label:"):
+		tokens := strings.SplitN(strings.TrimPrefix(selector, "node-label:"), "=", 2)
+		b.NodeLabel = tokens[0]
+		if len(tokens) == 2 {
+			b.NodeLabelValue = tokens[1]
+		}
+	}
+	if b.Role == "" && b.NodeLabel == "" {
+		return nil, fmt.Errorf("invalid target group selector %q for %q; expected role:<role> or node-label:<key>[=<value>]", selector, targetGroupARN)
+	}
+	return b, nil
+}
+
+// TargetGroupController registers running cluster instances in ELBv2 target groups, selected by
+// role tag or node label, and deregisters instances which no longer match (or are draining).  Targets
+// which are not instances of our cluster are left alone, and a target group is never emptied unless
+// AllowEmpty is set.
+type TargetGroupController struct {
+	Bindings []*Binding
+	// AllowEmpty allows us to deregister every instance of a target group, when none match its binding
+	AllowEmpty bool
+
+	cloud *kopeaws.AWSCloud
+
+	// cache is the instance list shared with other controllers
+
+	cache *kopeaws.InstanceCache
+	// kubernetes is needed only for node label selectors
+	kubernetes kubernetes.Interface
+
+	*kope.PeriodicController
+}
+
+func NewTargetGroupController(cloud *kopeaws.AWSCloud, cache *kopeaws.InstanceCache, kubernetes kubernetes.Interface, bindings []*Binding, period time.Duration) *TargetGroupController {
+	c := &TargetGroupController{
+		Bindings:   bindings,
+		cloud:      cloud,
+		cache:      cache,
+		kubernetes: kubernetes,
+	}
+	c.PeriodicController = kope.NewPeriodicController("target group controller", period, c.runOnce)
+	return c
+}
+
+func (c *TargetGroupController) runOnce(ctx context.Context) error {
+	instances, err := c.cache.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Only list nodes if a binding needs them
+	var nodeLabels map[string]map[string]string
+	for _, b := range c.Bindings {
+		if b.NodeLabel != "" {
+			nodeLabels, err = c.listNodeLabels(ctx)
+			if err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	clusterInstances := InstanceIDs(instances)
+	for _, b := range c.Bindings {
+		var desired []string
+		for _, i := range instances {
+			if b.matches(i, nodeLabels) {
+				desired = append(desired, aws.StringValue(i.InstanceId))
+			}
+		}
+
+		if err := SyncTargets(ctx, c.cloud, b.TargetGroupARN, desired, clusterInstances, c.AllowEmpty); err != nil {
+			runtime.HandleError(err)
+		}
+	}
+	return nil
+}
+
+// listNodeLabels returns the labels of each node, keyed by instance id
+func (c *TargetGroupController) listNodeLabels(ctx context.Context) (map[string]map[string]string, error) {
+	nodes, err := c.kubernetes.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing nodes: %v", err)
+	}
+
+	labels := make(map[string]map[string]string)
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if id := kubeutils.InstanceIDFromProviderID(node.Spec.ProviderID); id != "" {
+			labels[id] = node.Labels
+		}
+	}
+	return labels, nil
+}
+
+// matches returns true if the instance should be registered in the target group
+func (b *Binding) matches(i *ec2.Instance, nodeLabels map[string]map[string]string) bool {
+	if aws.StringValue(i.State.Name) != ec2.InstanceStateNameRunning {
+		return false
+	}
+	if _, draining := kopeaws.FindTag(i, kopeaws.TagNameDraining); draining {
+		return false
+	}
+
+	if b.Role != "" && kopeaws.InstanceRole(i) != b.Role {
+		return false
+	}
+	if b.NodeLabel != "" {
+		v, found := nodeLabels[aws.StringValue(i.InstanceId)][b.NodeLabel]
+		if !found || (b.NodeLabelValue != "" && v != b.NodeLabelValue) {
+			return false
+		}
+	}
+	return true
+}
+
+// InstanceIDs returns the set of ids of the instances
+func InstanceIDs(instances []*ec2.Instance) map[string]bool {
+	ids := make(map[string]bool)
+	for _, i := range instances {
+		ids[aws.StringValue(i.InstanceId)] = true
+	}
+	return ids
+}
+
+// SyncTargets registers and deregisters instances so that the target group holds the desired instances.  Only
+// clusterInstances are deregistered, so targets registered by hand or by another cluster are left alone.  We
+// refuse to deregister every target, leaving the target group with no healthy targets, unless allowEmpty is set.
+func SyncTargets(ctx context.Context, cloud *kopeaws.AWSCloud, targetGroupARN string, desired []string, clusterInstances map[string]bool, allowEmpty bool) error {
+	actual, err := cloud.ListTargets(ctx, targetGroupARN)
+	if err != nil {
+		return err
+	}
+
+	registered := make(map[string]bool)
+	for _, id := range actual {
+		registered[id] = true
+	}
+	wanted := make(map[string]bool)
+	for _, id := range desired {
+		wanted[id] = true
+	}
+
+	var register, deregister []string
+	for id := range wanted {
+		if !registered[id] {
+			register = append(register, id)
+		}
+	}
+	for id := range registered {
+		if !wanted[id] && clusterInstances[id] {
+			deregister = append(deregister, id)
+		}
+	}
+	sort.Strings(register)
+	sort.Strings(deregister)
+
+	if len(deregister) != 0 && len(deregister) == len(registered) && len(register) == 0 && !allowEmpty {
+		return fmt.Errorf("refusing to deregister every target of target group %q (%v), as no instances match; set target-group-allow-empty to allow this", targetGroupARN, deregister)
+	}
+
+	if len(register) != 0 {
+		if err := cloud.RegisterTargets(ctx, targetGroupARN, register); err != nil {
+			return err
+		}
+	}
+	if len(deregister) != 0 {
+		if err := cloud.DeregisterTargets(ctx, targetGroupARN, deregister); err != nil {
+			return err
+		}
+	}
+
+	glog.V(2).Infof("Target group %q has %d targets", targetGroupARN, len(registered)+len(register)-len(deregister))
+	return nil
+}
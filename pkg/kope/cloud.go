@@ -1,8 +1,168 @@
 package kope
 
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrInstanceMustBeStopped is returned by changes which the cloud only allows while the instance is stopped
+var ErrInstanceMustBeStopped = errors.New("the instance must be stopped to make this change")
+
+// Cloud is the cloud-neutral view of the instances of a cluster, and the changes we make to them
 type Cloud interface {
+	// ClusterID returns the id of the cluster whose instances we manage
+	ClusterID() string
+
+	// ListInstances returns the instances of the cluster
+	ListInstances(ctx context.Context) ([]*Instance, error)
+
+	// SetInstanceIPForwarding sets whether the instance may send and receive traffic for addresses
+	// other than its own; it returns ErrInstanceMustBeStopped if the cloud cannot change it on the
+	// instance while it is running
+	SetInstanceIPForwarding(ctx context.Context, instanceID string, enabled bool) error
+	// SetInstanceTerminationProtection sets whether the instance is protected from termination through the API
+	SetInstanceTerminationProtection(ctx context.Context, instanceID string, protect bool) error
+
+	// TagInstance adds (or replaces) tags on the instance
+	TagInstance(ctx context.Context, instanceID string, tags map[string]string) error
+	// UntagInstance removes tags from the instance
+	UntagInstance(ctx context.Context, instanceID string, keys []string) error
+
+	RebootInstance(ctx context.Context, instanceID string) error
+	TerminateInstance(ctx context.Context, instanceID string) error
+}
+
+// The states of instances
+const (
+	InstanceStatePending     = "pending"
+	InstanceStateRunning     = "running"
+	InstanceStateStopping    = "stopping"
+	InstanceStateStopped     = "stopped"
+	InstanceStateTerminating = "terminating"
+	InstanceStateTerminated  = "terminated"
+)
+
+// Instance is the cloud-neutral view of an instance
+type Instance struct {
+	ID     string
+	Region string
+	Zone   string
+	// State is one of the InstanceState constants
+	State string
+	// Role is the role of the instance in the cluster (e.g. master or node), from its tags
+	Role string
+
+	PrivateIP      string
+	PrivateDNSName string
+	PublicIP       string
+	PublicDNSName  string
+
+	// IPForwarding is true if the instance may send and receive traffic for addresses other than its own
+	IPForwarding bool
+
+	LaunchTime time.Time
+
+	// Tags holds the tags (or labels) of the instance; use Tag to look them up
+	Tags map[string]string
+}
+
+// The DNS record types we publish
+const (
+	DNSTypeA     = "A"
+	DNSTypeCNAME = "CNAME"
+	DNSTypeSRV   = "SRV"
+	DNSTypePTR   = "PTR"
+)
+
+// Failover roles of record sets
+const (
+	DNSFailoverPrimary   = "PRIMARY"
+	DNSFailoverSecondary = "SECONDARY"
+)
+
+// DNSRecordKey identifies a DNS record set by name and type, along with its routing policy
+type DNSRecordKey struct {
+	Name string
+	Type string
+
+	// SetIdentifier distinguishes record sets with the same name and type which use a routing policy
+	SetIdentifier string
+	// Failover is DNSFailoverPrimary or DNSFailoverSecondary for failover record sets
+	Failover string
+	// Region is the AWS region of latency-based record sets
+	Region string
+	// MultiValue marks multi-value answer record sets
+	MultiValue bool
+	// Weighted marks weighted record sets, which receive a share of the queries proportional to Weight
+	Weighted bool
+	Weight   int64
+	// HealthCheckPort, if set, makes the record set's health depend on a TCP health check of its
+	// value, which must be a single IP, on this port
+	HealthCheckPort int
+	// AliasHostedZoneID, if set, makes the record set an alias to the AWS resource (such as a load
+	// balancer) whose DNS name is the single value; it is the hosted zone id of that resource
+	AliasHostedZoneID string
+	// Private marks record sets for the private zone of a split-horizon pair (see NewSplitHorizonDNSProvider)
+	Private bool
+}
+
+func (k DNSRecordKey) String() string {
+	s := k.Name + "/" + k.Type
+	if k.SetIdentifier != "" {
+		s += "/" + k.SetIdentifier
+	}
+	if k.Private {
+		s += " (private)"
+	}
+	return s
+}
+
+// ARecord returns the key of the A record set for name
+func ARecord(name string) DNSRecordKey {
+	return DNSRecordKey{Name: name, Type: DNSTypeA}
 }
 
 type DNSProvider interface {
-	ApplyDNSChanges(records map[string][]string) error
+	// ApplyDNSChanges creates or replaces each record set with the values
+	ApplyDNSChanges(ctx context.Context, records map[DNSRecordKey][]string) error
+}
+
+// OwnedDNSProvider is implemented by DNS providers which can mark the records they create as owned by us
+type OwnedDNSProvider interface {
+	DNSProvider
+
+	// ListOwnedDNSRecords returns the record sets at the names we own, or nil if ownership is not being recorded
+	ListOwnedDNSRecords(ctx context.Context) (map[DNSRecordKey][]string, error)
+	// DeleteDNSRecords deletes the record sets, whatever their current values
+	DeleteDNSRecords(ctx context.Context, keys []DNSRecordKey) error
+}
+
+// maxLabelLength is the maximum length of label keys and values on clouds which have labels rather than tags
+const maxLabelLength = 63
+
+// SanitizeLabel converts a tag key or value to the restricted character set of labels (lowercase letters,
+// digits, "-" and "_") on clouds which have labels rather than tags, such as GCE; k8s.io/role/node becomes
+// k8s-io-role-node
+func SanitizeLabel(s string) string {
+	b := []byte(strings.ToLower(s))
+	for i, c := range b {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			b[i] = '-'
+		}
+	}
+	if len(b) > maxLabelLength {
+		b = b[:maxLabelLength]
+	}
+	return string(b)
+}
+
+// Tag returns the value of the instance's tag, matching the sanitized label on clouds which have labels
+func (i *Instance) Tag(key string) (string, bool) {
+	if v, found := i.Tags[key]; found {
+		return v, true
+	}
+	v, found := i.Tags[SanitizeLabel(key)]
+	return v, found
 }
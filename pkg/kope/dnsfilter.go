@@ -0,0 +1,127 @@
+package kope
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"path"
+	"strings"
+)
+
+// DNSNameFilter selects the DNS names we manage, so that we can share a zone with other tools (such as
+// external-dns) or manually created records.  Patterns are globs as understood by path.Match, matched
+// case-insensitively against names without the trailing dot; * matches any run of characters, including dots.
+type DNSNameFilter struct {
+	// Include, if not empty, limits us to names matching at least one of the patterns
+	Include []string
+	// Exclude lists patterns of names we must not manage, even if they are included
+	Exclude []string
+}
+
+// Validate returns an error if any of the patterns is malformed
+func (f *DNSNameFilter) Validate() error {
+	for _, pattern := range append(append([]string(nil), f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid DNS name pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Matches returns true if we should manage the name
+func (f *DNSNameFilter) Matches(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if len(f.Include) != 0 && !matchesAny(f.Include, name) {
+		return false
+	}
+	return !matchesAny(f.Exclude, name)
+}
+
+// matchesRecord returns true if we should manage the record set.  PTR record sets are always managed, as
+// their in-addr.arpa names are derived from forward names which were filtered.
+func (f *DNSNameFilter) matchesRecord(k DNSRecordKey) bool {
+	return k.Type == DNSTypePTR || f.Matches(k.Name)
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(strings.TrimSuffix(pattern, ".")), name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// filteredDNSProvider publishes only the record sets whose names match the filter; the others are skipped
+type filteredDNSProvider struct {
+	dns    DNSProvider
+	filter *DNSNameFilter
+}
+
+// ownedFilteredDNSProvider is a filteredDNSProvider whose provider records ownership
+type ownedFilteredDNSProvider struct {
+	filteredDNSProvider
+}
+
+var _ OwnedDNSProvider = &ownedFilteredDNSProvider{}
+
+// NewFilteredDNSProvider restricts the provider to the names matching the filter; the result is an
+// OwnedDNSProvider if the provider is
+func NewFilteredDNSProvider(dns DNSProvider, filter *DNSNameFilter) DNSProvider {
+	p := filteredDNSProvider{dns: dns, filter: filter}
+	if _, owned := dns.(OwnedDNSProvider); owned {
+		return &ownedFilteredDNSProvider{p}
+	}
+	return &p
+}
+
+// ListNameServers returns the name servers of the provider's zone, or nil if they are not known
+func (p *filteredDNSProvider) ListNameServers(ctx context.Context) ([]string, error) {
+	if lister, ok := p.dns.(NameServerLister); ok {
+		return lister.ListNameServers(ctx)
+	}
+	return nil, nil
+}
+
+func (p *filteredDNSProvider) ApplyDNSChanges(ctx context.Context, records map[DNSRecordKey][]string) error {
+	filtered := make(map[DNSRecordKey][]string)
+	for k, v := range records {
+		if !p.filter.matchesRecord(k) {
+			glog.V(2).Infof("Skipping %s: the name is excluded by the DNS name filters", k)
+			continue
+		}
+		filtered[k] = v
+	}
+	if len(filtered) == 0 {
+		return nil
+	}
+	return p.dns.ApplyDNSChanges(ctx, filtered)
+}
+
+// ListOwnedDNSRecords returns the owned record sets whose names match the filter
+func (p *ownedFilteredDNSProvider) ListOwnedDNSRecords(ctx context.Context) (map[DNSRecordKey][]string, error) {
+	listed, err := p.dns.(OwnedDNSProvider).ListOwnedDNSRecords(ctx)
+	if err != nil || listed == nil {
+		return nil, err
+	}
+	records := make(map[DNSRecordKey][]string)
+	for k, v := range listed {
+		if p.filter.matchesRecord(k) {
+			records[k] = v
+		}
+	}
+	return records, nil
+}
+
+func (p *ownedFilteredDNSProvider) DeleteDNSRecords(ctx context.Context, keys []DNSRecordKey) error {
+	var filtered []DNSRecordKey
+	for _, k := range keys {
+		if p.filter.matchesRecord(k) {
+			filtered = append(filtered, k)
+		}
+	}
+	if len(filtered) == 0 {
+		return nil
+	}
+	return p.dns.(OwnedDNSProvider).DeleteDNSRecords(ctx, filtered)
+}
@@ -0,0 +1,109 @@
+package kope_test
+
+import (
+	"context"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/fakecloud"
+	"reflect"
+	"testing"
+)
+
+func TestDNSNameFilterMatches(t *testing.T) {
+	grid := []struct {
+		Include []string
+		Exclude []string
+		Name    string
+		Matches bool
+	}{
+		{Name: "node1.example.com", Matches: true},
+		{Include: []string{"*.example.com"}, Name: "node1.example.com", Matches: true},
+		{Include: []string{"*.example.com"}, Name: "node1.nodes.example.com.", Matches: true},
+		{Include: []string{"*.example.com"}, Name: "example.com", Matches: false},
+		{Include: []string{"*.example.com"}, Name: "NODE1.Example.COM", Matches: true},
+		{Include: []string{"*.EXAMPLE.com."}, Name: "node1.example.com", Matches: true},
+		{Include: []string{"*.example.org", "*.example.com"}, Name: "node1.example.com", Matches: true},
+		{Include: []string{"*.example.org"}, Name: "node1.example.com", Matches: false},
+		{Exclude: []string{"api.example.com"}, Name: "api.example.com.", Matches: false},
+		{Exclude: []string{"api.example.com"}, Name: "node1.example.com", Matches: true},
+		{Include: []string{"*.example.com"}, Exclude: []string{"api.*"}, Name: "api.example.com", Matches: false},
+		{Include: []string{"node?.example.com"}, Name: "node1.example.com", Matches: true},
+		{Include: []string{"node?.example.com"}, Name: "node10.example.com", Matches: false},
+	}
+	for _, g := range grid {
+		f := &kope.DNSNameFilter{Include: g.Include, Exclude: g.Exclude}
+		if matches := f.Matches(g.Name); matches != g.Matches {
+			t.Errorf("include %v, exclude %v: Matches(%q) = %v, expected %v", g.Include, g.Exclude, g.Name, matches, g.Matches)
+		}
+	}
+}
+
+func TestDNSNameFilterValidate(t *testing.T) {
+	grid := []struct {
+		Filter kope.DNSNameFilter
+		Error  bool
+	}{
+		{Filter: kope.DNSNameFilter{}},
+		{Filter: kope.DNSNameFilter{Include: []string{"*.example.com"}, Exclude: []string{"api.example.com"}}},
+		{Filter: kope.DNSNameFilter{Include: []string{"[a-.example.com"}}, Error: true},
+		{Filter: kope.DNSNameFilter{Exclude: []string{"[.example.com"}}, Error: true},
+	}
+	for _, g := range grid {
+		err := g.Filter.Validate()
+		if (err != nil) != g.Error {
+			t.Errorf("Validate(%+v): got error %v, expected error %v", g.Filter, err, g.Error)
+		}
+	}
+}
+
+func TestFilteredDNSProvider(t *testing.T) {
+	ctx := context.Background()
+	fake := fakecloud.NewFakeDNSProvider()
+	filter := &kope.DNSNameFilter{Include: []string{"*.nodes.example.com"}}
+	p := kope.NewFilteredDNSProvider(fake, filter)
+
+	ptr := kope.DNSRecordKey{Name: "1.0.0.10.in-addr.arpa", Type: kope.DNSTypePTR}
+	err := p.ApplyDNSChanges(ctx, map[kope.DNSRecordKey][]string{
+		kope.ARecord("node1.nodes.example.com"): {"10.0.0.1"},
+		kope.ARecord("api.example.com"):         {"10.0.0.2"},
+		// PTR records are always managed
+		ptr: {"node1.nodes.example.com"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[kope.DNSRecordKey][]string{
+		kope.ARecord("node1.nodes.example.com"): {"10.0.0.1"},
+		ptr:                                     {"node1.nodes.example.com"},
+	}
+	if actual := fake.Records(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("published %v, expected %v", actual, expected)
+	}
+
+	// Records published by others outside the filter are neither listed nor deleted
+	other := kope.ARecord("www.example.com")
+	if err := fake.ApplyDNSChanges(ctx, map[kope.DNSRecordKey][]string{other: {"10.0.0.3"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	owned, ok := p.(kope.OwnedDNSProvider)
+	if !ok {
+		t.Fatalf("a filtered OwnedDNSProvider should be an OwnedDNSProvider")
+	}
+	listed, err := owned.ListOwnedDNSRecords(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(listed, expected) {
+		t.Errorf("listed %v, expected %v", listed, expected)
+	}
+	if err := owned.DeleteDNSRecords(ctx, []kope.DNSRecordKey{kope.ARecord("node1.nodes.example.com"), other}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = map[kope.DNSRecordKey][]string{
+		ptr:   {"node1.nodes.example.com"},
+		other: {"10.0.0.3"},
+	}
+	if actual := fake.Records(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("after delete %v, expected %v", actual, expected)
+	}
+}
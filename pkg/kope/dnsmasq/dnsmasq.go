@@ -0,0 +1,407 @@
+package dnsmasq
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/utils"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// DnsmasqProviderName is the name under which RegisterDnsmasqProvider registers the dnsmasq provider
+const DnsmasqProviderName = "dnsmasq"
+
+// DnsmasqOptions configures the files the dnsmasq provider writes, and the dnsmasq process it signals
+type DnsmasqOptions struct {
+	// HostsFile is the file A records are written to, which dnsmasq should load with addn-hosts
+	HostsFile string
+	// ConfFile, if set, is the configuration fragment CNAME (cname=) and SRV (srv-host=) records are
+	// written to, which dnsmasq should load with conf-file or conf-dir; if not set, they are skipped
+	ConfFile string
+	// PIDFile, if set, is the pid file of the dnsmasq process, which we send SIGHUP when the hosts file changes
+	PIDFile string
+}
+
+// DnsmasqProvider publishes records to files loaded by dnsmasq, so that dnsmasq can serve as a lightweight
+// cluster-internal resolver.  The files are written only by aws-controller, but may be shared by several
+// controllers: each provider keeps its records in a block of each file delimited by marker comments naming
+// its owner, and everything in that block is ours, so the provider is an OwnedDNSProvider.  On SIGHUP
+// dnsmasq reloads addn-hosts files but not its configuration, so changes to CNAME and SRV records only take
+// effect when dnsmasq is restarted.  Routing policies are rejected.
+type DnsmasqProvider struct {
+	options DnsmasqOptions
+	ownerID string
+	// pause, if set, refuses changes to the files while mutations are paused
+	pause *kope.PauseSwitch
+
+	// mutex serializes updates of the files, by all the providers sharing them
+	mutex *sync.Mutex
+}
+
+var _ kope.OwnedDNSProvider = &DnsmasqProvider{}
+
+// RegisterDnsmasqProvider registers the dnsmasq provider, configured by options
+func RegisterDnsmasqProvider(options DnsmasqOptions) {
+	kope.RegisterDNSProvider(DnsmasqProviderName, func(providerOptions kope.DNSProviderOptions) (kope.DNSProvider, error) {
+		return NewDnsmasqProvider(options, providerOptions.OwnerID, providerOptions.Pause)
+	})
+}
+
+func NewDnsmasqProvider(options DnsmasqOptions, ownerID string, pause *kope.PauseSwitch) (*DnsmasqProvider, error) {
+	if options.HostsFile == "" {
+		return nil, fmt.Errorf("the path of the dnsmasq hosts file is required")
+	}
+	return &DnsmasqProvider{
+		options: options,
+		ownerID: ownerID,
+		pause:   pause,
+		mutex:   utils.FileMutex(options.HostsFile),
+	}, nil
+}
+
+// fileHeader starts each file we write
+const fileHeader = "# Written by aws-controller; do not edit"
+
+// blockMarkerPrefix starts the marker comments delimiting the block of each owner
+const blockMarkerPrefix = "# BEGIN aws-controller records"
+
+// beginMarker and endMarker delimit our block of each file
+func (p *DnsmasqProvider) beginMarker() string {
+	return blockMarkerPrefix + p.ownerSuffix()
+}
+
+func (p *DnsmasqProvider) endMarker() string {
+	return "# END aws-controller records" + p.ownerSuffix()
+}
+
+func (p *DnsmasqProvider) ownerSuffix() string {
+	if p.ownerID == "" {
+		return ""
+	}
+	return " owned by " + p.ownerID
+}
+
+// otherBlocks holds the lines of the blocks of other owners in each of our files, which we preserve
+type otherBlocks struct {
+	hosts []string
+	conf  []string
+}
+
+// read returns the records in our blocks of the files, and the blocks of other owners; missing files are
+// treated as empty
+func (p *DnsmasqProvider) read() (map[kope.DNSRecordKey][]string, *otherBlocks, error) {
+	records := make(map[kope.DNSRecordKey][]string)
+	others := &otherBlocks{}
+
+	var err error
+	others.hosts, err = p.readLines(p.options.HostsFile, func(fields []string) error {
+		for _, name := range fields[1:] {
+			key := kope.ARecord(name)
+			records[key] = append(records[key], fields[0])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if p.options.ConfFile != "" {
+		others.conf, err = p.readLines(p.options.ConfFile, func(fields []string) error {
+			option := strings.SplitN(fields[0], "=", 2)
+			if len(option) != 2 {
+				return fmt.Errorf("unexpected line %q", fields[0])
+			}
+			args := strings.Split(option[1], ",")
+			switch {
+			case option[0] == "cname" && len(args) == 2:
+				key := kope.DNSRecordKey{Name: args[0], Type: kope.DNSTypeCNAME}
+				records[key] = append(records[key], args[1])
+			case option[0] == "srv-host" && len(args) == 5:
+				// srv-host=<name>,<target>,<port>,<priority>,<weight>
+				key := kope.DNSRecordKey{Name: args[0], Type: kope.DNSTypeSRV}
+				records[key] = append(records[key], strings.Join([]string{args[3], args[4], args[2], args[1]}, " "))
+			default:
+				return fmt.Errorf("unexpected line %q", fields[0])
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, values := range records {
+		sort.Strings(values)
+	}
+	return records, others, nil
+}
+
+// readLines calls f with the fields of each line of our block of the file which is not blank or a comment,
+// returning the lines of the blocks of other owners.  Records outside any block were written before records
+// were kept in blocks, and are dropped: their owner is re-publishing them in its block.
+func (p *DnsmasqProvider) readLines(path string, f func(fields []string) error) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading %q: %v", path, err)
+	}
+
+	var others []string
+	inBlock, inOtherBlock := false, false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == p.beginMarker():
+			inBlock = true
+			continue
+		case inBlock && trimmed == p.endMarker():
+			inBlock = false
+			continue
+		case !inBlock && strings.HasPrefix(trimmed, blockMarkerPrefix):
+			inOtherBlock = true
+		}
+
+		if inOtherBlock {
+			others = append(others, line)
+			if strings.HasPrefix(trimmed, "# END aws-controller records") {
+				inOtherBlock = false
+			}
+			continue
+		}
+		if !inBlock {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if err := f(fields); err != nil {
+			return nil, fmt.Errorf("error parsing %q: %v", path, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %q: %v", path, err)
+	}
+	if inBlock {
+		return nil, fmt.Errorf("%q has no %q line to end our block", path, p.endMarker())
+	}
+	return others, nil
+}
+
+// write writes the records to our blocks of the files, signalling dnsmasq if the hosts file changed
+func (p *DnsmasqProvider) write(current map[kope.DNSRecordKey][]string, records map[kope.DNSRecordKey][]string, others *otherBlocks) error {
+	var keys []kope.DNSRecordKey
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		return keys[a].String() < keys[b].String()
+	})
+
+	hosts := append(append([]string{fileHeader}, others.hosts...), p.beginMarker())
+	conf := append(append([]string{fileHeader}, others.conf...), p.beginMarker())
+	hostsChanged, confChanged := false, false
+	for _, key := range keys {
+		switch key.Type {
+		case kope.DNSTypeA:
+			for _, ip := range records[key] {
+				hosts = append(hosts, ip+"\t"+key.Name)
+			}
+		case kope.DNSTypeCNAME:
+			for _, target := range records[key] {
+				conf = append(conf, "cname="+key.Name+","+target)
+			}
+		case kope.DNSTypeSRV:
+			for _, value := range records[key] {
+				fields := strings.Fields(value)
+				// srv-host=<name>,<target>,<port>,<priority>,<weight>
+				conf = append(conf, "srv-host="+strings.Join([]string{key.Name, fields[3], fields[2], fields[0], fields[1]}, ","))
+			}
+		}
+	}
+	hosts = append(hosts, p.endMarker())
+	conf = append(conf, p.endMarker())
+	for _, key := range unionKeys(current, records) {
+		if strings.Join(current[key], ",") == strings.Join(records[key], ",") {
+			continue
+		}
+		if key.Type == kope.DNSTypeA {
+			hostsChanged = true
+		} else {
+			confChanged = true
+		}
+	}
+
+	if (hostsChanged || confChanged) && p.pause.Paused() {
+		return kope.ErrPaused
+	}
+	if hostsChanged {
+		glog.V(2).Infof("Writing dnsmasq hosts file %q", p.options.HostsFile)
+		if err := utils.WriteFileAtomically(p.options.HostsFile, []byte(strings.Join(hosts, "\n")+"\n")); err != nil {
+			return fmt.Errorf("error writing dnsmasq hosts file %q: %v", p.options.HostsFile, err)
+		}
+	}
+	if confChanged {
+		glog.Infof("Writing dnsmasq configuration %q; dnsmasq must be restarted to load CNAME and SRV changes", p.options.ConfFile)
+		if err := utils.WriteFileAtomically(p.options.ConfFile, []byte(strings.Join(conf, "\n")+"\n")); err != nil {
+			return fmt.Errorf("error writing dnsmasq configuration %q: %v", p.options.ConfFile, err)
+		}
+	}
+	if hostsChanged && p.options.PIDFile != "" {
+		if err := p.signal(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signal sends SIGHUP to dnsmasq, so that it reloads the hosts file
+func (p *DnsmasqProvider) signal() error {
+	data, err := ioutil.ReadFile(p.options.PIDFile)
+	if err != nil {
+		return fmt.Errorf("error reading dnsmasq pid file: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("error parsing dnsmasq pid file %q: %v", p.options.PIDFile, err)
+	}
+	process, err := os.FindProcess(pid)
+	if err == nil {
+		err = process.Signal(syscall.SIGHUP)
+	}
+	if err != nil {
+		return fmt.Errorf("error sending SIGHUP to dnsmasq (pid %d): %v", pid, err)
+	}
+	glog.V(2).Infof("Sent SIGHUP to dnsmasq (pid %d)", pid)
+	return nil
+}
+
+// update applies mutate to the records in our files, rewriting the files which changed
+func (p *DnsmasqProvider) update(mutate func(records map[kope.DNSRecordKey][]string)) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	current, others, err := p.read()
+	if err != nil {
+		return err
+	}
+	records := make(map[kope.DNSRecordKey][]string)
+	for k, v := range current {
+		records[k] = v
+	}
+	mutate(records)
+	return p.write(current, records, others)
+}
+
+// ApplyDNSChanges creates or replaces each record set with the values; an empty record set removes the records
+func (p *DnsmasqProvider) ApplyDNSChanges(ctx context.Context, records map[kope.DNSRecordKey][]string) error {
+	for key, values := range records {
+		if key.SetIdentifier != "" || key.Failover != "" || key.Region != "" || key.MultiValue || key.HealthCheckPort != 0 || key.AliasHostedZoneID != "" {
+			return fmt.Errorf("cannot publish %s: dnsmasq does not support routing policies, health checks or aliases", key)
+		}
+		if err := validate(key, values); err != nil {
+			return err
+		}
+	}
+
+	return p.update(func(current map[kope.DNSRecordKey][]string) {
+		for key, values := range records {
+			if key.Type != kope.DNSTypeA && p.options.ConfFile == "" {
+				glog.V(2).Infof("Skipping %s: no dnsmasq configuration file is set", key)
+				continue
+			}
+			key.Name = normalizeName(key.Name)
+			if len(values) == 0 {
+				delete(current, key)
+				continue
+			}
+			var normalized []string
+			for _, value := range values {
+				if key.Type == kope.DNSTypeCNAME {
+					value = normalizeName(value)
+				}
+				normalized = append(normalized, value)
+			}
+			sort.Strings(normalized)
+			current[key] = normalized
+		}
+	})
+}
+
+// validate returns an error if the values cannot be written to our files
+func validate(key kope.DNSRecordKey, values []string) error {
+	switch key.Type {
+	case kope.DNSTypeA:
+		for _, value := range values {
+			if net.ParseIP(value) == nil {
+				return fmt.Errorf("invalid address %q for %s", value, key)
+			}
+		}
+	case kope.DNSTypeCNAME:
+		if len(values) > 1 {
+			return fmt.Errorf("CNAME record %s must have a single target, had %v", key, values)
+		}
+	case kope.DNSTypeSRV:
+		for _, value := range values {
+			if len(strings.Fields(value)) != 4 {
+				return fmt.Errorf("invalid SRV value %q for %s", value, key)
+			}
+		}
+	default:
+		return fmt.Errorf("cannot publish %s: unsupported record type", key)
+	}
+	return nil
+}
+
+// ListOwnedDNSRecords returns the record sets in our blocks of the files
+func (p *DnsmasqProvider) ListOwnedDNSRecords(ctx context.Context) (map[kope.DNSRecordKey][]string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	records, _, err := p.read()
+	return records, err
+}
+
+// DeleteDNSRecords removes the record sets from our blocks of the files; record sets which don't exist are ignored
+func (p *DnsmasqProvider) DeleteDNSRecords(ctx context.Context, keys []kope.DNSRecordKey) error {
+	return p.update(func(current map[kope.DNSRecordKey][]string) {
+		for _, key := range keys {
+			key.Name = normalizeName(key.Name)
+			delete(current, key)
+		}
+	})
+}
+
+// unionKeys returns the keys of both maps
+func unionKeys(a map[kope.DNSRecordKey][]string, b map[kope.DNSRecordKey][]string) []kope.DNSRecordKey {
+	var keys []kope.DNSRecordKey
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, found := a[k]; !found {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// normalizeName returns the name as we write it: lowercase, without a trailing dot
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
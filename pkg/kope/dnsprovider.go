@@ -0,0 +1,70 @@
+package kope
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DNSProviderOptions is the configuration common to all DNS providers; provider-specific configuration
+// is supplied when the provider is registered
+type DNSProviderOptions struct {
+	// ZoneName identifies the zone to manage, by name or by a provider-specific id
+	ZoneName string
+	// OwnerID, if set, marks the names we publish as owned by us, so they can be listed (as an
+	// OwnedDNSProvider) and are not confused with records created by others
+	OwnerID string
+	// ForceOverwrite allows us to overwrite (and take ownership of) existing records which are not
+	// marked as ours
+	ForceOverwrite bool
+	// PrivateZone, if set, selects the private (true) or public (false) zone, where a zone of each
+	// visibility has the same name, as in a split-horizon pair
+	PrivateZone *bool
+	// Pause, if set, refuses changes to the zone (with ErrPaused) while mutations are paused; providers
+	// whose API is called through an AWS session are already paused by the session
+	Pause *PauseSwitch
+}
+
+// DNSProviderFactory builds a DNS provider for a zone
+type DNSProviderFactory func(options DNSProviderOptions) (DNSProvider, error)
+
+var (
+	// dnsProvidersMutex protects dnsProviders
+	dnsProvidersMutex sync.Mutex
+	// dnsProviders holds the registered DNS providers, by name
+	dnsProviders = make(map[string]DNSProviderFactory)
+)
+
+// RegisterDNSProvider registers a DNS provider under name, replacing any existing registration
+func RegisterDNSProvider(name string, factory DNSProviderFactory) {
+	dnsProvidersMutex.Lock()
+	defer dnsProvidersMutex.Unlock()
+
+	dnsProviders[name] = factory
+}
+
+// DNSProviderNames returns the sorted names of the registered DNS providers
+func DNSProviderNames() []string {
+	dnsProvidersMutex.Lock()
+	defer dnsProvidersMutex.Unlock()
+
+	var names []string
+	for name := range dnsProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BuildDNSProvider builds the named DNS provider
+func BuildDNSProvider(name string, options DNSProviderOptions) (DNSProvider, error) {
+	dnsProvidersMutex.Lock()
+	factory := dnsProviders[name]
+	dnsProvidersMutex.Unlock()
+
+	if factory == nil {
+		return nil, fmt.Errorf("unknown DNS provider %q; known providers are %s", name, strings.Join(DNSProviderNames(), ","))
+	}
+	return factory(options)
+}
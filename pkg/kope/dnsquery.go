@@ -0,0 +1,169 @@
+package kope
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NameServerLister is implemented by DNS providers which know the authoritative name servers of their zone
+type NameServerLister interface {
+	// ListNameServers returns the host names (or addresses) of the zone's authoritative name servers,
+	// or nil if they are not known, or ErrPrivateZone if the zone is private
+	ListNameServers(ctx context.Context) ([]string, error)
+}
+
+// ErrPrivateZone is returned by ListNameServers for a private zone, which has no public name servers
+var ErrPrivateZone = errors.New("the zone is private")
+
+// ErrNotAuthoritative is returned by QueryDNS if the server is not authoritative for the name, e.g. because
+// the name is in a delegated zone
+var ErrNotAuthoritative = errors.New("name server is not authoritative for the name")
+
+// defaultQueryTimeout bounds a DNS query if the context has no earlier deadline
+const defaultQueryTimeout = 5 * time.Second
+
+var queryTypes = map[string]dnsmessage.Type{
+	DNSTypeA:     dnsmessage.TypeA,
+	DNSTypeCNAME: dnsmessage.TypeCNAME,
+	DNSTypeSRV:   dnsmessage.TypeSRV,
+	DNSTypePTR:   dnsmessage.TypePTR,
+}
+
+// QueryDNS asks the name server (host or host:port) for the record set of the name and type, without
+// recursion, and returns its values in the form we publish them, sorted; a name or record set which does
+// not exist has no values.  Names in the values are lowercase, without the trailing dot.
+func QueryDNS(ctx context.Context, server string, name string, recordType string) ([]string, error) {
+	qtype, found := queryTypes[recordType]
+	if !found {
+		return nil, fmt.Errorf("cannot query records of type %q", recordType)
+	}
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS name %q: %v", name, err)
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultQueryTimeout)
+		defer cancel()
+	}
+
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(rand.Intn(65536))},
+		Questions: []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("error building DNS query for %s: %v", name, err)
+	}
+
+	response, err := exchange(ctx, "udp", server, packed)
+	if err == nil && response.Header.Truncated {
+		response, err = exchange(ctx, "tcp", server, packed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error querying %s for %s %s: %v", server, name, recordType, err)
+	}
+	if response.Header.ID != query.Header.ID {
+		return nil, fmt.Errorf("error querying %s for %s %s: mismatched response id", server, name, recordType)
+	}
+	if !response.Header.Authoritative {
+		return nil, ErrNotAuthoritative
+	}
+	switch response.Header.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("error querying %s for %s %s: %s", server, name, recordType, response.Header.RCode)
+	}
+
+	var values []string
+	for _, answer := range response.Answers {
+		if answer.Header.Type != qtype || !strings.EqualFold(answer.Header.Name.String(), qname.String()) {
+			continue
+		}
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			values = append(values, net.IP(body.A[:]).String())
+		case *dnsmessage.CNAMEResource:
+			values = append(values, normalizeQueryName(body.CNAME))
+		case *dnsmessage.PTRResource:
+			values = append(values, normalizeQueryName(body.PTR))
+		case *dnsmessage.SRVResource:
+			values = append(values, strings.Join([]string{
+				strconv.Itoa(int(body.Priority)),
+				strconv.Itoa(int(body.Weight)),
+				strconv.Itoa(int(body.Port)),
+				normalizeQueryName(body.Target),
+			}, " "))
+		}
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+func normalizeQueryName(name dnsmessage.Name) string {
+	return strings.ToLower(strings.TrimSuffix(name.String(), "."))
+}
+
+// exchange sends the packed query to the server over the network, and returns the parsed response
+func exchange(ctx context.Context, network string, server string, packed []byte) (*dnsmessage.Message, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var buf []byte
+	if network == "tcp" {
+		// Messages over TCP are prefixed with their length
+		prefixed := make([]byte, 2+len(packed))
+		binary.BigEndian.PutUint16(prefixed, uint16(len(packed)))
+		copy(prefixed[2:], packed)
+		if _, err := conn.Write(prefixed); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(packed); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = buf[:n]
+	}
+
+	var response dnsmessage.Message
+	if err := response.Unpack(buf); err != nil {
+		return nil, fmt.Errorf("error parsing DNS response: %v", err)
+	}
+	return &response, nil
+}
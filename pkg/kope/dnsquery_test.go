@@ -0,0 +1,138 @@
+package kope
+
+import (
+	"context"
+	"golang.org/x/net/dns/dnsmessage"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// serveDNS answers a single query on a local UDP port with the response built by respond, returning the address
+func serveDNS(t *testing.T, respond func(query *dnsmessage.Message) *dnsmessage.Message) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var query dnsmessage.Message
+		if err := query.Unpack(buf[:n]); err != nil {
+			return
+		}
+		response := respond(&query)
+		response.Header.ID = query.Header.ID
+		response.Header.Response = true
+		response.Questions = query.Questions
+		packed, err := response.Pack()
+		if err != nil {
+			return
+		}
+		conn.WriteTo(packed, addr)
+	}()
+	return conn.LocalAddr().String()
+}
+
+func mustName(s string) dnsmessage.Name {
+	name, err := dnsmessage.NewName(s)
+	if err != nil {
+		panic(err)
+	}
+	return name
+}
+
+func TestQueryDNS(t *testing.T) {
+	header := func(name string, qtype dnsmessage.Type) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: mustName(name), Type: qtype, Class: dnsmessage.ClassINET, TTL: 60}
+	}
+
+	grid := []struct {
+		Name          string
+		Type          string
+		Authoritative bool
+		RCode         dnsmessage.RCode
+		Answers       []dnsmessage.Resource
+		Expected      []string
+		Error         error
+	}{
+		{
+			// Addresses are sorted; answers for other names are ignored; the name matches case-insensitively
+			Name:          "nodes.example.com",
+			Type:          DNSTypeA,
+			Authoritative: true,
+			Answers: []dnsmessage.Resource{
+				{Header: header("NODES.example.com.", dnsmessage.TypeA), Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, 2}}},
+				{Header: header("nodes.example.com.", dnsmessage.TypeA), Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}}},
+				{Header: header("other.example.com.", dnsmessage.TypeA), Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, 3}}},
+			},
+			Expected: []string{"10.0.0.1", "10.0.0.2"},
+		},
+		{
+			// Names in values are lowercased, without the trailing dot
+			Name:          "api.example.com.",
+			Type:          DNSTypeCNAME,
+			Authoritative: true,
+			Answers: []dnsmessage.Resource{
+				{Header: header("api.example.com.", dnsmessage.TypeCNAME), Body: &dnsmessage.CNAMEResource{CNAME: mustName("LB.Example.COM.")}},
+			},
+			Expected: []string{"lb.example.com"},
+		},
+		{
+			Name:          "_etcd-server._tcp.example.com",
+			Type:          DNSTypeSRV,
+			Authoritative: true,
+			Answers: []dnsmessage.Resource{
+				{Header: header("_etcd-server._tcp.example.com.", dnsmessage.TypeSRV), Body: &dnsmessage.SRVResource{Priority: 0, Weight: 10, Port: 2380, Target: mustName("Etcd-A.example.com.")}},
+			},
+			Expected: []string{"0 10 2380 etcd-a.example.com"},
+		},
+		{
+			Name:          "1.0.0.10.in-addr.arpa",
+			Type:          DNSTypePTR,
+			Authoritative: true,
+			Answers: []dnsmessage.Resource{
+				{Header: header("1.0.0.10.in-addr.arpa.", dnsmessage.TypePTR), Body: &dnsmessage.PTRResource{PTR: mustName("node1.example.com.")}},
+			},
+			Expected: []string{"node1.example.com"},
+		},
+		{
+			Name:          "missing.example.com",
+			Type:          DNSTypeA,
+			Authoritative: true,
+			RCode:         dnsmessage.RCodeNameError,
+		},
+		{
+			Name:  "delegated.example.com",
+			Type:  DNSTypeA,
+			Error: ErrNotAuthoritative,
+		},
+	}
+	for _, g := range grid {
+		server := serveDNS(t, func(query *dnsmessage.Message) *dnsmessage.Message {
+			return &dnsmessage.Message{
+				Header:  dnsmessage.Header{Authoritative: g.Authoritative, RCode: g.RCode},
+				Answers: g.Answers,
+			}
+		})
+		values, err := QueryDNS(context.Background(), server, g.Name, g.Type)
+		if err != g.Error {
+			t.Errorf("QueryDNS(%s %s): got error %v, expected %v", g.Name, g.Type, err, g.Error)
+			continue
+		}
+		if !reflect.DeepEqual(values, g.Expected) {
+			t.Errorf("QueryDNS(%s %s): got %q, expected %q", g.Name, g.Type, values, g.Expected)
+		}
+	}
+}
+
+func TestQueryDNSUnsupportedType(t *testing.T) {
+	if _, err := QueryDNS(context.Background(), "127.0.0.1", "example.com", "MX"); err == nil {
+		t.Errorf("expected error querying an unsupported type")
+	}
+}
@@ -0,0 +1,190 @@
+package fakecloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"sort"
+	"sync"
+)
+
+// FakeCloud is an in-memory kope.Cloud, for exercising controllers without a real cloud.  Instances are
+// copied in and out, so callers cannot change its state except through the Cloud methods.
+type FakeCloud struct {
+	clusterID string
+
+	// mutex protects all the following fields
+	mutex     sync.Mutex
+	instances map[string]*kope.Instance
+	// terminationProtection holds the instances protected from termination
+	terminationProtection map[string]bool
+	// reboots counts the reboots of each instance
+	reboots map[string]int
+}
+
+var _ kope.Cloud = &FakeCloud{}
+
+func NewFakeCloud(clusterID string) *FakeCloud {
+	return &FakeCloud{
+		clusterID:             clusterID,
+		instances:             make(map[string]*kope.Instance),
+		terminationProtection: make(map[string]bool),
+		reboots:               make(map[string]int),
+	}
+}
+
+// AddInstance adds (or replaces) an instance
+func (c *FakeCloud) AddInstance(instance *kope.Instance) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.instances[instance.ID] = copyInstance(instance)
+}
+
+// Instance returns the instance, or nil if it does not exist
+func (c *FakeCloud) Instance(instanceID string) *kope.Instance {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	i := c.instances[instanceID]
+	if i == nil {
+		return nil
+	}
+	return copyInstance(i)
+}
+
+// TerminationProtection returns true if the instance is protected from termination
+func (c *FakeCloud) TerminationProtection(instanceID string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.terminationProtection[instanceID]
+}
+
+// Reboots returns the number of times the instance has been rebooted
+func (c *FakeCloud) Reboots(instanceID string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.reboots[instanceID]
+}
+
+func (c *FakeCloud) ClusterID() string {
+	return c.clusterID
+}
+
+// ListInstances returns the instances, sorted by id
+func (c *FakeCloud) ListInstances(ctx context.Context) ([]*kope.Instance, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var instances []*kope.Instance
+	for _, i := range c.instances {
+		instances = append(instances, copyInstance(i))
+	}
+	sort.Slice(instances, func(a, b int) bool {
+		return instances[a].ID < instances[b].ID
+	})
+	return instances, nil
+}
+
+func (c *FakeCloud) SetInstanceIPForwarding(ctx context.Context, instanceID string, enabled bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	i, err := c.findInstance(instanceID)
+	if err != nil {
+		return err
+	}
+	i.IPForwarding = enabled
+	return nil
+}
+
+func (c *FakeCloud) SetInstanceTerminationProtection(ctx context.Context, instanceID string, protect bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, err := c.findInstance(instanceID); err != nil {
+		return err
+	}
+	c.terminationProtection[instanceID] = protect
+	return nil
+}
+
+func (c *FakeCloud) TagInstance(ctx context.Context, instanceID string, tags map[string]string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	i, err := c.findInstance(instanceID)
+	if err != nil {
+		return err
+	}
+	for k, v := range tags {
+		i.Tags[k] = v
+	}
+	return nil
+}
+
+func (c *FakeCloud) UntagInstance(ctx context.Context, instanceID string, keys []string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	i, err := c.findInstance(instanceID)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		delete(i.Tags, k)
+	}
+	return nil
+}
+
+func (c *FakeCloud) RebootInstance(ctx context.Context, instanceID string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	i, err := c.findInstance(instanceID)
+	if err != nil {
+		return err
+	}
+	if i.State != kope.InstanceStateRunning {
+		return fmt.Errorf("cannot reboot instance %q in state %q", instanceID, i.State)
+	}
+	c.reboots[instanceID]++
+	return nil
+}
+
+// TerminateInstance marks the instance terminated; as on EC2, it remains listed
+func (c *FakeCloud) TerminateInstance(ctx context.Context, instanceID string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	i, err := c.findInstance(instanceID)
+	if err != nil {
+		return err
+	}
+	if c.terminationProtection[instanceID] {
+		return fmt.Errorf("instance %q is protected from termination", instanceID)
+	}
+	i.State = kope.InstanceStateTerminated
+	return nil
+}
+
+// findInstance returns the instance, or an error if it does not exist; the caller must hold the mutex
+func (c *FakeCloud) findInstance(instanceID string) (*kope.Instance, error) {
+	i := c.instances[instanceID]
+	if i == nil {
+		return nil, fmt.Errorf("instance %q not found", instanceID)
+	}
+	return i, nil
+}
+
+// copyInstance returns a deep copy of the instance
+func copyInstance(i *kope.Instance) *kope.Instance {
+	c := *i
+	c.Tags = make(map[string]string)
+	for k, v := range i.Tags {
+		c.Tags[k] = v
+	}
+	return &c
+}
@@ -0,0 +1,69 @@
+package fakecloud
+
+import (
+	"context"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"sync"
+)
+
+// FakeDNSProvider is an in-memory kope.OwnedDNSProvider, for exercising DNS publishing without a real zone.
+// Every record set it holds is ours.
+type FakeDNSProvider struct {
+	// mutex protects records
+	mutex   sync.Mutex
+	records map[kope.DNSRecordKey][]string
+}
+
+var _ kope.OwnedDNSProvider = &FakeDNSProvider{}
+
+func NewFakeDNSProvider() *FakeDNSProvider {
+	return &FakeDNSProvider{
+		records: make(map[kope.DNSRecordKey][]string),
+	}
+}
+
+// Records returns a copy of the record sets
+func (p *FakeDNSProvider) Records() map[kope.DNSRecordKey][]string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return copyRecords(p.records)
+}
+
+// ApplyDNSChanges creates or replaces each record set with the values; an empty record set removes it
+func (p *FakeDNSProvider) ApplyDNSChanges(ctx context.Context, records map[kope.DNSRecordKey][]string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for k, v := range records {
+		if len(v) == 0 {
+			delete(p.records, k)
+			continue
+		}
+		p.records[k] = append([]string(nil), v...)
+	}
+	return nil
+}
+
+func (p *FakeDNSProvider) ListOwnedDNSRecords(ctx context.Context) (map[kope.DNSRecordKey][]string, error) {
+	return p.Records(), nil
+}
+
+func (p *FakeDNSProvider) DeleteDNSRecords(ctx context.Context, keys []kope.DNSRecordKey) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, k := range keys {
+		delete(p.records, k)
+	}
+	return nil
+}
+
+// copyRecords returns a deep copy of the record sets
+func copyRecords(records map[kope.DNSRecordKey][]string) map[kope.DNSRecordKey][]string {
+	c := make(map[kope.DNSRecordKey][]string)
+	for k, v := range records {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
@@ -0,0 +1,65 @@
+package kope
+
+import (
+	"sync"
+	"time"
+)
+
+// SyncHealth records the results of a controller's resyncs, for health checks and metrics.
+// The zero value is ready to use.
+type SyncHealth struct {
+	mutex  sync.Mutex
+	status SyncStatus
+}
+
+// SyncStatus summarizes the results of a controller's resyncs
+type SyncStatus struct {
+	LastSuccessTime time.Time `json:"lastSuccessTime,omitempty"`
+	LastFailureTime time.Time `json:"lastFailureTime,omitempty"`
+	// LastError is the error of the most recent resync, cleared on success
+	LastError string `json:"lastError,omitempty"`
+
+	// ConsecutiveFailures is the number of resyncs which have failed since the last success
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+
+	Successes int64 `json:"successes"`
+	Failures  int64 `json:"failures"`
+
+	// Warnings describe problems the controller cannot fix, which don't fail its resyncs
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Record records the result of a resync
+func (h *SyncHealth) Record(err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if err != nil {
+		h.status.LastFailureTime = time.Now()
+		h.status.LastError = err.Error()
+		h.status.ConsecutiveFailures++
+		h.status.Failures++
+	} else {
+		h.status.LastSuccessTime = time.Now()
+		h.status.LastError = ""
+		h.status.ConsecutiveFailures = 0
+		h.status.Successes++
+	}
+}
+
+// SetWarnings replaces the warnings reported in the status
+func (h *SyncHealth) SetWarnings(warnings []string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.status.Warnings = warnings
+}
+
+// Status returns a snapshot of the resync results
+func (h *SyncHealth) Status() *SyncStatus {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	status := h.status
+	return &status
+}
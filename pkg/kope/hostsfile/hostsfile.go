@@ -0,0 +1,251 @@
+package hostsfile
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/utils"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// HostsFileProviderName is the name under which RegisterHostsFileProvider registers the hosts-file provider
+const HostsFileProviderName = "hosts-file"
+
+// HostsFileProvider publishes A records to a block of an /etc/hosts-format file, for nodes or jump hosts
+// without dynamic DNS.  The block is delimited by marker comments naming the zone and the owner, so several
+// controllers (or zones) can share a file, and the rest of the file is left untouched.  Everything in the
+// block is ours, so the provider is an OwnedDNSProvider whether or not an owner id is set.  A hosts file can
+// only express addresses, so record sets of other types are skipped, and routing policies are rejected.
+//
+// The file is replaced atomically, unless it is a mount point (as /etc/hosts is in a container), which
+// must be rewritten in place.
+type HostsFileProvider struct {
+	path     string
+	zoneName string
+	ownerID  string
+	// pause, if set, refuses changes to the file while mutations are paused
+	pause *kope.PauseSwitch
+
+	// mutex serializes updates of the file, by all the providers sharing it
+	mutex *sync.Mutex
+}
+
+var _ kope.OwnedDNSProvider = &HostsFileProvider{}
+
+// RegisterHostsFileProvider registers the hosts-file provider, writing to the file at path
+func RegisterHostsFileProvider(path string) {
+	kope.RegisterDNSProvider(HostsFileProviderName, func(options kope.DNSProviderOptions) (kope.DNSProvider, error) {
+		return NewHostsFileProvider(path, options.ZoneName, options.OwnerID, options.Pause)
+	})
+}
+
+func NewHostsFileProvider(path string, zoneName string, ownerID string, pause *kope.PauseSwitch) (*HostsFileProvider, error) {
+	if path == "" {
+		return nil, fmt.Errorf("the path of the hosts file is required")
+	}
+	return &HostsFileProvider{
+		path:     path,
+		zoneName: strings.TrimSuffix(zoneName, "."),
+		ownerID:  ownerID,
+		pause:    pause,
+		mutex:    utils.FileMutex(path),
+	}, nil
+}
+
+// beginMarker and endMarker delimit our block of the file
+func (p *HostsFileProvider) beginMarker() string {
+	return "# BEGIN aws-controller records for " + p.blockName()
+}
+
+func (p *HostsFileProvider) endMarker() string {
+	return "# END aws-controller records for " + p.blockName()
+}
+
+// blockName identifies our block: the zone, and our owner id if set
+func (p *HostsFileProvider) blockName() string {
+	if p.ownerID == "" {
+		return p.zoneName
+	}
+	return p.zoneName + " owned by " + p.ownerID
+}
+
+// hostsFile is the content of the file, split around our block
+type hostsFile struct {
+	before []string
+	after  []string
+	// records holds the addresses of each name in our block
+	records map[string][]string
+}
+
+// read reads and parses the file; a missing file is treated as empty
+func (p *HostsFileProvider) read() (*hostsFile, error) {
+	f := &hostsFile{records: make(map[string][]string)}
+
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return f, nil
+		}
+		return nil, fmt.Errorf("error reading hosts file %q: %v", p.path, err)
+	}
+
+	inBlock, seenBlock := false, false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case !seenBlock && strings.TrimSpace(line) == p.beginMarker():
+			inBlock, seenBlock = true, true
+		case inBlock && strings.TrimSpace(line) == p.endMarker():
+			inBlock = false
+		case inBlock:
+			fields := strings.Fields(line)
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			for _, name := range fields[1:] {
+				f.records[name] = append(f.records[name], fields[0])
+			}
+		case seenBlock:
+			f.after = append(f.after, line)
+		default:
+			f.before = append(f.before, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading hosts file %q: %v", p.path, err)
+	}
+	if inBlock {
+		return nil, fmt.Errorf("hosts file %q has no %q line to end our block", p.path, p.endMarker())
+	}
+	return f, nil
+}
+
+// write replaces the file, preserving its permissions
+func (p *HostsFileProvider) write(f *hostsFile) error {
+	var b bytes.Buffer
+	for _, line := range f.before {
+		b.WriteString(line + "\n")
+	}
+
+	var names []string
+	for name := range f.records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	b.WriteString(p.beginMarker() + "\n")
+	for _, name := range names {
+		for _, ip := range f.records[name] {
+			b.WriteString(ip + "\t" + name + "\n")
+		}
+	}
+	b.WriteString(p.endMarker() + "\n")
+
+	for _, line := range f.after {
+		b.WriteString(line + "\n")
+	}
+
+	if err := utils.WriteFileInPlaceIfBusy(p.path, b.Bytes()); err != nil {
+		return fmt.Errorf("error writing hosts file %q: %v", p.path, err)
+	}
+	return nil
+}
+
+// update applies mutate to the records in our block, rewriting the file if they changed
+func (p *HostsFileProvider) update(mutate func(records map[string][]string) error) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	f, err := p.read()
+	if err != nil {
+		return err
+	}
+	before := fmt.Sprint(f.records)
+	if err := mutate(f.records); err != nil {
+		return err
+	}
+	if fmt.Sprint(f.records) == before {
+		return nil
+	}
+	if p.pause.Paused() {
+		return kope.ErrPaused
+	}
+
+	glog.V(2).Infof("Writing %d names to hosts file %q", len(f.records), p.path)
+	return p.write(f)
+}
+
+// ApplyDNSChanges creates or replaces each record set with the values; an empty record set removes the name
+func (p *HostsFileProvider) ApplyDNSChanges(ctx context.Context, records map[kope.DNSRecordKey][]string) error {
+	for key, values := range records {
+		if key.SetIdentifier != "" || key.Failover != "" || key.Region != "" || key.MultiValue || key.HealthCheckPort != 0 || key.AliasHostedZoneID != "" {
+			return fmt.Errorf("cannot publish %s: a hosts file does not support routing policies, health checks or aliases", key)
+		}
+		for _, value := range values {
+			if key.Type == kope.DNSTypeA && net.ParseIP(value) == nil {
+				return fmt.Errorf("invalid address %q for %s", value, key)
+			}
+		}
+	}
+
+	return p.update(func(current map[string][]string) error {
+		for key, values := range records {
+			if key.Type != kope.DNSTypeA {
+				glog.V(2).Infof("Skipping %s: a hosts file can only hold addresses", key)
+				continue
+			}
+			name := normalizeName(key.Name)
+			if len(values) == 0 {
+				delete(current, name)
+				continue
+			}
+			ips := append([]string(nil), values...)
+			sort.Strings(ips)
+			current[name] = ips
+		}
+		return nil
+	})
+}
+
+// ListOwnedDNSRecords returns the A record sets in our block of the file
+func (p *HostsFileProvider) ListOwnedDNSRecords(ctx context.Context) (map[kope.DNSRecordKey][]string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	f, err := p.read()
+	if err != nil {
+		return nil, err
+	}
+	records := make(map[kope.DNSRecordKey][]string)
+	for name, ips := range f.records {
+		sort.Strings(ips)
+		records[kope.ARecord(name)] = ips
+	}
+	return records, nil
+}
+
+// DeleteDNSRecords removes the record sets from our block of the file; record sets which don't exist are ignored
+func (p *HostsFileProvider) DeleteDNSRecords(ctx context.Context, keys []kope.DNSRecordKey) error {
+	return p.update(func(current map[string][]string) error {
+		for _, key := range keys {
+			if key.Type != kope.DNSTypeA {
+				continue
+			}
+			delete(current, normalizeName(key.Name))
+		}
+		return nil
+	})
+}
+
+// normalizeName returns the name as it appears in the file: lowercase, without a trailing dot
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
@@ -0,0 +1,91 @@
+package kopeaws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+)
+
+// TagNameEIPPool marks the elastic IPs in a pool; the value is the name of the pool
+const TagNameEIPPool = "k8s.io/eip-pool"
+
+// Address is an elastic IP belonging to the cluster
+type Address struct {
+	AllocationID string
+	PublicIP     string
+	Region       string
+	// AssociationID and InstanceID are set if the address is associated
+	AssociationID string
+	InstanceID    string
+}
+
+// ListPoolAddresses returns the elastic IPs in the named pool, in all our regions
+func (a *AWSCloud) ListPoolAddresses(ctx context.Context, pool string) ([]*Address, error) {
+	var addresses []*Address
+	for _, region := range a.Regions() {
+		request := &ec2.DescribeAddressesInput{
+			Filters: []*ec2.Filter{
+				newEc2Filter("tag:"+TagNameKubernetesCluster, a.clusterID),
+				newEc2Filter("tag:"+TagNameEIPPool, pool),
+			},
+		}
+
+		callCtx, cancel := withTimeout(ctx)
+		response, err := a.regions[region].DescribeAddressesWithContext(callCtx, request)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("error describing addresses in %s: %v", region, err)
+		}
+
+		for _, address := range response.Addresses {
+			addresses = append(addresses, &Address{
+				AllocationID:  aws.StringValue(address.AllocationId),
+				PublicIP:      aws.StringValue(address.PublicIp),
+				Region:        region,
+				AssociationID: aws.StringValue(address.AssociationId),
+				InstanceID:    aws.StringValue(address.InstanceId),
+			})
+		}
+	}
+	return addresses, nil
+}
+
+// AssociateAddress associates an elastic IP with an instance; it fails if the address is already associated
+func (a *AWSCloud) AssociateAddress(ctx context.Context, address *Address, instanceID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Associating address %s with instance %q", address.PublicIP, instanceID)
+
+	request := &ec2.AssociateAddressInput{
+		AllocationId:       aws.String(address.AllocationID),
+		InstanceId:         aws.String(instanceID),
+		AllowReassociation: aws.Bool(false),
+	}
+
+	_, err := a.regions[address.Region].AssociateAddressWithContext(ctx, request)
+	if err != nil {
+		return fmt.Errorf("error associating address %s with instance %q: %v", address.PublicIP, instanceID, err)
+	}
+	return nil
+}
+
+// DisassociateAddress disassociates an elastic IP from its instance
+func (a *AWSCloud) DisassociateAddress(ctx context.Context, address *Address) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Disassociating address %s from instance %q", address.PublicIP, address.InstanceID)
+
+	request := &ec2.DisassociateAddressInput{
+		AssociationId: aws.String(address.AssociationID),
+	}
+
+	_, err := a.regions[address.Region].DisassociateAddressWithContext(ctx, request)
+	if err != nil {
+		return fmt.Errorf("error disassociating address %s: %v", address.PublicIP, err)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package kopeaws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"strings"
+)
+
+// Prefixes of alias targets which are not ARNs
+const (
+	aliasTargetTagPrefix = "tag:"
+	aliasTargetELBPrefix = "elb:"
+)
+
+// DescribeTags accepts at most 20 ELBv2 resource ARNs per call
+const maxELBV2DescribeTags = 20
+
+// AliasTarget is a load balancer which a Route53 alias record can point at
+type AliasTarget struct {
+	// DNSName is the DNS name of the load balancer
+	DNSName string
+	// HostedZoneID is the id of the load balancer's canonical hosted zone (not our zone)
+	HostedZoneID string
+}
+
+// Record returns the key and value of an alias A record set for name pointing at the target
+func (t *AliasTarget) Record(name string) (kope.DNSRecordKey, []string) {
+	key := kope.ARecord(name)
+	key.AliasHostedZoneID = t.HostedZoneID
+	return key, []string{t.DNSName}
+}
+
+// LoadBalancerAliasTarget returns the alias target for an ELBv2 load balancer
+func LoadBalancerAliasTarget(lb *elbv2.LoadBalancer) *AliasTarget {
+	return &AliasTarget{
+		DNSName:      aws.StringValue(lb.DNSName),
+		HostedZoneID: aws.StringValue(lb.CanonicalHostedZoneId),
+	}
+}
+
+// ValidateAliasTarget checks the syntax of an alias target, as accepted by FindAliasTarget
+func ValidateAliasTarget(target string) error {
+	switch {
+	case strings.HasPrefix(target, "arn:"):
+		return nil
+	case strings.HasPrefix(target, aliasTargetTagPrefix):
+		if tokens := strings.SplitN(strings.TrimPrefix(target, aliasTargetTagPrefix), "=", 2); len(tokens) != 2 || tokens[0] == "" {
+			return fmt.Errorf("expected tag:<key>=<value>, got %q", target)
+		}
+		return nil
+	case strings.HasPrefix(target, aliasTargetELBPrefix):
+		if strings.TrimPrefix(target, aliasTargetELBPrefix) == "" {
+			return fmt.Errorf("expected elb:<name>, got %q", target)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown alias target %q; expected a load balancer ARN, tag:<key>=<value> or elb:<name>", target)
+}
+
+// FindAliasTarget finds the load balancer identified by target, which is the ARN of an ELBv2 load balancer,
+// tag:<key>=<value> to select an ELBv2 load balancer in our region by tag, or elb:<name> for a classic
+// ELB in our region.  It returns nil if no load balancer matches.
+func (a *AWSCloud) FindAliasTarget(ctx context.Context, target string) (*AliasTarget, error) {
+	if err := ValidateAliasTarget(target); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasPrefix(target, aliasTargetTagPrefix):
+		tokens := strings.SplitN(strings.TrimPrefix(target, aliasTargetTagPrefix), "=", 2)
+		lb, err := a.findLoadBalancerByTag(ctx, tokens[0], tokens[1])
+		if err != nil || lb == nil {
+			return nil, err
+		}
+		return LoadBalancerAliasTarget(lb), nil
+
+	case strings.HasPrefix(target, aliasTargetELBPrefix):
+		return a.findClassicLoadBalancerAliasTarget(ctx, strings.TrimPrefix(target, aliasTargetELBPrefix))
+
+	default:
+		lb, err := a.findLoadBalancerByARN(ctx, target)
+		if err != nil || lb == nil {
+			return nil, err
+		}
+		return LoadBalancerAliasTarget(lb), nil
+	}
+}
+
+// findLoadBalancerByARN returns the ELBv2 load balancer, or nil if it does not exist
+func (a *AWSCloud) findLoadBalancerByARN(ctx context.Context, loadBalancerARN string) (*elbv2.LoadBalancer, error) {
+	client, err := a.elbv2ForARN(loadBalancerARN)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	request := &elbv2.DescribeLoadBalancersInput{
+		LoadBalancerArns: []*string{aws.String(loadBalancerARN)},
+	}
+
+	response, err := client.DescribeLoadBalancersWithContext(ctx, request)
+	if err != nil {
+		if AWSErrorCode(err) == elbv2.ErrCodeLoadBalancerNotFoundException {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error describing load balancer %q: %v", loadBalancerARN, err)
+	}
+	if len(response.LoadBalancers) == 0 {
+		return nil, nil
+	}
+	return response.LoadBalancers[0], nil
+}
+
+// findLoadBalancerByTag returns the ELBv2 load balancer in our region with the tag, or nil if there is none.
+// It is an error for more than one load balancer to match.
+func (a *AWSCloud) findLoadBalancerByTag(ctx context.Context, key string, value string) (*elbv2.LoadBalancer, error) {
+	client := a.elbv2(a.region)
+
+	all := make(map[string]*elbv2.LoadBalancer)
+	var arns []*string
+
+	callCtx, cancel := withTimeout(ctx)
+	err := client.DescribeLoadBalancersPagesWithContext(callCtx, &elbv2.DescribeLoadBalancersInput{}, func(p *elbv2.DescribeLoadBalancersOutput, lastPage bool) bool {
+		for _, lb := range p.LoadBalancers {
+			all[aws.StringValue(lb.LoadBalancerArn)] = lb
+			arns = append(arns, lb.LoadBalancerArn)
+		}
+		return true
+	})
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("error describing load balancers in %s: %v", a.region, err)
+	}
+
+	// Tags are not returned by DescribeLoadBalancers, so we must filter afterwards
+	var found *elbv2.LoadBalancer
+	for start := 0; start < len(arns); start += maxELBV2DescribeTags {
+		end := start + maxELBV2DescribeTags
+		if end > len(arns) {
+			end = len(arns)
+		}
+
+		callCtx, cancel := withTimeout(ctx)
+		response, err := client.DescribeTagsWithContext(callCtx, &elbv2.DescribeTagsInput{ResourceArns: arns[start:end]})
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("error describing load balancer tags in %s: %v", a.region, err)
+		}
+
+		for _, d := range response.TagDescriptions {
+			for _, tag := range d.Tags {
+				if aws.StringValue(tag.Key) != key || aws.StringValue(tag.Value) != value {
+					continue
+				}
+				lb := all[aws.StringValue(d.ResourceArn)]
+				if found != nil && lb != nil {
+					return nil, fmt.Errorf("found multiple load balancers with tag %s=%s: %q and %q", key, value, aws.StringValue(found.LoadBalancerArn), aws.StringValue(lb.LoadBalancerArn))
+				}
+				if lb != nil {
+					found = lb
+				}
+			}
+		}
+	}
+	return found, nil
+}
+
+// findClassicLoadBalancerAliasTarget returns the alias target for the named classic ELB in our region, or nil if it does not exist
+func (a *AWSCloud) findClassicLoadBalancerAliasTarget(ctx context.Context, name string) (*AliasTarget, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	request := &elb.DescribeLoadBalancersInput{
+		LoadBalancerNames: []*string{aws.String(name)},
+	}
+
+	response, err := a.elb(a.region).DescribeLoadBalancersWithContext(ctx, request)
+	if err != nil {
+		if AWSErrorCode(err) == elb.ErrCodeAccessPointNotFoundException {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error describing load balancer %q: %v", name, err)
+	}
+	if len(response.LoadBalancerDescriptions) == 0 {
+		return nil, nil
+	}
+	lb := response.LoadBalancerDescriptions[0]
+	return &AliasTarget{
+		DNSName:      aws.StringValue(lb.DNSName),
+		HostedZoneID: aws.StringValue(lb.CanonicalHostedZoneNameID),
+	}, nil
+}
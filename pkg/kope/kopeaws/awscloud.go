@@ -1,95 +1,313 @@
 package kopeaws
 
 import (
+	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
-	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
 	"github.com/kopeio/aws-controller/pkg/kope"
 	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
 )
 
 // The tag name we use to differentiate multiple logically independent clusters running in the same region
 const TagNameKubernetesCluster = "KubernetesCluster"
 
-// Set to expose the public IP of this instance via DNS
+// Set to expose the public IP of this instance via DNS.  The values of the DNS tags may be templates
+// expanded against the instance, e.g. {{.InstanceID}}.nodes.example.com or {{.AZ}}.workers.example.com, and
+// may set the routing of the instance's record after the name, e.g. api.example.com;policy=weighted;weight=10
+// or api.example.com;policy=failover;role=primary (options: policy, weight, role, set)
 const TagNameKubernetesDnsPublic = "k8s.io/dns/public"
 
 // Set to expose the internal IP of this instance via DNS
 const TagNameKubernetesDnsInternal = "k8s.io/dns/internal"
 
+// Set to expose this instance under a wildcard name (e.g. *.apps.example.com for an ingress node), with its
+// public IP, or its internal IP if it has none; the leading "*." may be omitted
+const TagNameKubernetesDnsWildcard = "k8s.io/dns/wildcard"
+
+// Set to publish the public IP of this instance under the name in the public zone, and its internal IP under
+// the same name in the private zone (split-horizon); the private record requires a private zone to be configured
+const TagNameKubernetesDnsSplitHorizon = "k8s.io/dns/split-horizon"
+
+// Set to publish the public IP of this instance as the primary (or secondary) health-checked failover record for the name
+const TagNameKubernetesDnsFailoverPrimary = "k8s.io/dns/failover-primary"
+const TagNameKubernetesDnsFailoverSecondary = "k8s.io/dns/failover-secondary"
+
+// Set while an instance is being drained (e.g. ahead of a spot interruption), to withdraw it from DNS
+const TagNameDraining = "k8s.io/aws-controller/draining"
+
+// Records the launch index of an instance (its number within its role and zone, for name templates), so
+// that it keeps its number across restarts
+const TagNameLaunchIndex = "k8s.io/aws-controller/launch-index"
+
+// defaultAPITimeout bounds each AWS API call, so that a hung call cannot stall the reconcile loop
+var defaultAPITimeout = time.Minute
+
 type AWSCloud struct {
+	// session is used to build the clients for the other services we use
+	session *session.Session
+
+	// ec2 is the client for our own region; regions holds the clients for every region we manage
 	ec2      *ec2.EC2
+	regions  map[string]*ec2.EC2
 	metadata *ec2metadata.EC2Metadata
 
+	// instanceRegionsMutex protects instanceRegions
+	instanceRegionsMutex sync.Mutex
+	// instanceRegions records the region of each instance found by DescribeInstances
+	instanceRegions map[string]string
+
+	region     string
 	zone       string
 	instanceID string
 
-	self       *ec2.Instance
-	clusterID  string
+	// self is the instance we are running on, or nil if we are running outside EC2
+	self      *ec2.Instance
+	clusterID string
+
+	// filterTagsMutex protects filterTags, which can be changed by configuration reloads
+	filterTagsMutex sync.Mutex
+	filterTags      map[string]string
+
+	vpcID      string
 	internalIP net.IP
 }
 
 var _ kope.Cloud = &AWSCloud{}
 
-func NewAWSCloud() (*AWSCloud, error) {
-	a := &AWSCloud{}
+// AWSCloudOptions configures NewAWSCloud
+type AWSCloudOptions struct {
+	// Region is the AWS region to manage.  If set, the metadata service is not queried,
+	// so that we can run outside EC2; ClusterID must then also be set.
+	Region string
 
-	s := session.New()
-	s.Handlers.Send.PushFront(func(r *request.Request) {
-		// Log requests
-		glog.V(4).Infof("AWS API Request: %s/%s", r.ClientInfo.ServiceName, r.Operation.Name)
-	})
+	// Regions lists the regions whose instances are managed; if empty only our own region is managed
+	Regions []string
 
-	config := aws.NewConfig()
-	a.metadata = ec2metadata.New(s, config)
+	// ClusterID is the value of the KubernetesCluster tag; if empty it is read from the tags on this instance
+	ClusterID string
 
-	region, err := a.metadata.Region()
-	if err != nil {
-		return nil, fmt.Errorf("error querying ec2 metadata service (for az/region): %v", err)
+	// FilterTags restricts management to instances with all these tags (in addition to the cluster tag)
+	FilterTags map[string]string
+
+	// VPCID restricts management to instances in the VPC.  If empty, it defaults to the VPC
+	// of the instance we are running on (when managing only our own region), unless AllVPCs is set.
+	VPCID string
+	// AllVPCs disables the default restriction to our own VPC
+	AllVPCs bool
+
+	// Session configures the credentials to use
+	Session SessionOptions
+
+	// EC2Endpoint and MetadataEndpoint override the default service endpoints, if set
+	EC2Endpoint      string
+	MetadataEndpoint string
+
+	// EC2RateLimit limits the rate of EC2 API requests
+	EC2RateLimit RateLimit
+}
+
+func NewAWSCloud(ctx context.Context, options AWSCloudOptions) (*AWSCloud, error) {
+	a := &AWSCloud{
+		clusterID:       options.ClusterID,
+		filterTags:      options.FilterTags,
+		vpcID:           options.VPCID,
+		regions:         make(map[string]*ec2.EC2),
+		instanceRegions: make(map[string]string),
 	}
 
-	a.zone, err = a.metadata.GetMetadata("placement/availability-zone")
+	s, err := newSession(options.Session)
 	if err != nil {
-		return nil, fmt.Errorf("error querying ec2 metadata service (for az): %v", err)
+		return nil, err
 	}
 
-	a.instanceID, err = a.metadata.GetMetadata("instance-id")
-	if err != nil {
-		return nil, fmt.Errorf("error querying ec2 metadata service (for instance-id): %v", err)
+	region := options.Region
+	if region != "" {
+		// Running outside EC2; cluster membership is determined purely from tags
+		if a.clusterID == "" {
+			return nil, fmt.Errorf("cluster id must be specified when region is specified")
+		}
+		glog.Infof("Region specified; not querying ec2 metadata service")
+	} else {
+		metadataConfig := aws.NewConfig()
+		if options.MetadataEndpoint != "" {
+			metadataConfig = metadataConfig.WithEndpoint(options.MetadataEndpoint)
+		}
+		a.metadata = ec2metadata.New(s, metadataConfig)
+
+		metadataCtx, cancel := withTimeout(ctx)
+		defer cancel()
+
+		region, err = a.metadata.RegionWithContext(metadataCtx)
+		if err != nil {
+			return nil, fmt.Errorf("error querying ec2 metadata service (for az/region): %v", err)
+		}
+
+		a.zone, err = a.metadata.GetMetadataWithContext(metadataCtx, "placement/availability-zone")
+		if err != nil {
+			return nil, fmt.Errorf("error querying ec2 metadata service (for az): %v", err)
+		}
+
+		a.instanceID, err = a.metadata.GetMetadataWithContext(metadataCtx, "instance-id")
+		if err != nil {
+			return nil, fmt.Errorf("error querying ec2 metadata service (for instance-id): %v", err)
+		}
 	}
 
-	a.ec2 = ec2.New(s, config.WithRegion(region))
+	a.region = region
+	glog.Infof("Using region %q in partition %q", region, PartitionForRegion(region))
 
-	err = a.getSelfInstance()
-	if err != nil {
-		return nil, err
+	a.session = s
+	a.ec2 = newEC2Client(s, region, options)
+	a.regions[region] = a.ec2
+
+	for _, r := range options.Regions {
+		if a.regions[r] != nil {
+			continue
+		}
+		if PartitionForRegion(r) != PartitionForRegion(region) {
+			return nil, fmt.Errorf("region %q is not in the same partition as region %q", r, region)
+		}
+		a.regions[r] = newEC2Client(s, r, options)
+	}
+	if len(options.Regions) != 0 && !containsString(options.Regions, region) {
+		// Our own region is used for lookups, but its instances are not managed
+		delete(a.regions, region)
+	}
+
+	if a.vpcID != "" && len(a.regions) > 1 {
+		return nil, fmt.Errorf("vpc id cannot be specified when managing multiple regions")
+	}
+
+	if a.instanceID != "" {
+		err = a.getSelfInstance(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if a.vpcID == "" && !options.AllVPCs && a.self != nil && len(a.regions) == 1 && a.regions[a.region] != nil {
+		// Clusters can reuse the same cluster tag across VPCs; don't cross-manage their instances
+		a.vpcID = aws.StringValue(a.self.VpcId)
+		glog.Infof("Only managing instances in our own VPC %q", a.vpcID)
 	}
 
 	return a, nil
 }
 
+func newEC2Client(s *session.Session, region string, options AWSCloudOptions) *ec2.EC2 {
+	config := aws.NewConfig().WithRegion(region)
+	if options.EC2Endpoint != "" {
+		glog.Infof("Using EC2 endpoint %q", options.EC2Endpoint)
+		config = config.WithEndpoint(options.EC2Endpoint)
+	}
+
+	client := ec2.New(s, config)
+	addRateLimiter(&client.Handlers, "EC2 "+region, options.EC2RateLimit)
+	return client
+}
+
 func (a *AWSCloud) ClusterID() string {
 	return a.clusterID
 }
 
-func (a *AWSCloud) getSelfInstance() error {
-	instance, err := a.describeInstance(a.instanceID)
+// VPCID returns the VPC we manage instances in, or empty if we are not restricted to one
+func (a *AWSCloud) VPCID() string {
+	return a.vpcID
+}
+
+// InstanceID returns the id of the instance we are running on, or "" if we are running outside EC2
+func (a *AWSCloud) InstanceID() string {
+	return a.instanceID
+}
+
+// Self returns the instance we are running on, or nil if we are running outside EC2
+func (a *AWSCloud) Self() *ec2.Instance {
+	return a.self
+}
+
+// GetMetadata queries the instance metadata service for path, returning false if it was not found
+func (a *AWSCloud) GetMetadata(ctx context.Context, path string) (string, bool, error) {
+	if a.metadata == nil {
+		return "", false, fmt.Errorf("ec2 metadata service is not available when running outside EC2")
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	value, err := a.metadata.GetMetadataWithContext(ctx, path)
+	if err != nil {
+		if requestFailure, ok := err.(awserr.RequestFailure); ok && requestFailure.StatusCode() == http.StatusNotFound {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("error querying ec2 metadata service for %q: %v", path, err)
+	}
+	return value, true, nil
+}
+
+// Region returns the region we are managing
+func (a *AWSCloud) Region() string {
+	return a.region
+}
+
+// Regions returns the regions whose instances we manage
+func (a *AWSCloud) Regions() []string {
+	var regions []string
+	for r := range a.regions {
+		regions = append(regions, r)
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+// InstanceRegion returns the region of an instance found by DescribeInstances
+func (a *AWSCloud) InstanceRegion(instanceID string) string {
+	a.instanceRegionsMutex.Lock()
+	defer a.instanceRegionsMutex.Unlock()
+
+	if r := a.instanceRegions[instanceID]; r != "" {
+		return r
+	}
+	return a.region
+}
+
+// ec2ForInstance returns the client for the region of an instance found by DescribeInstances
+func (a *AWSCloud) ec2ForInstance(instanceID string) *ec2.EC2 {
+	if client := a.regions[a.InstanceRegion(instanceID)]; client != nil {
+		return client
+	}
+	return a.ec2
+}
+
+// Partition returns the id of the AWS partition containing our region, for use when constructing ARNs
+func (a *AWSCloud) Partition() string {
+	return PartitionForRegion(a.region)
+}
+
+func (a *AWSCloud) getSelfInstance(ctx context.Context) error {
+	instance, err := a.DescribeInstance(ctx, a.instanceID)
 	if err != nil {
 		return err
 	}
 
 	a.self = instance
 
-	clusterID, _ := FindTag(instance, TagNameKubernetesCluster)
-	if clusterID == "" {
-		return fmt.Errorf("Cluster tag %q not found on this instance (%q)", TagNameKubernetesCluster, a.instanceID)
-	}
+	if a.clusterID == "" {
+		clusterID, _ := FindTag(instance, TagNameKubernetesCluster)
+		if clusterID == "" {
+			return fmt.Errorf("Cluster tag %q not found on this instance (%q)", TagNameKubernetesCluster, a.instanceID)
+		}
 
-	a.clusterID = clusterID
+		a.clusterID = clusterID
+	}
 
 	a.internalIP = net.ParseIP(aws.StringValue(instance.PrivateIpAddress))
 	if a.internalIP == nil {
@@ -99,12 +317,16 @@ func (a *AWSCloud) getSelfInstance() error {
 	return nil
 }
 
-func (a *AWSCloud) describeInstance(instanceID string) (*ec2.Instance, error) {
+// DescribeInstance queries for a single instance by id
+func (a *AWSCloud) DescribeInstance(ctx context.Context, instanceID string) (*ec2.Instance, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	request := &ec2.DescribeInstancesInput{}
 	request.InstanceIds = []*string{&instanceID}
 
 	var instances []*ec2.Instance
-	err := a.ec2.DescribeInstancesPages(request, func(p *ec2.DescribeInstancesOutput, lastPage bool) (shouldContinue bool) {
+	err := a.ec2ForInstance(instanceID).DescribeInstancesPagesWithContext(ctx, request, func(p *ec2.DescribeInstancesOutput, lastPage bool) (shouldContinue bool) {
 		for _, r := range p.Reservations {
 			instances = append(instances, r.Instances...)
 		}
@@ -122,12 +344,37 @@ func (a *AWSCloud) describeInstance(instanceID string) (*ec2.Instance, error) {
 	return instances[0], nil
 }
 
+// FilterTags returns the tags (in addition to the cluster tag) that managed instances must have
+func (a *AWSCloud) FilterTags() map[string]string {
+	a.filterTagsMutex.Lock()
+	defer a.filterTagsMutex.Unlock()
+	return a.filterTags
+}
+
+// SetFilterTags changes the tags that managed instances must have, taking effect on the next DescribeInstances
+func (a *AWSCloud) SetFilterTags(filterTags map[string]string) {
+	a.filterTagsMutex.Lock()
+	defer a.filterTagsMutex.Unlock()
+	a.filterTags = filterTags
+}
+
 // Add additional filters, to match on our tags
 // This lets us run multiple k8s clusters in a single EC2 AZ
 func (a *AWSCloud) addFilterTags(filters []*ec2.Filter) []*ec2.Filter {
-	//for k, v := range c.filterTags {
 	filters = append(filters, newEc2Filter("tag:"+TagNameKubernetesCluster, a.clusterID))
-	//}
+
+	filterTags := a.FilterTags()
+	var keys []string
+	for k := range filterTags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		filters = append(filters, newEc2Filter("tag:"+k, filterTags[k]))
+	}
+	if a.vpcID != "" {
+		filters = append(filters, newEc2Filter("vpc-id", a.vpcID))
+	}
 	if len(filters) == 0 {
 		// We can't pass a zero-length Filters to AWS (it's an error)
 		// So if we end up with no filters; just return nil
@@ -137,16 +384,42 @@ func (a *AWSCloud) addFilterTags(filters []*ec2.Filter) []*ec2.Filter {
 	return filters
 }
 
-func (a *AWSCloud) DescribeInstances() ([]*ec2.Instance, error) {
+// DescribeInstances returns the instances in the cluster, across all the regions we manage
+func (a *AWSCloud) DescribeInstances(ctx context.Context) ([]*ec2.Instance, error) {
+	var instances []*ec2.Instance
+	instanceRegions := make(map[string]string)
+
+	for _, region := range a.Regions() {
+		regionInstances, err := a.describeInstancesInRegion(ctx, region)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range regionInstances {
+			instanceRegions[aws.StringValue(i.InstanceId)] = region
+		}
+		instances = append(instances, regionInstances...)
+	}
+
+	a.instanceRegionsMutex.Lock()
+	a.instanceRegions = instanceRegions
+	a.instanceRegionsMutex.Unlock()
+
+	return instances, nil
+}
+
+func (a *AWSCloud) describeInstancesInRegion(ctx context.Context, region string) ([]*ec2.Instance, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	request := &ec2.DescribeInstancesInput{
 		Filters: a.addFilterTags(nil),
 	}
 
-	glog.Infof("Querying EC2 instances")
+	glog.Infof("Querying EC2 instances in %s", region)
 
 	var instances []*ec2.Instance
 
-	err := a.ec2.DescribeInstancesPages(request, func(p *ec2.DescribeInstancesOutput, lastPage bool) bool {
+	err := a.regions[region].DescribeInstancesPagesWithContext(ctx, request, func(p *ec2.DescribeInstancesOutput, lastPage bool) bool {
 		for _, r := range p.Reservations {
 			for _, i := range r.Instances {
 				instances = append(instances, i)
@@ -156,27 +429,278 @@ func (a *AWSCloud) DescribeInstances() ([]*ec2.Instance, error) {
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("error doing EC2 describe instances: %v", err)
+		return nil, fmt.Errorf("error doing EC2 describe instances in %s: %v", region, err)
 	}
 
 	return instances, nil
 }
 
+// DescribeInstanceStatus returns the status (including scheduled events) of the specified instances
+func (a *AWSCloud) DescribeInstanceStatus(ctx context.Context, instanceIDs []string) ([]*ec2.InstanceStatus, error) {
+	// DescribeInstanceStatus can't filter on tags, so we query by instance id, in each instance's region
+	byRegion := make(map[string][]*string)
+	for _, id := range instanceIDs {
+		region := a.InstanceRegion(id)
+		byRegion[region] = append(byRegion[region], aws.String(id))
+	}
+
+	var statuses []*ec2.InstanceStatus
+	for region, ids := range byRegion {
+		client := a.regions[region]
+		if client == nil {
+			client = a.ec2
+		}
+
+		// The API accepts at most 100 instance ids per request
+		for len(ids) != 0 {
+			batch := ids
+			if len(batch) > 100 {
+				batch = batch[:100]
+			}
+			ids = ids[len(batch):]
+
+			request := &ec2.DescribeInstanceStatusInput{
+				InstanceIds:         batch,
+				IncludeAllInstances: aws.Bool(true),
+			}
+
+			callCtx, cancel := withTimeout(ctx)
+			err := client.DescribeInstanceStatusPagesWithContext(callCtx, request, func(p *ec2.DescribeInstanceStatusOutput, lastPage bool) bool {
+				statuses = append(statuses, p.InstanceStatuses...)
+				return true
+			})
+			cancel()
+			if err != nil {
+				return nil, fmt.Errorf("error doing EC2 describe instance status in %s: %v", region, err)
+			}
+		}
+	}
+
+	return statuses, nil
+}
+
 // Sets the instance attribute "source-dest-check" to the specified value
-func (a *AWSCloud) ConfigureInstanceSourceDestCheck(instanceID string, sourceDestCheck bool) error {
+func (a *AWSCloud) ConfigureInstanceSourceDestCheck(ctx context.Context, instanceID string, sourceDestCheck bool) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	glog.Infof("Configuring SourceDestCheck on %q to %v", instanceID, sourceDestCheck)
 
 	request := &ec2.ModifyInstanceAttributeInput{}
 	request.InstanceId = aws.String(instanceID)
 	request.SourceDestCheck = &ec2.AttributeBooleanValue{Value: aws.Bool(sourceDestCheck)}
 
-	_, err := a.ec2.ModifyInstanceAttribute(request)
+	_, err := a.ec2ForInstance(instanceID).ModifyInstanceAttributeWithContext(ctx, request)
 	if err != nil {
 		return fmt.Errorf("error configuring source-dest-check on instance %q: %v", instanceID, err)
 	}
 	return nil
 }
 
+// GetInstanceDisableApiTermination returns the instance attribute "disableApiTermination"
+// (which DescribeInstances does not return)
+func (a *AWSCloud) GetInstanceDisableApiTermination(ctx context.Context, instanceID string) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	request := &ec2.DescribeInstanceAttributeInput{}
+	request.InstanceId = aws.String(instanceID)
+	request.Attribute = aws.String(ec2.InstanceAttributeNameDisableApiTermination)
+
+	response, err := a.ec2ForInstance(instanceID).DescribeInstanceAttributeWithContext(ctx, request)
+	if err != nil {
+		return false, fmt.Errorf("error querying disable-api-termination on instance %q: %v", instanceID, err)
+	}
+	if response.DisableApiTermination == nil {
+		return false, nil
+	}
+	return aws.BoolValue(response.DisableApiTermination.Value), nil
+}
+
+// Sets the instance attribute "disableApiTermination" to the specified value
+func (a *AWSCloud) ConfigureInstanceDisableApiTermination(ctx context.Context, instanceID string, disableApiTermination bool) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Configuring DisableApiTermination on %q to %v", instanceID, disableApiTermination)
+
+	request := &ec2.ModifyInstanceAttributeInput{}
+	request.InstanceId = aws.String(instanceID)
+	request.DisableApiTermination = &ec2.AttributeBooleanValue{Value: aws.Bool(disableApiTermination)}
+
+	_, err := a.ec2ForInstance(instanceID).ModifyInstanceAttributeWithContext(ctx, request)
+	if err != nil {
+		return fmt.Errorf("error configuring disable-api-termination on instance %q: %v", instanceID, err)
+	}
+	return nil
+}
+
+// withTimeout returns a context bounding a single AWS API call to defaultAPITimeout
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, defaultAPITimeout)
+}
+
+// Sets the "source-dest-check" attribute of a network interface attached to an instance to the specified value
+func (a *AWSCloud) ConfigureNetworkInterfaceSourceDestCheck(ctx context.Context, instanceID string, networkInterfaceID string, sourceDestCheck bool) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Configuring SourceDestCheck on network interface %q (of %q) to %v", networkInterfaceID, instanceID, sourceDestCheck)
+
+	request := &ec2.ModifyNetworkInterfaceAttributeInput{}
+	request.NetworkInterfaceId = aws.String(networkInterfaceID)
+	request.SourceDestCheck = &ec2.AttributeBooleanValue{Value: aws.Bool(sourceDestCheck)}
+
+	_, err := a.ec2ForInstance(instanceID).ModifyNetworkInterfaceAttributeWithContext(ctx, request)
+	if err != nil {
+		return fmt.Errorf("error configuring source-dest-check on network interface %q: %v", networkInterfaceID, err)
+	}
+	return nil
+}
+
+// ConfigureInstanceMonitoring enables or disables detailed (1-minute) CloudWatch monitoring of an instance
+func (a *AWSCloud) ConfigureInstanceMonitoring(ctx context.Context, instanceID string, detailed bool) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Configuring instance detailed monitoring on %q to %v", instanceID, detailed)
+
+	ids := []*string{aws.String(instanceID)}
+	var err error
+	if detailed {
+		_, err = a.ec2ForInstance(instanceID).MonitorInstancesWithContext(ctx, &ec2.MonitorInstancesInput{InstanceIds: ids})
+	} else {
+		_, err = a.ec2ForInstance(instanceID).UnmonitorInstancesWithContext(ctx, &ec2.UnmonitorInstancesInput{InstanceIds: ids})
+	}
+	if err != nil {
+		return fmt.Errorf("error configuring instance detailed monitoring on %q: %v", instanceID, err)
+	}
+	return nil
+}
+
+// InstanceMetadataOptions are the settings of the instance metadata service on an instance
+type InstanceMetadataOptions struct {
+	// HttpTokens is "required" to require IMDSv2 session tokens, or "optional"
+	HttpTokens string
+	// HttpPutResponseHopLimit is the hop limit of token responses; 0 leaves it unchanged
+	HttpPutResponseHopLimit int64
+}
+
+// MatchesInstance returns true if the instance's metadata options already match
+func (o *InstanceMetadataOptions) MatchesInstance(instance *ec2.Instance) bool {
+	actual := instance.MetadataOptions
+	if actual == nil {
+		return false
+	}
+	if o.HttpTokens != "" && o.HttpTokens != aws.StringValue(actual.HttpTokens) {
+		return false
+	}
+	if o.HttpPutResponseHopLimit != 0 && o.HttpPutResponseHopLimit != aws.Int64Value(actual.HttpPutResponseHopLimit) {
+		return false
+	}
+	return true
+}
+
+// ConfigureInstanceMetadataOptions sets the metadata service options on an instance
+func (a *AWSCloud) ConfigureInstanceMetadataOptions(ctx context.Context, instanceID string, options *InstanceMetadataOptions) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Configuring instance metadata options on %q to %+v", instanceID, *options)
+
+	request := &ec2.ModifyInstanceMetadataOptionsInput{
+		InstanceId: aws.String(instanceID),
+	}
+	if options.HttpTokens != "" {
+		request.HttpTokens = aws.String(options.HttpTokens)
+	}
+	if options.HttpPutResponseHopLimit != 0 {
+		request.HttpPutResponseHopLimit = aws.Int64(options.HttpPutResponseHopLimit)
+	}
+
+	_, err := a.ec2ForInstance(instanceID).ModifyInstanceMetadataOptionsWithContext(ctx, request)
+	if err != nil {
+		return fmt.Errorf("error configuring instance metadata options on %q: %v", instanceID, err)
+	}
+	return nil
+}
+
+// TagInstance creates (or overwrites) tags on an instance
+func (a *AWSCloud) TagInstance(ctx context.Context, instanceID string, tags map[string]string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Tagging instance %q with %v", instanceID, tags)
+
+	request := &ec2.CreateTagsInput{}
+	request.Resources = []*string{aws.String(instanceID)}
+	for k, v := range tags {
+		request.Tags = append(request.Tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	_, err := a.ec2ForInstance(instanceID).CreateTagsWithContext(ctx, request)
+	if err != nil {
+		return fmt.Errorf("error tagging instance %q: %v", instanceID, err)
+	}
+	return nil
+}
+
+// RebootInstance requests a reboot of an instance
+func (a *AWSCloud) RebootInstance(ctx context.Context, instanceID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Rebooting instance %q", instanceID)
+
+	request := &ec2.RebootInstancesInput{
+		InstanceIds: []*string{aws.String(instanceID)},
+	}
+
+	_, err := a.ec2ForInstance(instanceID).RebootInstancesWithContext(ctx, request)
+	if err != nil {
+		return fmt.Errorf("error rebooting instance %q: %v", instanceID, err)
+	}
+	return nil
+}
+
+// TerminateInstance terminates an instance
+func (a *AWSCloud) TerminateInstance(ctx context.Context, instanceID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Terminating instance %q", instanceID)
+
+	request := &ec2.TerminateInstancesInput{
+		InstanceIds: []*string{aws.String(instanceID)},
+	}
+
+	_, err := a.ec2ForInstance(instanceID).TerminateInstancesWithContext(ctx, request)
+	if err != nil {
+		return fmt.Errorf("error terminating instance %q: %v", instanceID, err)
+	}
+	return nil
+}
+
+// UntagInstance deletes tags (with any value) from an instance
+func (a *AWSCloud) UntagInstance(ctx context.Context, instanceID string, keys []string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Removing tags %v from instance %q", keys, instanceID)
+
+	request := &ec2.DeleteTagsInput{}
+	request.Resources = []*string{aws.String(instanceID)}
+	for _, k := range keys {
+		request.Tags = append(request.Tags, &ec2.Tag{Key: aws.String(k)})
+	}
+
+	_, err := a.ec2ForInstance(instanceID).DeleteTagsWithContext(ctx, request)
+	if err != nil {
+		return fmt.Errorf("error removing tags from instance %q: %v", instanceID, err)
+	}
+	return nil
+}
+
 func newEc2Filter(name string, value string) *ec2.Filter {
 	filter := &ec2.Filter{
 		Name: aws.String(name),
@@ -187,6 +711,15 @@ func newEc2Filter(name string, value string) *ec2.Filter {
 	return filter
 }
 
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 func FindTag(instance *ec2.Instance, name string) (string, bool) {
 	for _, tag := range instance.Tags {
 		k := aws.StringValue(tag.Key)
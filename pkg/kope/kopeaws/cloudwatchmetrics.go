@@ -0,0 +1,66 @@
+package kopeaws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"sort"
+	"time"
+)
+
+// maxMetricsPerRequest is the most metric values PutMetricData accepts in one request
+const maxMetricsPerRequest = 1000
+
+// CloudWatchMetric is a value of a custom CloudWatch metric
+type CloudWatchMetric struct {
+	Name       string
+	Dimensions map[string]string
+	Value      float64
+	// Unit is a cloudwatch.StandardUnit, e.g. cloudwatch.StandardUnitCount
+	Unit string
+}
+
+// PutCloudWatchMetrics publishes the metric values under the namespace, in our region, all timestamped now
+func (a *AWSCloud) PutCloudWatchMetrics(ctx context.Context, namespace string, metrics []CloudWatchMetric) error {
+	now := time.Now()
+
+	var data []*cloudwatch.MetricDatum
+	for _, m := range metrics {
+		datum := &cloudwatch.MetricDatum{
+			MetricName: aws.String(m.Name),
+			Value:      aws.Float64(m.Value),
+			Unit:       aws.String(m.Unit),
+			Timestamp:  aws.Time(now),
+		}
+		var names []string
+		for k := range m.Dimensions {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			datum.Dimensions = append(datum.Dimensions, &cloudwatch.Dimension{Name: aws.String(k), Value: aws.String(m.Dimensions[k])})
+		}
+		data = append(data, datum)
+	}
+
+	for len(data) != 0 {
+		batch := data
+		if len(batch) > maxMetricsPerRequest {
+			batch = batch[:maxMetricsPerRequest]
+		}
+		data = data[len(batch):]
+
+		request := &cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(namespace),
+			MetricData: batch,
+		}
+		callCtx, cancel := withTimeout(ctx)
+		_, err := a.cloudwatch(a.region).PutMetricDataWithContext(callCtx, request)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("error publishing CloudWatch metrics to %q: %v", namespace, err)
+		}
+	}
+	return nil
+}
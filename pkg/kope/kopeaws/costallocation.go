@@ -0,0 +1,253 @@
+package kopeaws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/golang/glog"
+	"sort"
+)
+
+// The types of TaggableResource
+const (
+	ResourceTypeInstance         = "instance"
+	ResourceTypeVolume           = "volume"
+	ResourceTypeNetworkInterface = "network-interface"
+	// ResourceTypeClassicLoadBalancer is a classic ELB, identified by its name
+	ResourceTypeClassicLoadBalancer = "classic-load-balancer"
+	// ResourceTypeLoadBalancer is an ELBv2 (network or application) load balancer, identified by its ARN
+	ResourceTypeLoadBalancer = "load-balancer"
+)
+
+// maxEC2FilterValues is the most values we pass in one DescribeVolumes / DescribeNetworkInterfaces filter
+const maxEC2FilterValues = 200
+
+// TaggableResource is a cluster resource whose tags we can set, for cost allocation
+type TaggableResource struct {
+	Type   string
+	ID     string
+	Region string
+	Tags   map[string]string
+}
+
+// ListTaggableResources returns the cluster's volumes and network interfaces, those tagged with our cluster
+// tag or attached to the instances, and its classic and ELBv2 load balancers, in all our regions.  The
+// instances themselves are not included.
+func (a *AWSCloud) ListTaggableResources(ctx context.Context, instanceIDs []string) ([]*TaggableResource, error) {
+	instancesByRegion := make(map[string][]string)
+	for _, id := range instanceIDs {
+		region := a.InstanceRegion(id)
+		instancesByRegion[region] = append(instancesByRegion[region], id)
+	}
+
+	all := make(map[string]*TaggableResource)
+	add := func(r *TaggableResource) {
+		all[r.Type+"/"+r.ID] = r
+	}
+
+	for _, region := range a.Regions() {
+		filters := [][]*ec2.Filter{{newEc2Filter("tag:"+TagNameKubernetesCluster, a.clusterID)}}
+		ids := instancesByRegion[region]
+		for start := 0; start < len(ids); start += maxEC2FilterValues {
+			end := start + maxEC2FilterValues
+			if end > len(ids) {
+				end = len(ids)
+			}
+			filters = append(filters, []*ec2.Filter{{Name: aws.String("attachment.instance-id"), Values: aws.StringSlice(ids[start:end])}})
+		}
+
+		for _, f := range filters {
+			volumes, err := a.describeTaggableVolumes(ctx, region, f)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range volumes {
+				add(r)
+			}
+			enis, err := a.describeTaggableNetworkInterfaces(ctx, region, f)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range enis {
+				add(r)
+			}
+		}
+
+		classicLoadBalancers, err := a.listClassicLoadBalancersInRegion(ctx, region)
+		if err != nil {
+			return nil, err
+		}
+		for _, lb := range classicLoadBalancers {
+			add(&TaggableResource{Type: ResourceTypeClassicLoadBalancer, ID: lb.Name, Region: region, Tags: lb.Tags})
+		}
+
+		loadBalancers, err := a.listTaggableLoadBalancers(ctx, region)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range loadBalancers {
+			add(r)
+		}
+	}
+
+	var resources []*TaggableResource
+	for _, r := range all {
+		resources = append(resources, r)
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].Type != resources[j].Type {
+			return resources[i].Type < resources[j].Type
+		}
+		return resources[i].ID < resources[j].ID
+	})
+	return resources, nil
+}
+
+func (a *AWSCloud) describeTaggableVolumes(ctx context.Context, region string, filters []*ec2.Filter) ([]*TaggableResource, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var resources []*TaggableResource
+	err := a.regions[region].DescribeVolumesPagesWithContext(ctx, &ec2.DescribeVolumesInput{Filters: filters}, func(p *ec2.DescribeVolumesOutput, lastPage bool) bool {
+		for _, v := range p.Volumes {
+			resources = append(resources, &TaggableResource{
+				Type:   ResourceTypeVolume,
+				ID:     aws.StringValue(v.VolumeId),
+				Region: region,
+				Tags:   ec2TagMap(v.Tags),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing volumes in %s: %v", region, err)
+	}
+	return resources, nil
+}
+
+func (a *AWSCloud) describeTaggableNetworkInterfaces(ctx context.Context, region string, filters []*ec2.Filter) ([]*TaggableResource, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var resources []*TaggableResource
+	err := a.regions[region].DescribeNetworkInterfacesPagesWithContext(ctx, &ec2.DescribeNetworkInterfacesInput{Filters: filters}, func(p *ec2.DescribeNetworkInterfacesOutput, lastPage bool) bool {
+		for _, eni := range p.NetworkInterfaces {
+			resources = append(resources, &TaggableResource{
+				Type:   ResourceTypeNetworkInterface,
+				ID:     aws.StringValue(eni.NetworkInterfaceId),
+				Region: region,
+				Tags:   ec2TagMap(eni.TagSet),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing network interfaces in %s: %v", region, err)
+	}
+	return resources, nil
+}
+
+// listTaggableLoadBalancers returns the ELBv2 load balancers tagged with our cluster tag in the region
+func (a *AWSCloud) listTaggableLoadBalancers(ctx context.Context, region string) ([]*TaggableResource, error) {
+	client := a.elbv2(region)
+
+	var arns []*string
+	callCtx, cancel := withTimeout(ctx)
+	err := client.DescribeLoadBalancersPagesWithContext(callCtx, &elbv2.DescribeLoadBalancersInput{}, func(p *elbv2.DescribeLoadBalancersOutput, lastPage bool) bool {
+		for _, lb := range p.LoadBalancers {
+			arns = append(arns, lb.LoadBalancerArn)
+		}
+		return true
+	})
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("error describing load balancers in %s: %v", region, err)
+	}
+
+	// Tags are not returned by DescribeLoadBalancers, so we must filter by cluster afterwards
+	var resources []*TaggableResource
+	for start := 0; start < len(arns); start += maxELBDescribeTags {
+		end := start + maxELBDescribeTags
+		if end > len(arns) {
+			end = len(arns)
+		}
+
+		callCtx, cancel := withTimeout(ctx)
+		response, err := client.DescribeTagsWithContext(callCtx, &elbv2.DescribeTagsInput{ResourceArns: arns[start:end]})
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("error describing load balancer tags in %s: %v", region, err)
+		}
+
+		for _, d := range response.TagDescriptions {
+			tags := make(map[string]string)
+			for _, t := range d.Tags {
+				tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+			}
+			if tags[TagNameKubernetesCluster] != a.clusterID {
+				continue
+			}
+			resources = append(resources, &TaggableResource{
+				Type:   ResourceTypeLoadBalancer,
+				ID:     aws.StringValue(d.ResourceArn),
+				Region: region,
+				Tags:   tags,
+			})
+		}
+	}
+	return resources, nil
+}
+
+// TagResource creates (or overwrites) tags on a resource
+func (a *AWSCloud) TagResource(ctx context.Context, r *TaggableResource, tags map[string]string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.V(2).Infof("Tagging %s %q with %v", r.Type, r.ID, tags)
+
+	var err error
+	switch r.Type {
+	case ResourceTypeInstance, ResourceTypeVolume, ResourceTypeNetworkInterface:
+		client := a.regions[r.Region]
+		if client == nil {
+			return fmt.Errorf("error tagging %s %q: unknown region %q", r.Type, r.ID, r.Region)
+		}
+		request := &ec2.CreateTagsInput{Resources: []*string{aws.String(r.ID)}}
+		for k, v := range tags {
+			request.Tags = append(request.Tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(v)})
+		}
+		_, err = client.CreateTagsWithContext(ctx, request)
+
+	case ResourceTypeClassicLoadBalancer:
+		request := &elb.AddTagsInput{LoadBalancerNames: []*string{aws.String(r.ID)}}
+		for k, v := range tags {
+			request.Tags = append(request.Tags, &elb.Tag{Key: aws.String(k), Value: aws.String(v)})
+		}
+		_, err = a.elb(r.Region).AddTagsWithContext(ctx, request)
+
+	case ResourceTypeLoadBalancer:
+		request := &elbv2.AddTagsInput{ResourceArns: []*string{aws.String(r.ID)}}
+		for k, v := range tags {
+			request.Tags = append(request.Tags, &elbv2.Tag{Key: aws.String(k), Value: aws.String(v)})
+		}
+		_, err = a.elbv2(r.Region).AddTagsWithContext(ctx, request)
+
+	default:
+		return fmt.Errorf("cannot tag %s %q: unknown resource type", r.Type, r.ID)
+	}
+	if err != nil {
+		return fmt.Errorf("error tagging %s %q: %v", r.Type, r.ID, err)
+	}
+	return nil
+}
+
+func ec2TagMap(tags []*ec2.Tag) map[string]string {
+	m := make(map[string]string)
+	for _, t := range tags {
+		m[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+	return m
+}
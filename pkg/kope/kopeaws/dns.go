@@ -1,15 +1,15 @@
 package kopeaws
 
 import (
+	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/request"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/route53"
 	"github.com/golang/glog"
 	"github.com/kopeio/aws-controller/pkg/kope"
 	"github.com/kopeio/aws-controller/pkg/kope/utils"
+	"sort"
 	"strings"
 	"time"
 )
@@ -23,32 +23,130 @@ type Route53DNSProvider struct {
 	route53  *route53.Route53
 
 	zone *route53.HostedZone
+
+	// healthChecks caches the ids of the health checks we manage, by ip:port
+	healthChecks map[string]string
+
+	// ownerID, if set, is recorded in an ownership record for each name we publish
+	ownerID string
+	// forceOverwrite allows us to take ownership of names with existing records we don't own
+	forceOverwrite bool
+	// ownedNames caches the names known to carry our ownership record
+	ownedNames map[string]bool
+
+	// waitTimeout, if set, is how long we wait for each change to reach INSYNC
+	waitTimeout time.Duration
+
+	// privateZone, if set, selects the private or public hosted zone of those with our zone name
+	privateZone *bool
 }
 
 var _ kope.DNSProvider = &Route53DNSProvider{}
+var _ kope.OwnedDNSProvider = &Route53DNSProvider{}
+var _ kope.NameServerLister = &Route53DNSProvider{}
 
-func NewRoute53DNSProvider(zoneName string) *Route53DNSProvider {
-	s := session.New()
-	s.Handlers.Send.PushFront(func(r *request.Request) {
-		// Log requests
-		glog.V(4).Infof("AWS API Request: %s/%s", r.ClientInfo.ServiceName, r.Operation.Name)
-	})
+// Route53Options configures the Route53 client
+type Route53Options struct {
+	// Session configures the credentials to use
+	Session SessionOptions
+
+	// RoleARN is a role assumed (on top of the Session credentials) for Route53 calls only,
+	// so that we can manage a hosted zone owned by a different account
+	RoleARN string
+	// RoleExternalID is the external id to pass when assuming RoleARN, if required
+	RoleExternalID string
+
+	// Region is a region in the partition whose Route53 service we should use;
+	// Route53 is global within each partition (aws, aws-cn, aws-us-gov)
+	Region string
+
+	// Endpoint overrides the default Route53 endpoint, if set
+	Endpoint string
+
+	// RateLimit limits the rate of Route53 API requests
+	RateLimit RateLimit
+	// ChangeRateLimit additionally limits the rate of ChangeResourceRecordSets requests, which Route53
+	// throttles separately
+	ChangeRateLimit RateLimit
+
+	// OwnerID, if set, marks the names we publish as owned by us (with a TXT record beside each name),
+	// so that our records can be listed and removed even after a restart
+	OwnerID string
+	// ForceOverwrite allows us to overwrite (and take ownership of) existing records which are not marked
+	// as ours; otherwise such names are refused
+	ForceOverwrite bool
+
+	// WaitTimeout, if set, makes each change wait until Route53 reports it INSYNC (propagated to all
+	// the authoritative name servers), failing if that takes longer than this
+	WaitTimeout time.Duration
+
+	// PrivateZone, if set, selects the private (true) or public (false) hosted zone, where zones of both
+	// visibilities have the zone name (as in a split-horizon pair)
+	PrivateZone *bool
+}
+
+// ZoneCreationOptions configures the hosted zone created by EnsureHostedZone
+type ZoneCreationOptions struct {
+	// ClusterID identifies the zone's creator, so that we never create the zone twice
+	ClusterID string
+
+	// PrivateVPCID, if set, makes the zone private, associated with the VPC in PrivateVPCRegion
+	PrivateVPCID     string
+	PrivateVPCRegion string
 
-	config := aws.NewConfig()
+	// Tags are applied to the zone, e.g. to mark it as owned by the cluster
+	Tags map[string]string
+}
 
-	route53 := route53.New(s, config)
+func NewRoute53DNSProvider(zoneName string, options Route53Options) (*Route53DNSProvider, error) {
+	client, err := newRoute53Client(options)
+	if err != nil {
+		return nil, err
+	}
 
 	return &Route53DNSProvider{
-		route53:  route53,
-		zoneName: zoneName,
+		route53:        client,
+		zoneName:       zoneName,
+		ownerID:        options.OwnerID,
+		forceOverwrite: options.ForceOverwrite,
+		waitTimeout:    options.WaitTimeout,
+		privateZone:    options.PrivateZone,
+	}, nil
+}
+
+func newRoute53Client(options Route53Options) (*route53.Route53, error) {
+	s, err := newSession(options.Session)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.RoleARN != "" {
+		s = assumeRole(s, options.RoleARN, options.RoleExternalID)
 	}
+
+	config := aws.NewConfig().WithRegion(route53Region(options.Region))
+	if options.Endpoint != "" {
+		glog.Infof("Using Route53 endpoint %q", options.Endpoint)
+		config = config.WithEndpoint(options.Endpoint)
+	}
+
+	// Every client (one per zone, and each zone's role) shares the limits of its account
+	limiterName := "Route53"
+	if options.RoleARN != "" {
+		limiterName += " as " + options.RoleARN
+	}
+
+	client := route53.New(s, config)
+	addRateLimiter(&client.Handlers, limiterName, options.RateLimit)
+	addOperationRateLimiter(&client.Handlers, limiterName, "ChangeResourceRecordSets", options.ChangeRateLimit)
+	return client, nil
 }
 
-func (d *Route53DNSProvider) ApplyDNSChanges(dns map[string][]string) error {
-	return d.set(dns, defaultTTL)
+func (d *Route53DNSProvider) ApplyDNSChanges(ctx context.Context, dns map[kope.DNSRecordKey][]string) error {
+	return d.set(ctx, dns, defaultTTL)
 }
 
-func (d *Route53DNSProvider) getZone() (*route53.HostedZone, error) {
+func (d *Route53DNSProvider) getZone(ctx context.Context) (*route53.HostedZone, error) {
 	if d.zone != nil {
 		return d.zone, nil
 	}
@@ -62,7 +160,9 @@ func (d *Route53DNSProvider) getZone() (*route53.HostedZone, error) {
 			Id: aws.String(zoneID),
 		}
 
-		response, err := d.route53.GetHostedZone(request)
+		getCtx, cancel := withTimeout(ctx)
+		response, err := d.route53.GetHostedZoneWithContext(getCtx, request)
+		cancel()
 		if err != nil {
 			if AWSErrorCode(err) == "NoSuchHostedZone" {
 				glog.Infof("Zone not found with id %q; will reattempt by name", zoneID)
@@ -85,16 +185,23 @@ func (d *Route53DNSProvider) getZone() (*route53.HostedZone, error) {
 		DNSName: aws.String(findZone),
 	}
 
-	response, err := d.route53.ListHostedZonesByName(request)
+	listCtx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	response, err := d.route53.ListHostedZonesByNameWithContext(listCtx, request)
 	if err != nil {
 		return nil, fmt.Errorf("error querying for DNS HostedZones %q: %v", findZone, err)
 	}
 
 	var zones []*route53.HostedZone
 	for _, zone := range response.HostedZones {
-		if aws.StringValue(zone.Name) == findZone {
-			zones = append(zones, zone)
+		if aws.StringValue(zone.Name) != findZone {
+			continue
+		}
+		if d.privateZone != nil && zone.Config != nil && aws.BoolValue(zone.Config.PrivateZone) != *d.privateZone {
+			continue
 		}
+		zones = append(zones, zone)
 	}
 	if len(zones) == 0 {
 		return nil, nil
@@ -108,25 +215,270 @@ func (d *Route53DNSProvider) getZone() (*route53.HostedZone, error) {
 	return d.zone, nil
 }
 
-func (d *Route53DNSProvider) set(records map[string][]string, ttl time.Duration) error {
-	zone, err := d.getZone()
+// EnsureHostedZone creates the named hosted zone if it does not exist, as configured by creation.  The
+// caller reference is derived from the cluster id and the zone name, so that a restarted (or a concurrent)
+// controller cannot create a duplicate zone.  A public zone we create is delegated from its parent zone, if
+// that is a hosted zone we can find.
+func EnsureHostedZone(ctx context.Context, zoneName string, options Route53Options, creation ZoneCreationOptions) error {
+	if !strings.Contains(zoneName, ".") {
+		return fmt.Errorf("cannot create hosted zone %q: must be named, not identified by id", zoneName)
+	}
+
+	d, err := NewRoute53DNSProvider(zoneName, options)
+	if err != nil {
+		return err
+	}
+	private := creation.PrivateVPCID != ""
+	d.privateZone = &private
+
+	zone, err := d.getZone(ctx)
 	if err != nil {
 		return err
 	}
+	if zone != nil {
+		return nil
+	}
+
+	nameServers, err := d.createHostedZone(ctx, creation)
+	if err != nil {
+		return err
+	}
+	if private {
+		return nil
+	}
+	return d.delegateFromParent(ctx, nameServers)
+}
+
+// createHostedZone creates our hosted zone, as configured by options, returning its name servers
+func (d *Route53DNSProvider) createHostedZone(ctx context.Context, options ZoneCreationOptions) ([]*string, error) {
+	name := strings.TrimSuffix(d.zoneName, ".") + "."
+	private := options.PrivateVPCID != ""
+
+	callerReference := fmt.Sprintf("aws-controller-%s-%s", options.ClusterID, name)
+	request := &route53.CreateHostedZoneInput{
+		Name:            aws.String(name),
+		CallerReference: aws.String(callerReference),
+		HostedZoneConfig: &route53.HostedZoneConfig{
+			Comment:     aws.String("Created by aws-controller"),
+			PrivateZone: aws.Bool(private),
+		},
+	}
+	if private {
+		request.VPC = &route53.VPC{
+			VPCId:     aws.String(options.PrivateVPCID),
+			VPCRegion: aws.String(options.PrivateVPCRegion),
+		}
+		glog.Infof("Creating private hosted zone %q in VPC %q", name, options.PrivateVPCID)
+	} else {
+		glog.Infof("Creating public hosted zone %q", name)
+	}
+
+	createCtx, cancel := withTimeout(ctx)
+	response, err := d.route53.CreateHostedZoneWithContext(createCtx, request)
+	cancel()
+	if err != nil {
+		if AWSErrorCode(err) != route53.ErrCodeHostedZoneAlreadyExists {
+			return nil, fmt.Errorf("error creating hosted zone %q: %v", name, err)
+		}
+
+		// The zone was created concurrently, or by an earlier attempt whose response we lost
+		zone, err := d.getZone(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if zone == nil {
+			return nil, fmt.Errorf("hosted zone %q was previously created with caller reference %q, but no longer exists; it must be recreated manually", name, callerReference)
+		}
+		if private {
+			return nil, nil
+		}
+		nameServers, err := d.ListNameServers(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return aws.StringSlice(nameServers), nil
+	}
+	d.zone = response.HostedZone
 
+	if len(options.Tags) != 0 {
+		var tags []*route53.Tag
+		for k, v := range options.Tags {
+			tags = append(tags, &route53.Tag{Key: aws.String(k), Value: aws.String(v)})
+		}
+		tagCtx, cancel := withTimeout(ctx)
+		_, err = d.route53.ChangeTagsForResourceWithContext(tagCtx, &route53.ChangeTagsForResourceInput{
+			ResourceType: aws.String(route53.TagResourceTypeHostedzone),
+			ResourceId:   aws.String(strings.TrimPrefix(aws.StringValue(d.zone.Id), "/hostedzone/")),
+			AddTags:      tags,
+		})
+		cancel()
+		if err != nil {
+			// The zone is found by name from now on, so we don't fail (and retry creating it)
+			glog.Warningf("error tagging hosted zone %q: %v", name, err)
+		}
+	}
+
+	if response.DelegationSet == nil {
+		return nil, nil
+	}
+	return response.DelegationSet.NameServers, nil
+}
+
+// delegateFromParent delegates our zone to nameServers from the closest enclosing public hosted zone, if any
+func (d *Route53DNSProvider) delegateFromParent(ctx context.Context, nameServers []*string) error {
+	name := strings.TrimSuffix(d.zoneName, ".")
+	for parentName := name[strings.Index(name, ".")+1:]; strings.Contains(parentName, "."); parentName = parentName[strings.Index(parentName, ".")+1:] {
+		parent := &Route53DNSProvider{route53: d.route53, zoneName: parentName, privateZone: aws.Bool(false)}
+		zone, err := parent.getZone(ctx)
+		if err != nil {
+			return err
+		}
+		if zone == nil {
+			continue
+		}
+		return parent.delegate(ctx, name, nameServers)
+	}
+
+	glog.Warningf("No parent hosted zone found for %q; it must be delegated manually to %v", name, aws.StringValueSlice(nameServers))
+	return nil
+}
+
+// delegate upserts the NS records in our zone that delegate the child zone name to nameServers
+func (d *Route53DNSProvider) delegate(ctx context.Context, name string, nameServers []*string) error {
+	if len(nameServers) == 0 {
+		return fmt.Errorf("no name servers found for hosted zone %q", name)
+	}
+
+	rrs := &route53.ResourceRecordSet{
+		Name: aws.String(name),
+		Type: aws.String("NS"),
+		TTL:  aws.Int64(int64(defaultTTL.Seconds())),
+	}
+	for _, nameServer := range nameServers {
+		rrs.ResourceRecords = append(rrs.ResourceRecords, &route53.ResourceRecord{Value: nameServer})
+	}
+
+	changeBatch := &route53.ChangeBatch{
+		Changes: []*route53.Change{
+			{
+				Action:            aws.String("UPSERT"),
+				ResourceRecordSet: rrs,
+			},
+		},
+	}
+
+	glog.V(2).Infof("Delegating %q from zone %q to %v", name, d.zoneName, aws.StringValueSlice(nameServers))
+
+	if err := d.changeRecordSets(ctx, changeBatch); err != nil {
+		return fmt.Errorf("error delegating %q from zone %q: %v", name, d.zoneName, err)
+	}
+	return nil
+}
+
+func (d *Route53DNSProvider) set(ctx context.Context, records map[kope.DNSRecordKey][]string, ttl time.Duration) error {
 	changeBatch := &route53.ChangeBatch{}
-	for name, hosts := range records {
+	// replacedHealthChecks are health checks which will no longer be used once the batch is applied
+	var replacedHealthChecks []string
+	// deleted records the record sets already being deleted in the batch
+	deleted := make(map[string]bool)
+	// owned records the names whose ownership records are being upserted in the batch
+	owned := make(map[string]bool)
+	// refused records the names we may not overwrite
+	var refused []string
+	refusedNames := make(map[string]bool)
+	for key, hosts := range records {
+		if d.ownerID != "" && !owned[key.Name] {
+			if refusedNames[key.Name] {
+				continue
+			}
+			ok, err := d.mayOverwrite(ctx, key.Name)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				glog.Warningf("Not publishing %q, which has existing records not owned by us", key.Name)
+				refusedNames[key.Name] = true
+				refused = append(refused, key.Name)
+				continue
+			}
+
+			owned[key.Name] = true
+			changeBatch.Changes = append(changeBatch.Changes, &route53.Change{
+				Action:            aws.String("UPSERT"),
+				ResourceRecordSet: d.ownerRecordSet(key.Name),
+			})
+		}
+
+		conflicts, err := d.conflictingRecordSets(ctx, key)
+		if err != nil {
+			return err
+		}
+		for _, existing := range conflicts {
+			id := aws.StringValue(existing.Name) + "/" + aws.StringValue(existing.Type) + "/" + aws.StringValue(existing.SetIdentifier)
+			if deleted[id] {
+				continue
+			}
+			deleted[id] = true
+
+			glog.Infof("Replacing DNS record %s with %s", id, key)
+			changeBatch.Changes = append(changeBatch.Changes, &route53.Change{
+				Action:            aws.String("DELETE"),
+				ResourceRecordSet: existing,
+			})
+		}
+
 		rrs := &route53.ResourceRecordSet{
-			Name: aws.String(name),
-			Type: aws.String("A"),
-			TTL:  aws.Int64(int64(ttl.Seconds())),
+			Name: aws.String(key.Name),
+			Type: aws.String(key.Type),
+		}
+		if key.SetIdentifier != "" {
+			rrs.SetIdentifier = aws.String(key.SetIdentifier)
+		}
+		if key.Failover != "" {
+			rrs.Failover = aws.String(key.Failover)
+		}
+		if key.Region != "" {
+			rrs.Region = aws.String(key.Region)
+		}
+		if key.MultiValue {
+			rrs.MultiValueAnswer = aws.Bool(true)
+		}
+		if key.Weighted {
+			rrs.Weight = aws.Int64(key.Weight)
+		}
+		if key.HealthCheckPort != 0 {
+			if len(hosts) != 1 {
+				return fmt.Errorf("health checked record %s must have a single value, had %v", key, hosts)
+			}
+			healthCheckID, err := d.ensureHealthCheck(ctx, hosts[0], key.HealthCheckPort)
+			if err != nil {
+				return err
+			}
+			rrs.HealthCheckId = aws.String(healthCheckID)
+
+			existing, err := d.listRecordSet(ctx, key)
+			if err != nil {
+				return err
+			}
+			if existing != nil && existing.HealthCheckId != nil && aws.StringValue(existing.HealthCheckId) != healthCheckID {
+				replacedHealthChecks = append(replacedHealthChecks, aws.StringValue(existing.HealthCheckId))
+			}
 		}
 
-		for _, host := range hosts {
-			rr := &route53.ResourceRecord{
-				Value: aws.String(host),
+		if key.AliasHostedZoneID != "" {
+			if len(hosts) != 1 {
+				return fmt.Errorf("alias record %s must have a single target, had %v", key, hosts)
+			}
+			// Alias record sets take their TTL from the target
+			rrs.AliasTarget = aliasTarget(key, hosts[0])
+		} else {
+			rrs.TTL = aws.Int64(int64(ttl.Seconds()))
+			for _, host := range hosts {
+				rr := &route53.ResourceRecord{
+					Value: aws.String(host),
+				}
+				rrs.ResourceRecords = append(rrs.ResourceRecords, rr)
 			}
-			rrs.ResourceRecords = append(rrs.ResourceRecords, rr)
 		}
 
 		change := &route53.Change{
@@ -136,20 +488,287 @@ func (d *Route53DNSProvider) set(records map[string][]string, ttl time.Duration)
 		changeBatch.Changes = append(changeBatch.Changes, change)
 	}
 
+	glog.V(2).Infof("Updating DNS records %q", records)
+
+	if len(changeBatch.Changes) != 0 {
+		if err := d.changeRecordSets(ctx, changeBatch); err != nil {
+			return err
+		}
+	}
+	d.recordOwned(owned)
+
+	for _, id := range replacedHealthChecks {
+		if err := d.deleteHealthCheck(ctx, id); err != nil {
+			glog.Warningf("error deleting unused health check: %v", err)
+		}
+	}
+
+	if len(refused) != 0 {
+		sort.Strings(refused)
+		return fmt.Errorf("refusing to overwrite existing records not owned by us at %v", refused)
+	}
+	return nil
+}
+
+// ZoneName returns the DNS name of the hosted zone, which may have been specified by id
+func (d *Route53DNSProvider) ZoneName(ctx context.Context) (string, error) {
+	zone, err := d.getZone(ctx)
+	if err != nil {
+		return "", err
+	}
+	if zone == nil {
+		return "", fmt.Errorf("hosted zone %q not found", d.zoneName)
+	}
+	return strings.TrimSuffix(aws.StringValue(zone.Name), "."), nil
+}
+
+// ListNameServers returns the name servers of the hosted zone's delegation set; private zones have none,
+// so kope.ErrPrivateZone is returned for them
+func (d *Route53DNSProvider) ListNameServers(ctx context.Context) ([]string, error) {
+	zone, err := d.getZone(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if zone == nil {
+		return nil, fmt.Errorf("hosted zone %q not found", d.zoneName)
+	}
+	if zone.Config != nil && aws.BoolValue(zone.Config.PrivateZone) {
+		return nil, kope.ErrPrivateZone
+	}
+
+	getCtx, cancel := withTimeout(ctx)
+	defer cancel()
+	response, err := d.route53.GetHostedZoneWithContext(getCtx, &route53.GetHostedZoneInput{Id: zone.Id})
+	if err != nil {
+		return nil, fmt.Errorf("error getting hosted zone %q: %v", aws.StringValue(zone.Id), err)
+	}
+	if response.DelegationSet == nil {
+		return nil, nil
+	}
+	return aws.StringValueSlice(response.DelegationSet.NameServers), nil
+}
+
+// conflictingRecordSets returns the existing record sets which must be deleted before the record set can be
+// created: a CNAME can't coexist with other records, and a name can't have both simple record sets and
+// record sets with a routing policy (such as failover or latency), so switching between them requires
+// deleting the old record sets in the same batch
+func (d *Route53DNSProvider) conflictingRecordSets(ctx context.Context, key kope.DNSRecordKey) ([]*route53.ResourceRecordSet, error) {
+	var conflicts []*route53.ResourceRecordSet
+
+	if conflicting := conflictingType(key.Type); conflicting != "" {
+		existing, err := d.listRecordSets(ctx, key.Name, conflicting)
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, existing...)
+	}
+
+	existing, err := d.listRecordSets(ctx, key.Name, key.Type)
+	if err != nil {
+		return nil, err
+	}
+	for _, rrs := range existing {
+		if (rrs.SetIdentifier == nil) != (key.SetIdentifier == "") {
+			conflicts = append(conflicts, rrs)
+		}
+	}
+	return conflicts, nil
+}
+
+// listRecordSets returns all the record sets with the name and type, whatever their set identifier
+func (d *Route53DNSProvider) listRecordSets(ctx context.Context, name string, recordType string) ([]*route53.ResourceRecordSet, error) {
+	zone, err := d.getZone(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if zone == nil {
+		return nil, fmt.Errorf("hosted zone %q not found", d.zoneName)
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	request := &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    zone.Id,
+		StartRecordName: aws.String(name),
+		StartRecordType: aws.String(recordType),
+	}
+
+	var recordSets []*route53.ResourceRecordSet
+	err = d.route53.ListResourceRecordSetsPagesWithContext(ctx, request, func(p *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+		for _, rrs := range p.ResourceRecordSets {
+			if normalizeRecordName(aws.StringValue(rrs.Name)) != normalizeRecordName(name) || aws.StringValue(rrs.Type) != recordType {
+				// Record sets are sorted by name and type, so we're past them
+				return false
+			}
+			recordSets = append(recordSets, rrs)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing ResourceRecordSets for %s %s: %v", name, recordType, err)
+	}
+	return recordSets, nil
+}
+
+// normalizeRecordName returns the name in a form we can compare: Route53 returns names with a trailing dot,
+// and escapes "*" (in wildcard names) as "\052"
+func normalizeRecordName(name string) string {
+	return strings.Replace(strings.TrimSuffix(name, "."), "\\052", "*", -1)
+}
+
+// conflictingType returns the record type which cannot coexist with recordType at the same name, if any
+func conflictingType(recordType string) string {
+	switch recordType {
+	case kope.DNSTypeA:
+		return kope.DNSTypeCNAME
+	case kope.DNSTypeCNAME:
+		return kope.DNSTypeA
+	}
+	return ""
+}
+
+// ListRecords returns the values of the record set, or nil if there is no such record set
+func (d *Route53DNSProvider) ListRecords(ctx context.Context, key kope.DNSRecordKey) ([]string, error) {
+	rrs, err := d.listRecordSet(ctx, key)
+	if err != nil || rrs == nil {
+		return nil, err
+	}
+
+	if rrs.AliasTarget != nil {
+		return []string{aws.StringValue(rrs.AliasTarget.DNSName)}, nil
+	}
+
+	var values []string
+	for _, rr := range rrs.ResourceRecords {
+		values = append(values, aws.StringValue(rr.Value))
+	}
+	return values, nil
+}
+
+// listRecordSet returns the record set, or nil if there is no such record set
+func (d *Route53DNSProvider) listRecordSet(ctx context.Context, key kope.DNSRecordKey) (*route53.ResourceRecordSet, error) {
+	zone, err := d.getZone(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if zone == nil {
+		return nil, fmt.Errorf("hosted zone %q not found", d.zoneName)
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	request := &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    zone.Id,
+		StartRecordName: aws.String(key.Name),
+		StartRecordType: aws.String(key.Type),
+		MaxItems:        aws.String("1"),
+	}
+	if key.SetIdentifier != "" {
+		request.StartRecordIdentifier = aws.String(key.SetIdentifier)
+	}
+
+	response, err := d.route53.ListResourceRecordSetsWithContext(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("error listing ResourceRecordSets for %s: %v", key, err)
+	}
+
+	for _, rrs := range response.ResourceRecordSets {
+		if normalizeRecordName(aws.StringValue(rrs.Name)) != normalizeRecordName(key.Name) || aws.StringValue(rrs.Type) != key.Type {
+			continue
+		}
+		if aws.StringValue(rrs.SetIdentifier) != key.SetIdentifier {
+			continue
+		}
+		return rrs, nil
+	}
+	return nil, nil
+}
+
+// DeleteRecords deletes the record sets, which must exactly match the values previously applied
+func (d *Route53DNSProvider) DeleteRecords(ctx context.Context, records map[kope.DNSRecordKey][]string) error {
+	changeBatch := &route53.ChangeBatch{}
+	for key, hosts := range records {
+		rrs := &route53.ResourceRecordSet{
+			Name: aws.String(key.Name),
+			Type: aws.String(key.Type),
+		}
+		if key.AliasHostedZoneID != "" && len(hosts) == 1 {
+			rrs.AliasTarget = aliasTarget(key, hosts[0])
+		} else {
+			rrs.TTL = aws.Int64(int64(defaultTTL.Seconds()))
+			for _, host := range hosts {
+				rrs.ResourceRecords = append(rrs.ResourceRecords, &route53.ResourceRecord{Value: aws.String(host)})
+			}
+		}
+
+		changeBatch.Changes = append(changeBatch.Changes, &route53.Change{
+			Action:            aws.String("DELETE"),
+			ResourceRecordSet: rrs,
+		})
+	}
+
+	glog.V(2).Infof("Deleting DNS records %q", records)
+
+	return d.changeRecordSets(ctx, changeBatch)
+}
+
+// aliasTarget builds the alias target of an alias record set pointing at dnsName
+func aliasTarget(key kope.DNSRecordKey, dnsName string) *route53.AliasTarget {
+	return &route53.AliasTarget{
+		DNSName:              aws.String(dnsName),
+		HostedZoneId:         aws.String(key.AliasHostedZoneID),
+		EvaluateTargetHealth: aws.Bool(true),
+	}
+}
+
+// changeRecordSets applies changeBatch to the zone, split into as many requests as Route53's limits require
+func (d *Route53DNSProvider) changeRecordSets(ctx context.Context, changeBatch *route53.ChangeBatch) error {
+	batches, err := splitChangeBatch(changeBatch)
+	if err != nil {
+		return err
+	}
+	if len(batches) > 1 {
+		glog.Infof("Applying %d DNS changes in %d batches", len(changeBatch.Changes), len(batches))
+	}
+	for _, batch := range batches {
+		if err := d.submitChangeBatch(ctx, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// submitChangeBatch applies a single changeBatch to the zone
+func (d *Route53DNSProvider) submitChangeBatch(ctx context.Context, changeBatch *route53.ChangeBatch) error {
+	zone, err := d.getZone(ctx)
+	if err != nil {
+		return err
+	}
+	if zone == nil {
+		return fmt.Errorf("hosted zone %q not found", d.zoneName)
+	}
+
 	request := &route53.ChangeResourceRecordSetsInput{}
 	request.HostedZoneId = zone.Id
 	request.ChangeBatch = changeBatch
 
-	glog.V(2).Infof("Updating DNS records %q", records)
 	glog.V(4).Infof("route53 request: %s", utils.DebugString(request))
 
-	response, err := d.route53.ChangeResourceRecordSets(request)
+	changeCtx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	response, err := d.route53.ChangeResourceRecordSetsWithContext(changeCtx, request)
 	if err != nil {
 		return fmt.Errorf("error creating ResourceRecordSets: %v", err)
 	}
 
 	glog.V(2).Infof("Change id is %q", aws.StringValue(response.ChangeInfo.Id))
 
+	if d.waitTimeout != 0 {
+		return d.waitForChange(ctx, response.ChangeInfo)
+	}
 	return nil
 }
 
@@ -160,3 +779,21 @@ func AWSErrorCode(err error) string {
 	}
 	return ""
 }
+
+// Route53ProviderName is the name under which RegisterRoute53DNSProvider registers Route53
+const Route53ProviderName = "route53"
+
+// RegisterRoute53DNSProvider registers Route53 as a DNS provider, configured by options; if shards are
+// set, the records beneath them are sharded into delegated zones (see ShardedRoute53DNSProvider)
+func RegisterRoute53DNSProvider(options Route53Options, shards []string) {
+	kope.RegisterDNSProvider(Route53ProviderName, func(providerOptions kope.DNSProviderOptions) (kope.DNSProvider, error) {
+		options := options
+		options.OwnerID = providerOptions.OwnerID
+		options.ForceOverwrite = providerOptions.ForceOverwrite
+		options.PrivateZone = providerOptions.PrivateZone
+		if len(shards) != 0 {
+			return NewShardedRoute53DNSProvider(providerOptions.ZoneName, options, shards)
+		}
+		return NewRoute53DNSProvider(providerOptions.ZoneName, options)
+	})
+}
@@ -0,0 +1,88 @@
+package kopeaws
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"strings"
+)
+
+// The limits Route53 places on a single ChangeResourceRecordSets request
+const (
+	// maxBatchRecords is the maximum number of ResourceRecord elements
+	maxBatchRecords = 1000
+	// maxBatchValueCharacters is the maximum total length of the ResourceRecord values
+	maxBatchValueCharacters = 32000
+)
+
+// changeSize returns the number of ResourceRecord elements and value characters a change counts
+// for, against Route53's limits; UPSERTs count twice
+func changeSize(change *route53.Change) (int, int) {
+	records := 0
+	characters := 0
+	if rrs := change.ResourceRecordSet; rrs != nil {
+		for _, rr := range rrs.ResourceRecords {
+			records++
+			characters += len(aws.StringValue(rr.Value))
+		}
+		if rrs.AliasTarget != nil {
+			records++
+			characters += len(aws.StringValue(rrs.AliasTarget.DNSName))
+		}
+	}
+	if aws.StringValue(change.Action) == route53.ChangeActionUpsert {
+		records *= 2
+		characters *= 2
+	}
+	return records, characters
+}
+
+// changeName returns the name a change applies to, treating ownership records as part of the name they mark
+func changeName(change *route53.Change) string {
+	if change.ResourceRecordSet == nil {
+		return ""
+	}
+	return strings.TrimPrefix(normalizeRecordName(aws.StringValue(change.ResourceRecordSet.Name)), ownerRecordPrefix)
+}
+
+// splitChangeBatch splits changeBatch into batches within Route53's limits.  All the changes to a name
+// are kept in the same batch, so that (for example) replacing an A record with a CNAME stays atomic.
+func splitChangeBatch(changeBatch *route53.ChangeBatch) ([]*route53.ChangeBatch, error) {
+	var names []string
+	groups := make(map[string][]*route53.Change)
+	for _, change := range changeBatch.Changes {
+		name := changeName(change)
+		if _, found := groups[name]; !found {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], change)
+	}
+
+	var batches []*route53.ChangeBatch
+	current := &route53.ChangeBatch{Comment: changeBatch.Comment}
+	records, characters := 0, 0
+	for _, name := range names {
+		groupRecords, groupCharacters := 0, 0
+		for _, change := range groups[name] {
+			r, c := changeSize(change)
+			groupRecords += r
+			groupCharacters += c
+		}
+		if groupRecords > maxBatchRecords || groupCharacters > maxBatchValueCharacters {
+			return nil, fmt.Errorf("changes to %q exceed the limits of a single route53 request", name)
+		}
+
+		if records+groupRecords > maxBatchRecords || characters+groupCharacters > maxBatchValueCharacters {
+			batches = append(batches, current)
+			current = &route53.ChangeBatch{Comment: changeBatch.Comment}
+			records, characters = 0, 0
+		}
+		current.Changes = append(current.Changes, groups[name]...)
+		records += groupRecords
+		characters += groupCharacters
+	}
+	if len(current.Changes) != 0 {
+		batches = append(batches, current)
+	}
+	return batches, nil
+}
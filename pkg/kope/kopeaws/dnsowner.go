@@ -0,0 +1,292 @@
+package kopeaws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"sort"
+	"strings"
+)
+
+// ownerRecordPrefix is prepended to a name to form the name of the TXT record marking its ownership
+const ownerRecordPrefix = "_aws-controller."
+
+// ownerRecordName returns the name of the ownership record for name
+func ownerRecordName(name string) string {
+	return ownerRecordPrefix + name
+}
+
+// ownerRecordValue returns the value of our ownership records (TXT values are quoted)
+func (d *Route53DNSProvider) ownerRecordValue() string {
+	return "\"heritage=aws-controller,owner=" + d.ownerID + "\""
+}
+
+// ownerRecordSet builds the ownership record set for name
+func (d *Route53DNSProvider) ownerRecordSet(name string) *route53.ResourceRecordSet {
+	return &route53.ResourceRecordSet{
+		Name: aws.String(ownerRecordName(name)),
+		Type: aws.String(route53.RRTypeTxt),
+		TTL:  aws.Int64(int64(defaultTTL.Seconds())),
+		ResourceRecords: []*route53.ResourceRecord{
+			{Value: aws.String(d.ownerRecordValue())},
+		},
+	}
+}
+
+// ListOwnedDNSRecords returns the A, CNAME, SRV and PTR record sets at the names marked with our ownership record
+func (d *Route53DNSProvider) ListOwnedDNSRecords(ctx context.Context) (map[kope.DNSRecordKey][]string, error) {
+	if d.ownerID == "" {
+		return nil, nil
+	}
+
+	zone, err := d.getZone(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if zone == nil {
+		return nil, fmt.Errorf("hosted zone %q not found", d.zoneName)
+	}
+
+	var all []*route53.ResourceRecordSet
+	listCtx, cancel := withTimeout(ctx)
+	err = d.route53.ListResourceRecordSetsPagesWithContext(listCtx, &route53.ListResourceRecordSetsInput{HostedZoneId: zone.Id}, func(p *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+		all = append(all, p.ResourceRecordSets...)
+		return true
+	})
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("error listing ResourceRecordSets in zone %q: %v", d.zoneName, err)
+	}
+
+	owned := make(map[string]bool)
+	defer d.recordOwned(owned)
+	for _, rrs := range all {
+		name := normalizeRecordName(aws.StringValue(rrs.Name))
+		if aws.StringValue(rrs.Type) != route53.RRTypeTxt || !strings.HasPrefix(name, ownerRecordPrefix) {
+			continue
+		}
+		for _, rr := range rrs.ResourceRecords {
+			if aws.StringValue(rr.Value) == d.ownerRecordValue() {
+				owned[strings.TrimPrefix(name, ownerRecordPrefix)] = true
+			}
+		}
+	}
+
+	records := make(map[kope.DNSRecordKey][]string)
+	for _, rrs := range all {
+		switch aws.StringValue(rrs.Type) {
+		case kope.DNSTypeA, kope.DNSTypeCNAME, kope.DNSTypeSRV, kope.DNSTypePTR:
+		default:
+			continue
+		}
+		if !owned[normalizeRecordName(aws.StringValue(rrs.Name))] {
+			continue
+		}
+
+		key, values, err := d.recordKey(ctx, rrs)
+		if err != nil {
+			return nil, err
+		}
+		records[key] = values
+	}
+
+	glog.V(2).Infof("Found %d record sets owned by %q in zone %q", len(records), d.ownerID, d.zoneName)
+	return records, nil
+}
+
+// recordOwned caches names as carrying our ownership record
+func (d *Route53DNSProvider) recordOwned(names map[string]bool) {
+	if d.ownedNames == nil {
+		d.ownedNames = make(map[string]bool)
+	}
+	for name := range names {
+		d.ownedNames[normalizeRecordName(name)] = true
+	}
+}
+
+// mayOverwrite returns true if we may publish records at name: it is ours, or has no records at all, or
+// forceOverwrite is set
+func (d *Route53DNSProvider) mayOverwrite(ctx context.Context, name string) (bool, error) {
+	name = normalizeRecordName(name)
+	if d.forceOverwrite || d.ownedNames[name] {
+		return true, nil
+	}
+
+	owners, err := d.listRecordSets(ctx, ownerRecordName(name), route53.RRTypeTxt)
+	if err != nil {
+		return false, err
+	}
+	for _, rrs := range owners {
+		for _, rr := range rrs.ResourceRecords {
+			if aws.StringValue(rr.Value) == d.ownerRecordValue() {
+				d.recordOwned(map[string]bool{name: true})
+				return true, nil
+			}
+		}
+	}
+	if len(owners) != 0 {
+		glog.V(2).Infof("Name %q is owned by %v", name, owners)
+		return false, nil
+	}
+
+	exists, err := d.hasRecordSets(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	return !exists, nil
+}
+
+// hasRecordSets returns true if there are any record sets at the (normalized) name
+func (d *Route53DNSProvider) hasRecordSets(ctx context.Context, name string) (bool, error) {
+	zone, err := d.getZone(ctx)
+	if err != nil {
+		return false, err
+	}
+	if zone == nil {
+		return false, fmt.Errorf("hosted zone %q not found", d.zoneName)
+	}
+
+	listCtx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	// Record sets are sorted by name, so the first one from name onwards tells us if there are any
+	response, err := d.route53.ListResourceRecordSetsWithContext(listCtx, &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    zone.Id,
+		StartRecordName: aws.String(name),
+		MaxItems:        aws.String("1"),
+	})
+	if err != nil {
+		return false, fmt.Errorf("error listing ResourceRecordSets for %s: %v", name, err)
+	}
+	for _, rrs := range response.ResourceRecordSets {
+		if normalizeRecordName(aws.StringValue(rrs.Name)) == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// recordKey converts a record set to the key and (sorted) values we would have applied
+func (d *Route53DNSProvider) recordKey(ctx context.Context, rrs *route53.ResourceRecordSet) (kope.DNSRecordKey, []string, error) {
+	key := kope.DNSRecordKey{
+		Name:          normalizeRecordName(aws.StringValue(rrs.Name)),
+		Type:          aws.StringValue(rrs.Type),
+		SetIdentifier: aws.StringValue(rrs.SetIdentifier),
+		Failover:      aws.StringValue(rrs.Failover),
+		Region:        aws.StringValue(rrs.Region),
+		MultiValue:    aws.BoolValue(rrs.MultiValueAnswer),
+		Weighted:      rrs.Weight != nil,
+		Weight:        aws.Int64Value(rrs.Weight),
+	}
+
+	if rrs.HealthCheckId != nil {
+		getCtx, cancel := withTimeout(ctx)
+		response, err := d.route53.GetHealthCheckWithContext(getCtx, &route53.GetHealthCheckInput{HealthCheckId: rrs.HealthCheckId})
+		cancel()
+		if err != nil {
+			return key, nil, fmt.Errorf("error getting health check %q of %s: %v", aws.StringValue(rrs.HealthCheckId), key, err)
+		}
+		if config := response.HealthCheck.HealthCheckConfig; config != nil {
+			key.HealthCheckPort = int(aws.Int64Value(config.Port))
+		}
+	}
+
+	if rrs.AliasTarget != nil {
+		key.AliasHostedZoneID = aws.StringValue(rrs.AliasTarget.HostedZoneId)
+		return key, []string{strings.TrimSuffix(aws.StringValue(rrs.AliasTarget.DNSName), ".")}, nil
+	}
+
+	var values []string
+	for _, rr := range rrs.ResourceRecords {
+		values = append(values, aws.StringValue(rr.Value))
+	}
+	sort.Strings(values)
+	return key, values, nil
+}
+
+// DeleteDNSRecords deletes the record sets (and any health checks we created for them), whatever their
+// values; record sets which don't exist are ignored.  The ownership record of a name is deleted along with
+// its last record set, so that names we no longer publish don't stay marked as ours.
+func (d *Route53DNSProvider) DeleteDNSRecords(ctx context.Context, keys []kope.DNSRecordKey) error {
+	changeBatch := &route53.ChangeBatch{}
+	var healthChecks []string
+	names := make(map[string]bool)
+	for _, key := range keys {
+		names[normalizeRecordName(key.Name)] = true
+
+		rrs, err := d.listRecordSet(ctx, key)
+		if err != nil {
+			return err
+		}
+		if rrs == nil {
+			continue
+		}
+
+		glog.Infof("Deleting DNS record %s", key)
+		changeBatch.Changes = append(changeBatch.Changes, &route53.Change{
+			Action:            aws.String("DELETE"),
+			ResourceRecordSet: rrs,
+		})
+		if rrs.HealthCheckId != nil {
+			healthChecks = append(healthChecks, aws.StringValue(rrs.HealthCheckId))
+		}
+	}
+	if len(changeBatch.Changes) != 0 {
+		if err := d.changeRecordSets(ctx, changeBatch); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range healthChecks {
+		if err := d.deleteHealthCheck(ctx, id); err != nil {
+			glog.Warningf("error deleting unused health check: %v", err)
+		}
+	}
+	return d.deleteOwnerRecords(ctx, names)
+}
+
+// deleteOwnerRecords deletes our ownership records of the names which no longer have any record sets
+func (d *Route53DNSProvider) deleteOwnerRecords(ctx context.Context, names map[string]bool) error {
+	if d.ownerID == "" {
+		return nil
+	}
+
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	changeBatch := &route53.ChangeBatch{}
+	for _, name := range sorted {
+		exists, err := d.hasRecordSets(ctx, name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		owners, err := d.listRecordSets(ctx, ownerRecordName(name), route53.RRTypeTxt)
+		if err != nil {
+			return err
+		}
+		for _, rrs := range owners {
+			if len(rrs.ResourceRecords) != 1 || aws.StringValue(rrs.ResourceRecords[0].Value) != d.ownerRecordValue() {
+				continue
+			}
+			glog.V(2).Infof("Deleting ownership record of %q", name)
+			changeBatch.Changes = append(changeBatch.Changes, &route53.Change{
+				Action:            aws.String("DELETE"),
+				ResourceRecordSet: rrs,
+			})
+			delete(d.ownedNames, name)
+		}
+	}
+	if len(changeBatch.Changes) == 0 {
+		return nil
+	}
+	return d.changeRecordSets(ctx, changeBatch)
+}
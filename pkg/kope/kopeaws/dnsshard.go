@@ -0,0 +1,366 @@
+package kopeaws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ShardedRoute53DNSProvider spreads records across multiple hosted zones, to keep
+// each zone small when managing very large numbers of records.
+//
+// A hosted zone can only hold names beneath its own apex, so the shards are a fixed,
+// configured set of subdomains of the parent zone: with the shard "nodes",
+// web-1.nodes.example.com is placed in a child zone nodes.example.com.  The shard of
+// a name depends only on the name, and names outside every shard (e.g.
+// api.example.com) stay in the parent zone.  The child zones are created and
+// delegated from the parent zone on first use, and any records we own beneath a
+// shard are then moved out of the parent zone.
+type ShardedRoute53DNSProvider struct {
+	parent *Route53DNSProvider
+
+	// shardLabels are the configured shards, as labels directly beneath the parent zone
+	shardLabels map[string]bool
+
+	// mutex protects shards
+	mutex sync.Mutex
+	// shards holds the providers for child zones which have been delegated, keyed by zone name
+	shards map[string]*Route53DNSProvider
+}
+
+var _ kope.DNSProvider = &ShardedRoute53DNSProvider{}
+var _ kope.OwnedDNSProvider = &ShardedRoute53DNSProvider{}
+
+// NewShardedRoute53DNSProvider builds a provider sharding the records beneath each of shards, labels
+// directly beneath zoneName, into a delegated hosted zone
+func NewShardedRoute53DNSProvider(zoneName string, options Route53Options, shards []string) (*ShardedRoute53DNSProvider, error) {
+	shardLabels := make(map[string]bool)
+	for _, shard := range shards {
+		shard = strings.ToLower(strings.TrimSpace(shard))
+		if shard == "" {
+			continue
+		}
+		if strings.Contains(shard, ".") {
+			return nil, fmt.Errorf("invalid DNS shard %q: must be a single label", shard)
+		}
+		shardLabels[shard] = true
+	}
+	if len(shardLabels) == 0 {
+		return nil, fmt.Errorf("no DNS shards specified")
+	}
+
+	parent, err := NewRoute53DNSProvider(zoneName, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ShardedRoute53DNSProvider{
+		parent:      parent,
+		shardLabels: shardLabels,
+		shards:      make(map[string]*Route53DNSProvider),
+	}, nil
+}
+
+// ZoneName returns the DNS name of the parent hosted zone
+func (d *ShardedRoute53DNSProvider) ZoneName(ctx context.Context) (string, error) {
+	return d.parent.ZoneName(ctx)
+}
+
+// ListNameServers returns the name servers of the parent hosted zone; names in child zones are answered
+// with a referral, which is not authoritative
+func (d *ShardedRoute53DNSProvider) ListNameServers(ctx context.Context) ([]string, error) {
+	return d.parent.ListNameServers(ctx)
+}
+
+func (d *ShardedRoute53DNSProvider) ApplyDNSChanges(ctx context.Context, records map[kope.DNSRecordKey][]string) error {
+	byShard := make(map[*Route53DNSProvider]map[kope.DNSRecordKey][]string)
+	for key, values := range records {
+		provider, err := d.providerFor(ctx, key.Name)
+		if err != nil {
+			return err
+		}
+		if byShard[provider] == nil {
+			byShard[provider] = make(map[kope.DNSRecordKey][]string)
+		}
+		byShard[provider][key] = values
+	}
+
+	var errors []error
+	for provider, shardRecords := range byShard {
+		if err := provider.ApplyDNSChanges(ctx, shardRecords); err != nil {
+			errors = append(errors, fmt.Errorf("error applying DNS changes to zone %q: %v", provider.zoneName, err))
+		}
+	}
+
+	if len(errors) != 0 {
+		return fmt.Errorf("error applying sharded DNS changes: %v", errors)
+	}
+	return nil
+}
+
+// ListOwnedDNSRecords returns the record sets at the names we own, in the parent zone and every shard
+func (d *ShardedRoute53DNSProvider) ListOwnedDNSRecords(ctx context.Context) (map[kope.DNSRecordKey][]string, error) {
+	if d.parent.ownerID == "" {
+		return nil, nil
+	}
+
+	shards, err := d.ensureShards(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[kope.DNSRecordKey][]string)
+	for _, provider := range append([]*Route53DNSProvider{d.parent}, shards...) {
+		owned, err := provider.ListOwnedDNSRecords(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range owned {
+			records[key] = values
+		}
+	}
+	return records, nil
+}
+
+// DeleteDNSRecords deletes the record sets from the zones holding them
+func (d *ShardedRoute53DNSProvider) DeleteDNSRecords(ctx context.Context, keys []kope.DNSRecordKey) error {
+	byShard := make(map[*Route53DNSProvider][]kope.DNSRecordKey)
+	for _, key := range keys {
+		provider, err := d.providerFor(ctx, key.Name)
+		if err != nil {
+			return err
+		}
+		byShard[provider] = append(byShard[provider], key)
+	}
+
+	for provider, shardKeys := range byShard {
+		if err := provider.DeleteDNSRecords(ctx, shardKeys); err != nil {
+			return fmt.Errorf("error deleting DNS records from zone %q: %v", provider.zoneName, err)
+		}
+	}
+	return nil
+}
+
+// providerFor returns the provider for the zone which should hold name
+func (d *ShardedRoute53DNSProvider) providerFor(ctx context.Context, name string) (*Route53DNSProvider, error) {
+	if _, err := d.ensureShards(ctx); err != nil {
+		return nil, err
+	}
+
+	parentZone, err := d.parent.getZone(ctx)
+	if err != nil {
+		return nil, err
+	}
+	shard := d.shardZoneName(name, aws.StringValue(parentZone.Name))
+	if shard == "" {
+		return d.parent, nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.shards[shard], nil
+}
+
+// shardZoneName returns the name of the child zone that should hold name, or "" if it belongs in the parent zone.
+// A shard's own name (e.g. nodes.example.com) is the apex of its child zone.
+func (d *ShardedRoute53DNSProvider) shardZoneName(name string, apex string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	apex = strings.ToLower(strings.TrimSuffix(apex, "."))
+
+	if !strings.HasSuffix(name, "."+apex) {
+		return ""
+	}
+	labels := strings.Split(strings.TrimSuffix(name, "."+apex), ".")
+	label := labels[len(labels)-1]
+	if !d.shardLabels[label] {
+		return ""
+	}
+	return label + "." + apex
+}
+
+// ensureShards creates and delegates the child zone of each shard, once, returning their providers
+func (d *ShardedRoute53DNSProvider) ensureShards(ctx context.Context) ([]*Route53DNSProvider, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	parentZone, err := d.parent.getZone(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if parentZone == nil {
+		return nil, fmt.Errorf("hosted zone %q not found", d.parent.zoneName)
+	}
+	if parentZone.Config != nil && aws.BoolValue(parentZone.Config.PrivateZone) {
+		// Route53 does not support delegation from private zones
+		return nil, fmt.Errorf("cannot shard private hosted zone %q", aws.StringValue(parentZone.Name))
+	}
+	apex := strings.TrimSuffix(aws.StringValue(parentZone.Name), ".")
+
+	var labels []string
+	for label := range d.shardLabels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	var providers []*Route53DNSProvider
+	for _, label := range labels {
+		shard := label + "." + apex
+		provider := d.shards[shard]
+		if provider == nil {
+			provider, err = d.createShard(ctx, parentZone, shard)
+			if err != nil {
+				return nil, err
+			}
+			d.shards[shard] = provider
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// createShard finds or creates the named child zone, moves the records we own beneath it from the parent zone,
+// and delegates it from the parent zone
+func (d *ShardedRoute53DNSProvider) createShard(ctx context.Context, parentZone *route53.HostedZone, shard string) (*Route53DNSProvider, error) {
+	provider := &Route53DNSProvider{
+		route53:        d.parent.route53,
+		zoneName:       shard,
+		ownerID:        d.parent.ownerID,
+		forceOverwrite: d.parent.forceOverwrite,
+		waitTimeout:    d.parent.waitTimeout,
+		privateZone:    aws.Bool(false),
+	}
+
+	zone, err := provider.getZone(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var nameServers []*string
+	if zone == nil {
+		zone, nameServers, err = d.createShardZone(ctx, parentZone, shard)
+		if err != nil {
+			return nil, err
+		}
+		provider.zone = zone
+	} else {
+		nameServers, err = d.getNameServers(ctx, zone)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// We copy our records into the child zone before delegating it, so that they keep resolving
+	migrated, err := d.copyOwnedRecords(ctx, provider, aws.StringValue(parentZone.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.parent.delegate(ctx, shard, nameServers); err != nil {
+		return nil, err
+	}
+
+	if len(migrated) != 0 {
+		glog.Infof("Moved %d DNS records from zone %q to DNS shard %q", len(migrated), d.parent.zoneName, shard)
+		if err := d.parent.DeleteDNSRecords(ctx, migrated); err != nil {
+			return nil, fmt.Errorf("error deleting DNS records moved to shard %q: %v", shard, err)
+		}
+	}
+	return provider, nil
+}
+
+// copyOwnedRecords applies the records we own beneath the shard in the parent zone to the shard's zone,
+// returning their keys
+func (d *ShardedRoute53DNSProvider) copyOwnedRecords(ctx context.Context, provider *Route53DNSProvider, apex string) ([]kope.DNSRecordKey, error) {
+	if d.parent.ownerID == "" {
+		glog.Warningf("Not moving existing records into DNS shard %q, as we are not recording ownership", provider.zoneName)
+		return nil, nil
+	}
+
+	owned, err := d.parent.ListOwnedDNSRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+	records := make(map[kope.DNSRecordKey][]string)
+	var keys []kope.DNSRecordKey
+	for key, values := range owned {
+		if d.shardZoneName(key.Name, apex) != provider.zoneName {
+			continue
+		}
+		records[key] = values
+		keys = append(keys, key)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	if err := provider.ApplyDNSChanges(ctx, records); err != nil {
+		return nil, fmt.Errorf("error copying DNS records to shard %q: %v", provider.zoneName, err)
+	}
+	return keys, nil
+}
+
+// createShardZone creates the named child zone.  The caller reference is derived from the parent zone, so
+// that concurrent or retried creations cannot create duplicate zones.
+func (d *ShardedRoute53DNSProvider) createShardZone(ctx context.Context, parentZone *route53.HostedZone, shard string) (*route53.HostedZone, []*string, error) {
+	glog.Infof("Creating hosted zone for DNS shard %q", shard)
+
+	callerReference := fmt.Sprintf("aws-controller-%s-%s", strings.TrimPrefix(aws.StringValue(parentZone.Id), "/hostedzone/"), shard)
+	request := &route53.CreateHostedZoneInput{
+		Name:            aws.String(shard),
+		CallerReference: aws.String(callerReference),
+		HostedZoneConfig: &route53.HostedZoneConfig{
+			Comment: aws.String("DNS shard of " + d.parent.zoneName + ", managed by aws-controller"),
+		},
+	}
+
+	createCtx, cancel := withTimeout(ctx)
+	response, err := d.parent.route53.CreateHostedZoneWithContext(createCtx, request)
+	cancel()
+	if err != nil {
+		if AWSErrorCode(err) != route53.ErrCodeHostedZoneAlreadyExists {
+			return nil, nil, fmt.Errorf("error creating hosted zone %q: %v", shard, err)
+		}
+
+		// The zone was created concurrently, or by an earlier attempt whose response we lost
+		provider := &Route53DNSProvider{route53: d.parent.route53, zoneName: shard, privateZone: aws.Bool(false)}
+		zone, err := provider.getZone(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if zone == nil {
+			return nil, nil, fmt.Errorf("hosted zone %q was previously created with caller reference %q, but no longer exists; it must be recreated manually", shard, callerReference)
+		}
+		nameServers, err := d.getNameServers(ctx, zone)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zone, nameServers, nil
+	}
+
+	return response.HostedZone, response.DelegationSet.NameServers, nil
+}
+
+func (d *ShardedRoute53DNSProvider) getNameServers(ctx context.Context, zone *route53.HostedZone) ([]*string, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	request := &route53.GetHostedZoneInput{
+		Id: zone.Id,
+	}
+
+	response, err := d.parent.route53.GetHostedZoneWithContext(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("error querying for DNS HostedZone %q: %v", aws.StringValue(zone.Name), err)
+	}
+	if response.DelegationSet == nil {
+		return nil, fmt.Errorf("hosted zone %q has no delegation set", aws.StringValue(zone.Name))
+	}
+
+	return response.DelegationSet.NameServers, nil
+}
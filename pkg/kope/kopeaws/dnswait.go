@@ -0,0 +1,91 @@
+package kopeaws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/golang/glog"
+	"sync"
+	"time"
+)
+
+// changePollInterval is how often we poll for a change to reach INSYNC; Route53 changes
+// typically propagate within 60 seconds
+const changePollInterval = 5 * time.Second
+
+// Route53PropagationStats describes how long our Route53 changes took to reach INSYNC
+type Route53PropagationStats struct {
+	// Changes is the number of changes which reached INSYNC
+	Changes int `json:"changes"`
+	// Timeouts is the number of changes which did not reach INSYNC in time
+	Timeouts int `json:"timeouts"`
+	// LastLatency and MaxLatency are the last and longest times from submitting a change to INSYNC
+	LastLatency time.Duration `json:"lastLatency"`
+	MaxLatency  time.Duration `json:"maxLatency"`
+}
+
+var (
+	// propagationMutex protects propagationStats
+	propagationMutex sync.Mutex
+	propagationStats Route53PropagationStats
+)
+
+// GetRoute53PropagationStats returns the propagation statistics of all Route53 changes we have waited for
+func GetRoute53PropagationStats() Route53PropagationStats {
+	propagationMutex.Lock()
+	defer propagationMutex.Unlock()
+	return propagationStats
+}
+
+func recordPropagation(latency time.Duration, timedOut bool) {
+	propagationMutex.Lock()
+	defer propagationMutex.Unlock()
+
+	if timedOut {
+		propagationStats.Timeouts++
+		return
+	}
+	propagationStats.Changes++
+	propagationStats.LastLatency = latency
+	if latency > propagationStats.MaxLatency {
+		propagationStats.MaxLatency = latency
+	}
+}
+
+// waitForChange polls the change until it is INSYNC, returning an error if that takes longer than waitTimeout
+func (d *Route53DNSProvider) waitForChange(ctx context.Context, changeInfo *route53.ChangeInfo) error {
+	id := aws.StringValue(changeInfo.Id)
+	start := time.Now()
+	if changeInfo.SubmittedAt != nil {
+		start = *changeInfo.SubmittedAt
+	}
+	deadline := time.Now().Add(d.waitTimeout)
+
+	status := aws.StringValue(changeInfo.Status)
+	for status != route53.ChangeStatusInsync {
+		if time.Now().After(deadline) {
+			recordPropagation(0, true)
+			return fmt.Errorf("route53 change %q was still %s after %v", id, status, d.waitTimeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("error waiting for route53 change %q: %v", id, ctx.Err())
+		case <-time.After(changePollInterval):
+		}
+
+		getCtx, cancel := withTimeout(ctx)
+		response, err := d.route53.GetChangeWithContext(getCtx, &route53.GetChangeInput{Id: aws.String(id)})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("error getting route53 change %q: %v", id, err)
+		}
+		status = aws.StringValue(response.ChangeInfo.Status)
+	}
+
+	latency := time.Since(start)
+	glog.V(2).Infof("Route53 change %q is INSYNC after %v", id, latency)
+	recordPropagation(latency, false)
+	return nil
+}
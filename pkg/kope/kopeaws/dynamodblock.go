@@ -0,0 +1,169 @@
+package kopeaws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/golang/glog"
+	"strconv"
+	"time"
+)
+
+// Attributes of the DynamoDB lock items; the table's partition key must be the string attribute LockName
+const (
+	dynamoDBLockAttributeName    = "LockName"
+	dynamoDBLockAttributeOwner   = "Owner"
+	dynamoDBLockAttributeExpires = "Expires"
+)
+
+// DynamoDBLock is a lease held by conditionally writing an item to a DynamoDB table, for electing a
+// single active controller where Kubernetes leader election is not available (e.g. when running as a
+// static pod while bootstrapping the API server).  The lease expiry is compared against our own clock,
+// so clocks must be roughly synchronized; LeaseDuration should be well above the expected skew.
+type DynamoDBLock struct {
+	// TableName is the DynamoDB table holding the locks
+	TableName string
+	// LockName identifies the lock within the table
+	LockName string
+	// Owner identifies us as the holder of the lock
+	Owner string
+
+	// LeaseDuration is how long the lock is held without being renewed
+	LeaseDuration time.Duration
+	// RenewPeriod is how often we renew the lock, or try to acquire it
+	RenewPeriod time.Duration
+
+	dynamodb *dynamodb.DynamoDB
+}
+
+func (a *AWSCloud) NewDynamoDBLock(tableName string, lockName string, owner string) *DynamoDBLock {
+	return &DynamoDBLock{
+		TableName:     tableName,
+		LockName:      lockName,
+		Owner:         owner,
+		LeaseDuration: 30 * time.Second,
+		RenewPeriod:   10 * time.Second,
+		dynamodb:      dynamodb.New(a.session, aws.NewConfig().WithRegion(a.region)),
+	}
+}
+
+// Run waits to acquire the lock, then runs onStartedLeading and holds the lock until ctx is done,
+// releasing it.  It returns an error if the lock could not be renewed before the lease expired, in which
+// case another owner may now hold it.
+func (l *DynamoDBLock) Run(ctx context.Context, onStartedLeading func(ctx context.Context)) error {
+	glog.Infof("waiting to acquire DynamoDB lock %s/%s as %q", l.TableName, l.LockName, l.Owner)
+	for {
+		acquired, err := l.tryAcquire(ctx)
+		if err != nil {
+			glog.Warningf("error acquiring DynamoDB lock %s/%s: %v", l.TableName, l.LockName, err)
+		}
+		if acquired {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(l.RenewPeriod):
+		}
+	}
+	glog.Infof("acquired DynamoDB lock %s/%s", l.TableName, l.LockName)
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go onStartedLeading(leaderCtx)
+
+	renewed := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			// ctx is already done, so we need a fresh context to release the lock
+			releaseCtx, cancel := context.WithTimeout(context.Background(), defaultAPITimeout)
+			defer cancel()
+			if err := l.release(releaseCtx); err != nil {
+				glog.Warningf("error releasing DynamoDB lock %s/%s: %v", l.TableName, l.LockName, err)
+			} else {
+				glog.Infof("released DynamoDB lock %s/%s", l.TableName, l.LockName)
+			}
+			return nil
+		case <-time.After(l.RenewPeriod):
+		}
+
+		acquired, err := l.tryAcquire(ctx)
+		if err != nil {
+			glog.Warningf("error renewing DynamoDB lock %s/%s: %v", l.TableName, l.LockName, err)
+		}
+		if acquired {
+			renewed = time.Now()
+			continue
+		}
+		if err == nil {
+			return fmt.Errorf("DynamoDB lock %s/%s was taken by another owner", l.TableName, l.LockName)
+		}
+		if time.Since(renewed) >= l.LeaseDuration-l.RenewPeriod {
+			return fmt.Errorf("unable to renew DynamoDB lock %s/%s before it expired: %v", l.TableName, l.LockName, err)
+		}
+	}
+}
+
+// tryAcquire writes the lock item if it is unheld, expired or already ours, returning false if another
+// owner holds it
+func (l *DynamoDBLock) tryAcquire(ctx context.Context) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now()
+	expires := now.Add(l.LeaseDuration)
+
+	request := &dynamodb.PutItemInput{
+		TableName: aws.String(l.TableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			dynamoDBLockAttributeName:    {S: aws.String(l.LockName)},
+			dynamoDBLockAttributeOwner:   {S: aws.String(l.Owner)},
+			dynamoDBLockAttributeExpires: {N: aws.String(strconv.FormatInt(expires.UnixNano()/int64(time.Millisecond), 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#name) OR #owner = :owner OR #expires < :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#name":    aws.String(dynamoDBLockAttributeName),
+			"#owner":   aws.String(dynamoDBLockAttributeOwner),
+			"#expires": aws.String(dynamoDBLockAttributeExpires),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {S: aws.String(l.Owner)},
+			":now":   {N: aws.String(strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10))},
+		},
+	}
+
+	if _, err := l.dynamodb.PutItemWithContext(ctx, request); err != nil {
+		if AWSErrorCode(err) == dynamodb.ErrCodeConditionalCheckFailedException {
+			return false, nil
+		}
+		return false, fmt.Errorf("error writing lock item: %v", err)
+	}
+	return true, nil
+}
+
+// release deletes the lock item, if we still hold it
+func (l *DynamoDBLock) release(ctx context.Context) error {
+	request := &dynamodb.DeleteItemInput{
+		TableName: aws.String(l.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			dynamoDBLockAttributeName: {S: aws.String(l.LockName)},
+		},
+		ConditionExpression: aws.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]*string{
+			"#owner": aws.String(dynamoDBLockAttributeOwner),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {S: aws.String(l.Owner)},
+		},
+	}
+
+	if _, err := l.dynamodb.DeleteItemWithContext(ctx, request); err != nil {
+		if AWSErrorCode(err) == dynamodb.ErrCodeConditionalCheckFailedException {
+			return nil
+		}
+		return fmt.Errorf("error deleting lock item: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package kopeaws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/golang/glog"
+	"time"
+)
+
+// TagNameServiceName is set by the kubernetes AWS cloud provider on load balancers it creates for Services
+const TagNameServiceName = "kubernetes.io/service-name"
+
+// DescribeTags accepts at most 20 load balancer names per call
+const maxELBDescribeTags = 20
+
+// ClassicLoadBalancer is a classic ELB belonging to the cluster
+type ClassicLoadBalancer struct {
+	Name    string
+	Region  string
+	Created time.Time
+	// ServiceName is the namespace/name of the Service the load balancer was created for, if any
+	ServiceName string
+	// Instances are the ids of the registered instances
+	Instances []string
+	Tags      map[string]string
+}
+
+func (a *AWSCloud) elb(region string) *elb.ELB {
+	return elb.New(a.session, aws.NewConfig().WithRegion(region))
+}
+
+// ListClassicLoadBalancers returns the classic ELBs tagged with our cluster tag, in all our regions
+func (a *AWSCloud) ListClassicLoadBalancers(ctx context.Context) ([]*ClassicLoadBalancer, error) {
+	var loadBalancers []*ClassicLoadBalancer
+	for _, region := range a.Regions() {
+		regionLoadBalancers, err := a.listClassicLoadBalancersInRegion(ctx, region)
+		if err != nil {
+			return nil, err
+		}
+		loadBalancers = append(loadBalancers, regionLoadBalancers...)
+	}
+	return loadBalancers, nil
+}
+
+func (a *AWSCloud) listClassicLoadBalancersInRegion(ctx context.Context, region string) ([]*ClassicLoadBalancer, error) {
+	client := a.elb(region)
+
+	all := make(map[string]*ClassicLoadBalancer)
+	var names []*string
+
+	callCtx, cancel := withTimeout(ctx)
+	err := client.DescribeLoadBalancersPagesWithContext(callCtx, &elb.DescribeLoadBalancersInput{}, func(p *elb.DescribeLoadBalancersOutput, lastPage bool) bool {
+		for _, lb := range p.LoadBalancerDescriptions {
+			l := &ClassicLoadBalancer{
+				Name:    aws.StringValue(lb.LoadBalancerName),
+				Region:  region,
+				Created: aws.TimeValue(lb.CreatedTime),
+			}
+			for _, i := range lb.Instances {
+				l.Instances = append(l.Instances, aws.StringValue(i.InstanceId))
+			}
+			all[l.Name] = l
+			names = append(names, lb.LoadBalancerName)
+		}
+		return true
+	})
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("error describing load balancers in %s: %v", region, err)
+	}
+
+	// Tags are not returned by DescribeLoadBalancers, so we must filter by cluster afterwards
+	var loadBalancers []*ClassicLoadBalancer
+	for start := 0; start < len(names); start += maxELBDescribeTags {
+		end := start + maxELBDescribeTags
+		if end > len(names) {
+			end = len(names)
+		}
+
+		callCtx, cancel := withTimeout(ctx)
+		response, err := client.DescribeTagsWithContext(callCtx, &elb.DescribeTagsInput{LoadBalancerNames: names[start:end]})
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("error describing load balancer tags in %s: %v", region, err)
+		}
+
+		for _, d := range response.TagDescriptions {
+			tags := make(map[string]string)
+			for _, t := range d.Tags {
+				tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+			}
+			if tags[TagNameKubernetesCluster] != a.clusterID {
+				continue
+			}
+			l := all[aws.StringValue(d.LoadBalancerName)]
+			if l == nil {
+				continue
+			}
+			l.ServiceName = tags[TagNameServiceName]
+			l.Tags = tags
+			loadBalancers = append(loadBalancers, l)
+		}
+	}
+
+	return loadBalancers, nil
+}
+
+// DeleteClassicLoadBalancer deletes a classic ELB
+func (a *AWSCloud) DeleteClassicLoadBalancer(ctx context.Context, lb *ClassicLoadBalancer) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Deleting load balancer %q in %s", lb.Name, lb.Region)
+
+	request := &elb.DeleteLoadBalancerInput{
+		LoadBalancerName: aws.String(lb.Name),
+	}
+
+	_, err := a.elb(lb.Region).DeleteLoadBalancerWithContext(ctx, request)
+	if err != nil {
+		return fmt.Errorf("error deleting load balancer %q: %v", lb.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package kopeaws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/golang/glog"
+)
+
+// elbv2ForARN returns an ELBv2 client for the region of the resource
+func (a *AWSCloud) elbv2ForARN(resourceARN string) (*elbv2.ELBV2, error) {
+	parsed, err := arn.Parse(resourceARN)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ARN %q: %v", resourceARN, err)
+	}
+	return a.elbv2(parsed.Region), nil
+}
+
+func (a *AWSCloud) elbv2(region string) *elbv2.ELBV2 {
+	return elbv2.New(a.session, aws.NewConfig().WithRegion(region))
+}
+
+// ListTargets returns the ids of the instances registered in a target group
+func (a *AWSCloud) ListTargets(ctx context.Context, targetGroupARN string) ([]string, error) {
+	client, err := a.elbv2ForARN(targetGroupARN)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	request := &elbv2.DescribeTargetHealthInput{
+		TargetGroupArn: aws.String(targetGroupARN),
+	}
+
+	response, err := client.DescribeTargetHealthWithContext(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("error listing targets of %q: %v", targetGroupARN, err)
+	}
+
+	var ids []string
+	for _, d := range response.TargetHealthDescriptions {
+		if d.Target != nil {
+			ids = append(ids, aws.StringValue(d.Target.Id))
+		}
+	}
+	return ids, nil
+}
+
+// RegisterTargets registers instances in a target group, on the target group's port
+func (a *AWSCloud) RegisterTargets(ctx context.Context, targetGroupARN string, instanceIDs []string) error {
+	client, err := a.elbv2ForARN(targetGroupARN)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Registering instances %v in target group %q", instanceIDs, targetGroupARN)
+
+	request := &elbv2.RegisterTargetsInput{
+		TargetGroupArn: aws.String(targetGroupARN),
+	}
+	for _, id := range instanceIDs {
+		request.Targets = append(request.Targets, &elbv2.TargetDescription{Id: aws.String(id)})
+	}
+
+	if _, err := client.RegisterTargetsWithContext(ctx, request); err != nil {
+		return fmt.Errorf("error registering targets in %q: %v", targetGroupARN, err)
+	}
+	return nil
+}
+
+// DeregisterTargets removes instances from a target group
+func (a *AWSCloud) DeregisterTargets(ctx context.Context, targetGroupARN string, instanceIDs []string) error {
+	client, err := a.elbv2ForARN(targetGroupARN)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Deregistering instances %v from target group %q", instanceIDs, targetGroupARN)
+
+	request := &elbv2.DeregisterTargetsInput{
+		TargetGroupArn: aws.String(targetGroupARN),
+	}
+	for _, id := range instanceIDs {
+		request.Targets = append(request.Targets, &elbv2.TargetDescription{Id: aws.String(id)})
+	}
+
+	if _, err := client.DeregisterTargetsWithContext(ctx, request); err != nil {
+		return fmt.Errorf("error deregistering targets from %q: %v", targetGroupARN, err)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package kopeaws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"sync"
+)
+
+// TagNameSecondaryENI marks the network interfaces we attach; the value is the instance id
+const TagNameSecondaryENI = "k8s.io/aws-controller/secondary-eni"
+
+// NetworkLimits are the network interface limits of an instance type
+type NetworkLimits struct {
+	MaxInterfaces int64
+	// IPv4PerInterface includes the primary address
+	IPv4PerInterface int64
+}
+
+var (
+	networkLimitsMutex sync.Mutex
+	networkLimits      = make(map[string]*NetworkLimits)
+)
+
+// InstanceNetworkLimits returns the network interface limits for the instance's type, caching them
+func (a *AWSCloud) InstanceNetworkLimits(ctx context.Context, instance *ec2.Instance) (*NetworkLimits, error) {
+	instanceType := aws.StringValue(instance.InstanceType)
+
+	networkLimitsMutex.Lock()
+	limits := networkLimits[instanceType]
+	networkLimitsMutex.Unlock()
+	if limits != nil {
+		return limits, nil
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	request := &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: []*string{aws.String(instanceType)},
+	}
+
+	response, err := a.ec2ForInstance(aws.StringValue(instance.InstanceId)).DescribeInstanceTypesWithContext(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("error describing instance type %q: %v", instanceType, err)
+	}
+	if len(response.InstanceTypes) == 0 || response.InstanceTypes[0].NetworkInfo == nil {
+		return nil, fmt.Errorf("network limits for instance type %q not found", instanceType)
+	}
+
+	info := response.InstanceTypes[0].NetworkInfo
+	limits = &NetworkLimits{
+		MaxInterfaces:    aws.Int64Value(info.MaximumNetworkInterfaces),
+		IPv4PerInterface: aws.Int64Value(info.Ipv4AddressesPerInterface),
+	}
+
+	networkLimitsMutex.Lock()
+	networkLimits[instanceType] = limits
+	networkLimitsMutex.Unlock()
+
+	return limits, nil
+}
+
+// AttachSecondaryNetworkInterface creates a network interface in the subnet and security groups of
+// the instance's primary interface, and attaches it at deviceIndex; it is deleted on termination
+func (a *AWSCloud) AttachSecondaryNetworkInterface(ctx context.Context, instance *ec2.Instance, deviceIndex int64) (string, error) {
+	instanceID := aws.StringValue(instance.InstanceId)
+	client := a.ec2ForInstance(instanceID)
+
+	var primary *ec2.InstanceNetworkInterface
+	for _, eni := range instance.NetworkInterfaces {
+		if eni.Attachment != nil && aws.Int64Value(eni.Attachment.DeviceIndex) == 0 {
+			primary = eni
+		}
+	}
+	if primary == nil {
+		return "", fmt.Errorf("primary network interface not found for instance %q", instanceID)
+	}
+
+	createRequest := &ec2.CreateNetworkInterfaceInput{
+		SubnetId:    primary.SubnetId,
+		Description: aws.String("Secondary interface of " + instanceID + "; managed by aws-controller"),
+		TagSpecifications: []*ec2.TagSpecification{
+			{
+				ResourceType: aws.String(ec2.ResourceTypeNetworkInterface),
+				Tags: []*ec2.Tag{
+					{Key: aws.String(TagNameKubernetesCluster), Value: aws.String(a.clusterID)},
+					{Key: aws.String(TagNameSecondaryENI), Value: aws.String(instanceID)},
+				},
+			},
+		},
+	}
+	for _, g := range primary.Groups {
+		createRequest.Groups = append(createRequest.Groups, g.GroupId)
+	}
+
+	glog.Infof("Creating secondary network interface for instance %q", instanceID)
+
+	callCtx, cancel := withTimeout(ctx)
+	createResponse, err := client.CreateNetworkInterfaceWithContext(callCtx, createRequest)
+	cancel()
+	if err != nil {
+		return "", fmt.Errorf("error creating network interface for instance %q: %v", instanceID, err)
+	}
+	eniID := aws.StringValue(createResponse.NetworkInterface.NetworkInterfaceId)
+
+	callCtx, cancel = withTimeout(ctx)
+	attachResponse, err := client.AttachNetworkInterfaceWithContext(callCtx, &ec2.AttachNetworkInterfaceInput{
+		InstanceId:         aws.String(instanceID),
+		NetworkInterfaceId: aws.String(eniID),
+		DeviceIndex:        aws.Int64(deviceIndex),
+	})
+	cancel()
+	if err != nil {
+		// Don't leak the interface we just created
+		callCtx, cancel = withTimeout(ctx)
+		_, deleteErr := client.DeleteNetworkInterfaceWithContext(callCtx, &ec2.DeleteNetworkInterfaceInput{NetworkInterfaceId: aws.String(eniID)})
+		cancel()
+		if deleteErr != nil {
+			glog.Warningf("error deleting unattached network interface %q: %v", eniID, deleteErr)
+		}
+		return "", fmt.Errorf("error attaching network interface %q to instance %q: %v", eniID, instanceID, err)
+	}
+
+	callCtx, cancel = withTimeout(ctx)
+	_, err = client.ModifyNetworkInterfaceAttributeWithContext(callCtx, &ec2.ModifyNetworkInterfaceAttributeInput{
+		NetworkInterfaceId: aws.String(eniID),
+		Attachment: &ec2.NetworkInterfaceAttachmentChanges{
+			AttachmentId:        attachResponse.AttachmentId,
+			DeleteOnTermination: aws.Bool(true),
+		},
+	})
+	cancel()
+	if err != nil {
+		return "", fmt.Errorf("error setting delete-on-termination on network interface %q: %v", eniID, err)
+	}
+
+	return eniID, nil
+}
+
+// ListSecondaryNetworkInterfaces returns the ids of the network interfaces we attached to the instance
+func (a *AWSCloud) ListSecondaryNetworkInterfaces(ctx context.Context, instanceID string) ([]string, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	request := &ec2.DescribeNetworkInterfacesInput{
+		Filters: []*ec2.Filter{
+			newEc2Filter("tag:"+TagNameKubernetesCluster, a.clusterID),
+			newEc2Filter("tag:"+TagNameSecondaryENI, instanceID),
+		},
+	}
+
+	var ids []string
+	err := a.ec2ForInstance(instanceID).DescribeNetworkInterfacesPagesWithContext(ctx, request, func(p *ec2.DescribeNetworkInterfacesOutput, lastPage bool) bool {
+		for _, eni := range p.NetworkInterfaces {
+			ids = append(ids, aws.StringValue(eni.NetworkInterfaceId))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing network interfaces of instance %q: %v", instanceID, err)
+	}
+	return ids, nil
+}
+
+// DetachSecondaryNetworkInterface detaches a network interface from its instance and deletes it
+func (a *AWSCloud) DetachSecondaryNetworkInterface(ctx context.Context, instanceID string, eni *ec2.InstanceNetworkInterface) error {
+	eniID := aws.StringValue(eni.NetworkInterfaceId)
+	client := a.ec2ForInstance(instanceID)
+
+	glog.Infof("Detaching secondary network interface %q from instance %q", eniID, instanceID)
+
+	callCtx, cancel := withTimeout(ctx)
+	_, err := client.DetachNetworkInterfaceWithContext(callCtx, &ec2.DetachNetworkInterfaceInput{
+		AttachmentId: eni.Attachment.AttachmentId,
+	})
+	cancel()
+	if err != nil {
+		return fmt.Errorf("error detaching network interface %q: %v", eniID, err)
+	}
+
+	describeRequest := &ec2.DescribeNetworkInterfacesInput{
+		NetworkInterfaceIds: []*string{aws.String(eniID)},
+	}
+	if err := client.WaitUntilNetworkInterfaceAvailableWithContext(ctx, describeRequest); err != nil {
+		return fmt.Errorf("error waiting for network interface %q to detach: %v", eniID, err)
+	}
+
+	callCtx, cancel = withTimeout(ctx)
+	_, err = client.DeleteNetworkInterfaceWithContext(callCtx, &ec2.DeleteNetworkInterfaceInput{
+		NetworkInterfaceId: aws.String(eniID),
+	})
+	cancel()
+	if err != nil {
+		return fmt.Errorf("error deleting network interface %q: %v", eniID, err)
+	}
+	return nil
+}
+
+// AssignSecondaryPrivateIPs assigns count additional private IPs to a network interface
+func (a *AWSCloud) AssignSecondaryPrivateIPs(ctx context.Context, instanceID string, eniID string, count int64) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Assigning %d secondary private IPs to network interface %q of instance %q", count, eniID, instanceID)
+
+	request := &ec2.AssignPrivateIpAddressesInput{
+		NetworkInterfaceId:             aws.String(eniID),
+		SecondaryPrivateIpAddressCount: aws.Int64(count),
+	}
+
+	_, err := a.ec2ForInstance(instanceID).AssignPrivateIpAddressesWithContext(ctx, request)
+	if err != nil {
+		return fmt.Errorf("error assigning private IPs to network interface %q: %v", eniID, err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package kopeaws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/golang/glog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// healthCheckReferencePrefix is the prefix of the CallerReference of the health checks we create for
+// records in this zone; CallerReferences must be unique even after deletion, so we append a timestamp
+func (d *Route53DNSProvider) healthCheckReferencePrefix() string {
+	return "awsc-" + strings.TrimPrefix(aws.StringValue(d.zone.Id), "/hostedzone/") + "-"
+}
+
+// ensureHealthCheck returns the id of our TCP health check of ip:port, creating it if needed
+func (d *Route53DNSProvider) ensureHealthCheck(ctx context.Context, ip string, port int) (string, error) {
+	endpoint := ip + ":" + strconv.Itoa(port)
+	if id := d.healthChecks[endpoint]; id != "" {
+		return id, nil
+	}
+
+	zone, err := d.getZone(ctx)
+	if err != nil {
+		return "", err
+	}
+	if zone == nil {
+		return "", fmt.Errorf("hosted zone %q not found", d.zoneName)
+	}
+	if d.healthChecks == nil {
+		d.healthChecks = make(map[string]string)
+	}
+
+	prefix := d.healthCheckReferencePrefix() + ip + "-" + strconv.Itoa(port) + "-"
+
+	id := ""
+	listCtx, cancel := withTimeout(ctx)
+	err = d.route53.ListHealthChecksPagesWithContext(listCtx, &route53.ListHealthChecksInput{}, func(p *route53.ListHealthChecksOutput, lastPage bool) bool {
+		for _, hc := range p.HealthChecks {
+			if strings.HasPrefix(aws.StringValue(hc.CallerReference), prefix) {
+				id = aws.StringValue(hc.Id)
+				return false
+			}
+		}
+		return true
+	})
+	cancel()
+	if err != nil {
+		return "", fmt.Errorf("error listing health checks: %v", err)
+	}
+
+	if id == "" {
+		glog.Infof("Creating health check for %s", endpoint)
+
+		request := &route53.CreateHealthCheckInput{
+			CallerReference: aws.String(prefix + strconv.FormatInt(time.Now().Unix(), 36)),
+			HealthCheckConfig: &route53.HealthCheckConfig{
+				Type:             aws.String(route53.HealthCheckTypeTcp),
+				IPAddress:        aws.String(ip),
+				Port:             aws.Int64(int64(port)),
+				RequestInterval:  aws.Int64(30),
+				FailureThreshold: aws.Int64(3),
+			},
+		}
+
+		createCtx, cancel := withTimeout(ctx)
+		response, err := d.route53.CreateHealthCheckWithContext(createCtx, request)
+		cancel()
+		if err != nil {
+			return "", fmt.Errorf("error creating health check for %s: %v", endpoint, err)
+		}
+		id = aws.StringValue(response.HealthCheck.Id)
+
+		// Name the health check in the console
+		tagCtx, cancel := withTimeout(ctx)
+		_, err = d.route53.ChangeTagsForResourceWithContext(tagCtx, &route53.ChangeTagsForResourceInput{
+			ResourceType: aws.String(route53.TagResourceTypeHealthcheck),
+			ResourceId:   aws.String(id),
+			AddTags: []*route53.Tag{
+				{Key: aws.String("Name"), Value: aws.String(endpoint + " (" + d.zoneName + ", aws-controller)")},
+			},
+		})
+		cancel()
+		if err != nil {
+			glog.Warningf("error tagging health check %q: %v", id, err)
+		}
+	}
+
+	d.healthChecks[endpoint] = id
+	return id, nil
+}
+
+// deleteHealthCheck deletes a health check, if it is one we created
+func (d *Route53DNSProvider) deleteHealthCheck(ctx context.Context, id string) error {
+	getCtx, cancel := withTimeout(ctx)
+	response, err := d.route53.GetHealthCheckWithContext(getCtx, &route53.GetHealthCheckInput{HealthCheckId: aws.String(id)})
+	cancel()
+	if err != nil {
+		return fmt.Errorf("error getting health check %q: %v", id, err)
+	}
+	if !strings.HasPrefix(aws.StringValue(response.HealthCheck.CallerReference), d.healthCheckReferencePrefix()) {
+		glog.V(2).Infof("Not deleting health check %q, which we did not create", id)
+		return nil
+	}
+
+	glog.Infof("Deleting health check %q", id)
+
+	deleteCtx, cancel := withTimeout(ctx)
+	_, err = d.route53.DeleteHealthCheckWithContext(deleteCtx, &route53.DeleteHealthCheckInput{HealthCheckId: aws.String(id)})
+	cancel()
+	if err != nil {
+		return fmt.Errorf("error deleting health check %q: %v", id, err)
+	}
+
+	for endpoint, cached := range d.healthChecks {
+		if cached == id {
+			delete(d.healthChecks, endpoint)
+		}
+	}
+	return nil
+}
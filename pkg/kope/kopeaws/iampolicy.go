@@ -0,0 +1,78 @@
+package kopeaws
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// iamPolicyVersion is the current version of the IAM policy language
+const iamPolicyVersion = "2012-10-17"
+
+// IAMPolicy is an IAM policy document, built up from the permissions needed by each enabled feature
+type IAMPolicy struct {
+	// actions maps each resource to the actions allowed on it
+	actions map[string]map[string]bool
+}
+
+func NewIAMPolicy() *IAMPolicy {
+	return &IAMPolicy{
+		actions: make(map[string]map[string]bool),
+	}
+}
+
+// Allow allows the actions on resource; actions which don't support resource-level permissions use "*"
+func (p *IAMPolicy) Allow(resource string, actions ...string) {
+	allowed := p.actions[resource]
+	if allowed == nil {
+		allowed = make(map[string]bool)
+		p.actions[resource] = allowed
+	}
+	for _, action := range actions {
+		allowed[action] = true
+	}
+}
+
+type iamPolicyDocument struct {
+	Version   string                `json:"Version"`
+	Statement []*iamPolicyStatement `json:"Statement"`
+}
+
+type iamPolicyStatement struct {
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource []string `json:"Resource"`
+}
+
+// MarshalJSON renders the policy document, with one statement for each set of resources allowed the same actions
+func (p *IAMPolicy) MarshalJSON() ([]byte, error) {
+	statements := make(map[string]*iamPolicyStatement)
+	for resource, allowed := range p.actions {
+		var actions []string
+		for action := range allowed {
+			actions = append(actions, action)
+		}
+		sort.Strings(actions)
+
+		key := strings.Join(actions, ",")
+		s := statements[key]
+		if s == nil {
+			s = &iamPolicyStatement{Effect: "Allow", Action: actions}
+			statements[key] = s
+		}
+		s.Resource = append(s.Resource, resource)
+	}
+
+	doc := &iamPolicyDocument{
+		Version:   iamPolicyVersion,
+		Statement: []*iamPolicyStatement{},
+	}
+	for _, s := range statements {
+		sort.Strings(s.Resource)
+		doc.Statement = append(doc.Statement, s)
+	}
+	sort.Slice(doc.Statement, func(i, j int) bool {
+		return doc.Statement[i].Resource[0] < doc.Statement[j].Resource[0]
+	})
+	return json.Marshal(doc)
+}
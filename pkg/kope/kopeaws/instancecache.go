@@ -0,0 +1,69 @@
+package kopeaws
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"sync"
+	"time"
+)
+
+// InstanceCache shares the results of DescribeInstances between controllers, so that controllers which
+// run together don't each list every instance.  The instances returned are shared, and must not be modified.
+type InstanceCache struct {
+	cloud  *AWSCloud
+	maxAge time.Duration
+
+	// mutex is held while listing, so concurrent callers wait for (and share) a single call
+	mutex     sync.Mutex
+	instances []*ec2.Instance
+	listTime  time.Time
+}
+
+func NewInstanceCache(cloud *AWSCloud, maxAge time.Duration) *InstanceCache {
+	return &InstanceCache{
+		cloud:  cloud,
+		maxAge: maxAge,
+	}
+}
+
+// List returns the cluster instances, listing them again if the cached list is older than maxAge
+func (c *InstanceCache) List(ctx context.Context) ([]*ec2.Instance, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.listTime.IsZero() && time.Since(c.listTime) < c.maxAge {
+		return c.instances, nil
+	}
+
+	instances, err := c.cloud.DescribeInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.instances = instances
+	c.listTime = time.Now()
+	return instances, nil
+}
+
+// ListInstances returns the cluster instances as kope instances, from the cache as for List
+func (c *InstanceCache) ListInstances(ctx context.Context) ([]*kope.Instance, error) {
+	instances, err := c.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*kope.Instance
+	for _, i := range instances {
+		out = append(out, c.cloud.toInstance(i))
+	}
+	return out, nil
+}
+
+// Invalidate discards the cached list, so that the next List lists the instances again
+func (c *InstanceCache) Invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.instances = nil
+	c.listTime = time.Time{}
+}
@@ -0,0 +1,73 @@
+package kopeaws
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/kopeio/aws-controller/pkg/kope"
+)
+
+var _ kope.Cloud = &AWSCloud{}
+
+// instanceStates maps EC2 instance states to kope instance states
+var instanceStates = map[string]string{
+	ec2.InstanceStateNamePending:      kope.InstanceStatePending,
+	ec2.InstanceStateNameRunning:      kope.InstanceStateRunning,
+	ec2.InstanceStateNameStopping:     kope.InstanceStateStopping,
+	ec2.InstanceStateNameStopped:      kope.InstanceStateStopped,
+	ec2.InstanceStateNameShuttingDown: kope.InstanceStateTerminating,
+	ec2.InstanceStateNameTerminated:   kope.InstanceStateTerminated,
+}
+
+// ListInstances returns the cluster instances found by DescribeInstances, as kope instances
+func (a *AWSCloud) ListInstances(ctx context.Context) ([]*kope.Instance, error) {
+	instances, err := a.DescribeInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*kope.Instance
+	for _, i := range instances {
+		out = append(out, a.toInstance(i))
+	}
+	return out, nil
+}
+
+// toInstance converts an EC2 instance to a kope instance
+func (a *AWSCloud) toInstance(i *ec2.Instance) *kope.Instance {
+	id := aws.StringValue(i.InstanceId)
+	instance := &kope.Instance{
+		ID:             id,
+		Region:         a.InstanceRegion(id),
+		Role:           InstanceRole(i),
+		PrivateIP:      aws.StringValue(i.PrivateIpAddress),
+		PrivateDNSName: aws.StringValue(i.PrivateDnsName),
+		PublicIP:       aws.StringValue(i.PublicIpAddress),
+		PublicDNSName:  aws.StringValue(i.PublicDnsName),
+		// SourceDestCheck is only unset for instances which are not running, which forward nothing
+		IPForwarding: i.SourceDestCheck != nil && !aws.BoolValue(i.SourceDestCheck),
+		LaunchTime:   aws.TimeValue(i.LaunchTime),
+		Tags:         make(map[string]string),
+	}
+	if i.Placement != nil {
+		instance.Zone = aws.StringValue(i.Placement.AvailabilityZone)
+	}
+	if i.State != nil {
+		instance.State = instanceStates[aws.StringValue(i.State.Name)]
+	}
+	for _, tag := range i.Tags {
+		instance.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return instance
+}
+
+// SetInstanceIPForwarding disables source-dest-check on the instance to enable IP forwarding (or enables
+// it to disable forwarding)
+func (a *AWSCloud) SetInstanceIPForwarding(ctx context.Context, instanceID string, enabled bool) error {
+	return a.ConfigureInstanceSourceDestCheck(ctx, instanceID, !enabled)
+}
+
+// SetInstanceTerminationProtection sets the disableApiTermination attribute of the instance
+func (a *AWSCloud) SetInstanceTerminationProtection(ctx context.Context, instanceID string, protect bool) error {
+	return a.ConfigureInstanceDisableApiTermination(ctx, instanceID, protect)
+}
@@ -0,0 +1,70 @@
+package kopeaws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"sync"
+)
+
+var (
+	// dualStackSubnets caches the subnets known to have an IPv6 CIDR; once associated a CIDR
+	// is rarely removed, so we don't cache negative results
+	dualStackSubnetsMutex sync.Mutex
+	dualStackSubnets      = make(map[string]bool)
+)
+
+// IsDualStackSubnet returns true if the subnet (of the instance) has an associated IPv6 CIDR block
+func (a *AWSCloud) IsDualStackSubnet(ctx context.Context, instanceID string, subnetID string) (bool, error) {
+	dualStackSubnetsMutex.Lock()
+	found := dualStackSubnets[subnetID]
+	dualStackSubnetsMutex.Unlock()
+	if found {
+		return true, nil
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	request := &ec2.DescribeSubnetsInput{
+		SubnetIds: []*string{aws.String(subnetID)},
+	}
+
+	response, err := a.ec2ForInstance(instanceID).DescribeSubnetsWithContext(ctx, request)
+	if err != nil {
+		return false, fmt.Errorf("error describing subnet %q: %v", subnetID, err)
+	}
+
+	for _, subnet := range response.Subnets {
+		for _, association := range subnet.Ipv6CidrBlockAssociationSet {
+			if association.Ipv6CidrBlockState != nil && aws.StringValue(association.Ipv6CidrBlockState.State) == ec2.SubnetCidrBlockStateCodeAssociated {
+				dualStackSubnetsMutex.Lock()
+				dualStackSubnets[subnetID] = true
+				dualStackSubnetsMutex.Unlock()
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// AssignIPv6Address assigns an IPv6 address (from the subnet's range) to a network interface of the instance
+func (a *AWSCloud) AssignIPv6Address(ctx context.Context, instanceID string, eniID string) ([]string, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Assigning IPv6 address to network interface %q of instance %q", eniID, instanceID)
+
+	request := &ec2.AssignIpv6AddressesInput{
+		NetworkInterfaceId: aws.String(eniID),
+		Ipv6AddressCount:   aws.Int64(1),
+	}
+
+	response, err := a.ec2ForInstance(instanceID).AssignIpv6AddressesWithContext(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("error assigning IPv6 address to network interface %q: %v", eniID, err)
+	}
+	return aws.StringValueSlice(response.AssignedIpv6Addresses), nil
+}
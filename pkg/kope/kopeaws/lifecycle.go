@@ -0,0 +1,137 @@
+package kopeaws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/golang/glog"
+	"time"
+)
+
+// The lifecycle transition for instances being terminated by an autoscaling group
+const LifecycleTransitionTerminating = "autoscaling:EC2_INSTANCE_TERMINATING"
+
+// The maximum time SQS allows for a long poll
+const sqsLongPollSeconds = 20
+
+// LifecycleMessage is an autoscaling lifecycle hook notification received from SQS
+type LifecycleMessage struct {
+	// ReceiptHandle identifies the SQS message, for deletion
+	ReceiptHandle string `json:"-"`
+
+	// Event is set on test notifications (autoscaling:TEST_NOTIFICATION), which carry no action
+	Event string `json:"Event"`
+
+	AutoScalingGroupName string `json:"AutoScalingGroupName"`
+	LifecycleHookName    string `json:"LifecycleHookName"`
+	LifecycleTransition  string `json:"LifecycleTransition"`
+	LifecycleActionToken string `json:"LifecycleActionToken"`
+	EC2InstanceID        string `json:"EC2InstanceId"`
+}
+
+// eventBridgeMessage is the envelope used when notifications are routed through EventBridge
+type eventBridgeMessage struct {
+	DetailType string            `json:"detail-type"`
+	Detail     *LifecycleMessage `json:"detail"`
+}
+
+func (a *AWSCloud) sqs() *sqs.SQS {
+	return sqs.New(a.session, aws.NewConfig().WithRegion(a.region))
+}
+
+func (a *AWSCloud) autoscaling() *autoscaling.AutoScaling {
+	return autoscaling.New(a.session, aws.NewConfig().WithRegion(a.region))
+}
+
+// ReceiveLifecycleMessages long-polls the queue for lifecycle notifications.
+// Received messages are hidden from other consumers for visibilityTimeout.
+func (a *AWSCloud) ReceiveLifecycleMessages(ctx context.Context, queueURL string, visibilityTimeout time.Duration) ([]*LifecycleMessage, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	request := &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(queueURL),
+		MaxNumberOfMessages: aws.Int64(10),
+		WaitTimeSeconds:     aws.Int64(sqsLongPollSeconds),
+		VisibilityTimeout:   aws.Int64(int64(visibilityTimeout.Seconds())),
+	}
+
+	response, err := a.sqs().ReceiveMessageWithContext(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("error receiving messages from %q: %v", queueURL, err)
+	}
+
+	var messages []*LifecycleMessage
+	for _, m := range response.Messages {
+		body := aws.StringValue(m.Body)
+
+		message := &LifecycleMessage{}
+		envelope := &eventBridgeMessage{}
+		if err := json.Unmarshal([]byte(body), envelope); err == nil && envelope.Detail != nil {
+			message = envelope.Detail
+		} else if err := json.Unmarshal([]byte(body), message); err != nil {
+			glog.Warningf("Ignoring unparseable lifecycle message %q: %v", body, err)
+		}
+		message.ReceiptHandle = aws.StringValue(m.ReceiptHandle)
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// DeleteLifecycleMessage removes a handled message from the queue
+func (a *AWSCloud) DeleteLifecycleMessage(ctx context.Context, queueURL string, message *LifecycleMessage) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	request := &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(queueURL),
+		ReceiptHandle: aws.String(message.ReceiptHandle),
+	}
+
+	if _, err := a.sqs().DeleteMessageWithContext(ctx, request); err != nil {
+		return fmt.Errorf("error deleting message from %q: %v", queueURL, err)
+	}
+	return nil
+}
+
+// RecordLifecycleActionHeartbeat extends the timeout of a pending lifecycle action
+func (a *AWSCloud) RecordLifecycleActionHeartbeat(ctx context.Context, message *LifecycleMessage) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	request := &autoscaling.RecordLifecycleActionHeartbeatInput{
+		AutoScalingGroupName: aws.String(message.AutoScalingGroupName),
+		LifecycleHookName:    aws.String(message.LifecycleHookName),
+		LifecycleActionToken: aws.String(message.LifecycleActionToken),
+		InstanceId:           aws.String(message.EC2InstanceID),
+	}
+
+	if _, err := a.autoscaling().RecordLifecycleActionHeartbeatWithContext(ctx, request); err != nil {
+		return fmt.Errorf("error recording lifecycle heartbeat for instance %q: %v", message.EC2InstanceID, err)
+	}
+	return nil
+}
+
+// CompleteLifecycleAction lets the autoscaling group proceed with the lifecycle transition
+func (a *AWSCloud) CompleteLifecycleAction(ctx context.Context, message *LifecycleMessage) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Completing lifecycle action %s for instance %q", message.LifecycleTransition, message.EC2InstanceID)
+
+	request := &autoscaling.CompleteLifecycleActionInput{
+		AutoScalingGroupName:  aws.String(message.AutoScalingGroupName),
+		LifecycleHookName:     aws.String(message.LifecycleHookName),
+		LifecycleActionToken:  aws.String(message.LifecycleActionToken),
+		InstanceId:            aws.String(message.EC2InstanceID),
+		LifecycleActionResult: aws.String("CONTINUE"),
+	}
+
+	if _, err := a.autoscaling().CompleteLifecycleActionWithContext(ctx, request); err != nil {
+		return fmt.Errorf("error completing lifecycle action for instance %q: %v", message.EC2InstanceID, err)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package kopeaws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"strings"
+	"time"
+)
+
+// snsQueueLength is how many notifications may be waiting to be published before we drop them
+const snsQueueLength = 100
+
+// snsMaxMessageSize is the largest message SNS accepts, in bytes
+const snsMaxMessageSize = 256 * 1024
+
+// Mutation describes a successful AWS API call which changed AWS state
+type Mutation struct {
+	Time      time.Time `json:"time"`
+	ClusterID string    `json:"clusterID,omitempty"`
+	Service   string    `json:"service"`
+	Region    string    `json:"region,omitempty"`
+	Operation string    `json:"operation"`
+	// Params are the parameters of the call
+	Params interface{} `json:"params,omitempty"`
+	// ParamsOmitted is set when the parameters were too large to include, e.g. a large batch of DNS changes
+	ParamsOmitted bool `json:"paramsOmitted,omitempty"`
+}
+
+// MutationNotifier is told about every mutation made through our AWS sessions
+type MutationNotifier interface {
+	NotifyMutation(m *Mutation)
+}
+
+// Services whose calls are never reported: our own housekeeping (queues, credentials, reports, locks and
+// the notifications themselves), which would otherwise drown out the changes to the cluster
+var unreportedServices = map[string]bool{
+	"sns":      true,
+	"sqs":      true,
+	"sts":      true,
+	"s3":       true,
+	"dynamodb": true,
+}
+
+// Prefixes of operations which don't change state, or are housekeeping
+var unreportedOperationPrefixes = []string{"Describe", "List", "Get", "Lookup", "RecordLifecycleActionHeartbeat"}
+
+// isMutation returns true if the completed request changed AWS state
+func isMutation(r *request.Request) bool {
+	return r.Error == nil && isMutatingRequest(r)
+}
+
+// isMutatingRequest returns true if the request would change AWS state (other than our housekeeping)
+func isMutatingRequest(r *request.Request) bool {
+	if r.Operation == nil || unreportedServices[r.ClientInfo.ServiceID] || unreportedServices[r.ClientInfo.ServiceName] {
+		return false
+	}
+	for _, prefix := range unreportedOperationPrefixes {
+		if strings.HasPrefix(r.Operation.Name, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// addMutationNotifier installs notifier on the handlers of a session, so it is told about the mutations
+// made by every client built from the session
+func addMutationNotifier(handlers *request.Handlers, notifier MutationNotifier) {
+	handlers.Complete.PushBack(func(r *request.Request) {
+		if !isMutation(r) {
+			return
+		}
+		notifier.NotifyMutation(&Mutation{
+			Time:      time.Now().UTC(),
+			Service:   r.ClientInfo.ServiceName,
+			Region:    aws.StringValue(r.Config.Region),
+			Operation: r.Operation.Name,
+			Params:    r.Params,
+		})
+	})
+}
+
+// ErrCodePaused is the error code of requests refused because mutations are paused
+const ErrCodePaused = "Paused"
+
+// addPauseCheck installs pause on the handlers of a session, so that mutating requests made by every
+// client built from the session fail (without being sent) while mutations are paused
+func addPauseCheck(handlers *request.Handlers, pause *kope.PauseSwitch) {
+	handlers.Validate.PushBack(func(r *request.Request) {
+		if !pause.Paused() || !isMutatingRequest(r) {
+			return
+		}
+		glog.V(2).Infof("Refusing %s %s: mutations are paused", r.ClientInfo.ServiceName, r.Operation.Name)
+		r.Error = awserr.New(ErrCodePaused, kope.ErrPaused.Error(), kope.ErrPaused)
+	})
+}
+
+// SNSNotifier publishes mutations to an SNS topic.  Messages are published in the background, so that
+// a slow or failing topic does not hold up reconciliation; if too many are waiting, new ones are dropped.
+type SNSNotifier struct {
+	// ClusterID, if set, is included in each message
+	ClusterID string
+
+	topicARN string
+	sns      *sns.SNS
+	queue    chan *Mutation
+}
+
+var _ MutationNotifier = &SNSNotifier{}
+
+// NewSNSNotifier builds a notifier for the topic, using credentials from options
+func NewSNSNotifier(options SessionOptions, topicARN string) (*SNSNotifier, error) {
+	parsed, err := arn.Parse(topicARN)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SNS topic ARN %q: %v", topicARN, err)
+	}
+
+	// The notifier must not report its own calls, so it never has a notifier itself
+	options.MutationNotifier = nil
+	s, err := newSession(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SNSNotifier{
+		topicARN: topicARN,
+		sns:      sns.New(s, aws.NewConfig().WithRegion(parsed.Region)),
+		queue:    make(chan *Mutation, snsQueueLength),
+	}, nil
+}
+
+// Run publishes queued notifications until ctx is done
+func (n *SNSNotifier) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case m := <-n.queue:
+			if err := n.publish(ctx, m); err != nil {
+				glog.Warningf("%v", err)
+			}
+		}
+	}
+}
+
+func (n *SNSNotifier) NotifyMutation(m *Mutation) {
+	m.ClusterID = n.ClusterID
+	select {
+	case n.queue <- m:
+	default:
+		glog.Warningf("Dropping SNS notification of %s %s: too many notifications queued", m.Service, m.Operation)
+	}
+}
+
+func (n *SNSNotifier) publish(ctx context.Context, m *Mutation) error {
+	message, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("error serializing notification of %s %s: %v", m.Service, m.Operation, err)
+	}
+	if len(message) > snsMaxMessageSize {
+		glog.V(2).Infof("Omitting parameters from SNS notification of %s %s: message is %d bytes", m.Service, m.Operation, len(message))
+		summary := *m
+		summary.Params = nil
+		summary.ParamsOmitted = true
+		message, err = json.Marshal(&summary)
+		if err != nil {
+			return fmt.Errorf("error serializing notification of %s %s: %v", m.Service, m.Operation, err)
+		}
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	request := &sns.PublishInput{
+		TopicArn: aws.String(n.topicARN),
+		Subject:  aws.String("aws-controller: " + m.Service + " " + m.Operation),
+		Message:  aws.String(string(message)),
+	}
+	if _, err := n.sns.PublishWithContext(ctx, request); err != nil {
+		return fmt.Errorf("error publishing notification of %s %s to %q: %v", m.Service, m.Operation, n.topicARN, err)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package kopeaws
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/golang/glog"
+	"regexp"
+	"strings"
+)
+
+// ELB names allow at most 32 alphanumerics and hyphens
+const maxELBNameLength = 32
+
+var invalidELBNameCharacters = regexp.MustCompile("[^a-zA-Z0-9-]+")
+
+// elbNameHashLength is the length of the hash suffix of truncated ELB names
+const elbNameHashLength = 8
+
+// ELBName builds a valid load balancer / target group name from prefix and the cluster id.  A name which is
+// too long is truncated, with a hash of the full name appended so that clusters whose ids share a long
+// prefix get distinct names.
+func (a *AWSCloud) ELBName(prefix string) string {
+	name := strings.Trim(invalidELBNameCharacters.ReplaceAllString(prefix+"-"+a.clusterID, "-"), "-")
+	if len(name) > maxELBNameLength {
+		hash := sha256.Sum256([]byte(prefix + "-" + a.clusterID))
+		name = strings.Trim(name[:maxELBNameLength-elbNameHashLength-1], "-") + "-" + hex.EncodeToString(hash[:])[:elbNameHashLength]
+	}
+	return name
+}
+
+// clusterELBTags returns the tags we set on load balancer resources we create
+func (a *AWSCloud) clusterELBTags() []*elbv2.Tag {
+	return []*elbv2.Tag{
+		{Key: aws.String(TagNameKubernetesCluster), Value: aws.String(a.clusterID)},
+	}
+}
+
+// FindLoadBalancer returns the named load balancer in our region, or nil if it does not exist; a load
+// balancer of that name which is not tagged for our cluster is an error
+func (a *AWSCloud) FindLoadBalancer(ctx context.Context, name string) (*elbv2.LoadBalancer, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	request := &elbv2.DescribeLoadBalancersInput{
+		Names: []*string{aws.String(name)},
+	}
+
+	response, err := a.elbv2(a.region).DescribeLoadBalancersWithContext(ctx, request)
+	if err != nil {
+		if AWSErrorCode(err) == elbv2.ErrCodeLoadBalancerNotFoundException {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error describing load balancer %q: %v", name, err)
+	}
+	if len(response.LoadBalancers) == 0 {
+		return nil, nil
+	}
+	lb := response.LoadBalancers[0]
+	if err := a.checkClusterELBTag(ctx, aws.StringValue(lb.LoadBalancerArn)); err != nil {
+		return nil, fmt.Errorf("load balancer %q %v", name, err)
+	}
+	return lb, nil
+}
+
+// CreateNetworkLoadBalancer creates a network load balancer in the subnets, tagged with the cluster tag
+func (a *AWSCloud) CreateNetworkLoadBalancer(ctx context.Context, name string, internal bool, subnetIDs []string) (*elbv2.LoadBalancer, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	scheme := elbv2.LoadBalancerSchemeEnumInternetFacing
+	if internal {
+		scheme = elbv2.LoadBalancerSchemeEnumInternal
+	}
+
+	glog.Infof("Creating %s network load balancer %q in subnets %v", scheme, name, subnetIDs)
+
+	request := &elbv2.CreateLoadBalancerInput{
+		Name:    aws.String(name),
+		Type:    aws.String(elbv2.LoadBalancerTypeEnumNetwork),
+		Scheme:  aws.String(scheme),
+		Subnets: aws.StringSlice(subnetIDs),
+		Tags:    a.clusterELBTags(),
+	}
+
+	response, err := a.elbv2(a.region).CreateLoadBalancerWithContext(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("error creating load balancer %q: %v", name, err)
+	}
+	if len(response.LoadBalancers) == 0 {
+		return nil, fmt.Errorf("load balancer %q was not returned on creation", name)
+	}
+	return response.LoadBalancers[0], nil
+}
+
+// FindTargetGroup returns the named target group in our region, or nil if it does not exist; a target group
+// of that name which is not tagged for our cluster is an error
+func (a *AWSCloud) FindTargetGroup(ctx context.Context, name string) (*elbv2.TargetGroup, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	request := &elbv2.DescribeTargetGroupsInput{
+		Names: []*string{aws.String(name)},
+	}
+
+	response, err := a.elbv2(a.region).DescribeTargetGroupsWithContext(ctx, request)
+	if err != nil {
+		if AWSErrorCode(err) == elbv2.ErrCodeTargetGroupNotFoundException {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error describing target group %q: %v", name, err)
+	}
+	if len(response.TargetGroups) == 0 {
+		return nil, nil
+	}
+	tg := response.TargetGroups[0]
+	if err := a.checkClusterELBTag(ctx, aws.StringValue(tg.TargetGroupArn)); err != nil {
+		return nil, fmt.Errorf("target group %q %v", name, err)
+	}
+	return tg, nil
+}
+
+// checkClusterELBTag returns an error if the load balancer or target group is not tagged for our cluster,
+// so that we never adopt the resources of another cluster (or created by hand) of the same name
+func (a *AWSCloud) checkClusterELBTag(ctx context.Context, arn string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	response, err := a.elbv2(a.region).DescribeTagsWithContext(ctx, &elbv2.DescribeTagsInput{ResourceArns: []*string{aws.String(arn)}})
+	if err != nil {
+		return fmt.Errorf("could not be checked: error describing tags: %v", err)
+	}
+	for _, d := range response.TagDescriptions {
+		for _, t := range d.Tags {
+			if aws.StringValue(t.Key) == TagNameKubernetesCluster {
+				if aws.StringValue(t.Value) == a.clusterID {
+					return nil
+				}
+				return fmt.Errorf("belongs to cluster %q", aws.StringValue(t.Value))
+			}
+		}
+	}
+	return fmt.Errorf("exists but is not tagged as belonging to cluster %q", a.clusterID)
+}
+
+// CreateTCPTargetGroup creates a TCP target group of instances, with TCP health checks
+func (a *AWSCloud) CreateTCPTargetGroup(ctx context.Context, name string, vpcID string, port int64) (*elbv2.TargetGroup, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Creating target group %q for TCP port %d in %s", name, port, vpcID)
+
+	request := &elbv2.CreateTargetGroupInput{
+		Name:                aws.String(name),
+		Protocol:            aws.String(elbv2.ProtocolEnumTcp),
+		Port:                aws.Int64(port),
+		VpcId:               aws.String(vpcID),
+		TargetType:          aws.String(elbv2.TargetTypeEnumInstance),
+		HealthCheckProtocol: aws.String(elbv2.ProtocolEnumTcp),
+	}
+
+	response, err := a.elbv2(a.region).CreateTargetGroupWithContext(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("error creating target group %q: %v", name, err)
+	}
+	if len(response.TargetGroups) == 0 {
+		return nil, fmt.Errorf("target group %q was not returned on creation", name)
+	}
+	tg := response.TargetGroups[0]
+
+	// CreateTargetGroup doesn't accept tags in older API versions, so tag separately
+	tagRequest := &elbv2.AddTagsInput{
+		ResourceArns: []*string{tg.TargetGroupArn},
+		Tags:         a.clusterELBTags(),
+	}
+	if _, err := a.elbv2(a.region).AddTagsWithContext(ctx, tagRequest); err != nil {
+		return nil, fmt.Errorf("error tagging target group %q: %v", name, err)
+	}
+	return tg, nil
+}
+
+// EnsureTCPListener ensures the load balancer has a TCP listener on port, forwarding to the target group
+func (a *AWSCloud) EnsureTCPListener(ctx context.Context, loadBalancerARN string, port int64, targetGroupARN string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	client := a.elbv2(a.region)
+
+	response, err := client.DescribeListenersWithContext(ctx, &elbv2.DescribeListenersInput{
+		LoadBalancerArn: aws.String(loadBalancerARN),
+	})
+	if err != nil {
+		return fmt.Errorf("error describing listeners of %q: %v", loadBalancerARN, err)
+	}
+
+	actions := []*elbv2.Action{
+		{
+			Type:           aws.String(elbv2.ActionTypeEnumForward),
+			TargetGroupArn: aws.String(targetGroupARN),
+		},
+	}
+
+	for _, l := range response.Listeners {
+		if aws.Int64Value(l.Port) != port {
+			continue
+		}
+		if len(l.DefaultActions) == 1 && aws.StringValue(l.DefaultActions[0].TargetGroupArn) == targetGroupARN {
+			return nil
+		}
+
+		glog.Infof("Updating listener on port %d of %q", port, loadBalancerARN)
+		_, err := client.ModifyListenerWithContext(ctx, &elbv2.ModifyListenerInput{
+			ListenerArn:    l.ListenerArn,
+			DefaultActions: actions,
+		})
+		if err != nil {
+			return fmt.Errorf("error updating listener on port %d of %q: %v", port, loadBalancerARN, err)
+		}
+		return nil
+	}
+
+	glog.Infof("Creating listener on port %d of %q", port, loadBalancerARN)
+	_, err = client.CreateListenerWithContext(ctx, &elbv2.CreateListenerInput{
+		LoadBalancerArn: aws.String(loadBalancerARN),
+		Protocol:        aws.String(elbv2.ProtocolEnumTcp),
+		Port:            aws.Int64(port),
+		DefaultActions:  actions,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating listener on port %d of %q: %v", port, loadBalancerARN, err)
+	}
+	return nil
+}
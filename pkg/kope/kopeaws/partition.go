@@ -0,0 +1,50 @@
+package kopeaws
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/golang/glog"
+	"strings"
+)
+
+// route53Regions maps each partition to the region in which its (global) Route53 endpoint is signed
+var route53Regions = map[string]string{
+	endpoints.AwsPartitionID:      "us-east-1",
+	endpoints.AwsCnPartitionID:    "cn-northwest-1",
+	endpoints.AwsUsGovPartitionID: "us-gov-west-1",
+}
+
+// PartitionForRegion returns the id of the AWS partition (aws, aws-cn, aws-us-gov) containing region
+func PartitionForRegion(region string) string {
+	if p, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		return p.ID()
+	}
+
+	// Fall back to the naming conventions, for regions newer than our SDK
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return endpoints.AwsCnPartitionID
+	case strings.HasPrefix(region, "us-gov-"):
+		return endpoints.AwsUsGovPartitionID
+	default:
+		glog.Warningf("Unknown region %q; assuming partition %q", region, endpoints.AwsPartitionID)
+		return endpoints.AwsPartitionID
+	}
+}
+
+// BuildARN constructs an ARN in the partition containing region
+func BuildARN(region string, service string, accountID string, resource string) string {
+	return fmt.Sprintf("arn:%s:%s:%s:%s:%s", PartitionForRegion(region), service, region, accountID, resource)
+}
+
+// route53Region returns the region to use for Route53 API calls, given a region in the same partition
+func route53Region(region string) string {
+	if region == "" {
+		return route53Regions[endpoints.AwsPartitionID]
+	}
+	partition := PartitionForRegion(region)
+	if r := route53Regions[partition]; r != "" {
+		return r
+	}
+	return region
+}
@@ -0,0 +1,84 @@
+package kopeaws
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/golang/glog"
+	"k8s.io/client-go/util/flowcontrol"
+	"sync"
+)
+
+// RateLimit configures a client-side token bucket for requests to an AWS service,
+// so that we share API quota fairly with other components in the same account.
+type RateLimit struct {
+	// QPS is the sustained rate of requests; zero disables rate limiting
+	QPS float32
+	// Burst is the maximum number of requests that may be sent in a burst
+	Burst int
+}
+
+var (
+	// rateLimitersMutex protects rateLimiters
+	rateLimitersMutex sync.Mutex
+	// rateLimiters holds the limiter of each service (or operation), shared by all its clients: API quota
+	// is per account, and we build several clients of a service, e.g. one for each hosted zone
+	rateLimiters = make(map[string]flowcontrol.RateLimiter)
+)
+
+// sharedRateLimiter returns the limiter named name, building it with limit if this is its first client
+func sharedRateLimiter(name string, limit RateLimit) flowcontrol.RateLimiter {
+	rateLimitersMutex.Lock()
+	defer rateLimitersMutex.Unlock()
+
+	if limiter := rateLimiters[name]; limiter != nil {
+		return limiter
+	}
+
+	burst := limit.Burst
+	if burst < 1 {
+		burst = 1
+	}
+
+	glog.V(2).Infof("Rate limiting %s requests to %v qps (burst %d)", name, limit.QPS, burst)
+
+	limiter := flowcontrol.NewTokenBucketRateLimiter(limit.QPS, burst)
+	rateLimiters[name] = limiter
+	return limiter
+}
+
+// addRateLimiter installs limit on the handlers of a service client; clients with the same serviceName share
+// the limit.  It is added to the Sign phase, which runs before every attempt, so that retries are also rate-limited.
+func addRateLimiter(handlers *request.Handlers, serviceName string, limit RateLimit) {
+	if limit.QPS <= 0 {
+		glog.V(2).Infof("Client-side rate limiting disabled for %s", serviceName)
+		return
+	}
+
+	limiter := sharedRateLimiter(serviceName+" API", limit)
+	handlers.Sign.PushFront(func(r *request.Request) {
+		waitForLimiter(r, limiter)
+	})
+}
+
+// addOperationRateLimiter installs limit on the requests of a single operation of a service client,
+// in addition to any limit on all its requests; clients with the same serviceName share the limit.
+func addOperationRateLimiter(handlers *request.Handlers, serviceName string, operation string, limit RateLimit) {
+	if limit.QPS <= 0 {
+		return
+	}
+
+	limiter := sharedRateLimiter(serviceName+" "+operation, limit)
+	handlers.Sign.PushFront(func(r *request.Request) {
+		if r.Operation != nil && r.Operation.Name == operation {
+			waitForLimiter(r, limiter)
+		}
+	})
+}
+
+// waitForLimiter waits until limiter allows the request, failing the request (without sending it) if its
+// context is cancelled first, or its deadline would pass before then
+func waitForLimiter(r *request.Request, limiter flowcontrol.RateLimiter) {
+	if err := limiter.Wait(r.Context()); err != nil {
+		r.Error = awserr.New(request.CanceledErrorCode, "request canceled while waiting for the client-side rate limiter", err)
+	}
+}
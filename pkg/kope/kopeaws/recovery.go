@@ -0,0 +1,118 @@
+package kopeaws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/golang/glog"
+	"regexp"
+	"strings"
+)
+
+// RecoveryAlarm is a CloudWatch alarm which recovers an instance when its system status check fails
+type RecoveryAlarm struct {
+	Name       string
+	InstanceID string
+	Region     string
+}
+
+// instanceIDPattern matches an EC2 instance id
+var instanceIDPattern = regexp.MustCompile(`^i-[0-9a-f]+$`)
+
+func (a *AWSCloud) cloudwatch(region string) *cloudwatch.CloudWatch {
+	return cloudwatch.New(a.session, aws.NewConfig().WithRegion(region))
+}
+
+// recoveryAlarmPrefix is the prefix of the names of the recovery alarms we manage for this cluster
+func (a *AWSCloud) recoveryAlarmPrefix() string {
+	return "aws-controller-recover-" + a.clusterID + "-"
+}
+
+// ListRecoveryAlarms returns the recovery alarms we manage for this cluster, in all our regions
+func (a *AWSCloud) ListRecoveryAlarms(ctx context.Context) ([]*RecoveryAlarm, error) {
+	prefix := a.recoveryAlarmPrefix()
+
+	var alarms []*RecoveryAlarm
+	for _, region := range a.Regions() {
+		request := &cloudwatch.DescribeAlarmsInput{
+			AlarmNamePrefix: aws.String(prefix),
+		}
+
+		callCtx, cancel := withTimeout(ctx)
+		err := a.cloudwatch(region).DescribeAlarmsPagesWithContext(callCtx, request, func(p *cloudwatch.DescribeAlarmsOutput, lastPage bool) bool {
+			for _, alarm := range p.MetricAlarms {
+				name := aws.StringValue(alarm.AlarmName)
+				instanceID := strings.TrimPrefix(name, prefix)
+				// The prefix of cluster "foo" is also a prefix of the alarms of cluster "foo-bar"
+				if !instanceIDPattern.MatchString(instanceID) {
+					continue
+				}
+				alarms = append(alarms, &RecoveryAlarm{
+					Name:       name,
+					InstanceID: instanceID,
+					Region:     region,
+				})
+			}
+			return true
+		})
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("error listing CloudWatch alarms in %s: %v", region, err)
+		}
+	}
+	return alarms, nil
+}
+
+// PutRecoveryAlarm creates (or updates) the alarm which recovers the instance when its
+// system status check fails for two consecutive minutes
+func (a *AWSCloud) PutRecoveryAlarm(ctx context.Context, instanceID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	region := a.InstanceRegion(instanceID)
+	name := a.recoveryAlarmPrefix() + instanceID
+
+	// The recover action is not account-specific, so it doesn't fit BuildARN
+	recoverARN := fmt.Sprintf("arn:%s:automate:%s:ec2:recover", PartitionForRegion(region), region)
+
+	glog.Infof("Creating recovery alarm %q for instance %q", name, instanceID)
+
+	request := &cloudwatch.PutMetricAlarmInput{
+		AlarmName:          aws.String(name),
+		AlarmDescription:   aws.String("Recover instance " + instanceID + " on host failure; managed by aws-controller"),
+		Namespace:          aws.String("AWS/EC2"),
+		MetricName:         aws.String("StatusCheckFailed_System"),
+		Dimensions:         []*cloudwatch.Dimension{{Name: aws.String("InstanceId"), Value: aws.String(instanceID)}},
+		Statistic:          aws.String(cloudwatch.StatisticMinimum),
+		Period:             aws.Int64(60),
+		EvaluationPeriods:  aws.Int64(2),
+		Threshold:          aws.Float64(0),
+		ComparisonOperator: aws.String(cloudwatch.ComparisonOperatorGreaterThanThreshold),
+		AlarmActions:       []*string{aws.String(recoverARN)},
+	}
+
+	_, err := a.cloudwatch(region).PutMetricAlarmWithContext(ctx, request)
+	if err != nil {
+		return fmt.Errorf("error creating recovery alarm for instance %q: %v", instanceID, err)
+	}
+	return nil
+}
+
+// DeleteRecoveryAlarm deletes a recovery alarm
+func (a *AWSCloud) DeleteRecoveryAlarm(ctx context.Context, alarm *RecoveryAlarm) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Deleting recovery alarm %q", alarm.Name)
+
+	request := &cloudwatch.DeleteAlarmsInput{
+		AlarmNames: []*string{aws.String(alarm.Name)},
+	}
+
+	_, err := a.cloudwatch(alarm.Region).DeleteAlarmsWithContext(ctx, request)
+	if err != nil {
+		return fmt.Errorf("error deleting recovery alarm %q: %v", alarm.Name, err)
+	}
+	return nil
+}
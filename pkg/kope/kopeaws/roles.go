@@ -0,0 +1,36 @@
+package kopeaws
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"strings"
+)
+
+// The prefix of the tags marking an instance's role in the cluster, e.g. k8s.io/role/master=1
+const TagNamePrefixRole = "k8s.io/role/"
+
+// The roles we recognize
+const (
+	RoleMaster = "master"
+	RoleNode   = "node"
+	RoleNAT    = "nat"
+	RoleEgress = "egress"
+)
+
+// InstanceRole returns the role of an instance from its k8s.io/role/<role> tag, or "" if it has none
+func InstanceRole(instance *ec2.Instance) string {
+	for _, tag := range instance.Tags {
+		k := aws.StringValue(tag.Key)
+		if strings.HasPrefix(k, TagNamePrefixRole) {
+			return strings.TrimPrefix(k, TagNamePrefixRole)
+		}
+	}
+	return ""
+}
+
+// HasRole returns true if the instance has the k8s.io/role/<role> tag; unlike InstanceRole this
+// recognizes instances which have more than one role
+func HasRole(instance *ec2.Instance, role string) bool {
+	_, found := FindTag(instance, TagNamePrefixRole+role)
+	return found
+}
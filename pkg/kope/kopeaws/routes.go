@@ -0,0 +1,121 @@
+package kopeaws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"strings"
+)
+
+// DefaultRouteCIDR is the destination of the default (egress) route
+const DefaultRouteCIDR = "0.0.0.0/0"
+
+// RouteTable is a route table belonging to the cluster
+type RouteTable struct {
+	ID     string
+	Region string
+	// DefaultTarget is the id of the instance or NAT gateway the default route points to, or "" if none
+	DefaultTarget string
+	// DefaultBlackhole is true if the target of the default route no longer exists
+	DefaultBlackhole bool
+}
+
+// NATGateway is a NAT gateway belonging to the cluster
+type NATGateway struct {
+	ID       string
+	Region   string
+	State    string
+	SubnetID string
+}
+
+// ListRouteTables returns the route tables tagged with our cluster tag, in all our regions
+func (a *AWSCloud) ListRouteTables(ctx context.Context) ([]*RouteTable, error) {
+	var routeTables []*RouteTable
+	for _, region := range a.Regions() {
+		request := &ec2.DescribeRouteTablesInput{
+			Filters: []*ec2.Filter{newEc2Filter("tag:"+TagNameKubernetesCluster, a.clusterID)},
+		}
+
+		callCtx, cancel := withTimeout(ctx)
+		err := a.regions[region].DescribeRouteTablesPagesWithContext(callCtx, request, func(p *ec2.DescribeRouteTablesOutput, lastPage bool) bool {
+			for _, rt := range p.RouteTables {
+				routeTable := &RouteTable{
+					ID:     aws.StringValue(rt.RouteTableId),
+					Region: region,
+				}
+				for _, route := range rt.Routes {
+					if aws.StringValue(route.DestinationCidrBlock) != DefaultRouteCIDR {
+						continue
+					}
+					if route.InstanceId != nil {
+						routeTable.DefaultTarget = aws.StringValue(route.InstanceId)
+					} else if route.NatGatewayId != nil {
+						routeTable.DefaultTarget = aws.StringValue(route.NatGatewayId)
+					}
+					routeTable.DefaultBlackhole = aws.StringValue(route.State) == ec2.RouteStateBlackhole
+				}
+				routeTables = append(routeTables, routeTable)
+			}
+			return true
+		})
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("error describing route tables in %s: %v", region, err)
+		}
+	}
+	return routeTables, nil
+}
+
+// ListNATGateways returns the NAT gateways tagged with our cluster tag, in all our regions
+func (a *AWSCloud) ListNATGateways(ctx context.Context) ([]*NATGateway, error) {
+	var natGateways []*NATGateway
+	for _, region := range a.Regions() {
+		request := &ec2.DescribeNatGatewaysInput{
+			Filter: []*ec2.Filter{newEc2Filter("tag:"+TagNameKubernetesCluster, a.clusterID)},
+		}
+
+		callCtx, cancel := withTimeout(ctx)
+		err := a.regions[region].DescribeNatGatewaysPagesWithContext(callCtx, request, func(p *ec2.DescribeNatGatewaysOutput, lastPage bool) bool {
+			for _, g := range p.NatGateways {
+				natGateways = append(natGateways, &NATGateway{
+					ID:       aws.StringValue(g.NatGatewayId),
+					Region:   region,
+					State:    aws.StringValue(g.State),
+					SubnetID: aws.StringValue(g.SubnetId),
+				})
+			}
+			return true
+		})
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("error describing NAT gateways in %s: %v", region, err)
+		}
+	}
+	return natGateways, nil
+}
+
+// ReplaceDefaultRoute points the default route of the route table at target, an instance or NAT gateway id
+func (a *AWSCloud) ReplaceDefaultRoute(ctx context.Context, routeTable *RouteTable, target string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Replacing default route in %q: %q -> %q", routeTable.ID, routeTable.DefaultTarget, target)
+
+	request := &ec2.ReplaceRouteInput{
+		RouteTableId:         aws.String(routeTable.ID),
+		DestinationCidrBlock: aws.String(DefaultRouteCIDR),
+	}
+	if strings.HasPrefix(target, "nat-") {
+		request.NatGatewayId = aws.String(target)
+	} else {
+		request.InstanceId = aws.String(target)
+	}
+
+	_, err := a.regions[routeTable.Region].ReplaceRouteWithContext(ctx, request)
+	if err != nil {
+		return fmt.Errorf("error replacing default route in %q: %v", routeTable.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package kopeaws
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io/ioutil"
+)
+
+func (a *AWSCloud) s3() *s3.S3 {
+	return s3.New(a.session, aws.NewConfig().WithRegion(a.region))
+}
+
+// PutS3Object writes an object to S3; the bucket must be in our region
+func (a *AWSCloud) PutS3Object(ctx context.Context, bucket string, key string, body []byte, contentType string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	request := &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	}
+
+	if _, err := a.s3().PutObjectWithContext(ctx, request); err != nil {
+		return fmt.Errorf("error writing s3://%s/%s: %v", bucket, key, err)
+	}
+	return nil
+}
+
+// LatestS3Object returns the content of the object with the greatest key under prefix, or nil if there are none
+func (a *AWSCloud) LatestS3Object(ctx context.Context, bucket string, prefix string) ([]byte, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	latest := ""
+	request := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+	err := a.s3().ListObjectsV2PagesWithContext(ctx, request, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, o := range page.Contents {
+			if key := aws.StringValue(o.Key); key > latest {
+				latest = key
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing s3://%s/%s: %v", bucket, prefix, err)
+	}
+	if latest == "" {
+		return nil, nil
+	}
+
+	response, err := a.s3().GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(latest),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading s3://%s/%s: %v", bucket, latest, err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading s3://%s/%s: %v", bucket, latest, err)
+	}
+	return body, nil
+}
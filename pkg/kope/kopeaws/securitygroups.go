@@ -0,0 +1,201 @@
+package kopeaws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"strings"
+)
+
+// SecurityGroupRule is a single ingress permission: a protocol and port range from one source
+type SecurityGroupRule struct {
+	GroupID string
+	// Protocol is tcp, udp, icmp, or -1 for all traffic (in which case the ports are ignored)
+	Protocol string
+	FromPort int64
+	ToPort   int64
+	// Exactly one of SourceGroupID and CIDR is set
+	SourceGroupID string
+	CIDR          string
+	Description   string
+}
+
+// NormalizeProtocol maps protocol names to the form returned by the EC2 API
+func NormalizeProtocol(protocol string) string {
+	switch p := strings.ToLower(protocol); p {
+	case "all", "", "-1":
+		return "-1"
+	case "6":
+		return "tcp"
+	case "17":
+		return "udp"
+	case "1":
+		return "icmp"
+	default:
+		return p
+	}
+}
+
+// Key identifies the permission the rule grants, ignoring the description
+func (r *SecurityGroupRule) Key() string {
+	from, to := r.FromPort, r.ToPort
+	if r.Protocol == "-1" {
+		from, to = 0, 0
+	}
+	return fmt.Sprintf("%s/%s/%d-%d/%s%s", r.GroupID, r.Protocol, from, to, r.SourceGroupID, r.CIDR)
+}
+
+func (r *SecurityGroupRule) String() string {
+	source := r.CIDR
+	if r.SourceGroupID != "" {
+		source = r.SourceGroupID
+	}
+	if r.Protocol == "-1" {
+		return fmt.Sprintf("%s: all from %s", r.GroupID, source)
+	}
+	return fmt.Sprintf("%s: %s %d-%d from %s", r.GroupID, r.Protocol, r.FromPort, r.ToPort, source)
+}
+
+func (r *SecurityGroupRule) ipPermission() *ec2.IpPermission {
+	p := &ec2.IpPermission{
+		IpProtocol: aws.String(r.Protocol),
+	}
+	if r.Protocol != "-1" {
+		p.FromPort = aws.Int64(r.FromPort)
+		p.ToPort = aws.Int64(r.ToPort)
+	}
+	if r.SourceGroupID != "" {
+		pair := &ec2.UserIdGroupPair{GroupId: aws.String(r.SourceGroupID)}
+		if r.Description != "" {
+			pair.Description = aws.String(r.Description)
+		}
+		p.UserIdGroupPairs = []*ec2.UserIdGroupPair{pair}
+	} else if strings.Contains(r.CIDR, ":") {
+		ipRange := &ec2.Ipv6Range{CidrIpv6: aws.String(r.CIDR)}
+		if r.Description != "" {
+			ipRange.Description = aws.String(r.Description)
+		}
+		p.Ipv6Ranges = []*ec2.Ipv6Range{ipRange}
+	} else {
+		ipRange := &ec2.IpRange{CidrIp: aws.String(r.CIDR)}
+		if r.Description != "" {
+			ipRange.Description = aws.String(r.Description)
+		}
+		p.IpRanges = []*ec2.IpRange{ipRange}
+	}
+	return p
+}
+
+// ResolveSecurityGroup returns the id of a security group given its id or name; names are
+// looked up in the VPC we manage
+func (a *AWSCloud) ResolveSecurityGroup(ctx context.Context, ref string) (string, error) {
+	if strings.HasPrefix(ref, "sg-") {
+		return ref, nil
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	request := &ec2.DescribeSecurityGroupsInput{
+		Filters: []*ec2.Filter{newEc2Filter("group-name", ref)},
+	}
+	if a.vpcID != "" {
+		request.Filters = append(request.Filters, newEc2Filter("vpc-id", a.vpcID))
+	}
+
+	response, err := a.ec2.DescribeSecurityGroupsWithContext(ctx, request)
+	if err != nil {
+		return "", fmt.Errorf("error finding security group %q: %v", ref, err)
+	}
+	switch len(response.SecurityGroups) {
+	case 0:
+		return "", fmt.Errorf("security group %q not found", ref)
+	case 1:
+		return aws.StringValue(response.SecurityGroups[0].GroupId), nil
+	default:
+		return "", fmt.Errorf("found multiple security groups named %q; specify the id (or a VPC)", ref)
+	}
+}
+
+// ListIngressRules returns the ingress permissions of a security group, one rule per source
+func (a *AWSCloud) ListIngressRules(ctx context.Context, groupID string) ([]*SecurityGroupRule, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	request := &ec2.DescribeSecurityGroupsInput{
+		GroupIds: []*string{aws.String(groupID)},
+	}
+
+	response, err := a.ec2.DescribeSecurityGroupsWithContext(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("error describing security group %q: %v", groupID, err)
+	}
+	if len(response.SecurityGroups) == 0 {
+		return nil, fmt.Errorf("security group %q not found", groupID)
+	}
+
+	var rules []*SecurityGroupRule
+	for _, p := range response.SecurityGroups[0].IpPermissions {
+		base := SecurityGroupRule{
+			GroupID:  groupID,
+			Protocol: NormalizeProtocol(aws.StringValue(p.IpProtocol)),
+			FromPort: aws.Int64Value(p.FromPort),
+			ToPort:   aws.Int64Value(p.ToPort),
+		}
+		for _, pair := range p.UserIdGroupPairs {
+			r := base
+			r.SourceGroupID = aws.StringValue(pair.GroupId)
+			r.Description = aws.StringValue(pair.Description)
+			rules = append(rules, &r)
+		}
+		for _, ipRange := range p.IpRanges {
+			r := base
+			r.CIDR = aws.StringValue(ipRange.CidrIp)
+			r.Description = aws.StringValue(ipRange.Description)
+			rules = append(rules, &r)
+		}
+		for _, ipRange := range p.Ipv6Ranges {
+			r := base
+			r.CIDR = aws.StringValue(ipRange.CidrIpv6)
+			r.Description = aws.StringValue(ipRange.Description)
+			rules = append(rules, &r)
+		}
+	}
+	return rules, nil
+}
+
+// AuthorizeIngress adds an ingress rule to a security group
+func (a *AWSCloud) AuthorizeIngress(ctx context.Context, rule *SecurityGroupRule) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Authorizing ingress %s", rule)
+
+	request := &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String(rule.GroupID),
+		IpPermissions: []*ec2.IpPermission{rule.ipPermission()},
+	}
+	if _, err := a.ec2.AuthorizeSecurityGroupIngressWithContext(ctx, request); err != nil {
+		return fmt.Errorf("error authorizing ingress %s: %v", rule, err)
+	}
+	return nil
+}
+
+// RevokeIngress removes an ingress rule from a security group
+func (a *AWSCloud) RevokeIngress(ctx context.Context, rule *SecurityGroupRule) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Revoking ingress %s", rule)
+
+	request := &ec2.RevokeSecurityGroupIngressInput{
+		GroupId:       aws.String(rule.GroupID),
+		IpPermissions: []*ec2.IpPermission{rule.ipPermission()},
+	}
+	if _, err := a.ec2.RevokeSecurityGroupIngressWithContext(ctx, request); err != nil {
+		return fmt.Errorf("error revoking ingress %s: %v", rule, err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package kopeaws
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// metadataHosts are the addresses of the instance metadata service, which must never be proxied
+var metadataHosts = []string{"169.254.169.254", "fd00:ec2::254"}
+
+// SessionOptions configures where the AWS sessions we build get their credentials.
+// By default the SDK's default chain is used (environment, shared files, instance profile).
+type SessionOptions struct {
+	// Profile is the name of a profile in the shared credentials and config files
+	Profile string
+
+	// StaticCredentials restricts credentials to AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY
+	// (and optionally AWS_SESSION_TOKEN) from the environment
+	StaticCredentials bool
+
+	// WebIdentityTokenFile and WebIdentityRoleARN configure IAM Roles for Service Accounts:
+	// the (projected service account) token in the file is exchanged via STS for credentials
+	// for the role, and refreshed automatically before they expire
+	WebIdentityTokenFile string
+	WebIdentityRoleARN   string
+
+	// AssumeRoleARN is a role that is assumed (via STS) using the base (or web identity) credentials
+	AssumeRoleARN string
+	// AssumeRoleExternalID is the external id to pass when assuming AssumeRoleARN, if required by the role
+	AssumeRoleExternalID string
+
+	// InsecureSkipTLSVerify disables verification of AWS endpoint certificates,
+	// for testing against fake endpoints (e.g. LocalStack) with self-signed certificates
+	InsecureSkipTLSVerify bool
+
+	// ProxyURL is an HTTP(S) proxy through which AWS API requests are sent;
+	// requests to the instance metadata service always bypass the proxy
+	ProxyURL string
+	// NoProxy lists additional hosts (or domain suffixes, starting with ".") which bypass the proxy
+	NoProxy []string
+
+	// MutationNotifier, if set, is told about every call which changes AWS state
+	MutationNotifier MutationNotifier
+
+	// Pause, if set, refuses calls which would change AWS state while it is paused
+	Pause *kope.PauseSwitch
+}
+
+// newSession builds a session with the configured credentials
+func newSession(options SessionOptions) (*session.Session, error) {
+	config := aws.NewConfig()
+	if options.StaticCredentials {
+		config = config.WithCredentials(credentials.NewEnvCredentials())
+	}
+	if options.InsecureSkipTLSVerify || options.ProxyURL != "" {
+		transport := &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+		}
+		if options.InsecureSkipTLSVerify {
+			glog.Warningf("TLS verification of AWS endpoints is disabled")
+			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+		if options.ProxyURL != "" {
+			proxyURL, err := url.Parse(options.ProxyURL)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing proxy url %q: %v", options.ProxyURL, err)
+			}
+			glog.Infof("Sending AWS API requests via proxy %q", proxyURL.Host)
+			transport.Proxy = buildProxyFunc(proxyURL, append(metadataHosts, options.NoProxy...))
+		}
+		config = config.WithHTTPClient(&http.Client{Transport: transport})
+	}
+
+	s, err := session.NewSessionWithOptions(session.Options{
+		Config:            *config,
+		Profile:           options.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error building AWS session: %v", err)
+	}
+
+	if options.WebIdentityTokenFile != "" || options.WebIdentityRoleARN != "" {
+		if options.WebIdentityTokenFile == "" || options.WebIdentityRoleARN == "" {
+			return nil, fmt.Errorf("both the web identity token file and role ARN must be specified")
+		}
+		glog.Infof("Using web identity credentials for role %q", options.WebIdentityRoleARN)
+		creds := stscreds.NewWebIdentityCredentials(s, options.WebIdentityRoleARN, "aws-controller", options.WebIdentityTokenFile)
+		s = s.Copy(aws.NewConfig().WithCredentials(creds))
+	}
+
+	if options.AssumeRoleARN != "" {
+		s = assumeRole(s, options.AssumeRoleARN, options.AssumeRoleExternalID)
+	}
+
+	s.Handlers.Send.PushFront(func(r *request.Request) {
+		// Log requests
+		glog.V(4).Infof("AWS API Request: %s/%s", r.ClientInfo.ServiceName, r.Operation.Name)
+	})
+
+	if options.MutationNotifier != nil {
+		addMutationNotifier(&s.Handlers, options.MutationNotifier)
+	}
+	if options.Pause != nil {
+		addPauseCheck(&s.Handlers, options.Pause)
+	}
+
+	return s, nil
+}
+
+// assumeRole returns a copy of s using credentials for roleARN, obtained from STS using the credentials of s
+func assumeRole(s *session.Session, roleARN string, externalID string) *session.Session {
+	glog.Infof("Using credentials from assumed role %q", roleARN)
+	creds := stscreds.NewCredentials(s, roleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = "aws-controller"
+		if externalID != "" {
+			p.ExternalID = aws.String(externalID)
+		}
+	})
+	return s.Copy(aws.NewConfig().WithCredentials(creds))
+}
+
+// buildProxyFunc returns a proxy function sending requests via proxyURL, except those to noProxy hosts
+func buildProxyFunc(proxyURL *url.URL, noProxy []string) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		host := req.URL.Hostname()
+		for _, s := range noProxy {
+			if host == s || (strings.HasPrefix(s, ".") && strings.HasSuffix(host, s)) {
+				return nil, nil
+			}
+		}
+		return proxyURL, nil
+	}
+}
@@ -0,0 +1,78 @@
+package kopeaws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"time"
+)
+
+// TagNameSnapshotVolume is set on scheduled snapshots, recording the volume they are of
+const TagNameSnapshotVolume = "k8s.io/snapshot/volume"
+
+// Snapshot is a scheduled EBS snapshot belonging to the cluster
+type Snapshot struct {
+	ID       string
+	Region   string
+	VolumeID string
+	State    string
+	Started  time.Time
+}
+
+// ListScheduledSnapshots returns the snapshots we have taken on a schedule, in all our regions
+func (a *AWSCloud) ListScheduledSnapshots(ctx context.Context) ([]*Snapshot, error) {
+	var snapshots []*Snapshot
+	for _, region := range a.Regions() {
+		request := &ec2.DescribeSnapshotsInput{
+			OwnerIds: []*string{aws.String("self")},
+			Filters: []*ec2.Filter{
+				newEc2Filter("tag:"+TagNameKubernetesCluster, a.clusterID),
+				newEc2Filter("tag-key", TagNameSnapshotVolume),
+			},
+		}
+
+		callCtx, cancel := withTimeout(ctx)
+		err := a.regions[region].DescribeSnapshotsPagesWithContext(callCtx, request, func(p *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+			for _, s := range p.Snapshots {
+				snapshot := &Snapshot{
+					ID:      aws.StringValue(s.SnapshotId),
+					Region:  region,
+					State:   aws.StringValue(s.State),
+					Started: aws.TimeValue(s.StartTime),
+				}
+				for _, t := range s.Tags {
+					if aws.StringValue(t.Key) == TagNameSnapshotVolume {
+						snapshot.VolumeID = aws.StringValue(t.Value)
+					}
+				}
+				snapshots = append(snapshots, snapshot)
+			}
+			return true
+		})
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("error describing snapshots in %s: %v", region, err)
+		}
+	}
+	return snapshots, nil
+}
+
+// DeleteSnapshot deletes a snapshot
+func (a *AWSCloud) DeleteSnapshot(ctx context.Context, snapshot *Snapshot) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Deleting snapshot %q of volume %q", snapshot.ID, snapshot.VolumeID)
+
+	request := &ec2.DeleteSnapshotInput{
+		SnapshotId: aws.String(snapshot.ID),
+	}
+
+	_, err := a.regions[snapshot.Region].DeleteSnapshotWithContext(ctx, request)
+	if err != nil {
+		return fmt.Errorf("error deleting snapshot %q: %v", snapshot.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package kopeaws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// maxDeleteParameters is the most parameters a single DeleteParameters request may delete
+const maxDeleteParameters = 10
+
+func (a *AWSCloud) ssm() *ssm.SSM {
+	return ssm.New(a.session, aws.NewConfig().WithRegion(a.region))
+}
+
+// PutSSMParameter creates or overwrites a String parameter in the SSM Parameter Store
+func (a *AWSCloud) PutSSMParameter(ctx context.Context, name string, value string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	request := &ssm.PutParameterInput{
+		Name:      aws.String(name),
+		Value:     aws.String(value),
+		Type:      aws.String(ssm.ParameterTypeString),
+		Overwrite: aws.Bool(true),
+		// Parameters larger than the 4KB limit of the standard tier are stored in the advanced tier
+		Tier: aws.String(ssm.ParameterTierIntelligentTiering),
+	}
+	if _, err := a.ssm().PutParameterWithContext(ctx, request); err != nil {
+		return fmt.Errorf("error writing SSM parameter %q: %v", name, err)
+	}
+	return nil
+}
+
+// ListSSMParameters returns the values of the parameters beneath the path, by name
+func (a *AWSCloud) ListSSMParameters(ctx context.Context, path string) (map[string]string, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	parameters := make(map[string]string)
+	request := &ssm.GetParametersByPathInput{
+		Path:      aws.String(path),
+		Recursive: aws.Bool(true),
+	}
+	err := a.ssm().GetParametersByPathPagesWithContext(ctx, request, func(page *ssm.GetParametersByPathOutput, lastPage bool) bool {
+		for _, p := range page.Parameters {
+			parameters[aws.StringValue(p.Name)] = aws.StringValue(p.Value)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing SSM parameters under %q: %v", path, err)
+	}
+	return parameters, nil
+}
+
+// DeleteSSMParameters deletes the parameters; parameters which don't exist are ignored
+func (a *AWSCloud) DeleteSSMParameters(ctx context.Context, names []string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	for len(names) != 0 {
+		batch := names
+		if len(batch) > maxDeleteParameters {
+			batch = batch[:maxDeleteParameters]
+		}
+		names = names[len(batch):]
+
+		request := &ssm.DeleteParametersInput{
+			Names: aws.StringSlice(batch),
+		}
+		if _, err := a.ssm().DeleteParametersWithContext(ctx, request); err != nil {
+			return fmt.Errorf("error deleting SSM parameters %v: %v", batch, err)
+		}
+	}
+	return nil
+}
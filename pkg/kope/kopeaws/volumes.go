@@ -0,0 +1,150 @@
+package kopeaws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"strings"
+	"time"
+)
+
+// Volume is an EBS volume belonging to the cluster
+type Volume struct {
+	ID      string
+	Region  string
+	State   string
+	Created time.Time
+	Tags    map[string]string
+}
+
+// ListVolumes returns the EBS volumes tagged with our cluster tag, in all our regions
+func (a *AWSCloud) ListVolumes(ctx context.Context) ([]*Volume, error) {
+	var volumes []*Volume
+	for _, region := range a.Regions() {
+		request := &ec2.DescribeVolumesInput{
+			Filters: []*ec2.Filter{newEc2Filter("tag:"+TagNameKubernetesCluster, a.clusterID)},
+		}
+
+		callCtx, cancel := withTimeout(ctx)
+		err := a.regions[region].DescribeVolumesPagesWithContext(callCtx, request, func(p *ec2.DescribeVolumesOutput, lastPage bool) bool {
+			for _, v := range p.Volumes {
+				volume := &Volume{
+					ID:      aws.StringValue(v.VolumeId),
+					Region:  region,
+					State:   aws.StringValue(v.State),
+					Created: aws.TimeValue(v.CreateTime),
+					Tags:    make(map[string]string),
+				}
+				for _, t := range v.Tags {
+					volume.Tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+				}
+				volumes = append(volumes, volume)
+			}
+			return true
+		})
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("error describing volumes in %s: %v", region, err)
+		}
+	}
+	return volumes, nil
+}
+
+// TagVolume creates (or overwrites) tags on a volume
+func (a *AWSCloud) TagVolume(ctx context.Context, volume *Volume, tags map[string]string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.V(2).Infof("Tagging volume %q with %v", volume.ID, tags)
+
+	request := &ec2.CreateTagsInput{}
+	request.Resources = []*string{aws.String(volume.ID)}
+	for k, v := range tags {
+		request.Tags = append(request.Tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	_, err := a.regions[volume.Region].CreateTagsWithContext(ctx, request)
+	if err != nil {
+		return fmt.Errorf("error tagging volume %q: %v", volume.ID, err)
+	}
+	return nil
+}
+
+// UntagVolume deletes tags (with any value) from a volume
+func (a *AWSCloud) UntagVolume(ctx context.Context, volume *Volume, keys []string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.V(2).Infof("Removing tags %v from volume %q", keys, volume.ID)
+
+	request := &ec2.DeleteTagsInput{}
+	request.Resources = []*string{aws.String(volume.ID)}
+	for _, k := range keys {
+		request.Tags = append(request.Tags, &ec2.Tag{Key: aws.String(k)})
+	}
+
+	_, err := a.regions[volume.Region].DeleteTagsWithContext(ctx, request)
+	if err != nil {
+		return fmt.Errorf("error removing tags from volume %q: %v", volume.ID, err)
+	}
+	return nil
+}
+
+// SnapshotVolume starts a snapshot of a volume, copying the volume's tags (and any additional tags)
+// to the snapshot.  Tags with the reserved aws: prefix cannot be set, so they are not copied.  The volume
+// may be deleted as soon as this returns; the snapshot still completes.
+func (a *AWSCloud) SnapshotVolume(ctx context.Context, volume *Volume, description string, tags map[string]string) (string, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Creating snapshot of volume %q", volume.ID)
+
+	tagSpecification := &ec2.TagSpecification{
+		ResourceType: aws.String(ec2.ResourceTypeSnapshot),
+	}
+	snapshotTags := make(map[string]string)
+	for k, v := range volume.Tags {
+		if strings.HasPrefix(k, "aws:") {
+			continue
+		}
+		snapshotTags[k] = v
+	}
+	for k, v := range tags {
+		snapshotTags[k] = v
+	}
+	for k, v := range snapshotTags {
+		tagSpecification.Tags = append(tagSpecification.Tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	request := &ec2.CreateSnapshotInput{
+		VolumeId:          aws.String(volume.ID),
+		Description:       aws.String(description),
+		TagSpecifications: []*ec2.TagSpecification{tagSpecification},
+	}
+
+	response, err := a.regions[volume.Region].CreateSnapshotWithContext(ctx, request)
+	if err != nil {
+		return "", fmt.Errorf("error creating snapshot of volume %q: %v", volume.ID, err)
+	}
+	return aws.StringValue(response.SnapshotId), nil
+}
+
+// DeleteVolume deletes a volume
+func (a *AWSCloud) DeleteVolume(ctx context.Context, volume *Volume) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	glog.Infof("Deleting volume %q in %s", volume.ID, volume.Region)
+
+	request := &ec2.DeleteVolumeInput{
+		VolumeId: aws.String(volume.ID),
+	}
+
+	_, err := a.regions[volume.Region].DeleteVolumeWithContext(ctx, request)
+	if err != nil {
+		return fmt.Errorf("error deleting volume %q: %v", volume.ID, err)
+	}
+	return nil
+}
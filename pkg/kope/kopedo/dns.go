@@ -0,0 +1,588 @@
+package kopedo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DigitalOceanProviderName is the name under which RegisterDigitalOceanDNSProvider registers DigitalOcean DNS
+const DigitalOceanProviderName = "digitalocean"
+
+// defaultEndpoint is the DigitalOcean API
+const defaultEndpoint = "https://api.digitalocean.com/v2/"
+
+// defaultAPITimeout is the timeout of each API call
+const defaultAPITimeout = 30 * time.Second
+
+// pageSize is the number of records we request in each page of a listing (the API maximum)
+const pageSize = 200
+
+// defaultTTL is the TTL of the records we publish; DigitalOcean's minimum is 30 seconds
+var defaultTTL = time.Minute
+
+// ownerRecordPrefix is prepended to a name to form the name of the TXT record marking its ownership; it
+// matches the ownership records of the Route53 provider
+const ownerRecordPrefix = "_aws-controller."
+
+// DigitalOceanOptions configures access to the DigitalOcean API
+type DigitalOceanOptions struct {
+	// Token is the API token, which needs read and write access to domains
+	Token string
+	// Endpoint overrides the API endpoint, e.g. for testing
+	Endpoint string
+}
+
+// DigitalOceanDNSProvider publishes records in a DigitalOcean domain.  DigitalOcean stores each value as a
+// separate record, so a record set is replaced by deleting the values which are no longer wanted and then
+// creating the new ones; unlike Route53 this is not atomic.  DigitalOcean has no routing policies, so record
+// keys with a failover, latency, multi-value, health check or alias policy are rejected.
+type DigitalOceanDNSProvider struct {
+	client   *http.Client
+	endpoint string
+	token    string
+	domain   string
+
+	ownerID        string
+	forceOverwrite bool
+	pause          *kope.PauseSwitch
+
+	// mutex protects ownedNames
+	mutex sync.Mutex
+	// ownedNames caches the names known to carry our ownership record
+	ownedNames map[string]bool
+}
+
+var _ kope.OwnedDNSProvider = &DigitalOceanDNSProvider{}
+
+// RegisterDigitalOceanDNSProvider registers DigitalOcean as a DNS provider; the zone name is the domain
+func RegisterDigitalOceanDNSProvider(options DigitalOceanOptions) {
+	kope.RegisterDNSProvider(DigitalOceanProviderName, func(providerOptions kope.DNSProviderOptions) (kope.DNSProvider, error) {
+		return NewDigitalOceanDNSProvider(options, providerOptions)
+	})
+}
+
+func NewDigitalOceanDNSProvider(options DigitalOceanOptions, providerOptions kope.DNSProviderOptions) (*DigitalOceanDNSProvider, error) {
+	if options.Token == "" {
+		return nil, fmt.Errorf("a DigitalOcean API token is required")
+	}
+	endpoint := options.Endpoint
+	if endpoint == "" {
+		endpoint = defaultEndpoint
+	}
+	if !strings.HasSuffix(endpoint, "/") {
+		endpoint += "/"
+	}
+	return &DigitalOceanDNSProvider{
+		client:         &http.Client{},
+		endpoint:       endpoint,
+		token:          options.Token,
+		domain:         normalizeRecordName(providerOptions.ZoneName),
+		ownerID:        providerOptions.OwnerID,
+		forceOverwrite: providerOptions.ForceOverwrite,
+		pause:          providerOptions.Pause,
+	}, nil
+}
+
+// domainRecord is a DigitalOcean DNS record, holding a single value
+type domainRecord struct {
+	ID   int64  `json:"id,omitempty"`
+	Type string `json:"type"`
+	// Name is relative to the domain, with "@" for the domain itself
+	Name string `json:"name"`
+	Data string `json:"data"`
+	TTL  int    `json:"ttl,omitempty"`
+
+	// Priority, Port and Weight are only set for SRV records
+	Priority *int `json:"priority,omitempty"`
+	Port     *int `json:"port,omitempty"`
+	Weight   *int `json:"weight,omitempty"`
+}
+
+// apiError is the body of a failed API call
+type apiError struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+func (d *DigitalOceanDNSProvider) recordsURL() string {
+	return d.endpoint + "domains/" + url.PathEscape(d.domain) + "/records"
+}
+
+// do makes an API call, decoding the response into out if it is not nil
+func (d *DigitalOceanDNSProvider) do(ctx context.Context, method string, url string, body interface{}, out interface{}) error {
+	if method != http.MethodGet && d.pause.Paused() {
+		glog.V(2).Infof("Refusing DigitalOcean API Request %s %s: mutations are paused", method, url)
+		return kope.ErrPaused
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultAPITimeout)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error serializing request: %v", err)
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+d.token)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	glog.V(4).Infof("DigitalOcean API Request: %s %s", method, url)
+	response, err := d.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		message := response.Status
+		e := &apiError{}
+		if err := json.NewDecoder(response.Body).Decode(e); err == nil && e.Message != "" {
+			message = e.Message
+		}
+		return fmt.Errorf("%s %s failed: %s", method, url, message)
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+			return fmt.Errorf("error decoding response: %v", err)
+		}
+	}
+	return nil
+}
+
+// listRecords returns the records of the domain, only those at name (if not empty) and of recordType (if not empty)
+func (d *DigitalOceanDNSProvider) listRecords(ctx context.Context, name string, recordType string) ([]*domainRecord, error) {
+	query := url.Values{}
+	query.Set("per_page", strconv.Itoa(pageSize))
+	if name != "" {
+		// The API filters by fully-qualified name
+		query.Set("name", normalizeRecordName(name))
+	}
+	if recordType != "" {
+		query.Set("type", recordType)
+	}
+
+	var records []*domainRecord
+	for page := 1; ; page++ {
+		query.Set("page", strconv.Itoa(page))
+		response := struct {
+			DomainRecords []*domainRecord `json:"domain_records"`
+			Links         struct {
+				Pages struct {
+					Next string `json:"next"`
+				} `json:"pages"`
+			} `json:"links"`
+		}{}
+		if err := d.do(ctx, http.MethodGet, d.recordsURL()+"?"+query.Encode(), nil, &response); err != nil {
+			return nil, fmt.Errorf("error listing records in domain %q: %v", d.domain, err)
+		}
+		records = append(records, response.DomainRecords...)
+		if response.Links.Pages.Next == "" {
+			return records, nil
+		}
+	}
+}
+
+func (d *DigitalOceanDNSProvider) createRecord(ctx context.Context, r *domainRecord) error {
+	if err := d.do(ctx, http.MethodPost, d.recordsURL(), r, nil); err != nil {
+		return fmt.Errorf("error creating %s record %q in domain %q: %v", r.Type, r.Name, d.domain, err)
+	}
+	return nil
+}
+
+func (d *DigitalOceanDNSProvider) deleteRecord(ctx context.Context, r *domainRecord) error {
+	u := d.recordsURL() + "/" + strconv.FormatInt(r.ID, 10)
+	if err := d.do(ctx, http.MethodDelete, u, nil, nil); err != nil {
+		return fmt.Errorf("error deleting %s record %q in domain %q: %v", r.Type, r.Name, d.domain, err)
+	}
+	return nil
+}
+
+// ApplyDNSChanges creates or replaces each record set with the values; an empty record set removes the records
+func (d *DigitalOceanDNSProvider) ApplyDNSChanges(ctx context.Context, records map[kope.DNSRecordKey][]string) error {
+	var keys []kope.DNSRecordKey
+	for key := range records {
+		if key.SetIdentifier != "" || key.Failover != "" || key.Region != "" || key.MultiValue || key.HealthCheckPort != 0 || key.AliasHostedZoneID != "" {
+			return fmt.Errorf("cannot publish %s: DigitalOcean does not support routing policies, health checks or aliases", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		return keys[a].String() < keys[b].String()
+	})
+
+	glog.V(2).Infof("Updating DNS records %q", records)
+
+	var refused []string
+	for _, key := range keys {
+		if d.ownerID != "" {
+			ok, err := d.claimName(ctx, key.Name)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				glog.Warningf("Not publishing %q, which has existing records not owned by us", key.Name)
+				refused = append(refused, key.Name)
+				continue
+			}
+		}
+
+		if err := d.applyRecordSet(ctx, key, records[key]); err != nil {
+			return err
+		}
+	}
+
+	if len(refused) != 0 {
+		return fmt.Errorf("refusing to overwrite existing records not owned by us at %v", refused)
+	}
+	return nil
+}
+
+// applyRecordSet replaces the records of the key with the values
+func (d *DigitalOceanDNSProvider) applyRecordSet(ctx context.Context, key kope.DNSRecordKey, values []string) error {
+	var desired []*domainRecord
+	for _, value := range values {
+		r, err := d.toRecord(key, value)
+		if err != nil {
+			return err
+		}
+		desired = append(desired, r)
+	}
+
+	existing, err := d.listRecords(ctx, key.Name, "")
+	if err != nil {
+		return err
+	}
+
+	// A CNAME cannot coexist with other records, so switching between A and CNAME replaces the other.
+	// Records of the other type must be deleted before we create ours; otherwise we create the new records
+	// before deleting the stale ones, so that the name keeps resolving throughout.
+	var conflicts, deletions []*domainRecord
+	for _, r := range existing {
+		if r.Type != key.Type && !(isAddressOrCNAME(r.Type) && isAddressOrCNAME(key.Type)) {
+			continue
+		}
+		found := false
+		for i, want := range desired {
+			if sameRecord(r, want) {
+				desired = append(desired[:i], desired[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			if r.Type != key.Type {
+				glog.Infof("Replacing DNS record %s/%s with %s", key.Name, r.Type, key)
+				conflicts = append(conflicts, r)
+			} else {
+				deletions = append(deletions, r)
+			}
+		}
+	}
+
+	for _, r := range conflicts {
+		if err := d.deleteRecord(ctx, r); err != nil {
+			return err
+		}
+	}
+	for _, r := range desired {
+		if err := d.createRecord(ctx, r); err != nil {
+			return err
+		}
+	}
+	for _, r := range deletions {
+		if err := d.deleteRecord(ctx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toRecord builds the record for one value of the key; SRV values are "priority weight port target", as for Route53
+func (d *DigitalOceanDNSProvider) toRecord(key kope.DNSRecordKey, value string) (*domainRecord, error) {
+	name, err := d.relativeName(key.Name)
+	if err != nil {
+		return nil, err
+	}
+	r := &domainRecord{
+		Type: key.Type,
+		Name: name,
+		Data: value,
+		TTL:  int(defaultTTL.Seconds()),
+	}
+
+	switch key.Type {
+	case kope.DNSTypeCNAME:
+		r.Data = fqdn(value)
+	case kope.DNSTypeSRV:
+		fields := strings.Fields(value)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid SRV value %q for %s", value, key)
+		}
+		var numbers []int
+		for _, f := range fields[:3] {
+			n, err := strconv.Atoi(f)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SRV value %q for %s", value, key)
+			}
+			numbers = append(numbers, n)
+		}
+		r.Priority, r.Weight, r.Port = &numbers[0], &numbers[1], &numbers[2]
+		r.Data = fqdn(fields[3])
+	}
+	return r, nil
+}
+
+// recordValue returns the value of the record, in the form we publish it
+func recordValue(r *domainRecord) string {
+	switch r.Type {
+	case kope.DNSTypeCNAME:
+		return strings.TrimSuffix(r.Data, ".")
+	case kope.DNSTypeSRV:
+		return fmt.Sprintf("%d %d %d %s", intValue(r.Priority), intValue(r.Weight), intValue(r.Port), strings.TrimSuffix(r.Data, "."))
+	default:
+		return r.Data
+	}
+}
+
+// sameRecord returns true if existing has the type and value of want
+func sameRecord(existing *domainRecord, want *domainRecord) bool {
+	if existing.Type != want.Type {
+		return false
+	}
+	return strings.TrimSuffix(existing.Data, ".") == strings.TrimSuffix(want.Data, ".") &&
+		intValue(existing.Priority) == intValue(want.Priority) &&
+		intValue(existing.Weight) == intValue(want.Weight) &&
+		intValue(existing.Port) == intValue(want.Port)
+}
+
+// ownerRecordValue returns the value of our ownership records
+func (d *DigitalOceanDNSProvider) ownerRecordValue() string {
+	return "heritage=aws-controller,owner=" + d.ownerID
+}
+
+// claimName returns true if we may publish records at name, marking it as ours: it is ours already, or has
+// no records at all, or forceOverwrite is set
+func (d *DigitalOceanDNSProvider) claimName(ctx context.Context, name string) (bool, error) {
+	name = normalizeRecordName(name)
+
+	d.mutex.Lock()
+	owned := d.ownedNames[name]
+	d.mutex.Unlock()
+	if owned {
+		return true, nil
+	}
+
+	owners, err := d.listRecords(ctx, ownerRecordPrefix+name, "TXT")
+	if err != nil {
+		return false, err
+	}
+	for _, r := range owners {
+		if r.Data == d.ownerRecordValue() {
+			d.recordOwned(name)
+			return true, nil
+		}
+	}
+
+	if !d.forceOverwrite {
+		if len(owners) != 0 {
+			glog.V(2).Infof("Name %q is owned by %v", name, owners[0].Data)
+			return false, nil
+		}
+		existing, err := d.listRecords(ctx, name, "")
+		if err != nil {
+			return false, err
+		}
+		if len(existing) != 0 {
+			return false, nil
+		}
+	}
+
+	ownerName, err := d.relativeName(ownerRecordPrefix + name)
+	if err != nil {
+		return false, err
+	}
+	owner := &domainRecord{
+		Type: "TXT",
+		Name: ownerName,
+		Data: d.ownerRecordValue(),
+		TTL:  int(defaultTTL.Seconds()),
+	}
+	if err := d.createRecord(ctx, owner); err != nil {
+		return false, err
+	}
+	d.recordOwned(name)
+	return true, nil
+}
+
+// recordOwned caches name as carrying our ownership record
+func (d *DigitalOceanDNSProvider) recordOwned(name string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.ownedNames == nil {
+		d.ownedNames = make(map[string]bool)
+	}
+	d.ownedNames[name] = true
+}
+
+// ListOwnedDNSRecords returns the A, CNAME and SRV record sets at the names marked with our ownership record
+func (d *DigitalOceanDNSProvider) ListOwnedDNSRecords(ctx context.Context) (map[kope.DNSRecordKey][]string, error) {
+	if d.ownerID == "" {
+		return nil, nil
+	}
+
+	all, err := d.listRecords(ctx, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	owned := make(map[string]bool)
+	for _, r := range all {
+		name := d.absoluteName(r.Name)
+		if r.Type == "TXT" && strings.HasPrefix(name, ownerRecordPrefix) && r.Data == d.ownerRecordValue() {
+			owned[strings.TrimPrefix(name, ownerRecordPrefix)] = true
+		}
+	}
+
+	records := make(map[kope.DNSRecordKey][]string)
+	for _, r := range all {
+		switch r.Type {
+		case kope.DNSTypeA, kope.DNSTypeCNAME, kope.DNSTypeSRV:
+		default:
+			continue
+		}
+		name := d.absoluteName(r.Name)
+		if !owned[name] {
+			continue
+		}
+		key := kope.DNSRecordKey{Name: name, Type: r.Type}
+		records[key] = append(records[key], recordValue(r))
+	}
+	for _, values := range records {
+		sort.Strings(values)
+	}
+	for name := range owned {
+		d.recordOwned(name)
+	}
+
+	glog.V(2).Infof("Found %d record sets owned by %q in domain %q", len(records), d.ownerID, d.domain)
+	return records, nil
+}
+
+// DeleteDNSRecords deletes the record sets, whatever their values; record sets which don't exist are ignored.
+// The ownership record of a name is deleted along with its last record set.
+func (d *DigitalOceanDNSProvider) DeleteDNSRecords(ctx context.Context, keys []kope.DNSRecordKey) error {
+	for _, key := range keys {
+		existing, err := d.listRecords(ctx, key.Name, key.Type)
+		if err != nil {
+			return err
+		}
+		if len(existing) != 0 {
+			glog.Infof("Deleting DNS record %s", key)
+		}
+		for _, r := range existing {
+			if err := d.deleteRecord(ctx, r); err != nil {
+				return err
+			}
+		}
+		if d.ownerID != "" {
+			if err := d.releaseName(ctx, normalizeRecordName(key.Name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// releaseName deletes our ownership record of name once it has no records left
+func (d *DigitalOceanDNSProvider) releaseName(ctx context.Context, name string) error {
+	remaining, err := d.listRecords(ctx, name, "")
+	if err != nil || len(remaining) != 0 {
+		return err
+	}
+	owners, err := d.listRecords(ctx, ownerRecordPrefix+name, "TXT")
+	if err != nil {
+		return err
+	}
+	for _, r := range owners {
+		if r.Data != d.ownerRecordValue() {
+			continue
+		}
+		glog.V(2).Infof("Deleting ownership record of %q", name)
+		if err := d.deleteRecord(ctx, r); err != nil {
+			return err
+		}
+	}
+
+	d.mutex.Lock()
+	delete(d.ownedNames, name)
+	d.mutex.Unlock()
+	return nil
+}
+
+// relativeName returns the name relative to the domain, as the API expects, or "@" for the domain itself
+func (d *DigitalOceanDNSProvider) relativeName(name string) (string, error) {
+	name = normalizeRecordName(name)
+	if name == d.domain {
+		return "@", nil
+	}
+	if !strings.HasSuffix(name, "."+d.domain) {
+		return "", fmt.Errorf("name %q is not in domain %q", name, d.domain)
+	}
+	return strings.TrimSuffix(name, "."+d.domain), nil
+}
+
+// absoluteName returns the fully-qualified form (without trailing dot) of a name relative to the domain
+func (d *DigitalOceanDNSProvider) absoluteName(name string) string {
+	if name == "@" {
+		return d.domain
+	}
+	return normalizeRecordName(name + "." + d.domain)
+}
+
+func isAddressOrCNAME(recordType string) bool {
+	return recordType == kope.DNSTypeA || recordType == kope.DNSTypeCNAME
+}
+
+// normalizeRecordName returns the name in a form we can compare: lowercase, without a trailing dot
+func normalizeRecordName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// fqdn returns name with a trailing dot, so that DigitalOcean does not treat it as relative to the domain
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+func intValue(p *int) int {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
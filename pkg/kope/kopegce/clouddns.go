@@ -0,0 +1,212 @@
+package kopegce
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CloudDNSProviderName is the name under which RegisterCloudDNSProvider registers Cloud DNS
+const CloudDNSProviderName = "clouddns"
+
+// defaultTTL is the TTL of the records we publish
+var defaultTTL = time.Minute
+
+// CloudDNSProvider publishes records in a Google Cloud DNS managed zone.  Cloud DNS has no routing
+// policies we can express, so record keys with a failover, latency, multi-value, health check or alias
+// policy are rejected.
+type CloudDNSProvider struct {
+	cloud    *GCECloud
+	zoneName string
+
+	// mutex protects zone
+	mutex sync.Mutex
+	// zone is the name (the id in the API) of the managed zone, once found
+	zone string
+}
+
+var _ kope.DNSProvider = &CloudDNSProvider{}
+
+// RegisterCloudDNSProvider registers Cloud DNS as a DNS provider, for zones in the cloud's project
+func RegisterCloudDNSProvider(cloud *GCECloud) {
+	kope.RegisterDNSProvider(CloudDNSProviderName, func(options kope.DNSProviderOptions) (kope.DNSProvider, error) {
+		if options.OwnerID != "" {
+			glog.V(2).Infof("Cloud DNS does not mark record ownership; records will not be removed when no longer needed")
+		}
+		return NewCloudDNSProvider(cloud, options.ZoneName), nil
+	})
+}
+
+// NewCloudDNSProvider builds a provider for the zone, identified by DNS name or (if it has no dots) by
+// the name of the managed zone
+func NewCloudDNSProvider(cloud *GCECloud, zoneName string) *CloudDNSProvider {
+	return &CloudDNSProvider{
+		cloud:    cloud,
+		zoneName: zoneName,
+	}
+}
+
+// resourceRecordSet is a Cloud DNS record set
+type resourceRecordSet struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	TTL     int64    `json:"ttl"`
+	RRDatas []string `json:"rrdatas"`
+}
+
+func (d *CloudDNSProvider) zoneURL(zone string) string {
+	return d.cloud.dnsEndpoint + "projects/" + d.cloud.project + "/managedZones/" + zone
+}
+
+// getZone returns the name of the managed zone
+func (d *CloudDNSProvider) getZone(ctx context.Context) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.zone != "" {
+		return d.zone, nil
+	}
+
+	if !strings.Contains(d.zoneName, ".") {
+		// Looks like the name of a managed zone
+		if err := d.cloud.do(ctx, http.MethodGet, d.zoneURL(d.zoneName), nil, nil); err != nil {
+			if !isNotFound(err) {
+				return "", fmt.Errorf("error getting managed zone %q: %v", d.zoneName, err)
+			}
+			glog.Infof("Managed zone %q not found; will reattempt by DNS name", d.zoneName)
+		} else {
+			d.zone = d.zoneName
+			return d.zone, nil
+		}
+	}
+
+	dnsName := d.zoneName
+	if !strings.HasSuffix(dnsName, ".") {
+		dnsName += "."
+	}
+
+	response := struct {
+		ManagedZones []struct {
+			Name    string `json:"name"`
+			DNSName string `json:"dnsName"`
+		} `json:"managedZones"`
+	}{}
+	u := d.cloud.dnsEndpoint + "projects/" + d.cloud.project + "/managedZones?dnsName=" + url.QueryEscape(dnsName)
+	if err := d.cloud.do(ctx, http.MethodGet, u, nil, &response); err != nil {
+		return "", fmt.Errorf("error listing managed zones for %q: %v", dnsName, err)
+	}
+
+	var zones []string
+	for _, z := range response.ManagedZones {
+		if z.DNSName == dnsName {
+			zones = append(zones, z.Name)
+		}
+	}
+	if len(zones) == 0 {
+		return "", fmt.Errorf("managed zone %q not found", dnsName)
+	}
+	if len(zones) != 1 {
+		return "", fmt.Errorf("found multiple managed zones for %q: %v", dnsName, zones)
+	}
+
+	d.zone = zones[0]
+	return d.zone, nil
+}
+
+// listRecordSets returns the record sets at name
+func (d *CloudDNSProvider) listRecordSets(ctx context.Context, zone string, name string) ([]*resourceRecordSet, error) {
+	response := struct {
+		RRSets []*resourceRecordSet `json:"rrsets"`
+	}{}
+	u := d.zoneURL(zone) + "/rrsets?name=" + url.QueryEscape(name)
+	if err := d.cloud.do(ctx, http.MethodGet, u, nil, &response); err != nil {
+		return nil, fmt.Errorf("error listing record sets for %s: %v", name, err)
+	}
+	return response.RRSets, nil
+}
+
+func (d *CloudDNSProvider) ApplyDNSChanges(ctx context.Context, records map[kope.DNSRecordKey][]string) error {
+	zone, err := d.getZone(ctx)
+	if err != nil {
+		return err
+	}
+
+	var keys []kope.DNSRecordKey
+	for key := range records {
+		if key.SetIdentifier != "" || key.Failover != "" || key.Region != "" || key.MultiValue || key.HealthCheckPort != 0 || key.AliasHostedZoneID != "" {
+			return fmt.Errorf("cannot publish %s: Cloud DNS does not support routing policies, health checks or aliases", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		return keys[a].String() < keys[b].String()
+	})
+
+	change := struct {
+		Additions []*resourceRecordSet `json:"additions"`
+		Deletions []*resourceRecordSet `json:"deletions"`
+	}{}
+	for _, key := range keys {
+		name := fqdn(key.Name)
+		existing, err := d.listRecordSets(ctx, zone, name)
+		if err != nil {
+			return err
+		}
+
+		// A CNAME cannot coexist with other records, so switching between A and CNAME replaces the other
+		for _, rrs := range existing {
+			if rrs.Type == key.Type || (isAddressOrCNAME(rrs.Type) && isAddressOrCNAME(key.Type)) {
+				if rrs.Type != key.Type {
+					glog.Infof("Replacing DNS record %s/%s with %s", name, rrs.Type, key)
+				}
+				change.Deletions = append(change.Deletions, rrs)
+			}
+		}
+
+		rrs := &resourceRecordSet{
+			Name: name,
+			Type: key.Type,
+			TTL:  int64(defaultTTL.Seconds()),
+		}
+		for _, value := range records[key] {
+			if key.Type == kope.DNSTypeCNAME {
+				value = fqdn(value)
+			}
+			rrs.RRDatas = append(rrs.RRDatas, value)
+		}
+		if len(rrs.RRDatas) == 0 {
+			// An empty record set removes the records
+			continue
+		}
+		change.Additions = append(change.Additions, rrs)
+	}
+
+	if len(change.Additions) == 0 && len(change.Deletions) == 0 {
+		return nil
+	}
+
+	glog.V(2).Infof("Applying Cloud DNS change to zone %q: %d additions, %d deletions", zone, len(change.Additions), len(change.Deletions))
+	if err := d.cloud.do(ctx, http.MethodPost, d.zoneURL(zone)+"/changes", change, nil); err != nil {
+		return fmt.Errorf("error applying DNS changes to zone %q: %v", zone, err)
+	}
+	return nil
+}
+
+func isAddressOrCNAME(recordType string) bool {
+	return recordType == kope.DNSTypeA || recordType == kope.DNSTypeCNAME
+}
+
+// fqdn returns name with the trailing dot Cloud DNS requires
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
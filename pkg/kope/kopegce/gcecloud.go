@@ -0,0 +1,282 @@
+package kopegce
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"golang.org/x/oauth2"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The label marking the instances of a cluster (with the sanitized cluster id), as set by kops
+const LabelClusterName = "k8s-io-cluster-name"
+
+// LabelPrefixRole is the prefix of the label marking the role of an instance, e.g. k8s-io-role-master
+const LabelPrefixRole = "k8s-io-role-"
+
+const (
+	defaultComputeEndpoint  = "https://compute.googleapis.com/compute/v1/"
+	defaultDNSEndpoint      = "https://dns.googleapis.com/dns/v1/"
+	defaultMetadataEndpoint = "http://metadata.google.internal/computeMetadata/v1/"
+)
+
+// defaultAPITimeout bounds each GCE API call, so that a hung call cannot stall the reconcile loop
+var defaultAPITimeout = time.Minute
+
+type GCEOptions struct {
+	// Project is the GCP project of the cluster; it defaults to our own project, from the metadata server
+	Project string
+	// ClusterID is the id of the cluster; it defaults to the cluster name label of our own instance
+	ClusterID string
+
+	// ComputeEndpoint, DNSEndpoint and MetadataEndpoint override the API endpoints (e.g. for testing)
+	ComputeEndpoint  string
+	DNSEndpoint      string
+	MetadataEndpoint string
+
+	// TokenSource supplies the OAuth2 access tokens for API calls; by default they are those of the
+	// instance's service account, from the metadata server
+	TokenSource oauth2.TokenSource
+
+	// Pause, if set, refuses mutating API calls (with kope.ErrPaused) while mutations are paused
+	Pause *kope.PauseSwitch
+}
+
+// GCECloud manages the instances of a cluster on Google Compute Engine
+type GCECloud struct {
+	project   string
+	clusterID string
+
+	computeEndpoint string
+	dnsEndpoint     string
+	metadata        *metadataClient
+	client          *http.Client
+	pause           *kope.PauseSwitch
+
+	// instanceZonesMutex protects instanceZones
+	instanceZonesMutex sync.Mutex
+	// instanceZones records the zone of each instance found by ListInstances, because GCE
+	// addresses instances by zone and name
+	instanceZones map[string]string
+}
+
+func NewGCECloud(ctx context.Context, options GCEOptions) (*GCECloud, error) {
+	c := &GCECloud{
+		project:         options.Project,
+		clusterID:       options.ClusterID,
+		computeEndpoint: withDefault(options.ComputeEndpoint, defaultComputeEndpoint),
+		dnsEndpoint:     withDefault(options.DNSEndpoint, defaultDNSEndpoint),
+		metadata:        &metadataClient{endpoint: withDefault(options.MetadataEndpoint, defaultMetadataEndpoint)},
+		instanceZones:   make(map[string]string),
+		pause:           options.Pause,
+	}
+
+	tokenSource := options.TokenSource
+	if tokenSource == nil {
+		tokenSource = &metadataTokenSource{metadata: c.metadata}
+	}
+	c.client = oauth2.NewClient(ctx, oauth2.ReuseTokenSource(nil, tokenSource))
+
+	if c.project == "" {
+		project, err := c.metadata.get(ctx, "project/project-id")
+		if err != nil {
+			return nil, fmt.Errorf("error getting project from metadata (set the project explicitly if not running on GCE): %v", err)
+		}
+		c.project = project
+	}
+
+	if c.clusterID == "" {
+		clusterID, err := c.getSelfClusterID(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.clusterID = clusterID
+	}
+
+	glog.Infof("Managing cluster %q in GCE project %q", c.clusterID, c.project)
+	return c, nil
+}
+
+func withDefault(s string, defaultValue string) string {
+	if s == "" {
+		return defaultValue
+	}
+	if !strings.HasSuffix(s, "/") {
+		s += "/"
+	}
+	return s
+}
+
+// getSelfClusterID returns the cluster name label of the instance we are running on
+func (c *GCECloud) getSelfClusterID(ctx context.Context) (string, error) {
+	name, err := c.metadata.get(ctx, "instance/name")
+	if err != nil {
+		return "", fmt.Errorf("error getting instance name from metadata (set the cluster id explicitly if not running on GCE): %v", err)
+	}
+	zone, err := c.metadata.get(ctx, "instance/zone")
+	if err != nil {
+		return "", fmt.Errorf("error getting instance zone from metadata: %v", err)
+	}
+
+	instance := &instance{}
+	if err := c.do(ctx, http.MethodGet, c.instanceURL(lastComponent(zone), name), nil, instance); err != nil {
+		return "", fmt.Errorf("error getting our own instance %q: %v", name, err)
+	}
+	clusterID := instance.Labels[LabelClusterName]
+	if clusterID == "" {
+		return "", fmt.Errorf("our instance %q has no %s label; the cluster id must be set", name, LabelClusterName)
+	}
+	return clusterID, nil
+}
+
+func (c *GCECloud) ClusterID() string {
+	return c.clusterID
+}
+
+func (c *GCECloud) Project() string {
+	return c.project
+}
+
+// apiError is the error returned by Google APIs
+type apiError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// httpError is a failed API call
+type httpError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("%d: %s", e.StatusCode, e.Message)
+}
+
+// isNotFound returns true if err is a 404 from the API
+func isNotFound(err error) bool {
+	e, ok := err.(*httpError)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// do makes an API call, sending body (if not nil) and decoding the response into out (if not nil)
+func (c *GCECloud) do(ctx context.Context, method string, url string, body interface{}, out interface{}) error {
+	if method != http.MethodGet && c.pause.Paused() {
+		glog.V(2).Infof("Refusing GCE API Request %s %s: mutations are paused", method, url)
+		return kope.ErrPaused
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultAPITimeout)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error serializing request: %v", err)
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	glog.V(4).Infof("GCE API Request: %s %s", method, url)
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		e := &apiError{}
+		message := response.Status
+		if err := json.NewDecoder(response.Body).Decode(e); err == nil && e.Error.Message != "" {
+			message = e.Error.Message
+		}
+		return &httpError{StatusCode: response.StatusCode, Message: message}
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+			return fmt.Errorf("error decoding response: %v", err)
+		}
+	}
+	return nil
+}
+
+// lastComponent returns the last component of a resource URL, e.g. the zone name from a zone URL
+func lastComponent(url string) string {
+	return url[strings.LastIndex(url, "/")+1:]
+}
+
+// metadataClient reads from the GCE metadata server
+type metadataClient struct {
+	endpoint string
+}
+
+func (m *metadataClient) get(ctx context.Context, path string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, m.endpoint+path, nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Metadata-Flavor", "Google")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata %s returned %s", path, response.Status)
+	}
+	b, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// metadataTokenSource supplies the access tokens of the instance's default service account
+type metadataTokenSource struct {
+	metadata *metadataClient
+}
+
+func (s *metadataTokenSource) Token() (*oauth2.Token, error) {
+	body, err := s.metadata.get(context.Background(), "instance/service-accounts/default/token")
+	if err != nil {
+		return nil, fmt.Errorf("error getting access token from metadata: %v", err)
+	}
+
+	response := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		TokenType   string `json:"token_type"`
+	}{}
+	if err := json.Unmarshal([]byte(body), &response); err != nil {
+		return nil, fmt.Errorf("error parsing access token from metadata: %v", err)
+	}
+	return &oauth2.Token{
+		AccessToken: response.AccessToken,
+		TokenType:   response.TokenType,
+		Expiry:      time.Now().Add(time.Duration(response.ExpiresIn) * time.Second),
+	}, nil
+}
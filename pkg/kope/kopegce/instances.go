@@ -0,0 +1,246 @@
+package kopegce
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var _ kope.Cloud = &GCECloud{}
+
+// instanceStates maps GCE instance statuses to kope instance states
+var instanceStates = map[string]string{
+	"PROVISIONING": kope.InstanceStatePending,
+	"STAGING":      kope.InstanceStatePending,
+	"RUNNING":      kope.InstanceStateRunning,
+	"REPAIRING":    kope.InstanceStateRunning,
+	"STOPPING":     kope.InstanceStateStopping,
+	"SUSPENDING":   kope.InstanceStateStopping,
+	"SUSPENDED":    kope.InstanceStateStopped,
+	// A TERMINATED instance is stopped; deleted instances are not listed at all
+	"TERMINATED": kope.InstanceStateStopped,
+}
+
+// instance holds the fields of a GCE instance which we use
+type instance struct {
+	Name              string            `json:"name"`
+	Zone              string            `json:"zone"`
+	Status            string            `json:"status"`
+	Labels            map[string]string `json:"labels"`
+	LabelFingerprint  string            `json:"labelFingerprint"`
+	CanIPForward      bool              `json:"canIpForward"`
+	CreationTimestamp string            `json:"creationTimestamp"`
+	NetworkInterfaces []struct {
+		NetworkIP     string `json:"networkIP"`
+		AccessConfigs []struct {
+			NatIP string `json:"natIP"`
+		} `json:"accessConfigs"`
+	} `json:"networkInterfaces"`
+}
+
+func (c *GCECloud) instanceURL(zone string, name string) string {
+	return c.computeEndpoint + "projects/" + c.project + "/zones/" + zone + "/instances/" + name
+}
+
+// ListInstances returns the instances labelled as belonging to the cluster, in all zones
+func (c *GCECloud) ListInstances(ctx context.Context) ([]*kope.Instance, error) {
+	query := url.Values{}
+	query.Set("filter", fmt.Sprintf("labels.%s=%q", LabelClusterName, kope.SanitizeLabel(c.clusterID)))
+
+	var instances []*kope.Instance
+	zones := make(map[string]string)
+	for {
+		response := struct {
+			Items map[string]struct {
+				Instances []*instance `json:"instances"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}{}
+		u := c.computeEndpoint + "projects/" + c.project + "/aggregated/instances?" + query.Encode()
+		if err := c.do(ctx, http.MethodGet, u, nil, &response); err != nil {
+			return nil, fmt.Errorf("error listing instances: %v", err)
+		}
+
+		for _, scope := range response.Items {
+			for _, i := range scope.Instances {
+				instance := c.toInstance(i)
+				zones[instance.ID] = instance.Zone
+				instances = append(instances, instance)
+			}
+		}
+
+		if response.NextPageToken == "" {
+			break
+		}
+		query.Set("pageToken", response.NextPageToken)
+	}
+
+	c.instanceZonesMutex.Lock()
+	for id, zone := range zones {
+		c.instanceZones[id] = zone
+	}
+	c.instanceZonesMutex.Unlock()
+
+	return instances, nil
+}
+
+// toInstance converts a GCE instance to a kope instance; GCE instances are identified by name, which
+// is also the suffix of the provider id of their nodes
+func (c *GCECloud) toInstance(i *instance) *kope.Instance {
+	zone := lastComponent(i.Zone)
+	instance := &kope.Instance{
+		ID:           i.Name,
+		Zone:         zone,
+		State:        instanceStates[i.Status],
+		IPForwarding: i.CanIPForward,
+		Tags:         make(map[string]string),
+		// The internal DNS name of the instance, which is also the name of its node
+		PrivateDNSName: i.Name + "." + zone + ".c." + c.project + ".internal",
+	}
+	if n := strings.LastIndex(zone, "-"); n != -1 {
+		instance.Region = zone[:n]
+	}
+	if t, err := time.Parse(time.RFC3339, i.CreationTimestamp); err == nil {
+		instance.LaunchTime = t
+	}
+	if len(i.NetworkInterfaces) != 0 {
+		instance.PrivateIP = i.NetworkInterfaces[0].NetworkIP
+		for _, ac := range i.NetworkInterfaces[0].AccessConfigs {
+			if ac.NatIP != "" {
+				instance.PublicIP = ac.NatIP
+			}
+		}
+	}
+	for k, v := range i.Labels {
+		instance.Tags[k] = v
+		if strings.HasPrefix(k, LabelPrefixRole) && instance.Role == "" {
+			instance.Role = strings.TrimPrefix(k, LabelPrefixRole)
+		}
+	}
+	return instance
+}
+
+// instanceZone returns the zone of an instance found by ListInstances
+func (c *GCECloud) instanceZone(instanceID string) (string, error) {
+	c.instanceZonesMutex.Lock()
+	defer c.instanceZonesMutex.Unlock()
+
+	zone := c.instanceZones[instanceID]
+	if zone == "" {
+		return "", fmt.Errorf("zone of instance %q is not known", instanceID)
+	}
+	return zone, nil
+}
+
+// instanceCall makes a call to the instance's URL (with the suffix, e.g. "/reset")
+func (c *GCECloud) instanceCall(ctx context.Context, method string, instanceID string, suffix string, body interface{}, out interface{}) error {
+	zone, err := c.instanceZone(instanceID)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, method, c.instanceURL(zone, instanceID)+suffix, body, out)
+}
+
+// SetInstanceIPForwarding sets canIpForward, the GCE analog of disabling source-dest-check.  GCE only
+// allows this to be changed on stopped instances, so it returns kope.ErrInstanceMustBeStopped for others
+// without attempting the change.
+func (c *GCECloud) SetInstanceIPForwarding(ctx context.Context, instanceID string, enabled bool) error {
+	// Update replaces the whole instance, so we must send back every field we were given
+	resource := make(map[string]interface{})
+	if err := c.instanceCall(ctx, http.MethodGet, instanceID, "", nil, &resource); err != nil {
+		return fmt.Errorf("error getting instance %q: %v", instanceID, err)
+	}
+	if status, _ := resource["status"].(string); status != "TERMINATED" {
+		return kope.ErrInstanceMustBeStopped
+	}
+
+	glog.Infof("Configuring canIpForward on %q to %v", instanceID, enabled)
+	resource["canIpForward"] = enabled
+	if err := c.instanceCall(ctx, http.MethodPut, instanceID, "", resource, nil); err != nil {
+		return fmt.Errorf("error configuring canIpForward on instance %q: %v", instanceID, err)
+	}
+	return nil
+}
+
+// SetInstanceTerminationProtection sets the deletion protection of the instance
+func (c *GCECloud) SetInstanceTerminationProtection(ctx context.Context, instanceID string, protect bool) error {
+	glog.Infof("Configuring deletionProtection on %q to %v", instanceID, protect)
+
+	suffix := fmt.Sprintf("/setDeletionProtection?deletionProtection=%v", protect)
+	if err := c.instanceCall(ctx, http.MethodPost, instanceID, suffix, nil, nil); err != nil {
+		return fmt.Errorf("error configuring deletion protection on instance %q: %v", instanceID, err)
+	}
+	return nil
+}
+
+// TagInstance sets labels on the instance; keys and values are sanitized to the label character set
+func (c *GCECloud) TagInstance(ctx context.Context, instanceID string, tags map[string]string) error {
+	glog.Infof("Labelling instance %q with %v", instanceID, tags)
+	return c.updateLabels(ctx, instanceID, func(labels map[string]string) {
+		for k, v := range tags {
+			labels[kope.SanitizeLabel(k)] = kope.SanitizeLabel(v)
+		}
+	})
+}
+
+// UntagInstance removes labels from the instance
+func (c *GCECloud) UntagInstance(ctx context.Context, instanceID string, keys []string) error {
+	glog.Infof("Removing labels %v from instance %q", keys, instanceID)
+	return c.updateLabels(ctx, instanceID, func(labels map[string]string) {
+		for _, k := range keys {
+			delete(labels, kope.SanitizeLabel(k))
+		}
+	})
+}
+
+// updateLabels applies update to the current labels of the instance; the label fingerprint makes
+// GCE reject the change if the labels were changed concurrently
+func (c *GCECloud) updateLabels(ctx context.Context, instanceID string, update func(labels map[string]string)) error {
+	i := &instance{}
+	if err := c.instanceCall(ctx, http.MethodGet, instanceID, "", nil, i); err != nil {
+		return fmt.Errorf("error getting instance %q: %v", instanceID, err)
+	}
+
+	labels := make(map[string]string)
+	for k, v := range i.Labels {
+		labels[k] = v
+	}
+	update(labels)
+
+	request := map[string]interface{}{
+		"labels":           labels,
+		"labelFingerprint": i.LabelFingerprint,
+	}
+	if err := c.instanceCall(ctx, http.MethodPost, instanceID, "/setLabels", request, nil); err != nil {
+		return fmt.Errorf("error setting labels on instance %q: %v", instanceID, err)
+	}
+	return nil
+}
+
+// RebootInstance resets the instance (a hard reset; GCE has no soft reboot)
+func (c *GCECloud) RebootInstance(ctx context.Context, instanceID string) error {
+	glog.Infof("Resetting instance %q", instanceID)
+
+	if err := c.instanceCall(ctx, http.MethodPost, instanceID, "/reset", nil, nil); err != nil {
+		return fmt.Errorf("error resetting instance %q: %v", instanceID, err)
+	}
+	return nil
+}
+
+// TerminateInstance deletes the instance (for its managed instance group to replace)
+func (c *GCECloud) TerminateInstance(ctx context.Context, instanceID string) error {
+	glog.Infof("Deleting instance %q", instanceID)
+
+	if err := c.instanceCall(ctx, http.MethodDelete, instanceID, "", nil, nil); err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("error deleting instance %q: %v", instanceID, err)
+	}
+	return nil
+}
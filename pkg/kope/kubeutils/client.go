@@ -0,0 +1,123 @@
+package kubeutils
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"net/http"
+	"strings"
+)
+
+// unpausedResources are the resources which may still be changed while mutations are paused: leader election
+// and events are bookkeeping, not changes to the cluster
+var unpausedResources = map[string]bool{
+	"leases": true,
+	"events": true,
+}
+
+// buildConfig returns the configuration for connecting to the Kubernetes API.  If kubeconfig is
+// set it is used; otherwise we use the pod's service account when running in-cluster, and
+// fall back to the default kubeconfig ($KUBECONFIG or ~/.kube/config) when we are not.
+func buildConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig != "" {
+		config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("error loading kubeconfig %q: %v", kubeconfig, err)
+		}
+		return config, nil
+	}
+
+	config, err := rest.InClusterConfig()
+	if err == nil {
+		return config, nil
+	}
+	if err != rest.ErrNotInCluster {
+		return nil, fmt.Errorf("error building in-cluster kubernetes configuration: %v", err)
+	}
+
+	glog.Infof("Not running in-cluster; using default kubeconfig")
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error loading default kubeconfig: %v", err)
+	}
+	return config, nil
+}
+
+// NewClient builds a Kubernetes client, from kubeconfig if set, otherwise auto-detecting the configuration.
+// If pause is set, mutating requests fail with kope.ErrPaused while it is paused.
+func NewClient(kubeconfig string, pause *kope.PauseSwitch) (kubernetes.Interface, error) {
+	config, err := buildConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	if pause != nil {
+		config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+			return &pauseRoundTripper{pause: pause, next: rt}
+		})
+	}
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error building kubernetes client: %v", err)
+	}
+	return client, nil
+}
+
+// NewDynamicClient builds a Kubernetes client for custom resources, configured as for NewClient
+func NewDynamicClient(kubeconfig string) (dynamic.Interface, error) {
+	config, err := buildConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error building kubernetes dynamic client: %v", err)
+	}
+	return client, nil
+}
+
+// pauseRoundTripper refuses mutating requests while mutations are paused
+type pauseRoundTripper struct {
+	pause *kope.PauseSwitch
+	next  http.RoundTripper
+}
+
+func (t *pauseRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return t.next.RoundTrip(req)
+	}
+	if t.pause.Paused() && !unpausedResources[requestResource(req.URL.Path)] {
+		glog.V(2).Infof("Refusing %s %s: mutations are paused", req.Method, req.URL.Path)
+		return nil, kope.ErrPaused
+	}
+	return t.next.RoundTrip(req)
+}
+
+// requestResource returns the resource of an API path, e.g. leases for
+// /apis/coordination.k8s.io/v1/namespaces/kube-system/leases/aws-controller
+func requestResource(path string) string {
+	tokens := strings.Split(strings.Trim(path, "/"), "/")
+	// Skip the api or apis prefix, and the group and version
+	switch {
+	case len(tokens) >= 2 && tokens[0] == "api":
+		tokens = tokens[2:]
+	case len(tokens) >= 3 && tokens[0] == "apis":
+		tokens = tokens[3:]
+	default:
+		return ""
+	}
+	if len(tokens) >= 3 && tokens[0] == "namespaces" {
+		tokens = tokens[2:]
+	}
+	if len(tokens) == 0 {
+		return ""
+	}
+	return tokens[0]
+}
@@ -0,0 +1,220 @@
+package kubeutils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"strings"
+	"time"
+)
+
+// The annotation the kubelet sets on mirror pods (of static pods), which cannot be evicted
+const annotationMirrorPod = "kubernetes.io/config.mirror"
+
+// FindNodeForInstance returns the name of the node for an EC2 instance, matching on the
+// provider id (aws:///<az>/<instance-id>) or else on the private DNS name.  It returns ""
+// if no node is found.
+func FindNodeForInstance(ctx context.Context, client kubernetes.Interface, instanceID string, privateDNSName string) (string, error) {
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error listing nodes: %v", err)
+	}
+
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if strings.HasSuffix(node.Spec.ProviderID, "/"+instanceID) {
+			return node.Name, nil
+		}
+	}
+	if privateDNSName != "" {
+		for i := range nodes.Items {
+			node := &nodes.Items[i]
+			if node.Name == privateDNSName {
+				return node.Name, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// InstanceIDFromProviderID returns the EC2 instance id from a node's provider id
+// (aws:///<az>/<instance-id>), or "" if it is not an AWS provider id
+func InstanceIDFromProviderID(providerID string) string {
+	if !strings.HasPrefix(providerID, "aws://") {
+		return ""
+	}
+	id := providerID[strings.LastIndex(providerID, "/")+1:]
+	if !strings.HasPrefix(id, "i-") {
+		return ""
+	}
+	return id
+}
+
+// CordonNode marks a node unschedulable
+func CordonNode(ctx context.Context, client kubernetes.Interface, nodeName string) error {
+	node, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error fetching node %q: %v", nodeName, err)
+	}
+	if node.Spec.Unschedulable {
+		glog.V(2).Infof("Node %q is already cordoned", nodeName)
+		return nil
+	}
+
+	glog.Infof("Cordoning node %q", nodeName)
+	patch := []byte(`{"spec":{"unschedulable":true}}`)
+	_, err = client.CoreV1().Nodes().Patch(ctx, nodeName, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("error cordoning node %q: %v", nodeName, err)
+	}
+	return nil
+}
+
+// DeleteNode deletes a node object, e.g. once its instance has been terminated; a missing node is not an error
+func DeleteNode(ctx context.Context, client kubernetes.Interface, nodeName string) error {
+	glog.Infof("Deleting node %q", nodeName)
+	err := client.CoreV1().Nodes().Delete(ctx, nodeName, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("error deleting node %q: %v", nodeName, err)
+	}
+	return nil
+}
+
+// AnnotateNode sets annotations on a node, leaving its other annotations unchanged
+func AnnotateNode(ctx context.Context, client kubernetes.Interface, nodeName string, annotations map[string]string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error building annotation patch: %v", err)
+	}
+
+	glog.V(2).Infof("Annotating node %q: %v", nodeName, annotations)
+	_, err = client.CoreV1().Nodes().Patch(ctx, nodeName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("error annotating node %q: %v", nodeName, err)
+	}
+	return nil
+}
+
+// DrainOptions configures DrainNode
+type DrainOptions struct {
+	// GracePeriod overrides the termination grace period of evicted pods, if non-zero
+	GracePeriod time.Duration
+	// Timeout bounds the whole drain, including waiting for pods to terminate
+	Timeout time.Duration
+}
+
+// DrainNode evicts all pods from a node (other than mirror and DaemonSet pods), respecting
+// PodDisruptionBudgets, and waits for them to terminate.  The node should already be cordoned.
+func DrainNode(ctx context.Context, client kubernetes.Interface, nodeName string, options DrainOptions) error {
+	if options.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+
+	pods, err := listPodsToEvict(ctx, client, nodeName)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("Draining %d pods from node %q", len(pods), nodeName)
+
+	for _, pod := range pods {
+		if err := evictPod(ctx, client, pod, options.GracePeriod); err != nil {
+			return err
+		}
+	}
+
+	// Wait for the evicted pods to terminate
+	for {
+		remaining, err := listPodsToEvict(ctx, client, nodeName)
+		if err != nil {
+			return err
+		}
+		if len(remaining) == 0 {
+			glog.Infof("Drained node %q", nodeName)
+			return nil
+		}
+		glog.V(2).Infof("Waiting for %d pods to terminate on node %q", len(remaining), nodeName)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout waiting for %d pods to terminate on node %q", len(remaining), nodeName)
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func listPodsToEvict(ctx context.Context, client kubernetes.Interface, nodeName string) ([]*v1.Pod, error) {
+	podList, err := client.CoreV1().Pods("").List(ctx, metav1.ListOptions{FieldSelector: "spec.nodeName=" + nodeName})
+	if err != nil {
+		return nil, fmt.Errorf("error listing pods on node %q: %v", nodeName, err)
+	}
+
+	var pods []*v1.Pod
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		if _, found := pod.Annotations[annotationMirrorPod]; found {
+			continue
+		}
+		if isDaemonSetPod(pod) {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+func isDaemonSetPod(pod *v1.Pod) bool {
+	for _, ref := range pod.OwnerReferences {
+		if ref.Controller != nil && *ref.Controller && ref.Kind == "DaemonSet" {
+			return true
+		}
+	}
+	return false
+}
+
+// evictPod evicts a pod, retrying while a PodDisruptionBudget prevents it
+func evictPod(ctx context.Context, client kubernetes.Interface, pod *v1.Pod, gracePeriod time.Duration) error {
+	eviction := &policy.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		},
+	}
+	if gracePeriod != 0 {
+		seconds := int64(gracePeriod.Seconds())
+		eviction.DeleteOptions = &metav1.DeleteOptions{GracePeriodSeconds: &seconds}
+	}
+
+	for {
+		err := client.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction)
+		if err == nil || errors.IsNotFound(err) {
+			return nil
+		}
+		if !errors.IsTooManyRequests(err) {
+			return fmt.Errorf("error evicting pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+
+		glog.V(2).Infof("Eviction of pod %s/%s blocked by disruption budget; will retry", pod.Namespace, pod.Name)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout evicting pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package kubeutils
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// HasNodeTaint returns true if the node has a taint with the key and effect
+func HasNodeTaint(node *v1.Node, key string, effect v1.TaintEffect) bool {
+	return findTaint(node, key, effect) >= 0
+}
+
+func findTaint(node *v1.Node, key string, effect v1.TaintEffect) int {
+	for i := range node.Spec.Taints {
+		if node.Spec.Taints[i].Key == key && node.Spec.Taints[i].Effect == effect {
+			return i
+		}
+	}
+	return -1
+}
+
+// SetNodeTaint adds (if present is true) or removes (if present is false) a taint on a node
+func SetNodeTaint(ctx context.Context, client kubernetes.Interface, nodeName string, taint v1.Taint, present bool) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		i := findTaint(node, taint.Key, taint.Effect)
+		if (i >= 0) == present {
+			return nil
+		}
+
+		if present {
+			glog.Infof("Adding taint %s:%s to node %q", taint.Key, taint.Effect, nodeName)
+			now := metav1.Now()
+			taint.TimeAdded = &now
+			node.Spec.Taints = append(node.Spec.Taints, taint)
+		} else {
+			glog.Infof("Removing taint %s:%s from node %q", taint.Key, taint.Effect, nodeName)
+			node.Spec.Taints = append(node.Spec.Taints[:i], node.Spec.Taints[i+1:]...)
+		}
+
+		_, err = client.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error updating taints on node %q: %v", nodeName, err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"context"
+	"github.com/golang/glog"
+	"time"
+)
+
+// sendTimeout bounds the delivery of each event to each sink
+const sendTimeout = 30 * time.Second
+
+// Reasons for events
+const (
+	ReasonInstanceRecycled   = "InstanceRecycled"
+	ReasonInstanceRemediated = "InstanceRemediated"
+	ReasonNATFailover        = "NATFailover"
+	ReasonDNSFailoverChanged = "DNSFailoverChanged"
+	// ReasonDNSVerificationFailed is sent when an applied DNS change is not served by the authoritative name servers
+	ReasonDNSVerificationFailed = "DNSVerificationFailed"
+)
+
+// Event is a significant action taken by the controller, which operators should hear about
+type Event struct {
+	Time      time.Time `json:"time"`
+	ClusterID string    `json:"clusterID"`
+	// Reason is a short CamelCase identifier of the kind of event
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// Sink delivers events somewhere an operator will see them
+type Sink interface {
+	Send(ctx context.Context, event *Event) error
+}
+
+// Notifier sends events to all of its sinks.  A nil Notifier discards events, so controllers can
+// notify unconditionally.
+type Notifier struct {
+	ClusterID string
+	Sinks     []Sink
+}
+
+// Notify sends an event to each sink in the background, so that a slow or failing sink does not hold
+// up the action being reported; errors are logged.
+func (n *Notifier) Notify(reason string, message string) {
+	if n == nil || len(n.Sinks) == 0 {
+		return
+	}
+
+	event := &Event{
+		Time:      time.Now().UTC(),
+		ClusterID: n.ClusterID,
+		Reason:    reason,
+		Message:   message,
+	}
+	for _, sink := range n.Sinks {
+		go func(sink Sink) {
+			ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+			defer cancel()
+
+			if err := sink.Send(ctx, event); err != nil {
+				glog.Warningf("error sending %s notification: %v", reason, err)
+			}
+		}(sink)
+	}
+}
@@ -0,0 +1,96 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// WebhookSink POSTs each event as JSON to a URL
+type WebhookSink struct {
+	url *url.URL
+}
+
+var _ Sink = &WebhookSink{}
+
+func NewWebhookSink(s string) (*WebhookSink, error) {
+	u, err := parseURL(s)
+	if err != nil {
+		return nil, err
+	}
+	return &WebhookSink{url: u}, nil
+}
+
+func (s *WebhookSink) Send(ctx context.Context, event *Event) error {
+	return post(ctx, s.url, event)
+}
+
+// SlackSink posts each event as a message to a Slack incoming webhook (or any webhook accepting
+// Slack's message payload, such as Mattermost or Rocket.Chat)
+type SlackSink struct {
+	url *url.URL
+}
+
+var _ Sink = &SlackSink{}
+
+func NewSlackSink(s string) (*SlackSink, error) {
+	u, err := parseURL(s)
+	if err != nil {
+		return nil, err
+	}
+	return &SlackSink{url: u}, nil
+}
+
+// slackMessage is the payload of a Slack incoming webhook
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+func (s *SlackSink) Send(ctx context.Context, event *Event) error {
+	message := &slackMessage{
+		Text: fmt.Sprintf("[%s] %s: %s", event.ClusterID, event.Reason, event.Message),
+	}
+	return post(ctx, s.url, message)
+}
+
+func parseURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing webhook URL %q: %v", s, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("webhook URL %q must be http or https", s)
+	}
+	return u, nil
+}
+
+// post POSTs payload as JSON to u.  Errors name only the host, because webhook URLs often embed a secret.
+func post(ctx context.Context, u *url.URL, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error serializing notification: %v", err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error building notification request: %v", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("error posting notification to %s: %v", u.Host, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("notification endpoint %s returned %s", u.Host, response.Status)
+	}
+	return nil
+}
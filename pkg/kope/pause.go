@@ -0,0 +1,89 @@
+package kope
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrPaused is the error of mutating calls refused because mutations are paused
+var ErrPaused = errors.New("mutating actions are paused")
+
+// The sources which can pause mutations; mutations are paused while any of them has paused them
+const (
+	// PauseSourceAdmin is the /pause and /resume admin endpoints
+	PauseSourceAdmin = "admin"
+	// PauseSourceConfig is the paused field of the configuration
+	PauseSourceConfig = "config"
+)
+
+// PauseSwitch pauses mutating actions (against the cloud and Kubernetes), while observation and status
+// reporting carry on, e.g. during incident response.  The zero value is not paused; a nil PauseSwitch is never paused.
+type PauseSwitch struct {
+	mutex   sync.Mutex
+	sources map[string]*Pause
+}
+
+// Pause records why and when mutations were paused by a source
+type Pause struct {
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since"`
+}
+
+// PauseStatus is the state of a PauseSwitch, for reporting
+type PauseStatus struct {
+	Paused bool `json:"paused"`
+	// Sources maps each source which has paused mutations to its pause
+	Sources map[string]*Pause `json:"sources,omitempty"`
+}
+
+// Pause pauses mutations on behalf of source; pausing again only updates the reason
+func (p *PauseSwitch) Pause(source string, reason string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.sources == nil {
+		p.sources = make(map[string]*Pause)
+	}
+	if existing := p.sources[source]; existing != nil {
+		existing.Reason = reason
+		return
+	}
+	p.sources[source] = &Pause{Reason: reason, Since: time.Now()}
+}
+
+// Resume withdraws the pause of source; mutations remain paused if another source has paused them
+func (p *PauseSwitch) Resume(source string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.sources, source)
+}
+
+// Paused returns true if mutations are paused
+func (p *PauseSwitch) Paused() bool {
+	if p == nil {
+		return false
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return len(p.sources) != 0
+}
+
+// Status returns a snapshot of the pause state
+func (p *PauseSwitch) Status() *PauseStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	status := &PauseStatus{Paused: len(p.sources) != 0}
+	if status.Paused {
+		status.Sources = make(map[string]*Pause)
+		for source, pause := range p.sources {
+			snapshot := *pause
+			status.Sources[source] = &snapshot
+		}
+	}
+	return status
+}
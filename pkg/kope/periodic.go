@@ -0,0 +1,131 @@
+package kope
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/aws-controller/pkg/kope/utils"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"sync"
+	"time"
+)
+
+// DefaultShutdownTimeout is how long controllers wait by default on Stop for their in-flight work to finish
+const DefaultShutdownTimeout = 20 * time.Second
+
+// PeriodicController runs a resync function every period until it is stopped, recording the results for
+// health checks.  Controllers which only resync periodically embed it, for their Run, Stop and SyncStatus.
+type PeriodicController struct {
+	// name describes the controller in logs, e.g. "snapshot controller"
+	name   string
+	period time.Duration
+	resync func(ctx context.Context) error
+
+	// health records the results of our resyncs
+	health SyncHealth
+
+	// shutdownTimeout is how long Stop waits for the in-flight resync to finish, before aborting it
+	shutdownTimeout time.Duration
+	// running tracks the in-flight resync, which Stop waits for
+	running sync.WaitGroup
+
+	// stopLock protects shutdown, so that stopCh is only closed once and no resync starts once Stop has
+	// started waiting for running
+	stopLock sync.Mutex
+	shutdown bool
+	stopCh   chan struct{}
+
+	// ctx is cancelled by Stop once the in-flight resync has finished or shutdownTimeout has passed,
+	// aborting any calls still in flight
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewPeriodicController builds a PeriodicController, which calls resync every period once it is Run
+func NewPeriodicController(name string, period time.Duration, resync func(ctx context.Context) error) *PeriodicController {
+	c := &PeriodicController{
+		name:   name,
+		period: period,
+		resync: resync,
+		stopCh: make(chan struct{}),
+
+		shutdownTimeout: DefaultShutdownTimeout,
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+func (c *PeriodicController) Run() {
+	glog.Infof("starting %s", c.name)
+
+	go utils.Resync(func() {
+		if !c.startResync() {
+			return
+		}
+		defer c.running.Done()
+
+		err := c.resync(c.ctx)
+		c.health.Record(err)
+		if err != nil {
+			runtime.HandleError(err)
+		}
+	}, c.period, c.stopCh)
+
+	<-c.stopCh
+	glog.Infof("shutting down %s", c.name)
+	c.running.Wait()
+}
+
+// startResync adds a resync to running, returning false if we are stopping
+func (c *PeriodicController) startResync() bool {
+	c.stopLock.Lock()
+	defer c.stopLock.Unlock()
+
+	if c.shutdown {
+		return false
+	}
+	c.running.Add(1)
+	return true
+}
+
+// Stop stops the controller.  No new resyncs are started, and Stop waits (for up to the shutdown timeout)
+// for the one in flight to finish, so that we don't stop in the middle of applying changes.
+func (c *PeriodicController) Stop() error {
+	c.stopLock.Lock()
+	defer c.stopLock.Unlock()
+
+	if c.shutdown {
+		return fmt.Errorf("shutdown already in progress")
+	}
+	close(c.stopCh)
+	c.shutdown = true
+	defer c.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		c.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(c.shutdownTimeout):
+		return fmt.Errorf("in-flight resync did not finish within %s; aborting it", c.shutdownTimeout)
+	}
+}
+
+// SetShutdownTimeout sets how long Stop waits for the in-flight resync to finish; it must be called before Run
+func (c *PeriodicController) SetShutdownTimeout(timeout time.Duration) {
+	c.shutdownTimeout = timeout
+}
+
+// SyncStatus returns the results of our resyncs
+func (c *PeriodicController) SyncStatus() *SyncStatus {
+	return c.health.Status()
+}
+
+// SetWarnings replaces the warnings reported in the status, for problems which don't fail the resync
+func (c *PeriodicController) SetWarnings(warnings []string) {
+	c.health.SetWarnings(warnings)
+}
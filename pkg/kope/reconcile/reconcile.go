@@ -0,0 +1,150 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/util/workqueue"
+	"sync"
+	"time"
+)
+
+// Result is the outcome of a successful reconcile
+type Result struct {
+	// Requeue reconciles the key again, after the rate limiter's backoff
+	Requeue bool
+	// RequeueAfter reconciles the key again after the delay; it takes precedence over Requeue
+	RequeueAfter time.Duration
+}
+
+// Reconciler brings the object identified by a key to its desired state.  An error is retried with
+// backoff; otherwise the Result says whether to reconcile the key again.
+type Reconciler interface {
+	Reconcile(ctx context.Context, key string) (Result, error)
+}
+
+// Stats counts the reconciles performed by a Controller, for reporting
+type Stats struct {
+	// Reconciles is the number of reconciles, whatever their result
+	Reconciles int64 `json:"reconciles"`
+	// Errors is the number of reconciles which returned an error
+	Errors int64 `json:"errors"`
+	// Requeues is the number of reconciles which asked to be requeued
+	Requeues int64 `json:"requeues"`
+	// Depth is the number of keys waiting to be reconciled
+	Depth int `json:"depth"`
+
+	LastReconcileTime time.Time `json:"lastReconcileTime,omitempty"`
+	LastErrorTime     time.Time `json:"lastErrorTime,omitempty"`
+}
+
+// Controller reconciles keys from a rate-limited work queue.  Keys added while already queued are
+// coalesced, a key is never reconciled concurrently with itself, and failures are retried with
+// backoff independently of other keys.
+type Controller struct {
+	// Name identifies the controller in logs and workqueue metrics
+	Name string
+
+	reconciler Reconciler
+	queue      workqueue.RateLimitingInterface
+
+	// mutex protects stats
+	mutex sync.Mutex
+	stats Stats
+}
+
+func NewController(name string, reconciler Reconciler) *Controller {
+	return &Controller{
+		Name:       name,
+		reconciler: reconciler,
+		queue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), name),
+	}
+}
+
+// Enqueue queues the key to be reconciled
+func (c *Controller) Enqueue(key string) {
+	c.queue.Add(key)
+}
+
+// Run runs the workers until stopCh is closed, then shuts down the queue and waits for the reconciles in
+// flight to finish; keys still queued are dropped.  Reconciles are passed ctx, so cancelling it aborts them.
+func (c *Controller) Run(ctx context.Context, stopCh <-chan struct{}, workers int) {
+	glog.V(2).Infof("starting %d %s workers", workers, c.Name)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.worker(ctx, stopCh)
+		}()
+	}
+
+	<-stopCh
+	c.queue.ShutDown()
+	wg.Wait()
+	glog.V(2).Infof("%s workers stopped", c.Name)
+}
+
+// Stats returns a snapshot of the reconcile counts
+func (c *Controller) Stats() Stats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats := c.stats
+	stats.Depth = c.queue.Len()
+	return stats
+}
+
+// worker processes items from the queue until it is shut down
+func (c *Controller) worker(ctx context.Context, stopCh <-chan struct{}) {
+	for c.processNextWorkItem(ctx, stopCh) {
+	}
+}
+
+func (c *Controller) processNextWorkItem(ctx context.Context, stopCh <-chan struct{}) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	select {
+	case <-stopCh:
+		// The queue returns the keys it still holds after shutdown, but we don't start new reconciles
+		return false
+	default:
+	}
+
+	result, err := c.reconciler.Reconcile(ctx, key.(string))
+	c.recordResult(result, err)
+
+	switch {
+	case err != nil:
+		runtime.HandleError(fmt.Errorf("error reconciling %s %q (will retry): %v", c.Name, key, err))
+		c.queue.AddRateLimited(key)
+	case result.RequeueAfter > 0:
+		c.queue.Forget(key)
+		c.queue.AddAfter(key, result.RequeueAfter)
+	case result.Requeue:
+		c.queue.AddRateLimited(key)
+	default:
+		c.queue.Forget(key)
+	}
+	return true
+}
+
+func (c *Controller) recordResult(result Result, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	c.stats.Reconciles++
+	c.stats.LastReconcileTime = now
+	if err != nil {
+		c.stats.Errors++
+		c.stats.LastErrorTime = now
+	} else if result.Requeue || result.RequeueAfter > 0 {
+		c.stats.Requeues++
+	}
+}
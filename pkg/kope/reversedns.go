@@ -0,0 +1,36 @@
+package kope
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ReverseName returns the in-addr.arpa name of the PTR record for an IPv4 address
+func ReverseName(ip string) (string, bool) {
+	parsed := net.ParseIP(ip).To4()
+	if parsed == nil {
+		return "", false
+	}
+	var labels []string
+	for i := 3; i >= 0; i-- {
+		labels = append(labels, strconv.Itoa(int(parsed[i])))
+	}
+	return strings.Join(labels, ".") + ".in-addr.arpa", true
+}
+
+// InZone returns true if name is zoneName or a name within it
+func InZone(name string, zoneName string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	zoneName = strings.ToLower(strings.TrimSuffix(zoneName, "."))
+	return name == zoneName || strings.HasSuffix(name, "."+zoneName)
+}
+
+// NewReverseDNSProvider combines the providers of a forward zone and of a reverse zone, so that PTR records
+// are kept alongside the forward records; the result is an OwnedDNSProvider if both providers are
+func NewReverseDNSProvider(forward DNSProvider, reverse DNSProvider) DNSProvider {
+	isPTR := func(k DNSRecordKey) (DNSRecordKey, bool) {
+		return k, k.Type == DNSTypePTR
+	}
+	return newZoneSplitDNSProvider(forward, reverse, isPTR, isPTR)
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// WriteFileAtomically replaces the file at path with data, by writing a temporary file alongside it and
+// renaming it into place, so that readers never see a partial file.  The permissions of an existing file
+// are preserved; a new file is created with mode 0644.
+func WriteFileAtomically(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if stat, err := os.Stat(path); err == nil {
+		mode = stat.Mode().Perm()
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// WriteFileInPlaceIfBusy replaces the file at path with data atomically, as WriteFileAtomically, unless the
+// file cannot be replaced because it is a mount point (such as /etc/hosts in a container), in which case it
+// is rewritten in place
+func WriteFileInPlaceIfBusy(path string, data []byte) error {
+	err := WriteFileAtomically(path, data)
+	if linkErr, ok := err.(*os.LinkError); ok && linkErr.Err == syscall.EBUSY {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(data)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+	return err
+}
+
+// fileMutexes holds the mutex of each path passed to FileMutex
+var fileMutexes sync.Map
+
+// FileMutex returns the mutex serializing read-modify-write updates of the file at path by all the users
+// (e.g. DNS providers) in this process
+func FileMutex(path string) *sync.Mutex {
+	path = filepath.Clean(path)
+	mutex, _ := fileMutexes.LoadOrStore(path, &sync.Mutex{})
+	return mutex.(*sync.Mutex)
+}
@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"k8s.io/apimachinery/pkg/util/wait"
+	"time"
+)
+
+// ResyncJitter is the maximum fraction by which each resync period is randomly extended, so that the
+// controllers of many clusters sharing an AWS account don't fall into step and hit its API throttling together
+var ResyncJitter = 0.0
+
+// Resync calls f every period (extended by up to ResyncJitter), until stopCh is closed
+func Resync(f func(), period time.Duration, stopCh <-chan struct{}) {
+	wait.JitterUntil(f, period, ResyncJitter, true, stopCh)
+}
+
+// JitteredPeriod returns period, randomly extended by up to ResyncJitter
+func JitteredPeriod(period time.Duration) time.Duration {
+	if ResyncJitter <= 0 {
+		return period
+	}
+	return wait.Jitter(period, ResyncJitter)
+}
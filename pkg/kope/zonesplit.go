@@ -0,0 +1,133 @@
+package kope
+
+import (
+	"context"
+)
+
+// zoneSplitDNSProvider publishes some record sets to the provider of a second zone (such as a reverse
+// zone, or the private zone of a split-horizon pair), and all other record sets to the provider of the main zone
+type zoneSplitDNSProvider struct {
+	main  DNSProvider
+	other DNSProvider
+
+	// toOther returns the key of the record set in the other zone, or false if it belongs in the main zone
+	toOther func(k DNSRecordKey) (DNSRecordKey, bool)
+	// fromOther returns our key for a record set listed in the other zone, or false if we don't manage it there
+	fromOther func(k DNSRecordKey) (DNSRecordKey, bool)
+}
+
+// ownedZoneSplitDNSProvider is a zoneSplitDNSProvider whose providers both record ownership
+type ownedZoneSplitDNSProvider struct {
+	zoneSplitDNSProvider
+}
+
+var _ OwnedDNSProvider = &ownedZoneSplitDNSProvider{}
+
+// newZoneSplitDNSProvider combines the providers of two zones; the result is an OwnedDNSProvider if both providers are
+func newZoneSplitDNSProvider(main DNSProvider, other DNSProvider, toOther func(k DNSRecordKey) (DNSRecordKey, bool), fromOther func(k DNSRecordKey) (DNSRecordKey, bool)) DNSProvider {
+	p := zoneSplitDNSProvider{main: main, other: other, toOther: toOther, fromOther: fromOther}
+	_, mainOwned := main.(OwnedDNSProvider)
+	_, otherOwned := other.(OwnedDNSProvider)
+	if mainOwned && otherOwned {
+		return &ownedZoneSplitDNSProvider{p}
+	}
+	return &p
+}
+
+// NewSplitHorizonDNSProvider combines the providers of the public and private zones of a split-horizon pair;
+// record sets marked Private are published to the private zone.  The result is an OwnedDNSProvider if both
+// providers are.
+func NewSplitHorizonDNSProvider(public DNSProvider, private DNSProvider) DNSProvider {
+	toPrivate := func(k DNSRecordKey) (DNSRecordKey, bool) {
+		if !k.Private {
+			return k, false
+		}
+		k.Private = false
+		return k, true
+	}
+	fromPrivate := func(k DNSRecordKey) (DNSRecordKey, bool) {
+		k.Private = true
+		return k, true
+	}
+	return newZoneSplitDNSProvider(public, private, toPrivate, fromPrivate)
+}
+
+// ListNameServers returns the name servers of the main zone, or nil if they are not known
+func (p *zoneSplitDNSProvider) ListNameServers(ctx context.Context) ([]string, error) {
+	if lister, ok := p.main.(NameServerLister); ok {
+		return lister.ListNameServers(ctx)
+	}
+	return nil, nil
+}
+
+func (p *zoneSplitDNSProvider) ApplyDNSChanges(ctx context.Context, records map[DNSRecordKey][]string) error {
+	main := make(map[DNSRecordKey][]string)
+	other := make(map[DNSRecordKey][]string)
+	for k, v := range records {
+		if otherKey, ok := p.toOther(k); ok {
+			other[otherKey] = v
+		} else {
+			main[k] = v
+		}
+	}
+
+	if len(main) != 0 {
+		if err := p.main.ApplyDNSChanges(ctx, main); err != nil {
+			return err
+		}
+	}
+	if len(other) != 0 {
+		if err := p.other.ApplyDNSChanges(ctx, other); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListOwnedDNSRecords returns the owned record sets of both zones, or nil if either is not recording ownership
+func (p *ownedZoneSplitDNSProvider) ListOwnedDNSRecords(ctx context.Context) (map[DNSRecordKey][]string, error) {
+	listed, err := p.main.(OwnedDNSProvider).ListOwnedDNSRecords(ctx)
+	if err != nil || listed == nil {
+		return nil, err
+	}
+	other, err := p.other.(OwnedDNSProvider).ListOwnedDNSRecords(ctx)
+	if err != nil || other == nil {
+		return nil, err
+	}
+
+	records := make(map[DNSRecordKey][]string)
+	for k, v := range listed {
+		if _, ok := p.toOther(k); !ok {
+			records[k] = v
+		}
+	}
+	for k, v := range other {
+		if key, ok := p.fromOther(k); ok {
+			records[key] = v
+		}
+	}
+	return records, nil
+}
+
+func (p *ownedZoneSplitDNSProvider) DeleteDNSRecords(ctx context.Context, keys []DNSRecordKey) error {
+	var main, other []DNSRecordKey
+	for _, k := range keys {
+		if otherKey, ok := p.toOther(k); ok {
+			other = append(other, otherKey)
+		} else {
+			main = append(main, k)
+		}
+	}
+
+	if len(main) != 0 {
+		if err := p.main.(OwnedDNSProvider).DeleteDNSRecords(ctx, main); err != nil {
+			return err
+		}
+	}
+	if len(other) != 0 {
+		if err := p.other.(OwnedDNSProvider).DeleteDNSRecords(ctx, other); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package kope_test
+
+import (
+	"context"
+	"github.com/kopeio/aws-controller/pkg/kope"
+	"github.com/kopeio/aws-controller/pkg/kope/fakecloud"
+	"reflect"
+	"testing"
+)
+
+func TestZoneSplitRouting(t *testing.T) {
+	node := kope.ARecord("node1.example.com")
+	privateNode := kope.DNSRecordKey{Name: "node1.example.com", Type: kope.DNSTypeA, Private: true}
+	ptr := kope.DNSRecordKey{Name: "1.0.0.10.in-addr.arpa", Type: kope.DNSTypePTR}
+
+	grid := []struct {
+		Name string
+		// Build combines the main and other providers
+		Build   func(main kope.DNSProvider, other kope.DNSProvider) kope.DNSProvider
+		Records map[kope.DNSRecordKey][]string
+		// Main and Other are the record sets expected in each zone
+		Main  map[kope.DNSRecordKey][]string
+		Other map[kope.DNSRecordKey][]string
+	}{
+		{
+			Name:    "split-horizon",
+			Build:   kope.NewSplitHorizonDNSProvider,
+			Records: map[kope.DNSRecordKey][]string{node: {"203.0.113.1"}, privateNode: {"10.0.0.1"}},
+			Main:    map[kope.DNSRecordKey][]string{node: {"203.0.113.1"}},
+			// The private zone holds the record set without the Private marker
+			Other: map[kope.DNSRecordKey][]string{node: {"10.0.0.1"}},
+		},
+		{
+			Name:    "reverse",
+			Build:   kope.NewReverseDNSProvider,
+			Records: map[kope.DNSRecordKey][]string{node: {"10.0.0.1"}, ptr: {"node1.example.com"}},
+			Main:    map[kope.DNSRecordKey][]string{node: {"10.0.0.1"}},
+			Other:   map[kope.DNSRecordKey][]string{ptr: {"node1.example.com"}},
+		},
+	}
+	for _, g := range grid {
+		ctx := context.Background()
+		main := fakecloud.NewFakeDNSProvider()
+		other := fakecloud.NewFakeDNSProvider()
+		p := g.Build(main, other)
+
+		if err := p.ApplyDNSChanges(ctx, g.Records); err != nil {
+			t.Fatalf("%s: unexpected error: %v", g.Name, err)
+		}
+		if actual := main.Records(); !reflect.DeepEqual(actual, g.Main) {
+			t.Errorf("%s: main zone has %v, expected %v", g.Name, actual, g.Main)
+		}
+		if actual := other.Records(); !reflect.DeepEqual(actual, g.Other) {
+			t.Errorf("%s: other zone has %v, expected %v", g.Name, actual, g.Other)
+		}
+
+		owned, ok := p.(kope.OwnedDNSProvider)
+		if !ok {
+			t.Fatalf("%s: combining two OwnedDNSProviders should give an OwnedDNSProvider", g.Name)
+		}
+		listed, err := owned.ListOwnedDNSRecords(ctx)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", g.Name, err)
+		}
+		if !reflect.DeepEqual(listed, g.Records) {
+			t.Errorf("%s: listed %v, expected %v", g.Name, listed, g.Records)
+		}
+
+		var keys []kope.DNSRecordKey
+		for k := range g.Records {
+			keys = append(keys, k)
+		}
+		if err := owned.DeleteDNSRecords(ctx, keys); err != nil {
+			t.Fatalf("%s: unexpected error: %v", g.Name, err)
+		}
+		if len(main.Records()) != 0 || len(other.Records()) != 0 {
+			t.Errorf("%s: after delete, main zone has %v and other zone has %v", g.Name, main.Records(), other.Records())
+		}
+	}
+}
+
+// notOwnedDNSProvider hides the ownership methods of a provider
+type notOwnedDNSProvider struct {
+	kope.DNSProvider
+}
+
+func TestZoneSplitOwnership(t *testing.T) {
+	owned := fakecloud.NewFakeDNSProvider()
+	notOwned := &notOwnedDNSProvider{fakecloud.NewFakeDNSProvider()}
+
+	if _, ok := kope.NewReverseDNSProvider(owned, notOwned).(kope.OwnedDNSProvider); ok {
+		t.Errorf("should not be an OwnedDNSProvider unless both providers are")
+	}
+	if _, ok := kope.NewReverseDNSProvider(notOwned, owned).(kope.OwnedDNSProvider); ok {
+		t.Errorf("should not be an OwnedDNSProvider unless both providers are")
+	}
+}